@@ -16,7 +16,6 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
-	"github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/improbable-eng/thanos/pkg/cluster"
 	"github.com/improbable-eng/thanos/pkg/discovery/cache"
@@ -35,11 +34,13 @@ import (
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/discovery/file"
 	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/pkg/relabel"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/tsdb/labels"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
 )
 
 // registerQuery registers a query command.
@@ -70,6 +71,15 @@ func registerQuery(m map[string]setupFunc, app *kingpin.Application, name string
 	replicaLabel := cmd.Flag("query.replica-label", "Label to treat as a replica indicator along which data is deduplicated. Still you will be able to query without deduplication using 'dedup=false' parameter.").
 		String()
 
+	replicaLabelIgnoreCase := cmd.Flag("query.replica-label-ignore-case", "Treat labels with name equal to --query.replica-label ignoring case as the replica label, so replicas whose configs differ by its casing are still deduplicated.").
+		Default("false").Bool()
+
+	replicaPriority := cmd.Flag("query.replica-priority", "Value of --query.replica-label whose replicas are preferred by deduplication over other replicas, including for samples at equal timestamps (repeated). Replicas are preferred in the order of the flags, replicas not listed after all listed ones.").
+		PlaceHolder("<value>").Strings()
+
+	requiredLabels := cmd.Flag("query.required-label", "Label every query must select by an equality matcher, or a regexp matcher of a single literal value, e.g. namespace in shared environments. Queries without it are rejected before reaching stores, preventing accidental cluster wide scans (repeated).").
+		PlaceHolder("<name>").Strings()
+
 	selectorLabels := cmd.Flag("selector-label", "Query selector labels that will be exposed in info endpoint (repeated).").
 		PlaceHolder("<name>=\"<value>\"").Strings()
 
@@ -91,6 +101,101 @@ func registerQuery(m map[string]setupFunc, app *kingpin.Application, name string
 	enablePartialResponse := cmd.Flag("query.partial-response", "Enable partial response for queries if no partial_response param is specified.").
 		Default("true").Bool()
 
+	maxQueryRange := modelDuration(cmd.Flag("query.max-select-range", "Maximum time range of data selected by a single query: end - start of the query widened by the lookback delta, widths of range selectors and offsets. Queries exceeding it are rejected before fetching any data. 0 disables the limit.").
+		Default("0s"))
+
+	maxConcurrentDecodes := cmd.Flag("query.max-concurrent-decodes", "Maximum number of chunks decoded concurrently by query node across all queries. 0 disables the limit.").
+		Default("0").Int()
+
+	parallelDecodeMinChunks := cmd.Flag("query.parallel-decode-min-chunks", "Minimum number of chunks of a single series for its chunks to be decoded in parallel, bounded by --query.max-concurrent-decodes. 0 disables parallel decoding, as does no limit of concurrent decodes.").
+		Default("0").Int()
+
+	seriesBatchSize := cmd.Flag("query.series-batch-size", "Estimated number of series of a store above which its Series requests are split into shards of about that many series, requested as separate streams and merged. Only stores reporting their estimated number of series are split. 0 disables splitting.").
+		Default("0").Int64()
+
+	shadowDedupStrategy := cmd.Flag("query.shadow-dedup-strategy", "Deduplication strategy validated against the one used for queries before rolling it out. Results of deduplicated queries sampled by --query.shadow-dedup-sample-rate are compared with it in the background and divergences are logged and counted, without affecting the results. Empty disables the comparison.").
+		Default("").Enum("", string(query.DedupPenalty), string(query.DedupFreshest))
+
+	shadowDedupSampleRate := cmd.Flag("query.shadow-dedup-sample-rate", "Fraction of deduplicated queries compared with --query.shadow-dedup-strategy. Comparisons run in the background one at a time, and queries sampled while one is running are skipped.").
+		Default("0.1").Float64()
+
+	storeSeriesRate := cmd.Flag("query.store-series-rate", "Maximum rate of Series calls per second to a single store, protecting stores backed by object storage from query storms. Calls over the limit wait for their turn until the query times out. 0 disables the limit.").
+		Default("0").Float64()
+
+	storeSeriesBurst := cmd.Flag("query.store-series-burst", "Maximum number of Series calls to a single store allowed at once above --query.store-series-rate.").
+		Default("1").Int()
+
+	chunkCacheSize := cmd.Flag("query.chunk-cache-size", "Maximum size of raw chunks held in the chunk cache shared across queries. Stores supporting it skip sending cached chunks, so repeated queries, like dashboards refreshing the same panels, fetch less data. 0 disables the cache.").
+		Default("0").Bytes()
+
+	chunkCacheTTL := modelDuration(cmd.Flag("query.chunk-cache-ttl", "Time for which chunks are reused from the chunk cache after being fetched.").
+		Default("10m"))
+
+	chunkCacheMinAge := modelDuration(cmd.Flag("query.chunk-cache-min-age", "Minimum age of the end of chunks held in the chunk cache. More recent chunks may still be appended to, so they are never cached.").
+		Default("3h"))
+
+	maxStoreChunkBytes := cmd.Flag("query.max-store-chunk-bytes", "Maximum size of chunks a single query receives from a single store, so a pathological store can't dominate bandwidth. Receiving from a store over the limit stops with a warning, or fails the query if partial response is disabled. 0 disables the limit.").
+		Default("0").Bytes()
+
+	futureGrace := modelDuration(cmd.Flag("query.future-grace", "How far in the future queries may end. Later end is clamped to it, so samples of stores with clocks slightly ahead of the querier are still returned within it. 0s clamps the end to now.").
+		Default("5m"))
+
+	maxDataAge := modelDuration(cmd.Flag("query.max-data-age", "Maximum age of data returned by queries. Queries starting earlier are clamped to start at that age with a warning, so accidental wide queries don't reach historical stores. Queries ending earlier return no data. 0s disables the limit.").
+		Default("0s"))
+
+	responseSizeWarning := cmd.Flag("query.response-size-warning", "Size of chunks a query receives from stores over which a warning is returned and the query is logged, to help noticing expensive queries. Queries are not limited by it. 0 disables the warning.").
+		Default("0").Bytes()
+
+	explainEmptyResults := cmd.Flag("query.explain-empty-results", "Return a warning listing outcome of up to 10 stores for selects with no series: skipped and why, failed or contacted with no series, to help telling missing data from unavailable stores.").
+		Default("false").Bool()
+
+	labelValuesConcurrency := cmd.Flag("query.label-values-concurrency", "Maximum number of concurrent LabelValues calls of stores made by a single /api/v1/labels/values request, across all its labels. 0 disables the limit.").
+		Default("0").Int()
+
+	retryBudget := cmd.Flag("query.retry-budget", "Maximum number of retries of failed store calls by a single query, across all stores. Once exhausted, failures are not retried, so retries don't amplify load of stores during an incident. 0 disables the budget.").
+		Default("0").Int()
+
+	maxSeriesChunks := cmd.Flag("query.max-series-chunks", "Maximum number of chunks of a single series fetched by a query. An abnormal number of chunks, often tiny ones, points at an unhealthy TSDB and is expensive to query. Series with more chunks are handled according to --query.excess-chunks. 0 disables the limit.").
+		Default("0").Int()
+
+	excessChunks := cmd.Flag("query.excess-chunks", "Handling of series with more chunks than --query.max-series-chunks: 'warn' returns a single warning with the number of such series and labels of a few of them, 'skip' also drops the series from the result.").
+		Default(string(query.SeriesPolicyWarn)).Enum(string(query.SeriesPolicyWarn), string(query.SeriesPolicySkip))
+
+	maxStaleness := modelDuration(cmd.Flag("query.max-staleness", "Maximum age of the freshest sample of a series, relative to the end of the query or now, whichever is earlier. Series with older freshest sample in all replicas are handled according to --query.stale-series, so silently stale series are surfaced. 0s disables the bound.").
+		Default("0s"))
+
+	staleSeries := cmd.Flag("query.stale-series", "Handling of series with freshest sample older than --query.max-staleness: 'warn' returns a single warning with the number of such series and labels of a few of them, 'skip' also drops the series from the result.").
+		Default(string(query.SeriesPolicyWarn)).Enum(string(query.SeriesPolicyWarn), string(query.SeriesPolicySkip))
+
+	relabelConfig := &pathOrContent{
+		fileFlagName:    "query.relabel-config-file",
+		contentFlagName: "query.relabel-config",
+
+		path:    cmd.Flag("query.relabel-config-file", "Path to YAML file with relabel configs, in the Prometheus relabel_config format, applied to labels of series returned by queries after merging and deduplication, e.g. to rename metrics or labels for presentation. Series dropped by them are not returned.").PlaceHolder("<relabel.config-yaml-path>").String(),
+		content: cmd.Flag("query.relabel-config", "Alternative to 'query.relabel-config-file' flag. Relabel configs in YAML.").PlaceHolder("<relabel.config-yaml>").String(),
+	}
+
+	maxStores := cmd.Flag("query.max-stores", "Maximum number of stores contacted by a single query after filtering out stores not matching it. Queries matching more stores are rejected. 0 disables the limit.").
+		Default("0").Int()
+
+	maxStoresTruncate := cmd.Flag("query.max-stores-truncate", "Instead of rejecting queries matching more than --query.max-stores stores, query only the ones holding the most data in the query time range and return a warning.").
+		Default("false").Bool()
+
+	maxStoreConcurrency := cmd.Flag("query.max-store-concurrency", "Maximum number of queries receiving from a single store concurrently. Queries over the limit wait for the store and are served round robin across tenants matched by --query.tenant-label, so a single tenant can't starve others. 0 disables the limit.").
+		Default("0").Int()
+
+	tenantLabel := cmd.Flag("query.tenant-label", "Label identifying tenants in external labels of stores. Queries with an equality matcher for it are sent only to stores with external label of the matched tenant.").
+		Default("").String()
+
+	hashringReplicas := cmd.Flag("query.hashring-replica", "Name of a horizontally scaled query node (repeated). Each store is queried by exactly one of the named query nodes, assigned by consistent hashing on its address, so results have to be aggregated by a higher query tier. No names queries all stores.").
+		PlaceHolder("<name>").Strings()
+
+	hashringSelf := cmd.Flag("query.hashring-self", "Name of this query node in --query.hashring-replica.").
+		Default("").String()
+
+	storeTransferStats := cmd.Flag("query.store-transfer-stats", "Account bytes received from stores on the wire and bytes of decoded messages to stats of queries, e.g. to evaluate compression of store responses. It installs a gRPC stats handler on every store connection.").
+		Default("false").Bool()
+
 	m[name] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ bool) error {
 		peer, err := newPeerFn(logger, reg, true, *httpAdvertiseAddr, true)
 		if err != nil {
@@ -110,6 +215,15 @@ func registerQuery(m map[string]setupFunc, app *kingpin.Application, name string
 			lookupStores[s] = struct{}{}
 		}
 
+		relabelContentYaml, err := relabelConfig.Content()
+		if err != nil {
+			return errors.Wrap(err, "get content of relabel configuration")
+		}
+		var relabelConfigs []*relabel.Config
+		if err := yaml.Unmarshal(relabelContentYaml, &relabelConfigs); err != nil {
+			return errors.Wrap(err, "parse relabel configuration")
+		}
+
 		var fileSD *file.Discovery
 		if len(*fileSDFiles) > 0 {
 			conf := &file.SDConfig{
@@ -140,11 +254,42 @@ func registerQuery(m map[string]setupFunc, app *kingpin.Application, name string
 			*maxConcurrentQueries,
 			time.Duration(*queryTimeout),
 			*replicaLabel,
+			*replicaLabelIgnoreCase,
+			*replicaPriority,
+			*requiredLabels,
 			peer,
 			selectorLset,
 			*stores,
 			*enableAutodownsampling,
 			*enablePartialResponse,
+			time.Duration(*maxQueryRange),
+			*maxConcurrentDecodes,
+			*parallelDecodeMinChunks,
+			*seriesBatchSize,
+			query.DedupStrategy(*shadowDedupStrategy),
+			*shadowDedupSampleRate,
+			*storeSeriesRate,
+			*storeSeriesBurst,
+			uint64(*chunkCacheSize),
+			time.Duration(*chunkCacheTTL),
+			time.Duration(*chunkCacheMinAge),
+			int64(*maxStoreChunkBytes),
+			time.Duration(*futureGrace),
+			time.Duration(*maxDataAge),
+			int64(*responseSizeWarning),
+			*explainEmptyResults,
+			*labelValuesConcurrency,
+			*retryBudget,
+			*maxSeriesChunks,
+			query.SeriesPolicy(*excessChunks),
+			time.Duration(*maxStaleness),
+			query.SeriesPolicy(*staleSeries),
+			relabelConfigs,
+			store.StoreLimit{Max: *maxStores, Truncate: *maxStoresTruncate, MaxConcurrency: *maxStoreConcurrency},
+			*tenantLabel,
+			*hashringSelf,
+			*hashringReplicas,
+			*storeTransferStats,
 			fileSD,
 			time.Duration(*dnsSDInterval),
 		)
@@ -164,19 +309,17 @@ func storeClientGRPCOpts(logger log.Logger, reg *prometheus.Registry, tracer ope
 		// Current limit is ~2GB.
 		// TODO(bplotka): Split sent chunks on store node per max 4MB chunks if needed.
 		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(math.MaxInt32)),
-		grpc.WithUnaryInterceptor(
-			grpc_middleware.ChainUnaryClient(
-				grpcMets.UnaryClientInterceptor(),
-				tracing.UnaryClientInterceptor(tracer),
-			),
-		),
-		grpc.WithStreamInterceptor(
-			grpc_middleware.ChainStreamClient(
-				grpcMets.StreamClientInterceptor(),
-				tracing.StreamClientInterceptor(tracer),
-			),
-		),
 	}
+	dialOpts = append(dialOpts, query.StoreInterceptors{
+		Unary: []grpc.UnaryClientInterceptor{
+			grpcMets.UnaryClientInterceptor(),
+			tracing.UnaryClientInterceptor(tracer),
+		},
+		Stream: []grpc.StreamClientInterceptor{
+			grpcMets.StreamClientInterceptor(),
+			tracing.StreamClientInterceptor(tracer),
+		},
+	}.DialOptions()...)
 
 	if reg != nil {
 		reg.MustRegister(grpcMets)
@@ -255,11 +398,42 @@ func runQuery(
 	maxConcurrentQueries int,
 	queryTimeout time.Duration,
 	replicaLabel string,
+	replicaLabelIgnoreCase bool,
+	replicaPriority []string,
+	requiredLabels []string,
 	peer cluster.Peer,
 	selectorLset labels.Labels,
 	storeAddrs []string,
 	enableAutodownsampling bool,
 	enablePartialResponse bool,
+	maxQueryRange time.Duration,
+	maxConcurrentDecodes int,
+	parallelDecodeMinChunks int,
+	seriesBatchSize int64,
+	shadowDedupStrategy query.DedupStrategy,
+	shadowDedupSampleRate float64,
+	storeSeriesRate float64,
+	storeSeriesBurst int,
+	chunkCacheSize uint64,
+	chunkCacheTTL time.Duration,
+	chunkCacheMinAge time.Duration,
+	maxStoreChunkBytes int64,
+	futureGrace time.Duration,
+	maxDataAge time.Duration,
+	responseSizeWarning int64,
+	explainEmptyResults bool,
+	labelValuesConcurrency int,
+	retryBudget int,
+	maxSeriesChunks int,
+	excessChunks query.SeriesPolicy,
+	maxStaleness time.Duration,
+	staleSeries query.SeriesPolicy,
+	relabelConfigs []*relabel.Config,
+	storeLimit store.StoreLimit,
+	tenantLabel string,
+	hashringSelf string,
+	hashringReplicas []string,
+	storeTransferStats bool,
 	fileSD *file.Discovery,
 	dnsSDInterval time.Duration,
 ) error {
@@ -274,10 +448,59 @@ func runQuery(
 	if err != nil {
 		return errors.Wrap(err, "building gRPC client")
 	}
+	if storeTransferStats {
+		dialOpts = append(dialOpts, query.NewStoreTransferStats().DialOptions()...)
+	}
 
 	fileSDCache := cache.New()
 	dnsProvider := dns.NewProvider(logger, extprom.NewSubsystem(reg, "query_store_api"))
 
+	querierOpts := query.QuerierOpts{
+		MaxQueryRange:          maxQueryRange,
+		DedupMetrics:           query.NewDedupMetrics(reg),
+		SeriesBatchSize:        seriesBatchSize,
+		MaxStoreChunkBytes:     maxStoreChunkBytes,
+		FutureGrace:            &futureGrace,
+		MaxDataAge:             maxDataAge,
+		ResponseSizeWarning:    responseSizeWarning,
+		ExplainEmptyResults:    explainEmptyResults,
+		LabelValuesConcurrency: labelValuesConcurrency,
+		RetryBudget:            retryBudget,
+		ReplicaLabelIgnoreCase: replicaLabelIgnoreCase,
+		ReplicaPriority:        replicaPriority,
+		RequiredLabels:         requiredLabels,
+		MaxSeriesChunks:        maxSeriesChunks,
+		ExcessChunks:           excessChunks,
+		MaxStaleness:           maxStaleness,
+		StaleSeries:            staleSeries,
+		RelabelConfigs:         relabelConfigs,
+	}
+	if maxConcurrentDecodes > 0 {
+		querierOpts.DecodePool = query.NewDecodePool(reg, maxConcurrentDecodes)
+		querierOpts.ParallelDecodeMinChunks = parallelDecodeMinChunks
+	}
+	if shadowDedupStrategy != "" {
+		querierOpts.ShadowDedup = query.NewShadowDedup(logger, reg, shadowDedupStrategy, shadowDedupSampleRate)
+	}
+	if chunkCacheSize > 0 {
+		querierOpts.ChunkCache, err = store.NewChunkCache(reg, chunkCacheSize, chunkCacheTTL, chunkCacheMinAge)
+		if err != nil {
+			return errors.Wrap(err, "create chunk cache")
+		}
+	}
+
+	var storeSelector query.StoreSelector
+	if len(hashringReplicas) > 0 {
+		var found bool
+		for _, r := range hashringReplicas {
+			found = found || r == hashringSelf
+		}
+		if !found {
+			return errors.Errorf("query node %q specified by --query.hashring-self is not one of --query.hashring-replica", hashringSelf)
+		}
+		storeSelector = query.NewHashringSelector(hashringSelf, hashringReplicas)
+	}
+
 	var (
 		stores = query.NewStoreSet(
 			logger,
@@ -304,10 +527,10 @@ func runQuery(
 			},
 			dialOpts,
 		)
-		proxy = store.NewProxyStore(logger, func(context.Context) ([]store.Client, error) {
+		proxy = store.NewProxyStore(logger, reg, query.SelectedStores(storeSelector, func(context.Context) ([]store.Client, error) {
 			return stores.Get(), nil
-		}, selectorLset)
-		queryableCreator = query.NewQueryableCreator(logger, proxy, replicaLabel)
+		}), selectorLset, storeLimit, store.WithTenantLabel(tenantLabel))
+		queryableCreator = query.NewQueryableCreator(logger, proxy, replicaLabel, querierOpts)
 		engine           = promql.NewEngine(
 			promql.EngineOpts{
 				Logger:        logger,
@@ -319,6 +542,8 @@ func runQuery(
 			},
 		)
 	)
+	stores.SetSeriesRateLimit(storeSeriesRate, storeSeriesBurst)
+
 	// Periodically update the store set with the addresses we see in our cluster.
 	{
 		ctx, cancel := context.WithCancel(context.Background())
@@ -412,7 +637,7 @@ func runQuery(
 
 		ui.NewQueryUI(logger, stores, flagsMap).Register(router.WithPrefix(webRoutePrefix))
 
-		api := v1.NewAPI(logger, reg, engine, queryableCreator, enableAutodownsampling, enablePartialResponse)
+		api := v1.NewAPI(logger, reg, engine, queryableCreator, enableAutodownsampling, enablePartialResponse, stores.GetStoreStatus)
 
 		api.Register(router.WithPrefix(path.Join(webRoutePrefix, "/api/v1")), tracer, logger)
 
@@ -497,11 +722,16 @@ func (s *gossipSpec) Addr() string {
 	return s.addr
 }
 
-// Metadata method for gossip store tries get current peer state.
-func (s *gossipSpec) Metadata(_ context.Context, _ storepb.StoreClient) (labels []storepb.Label, mint int64, maxt int64, err error) {
+// Metadata method for gossip store tries get current peer state. Peer state does not hold a series estimate or
+// capabilities, so the estimate is unknown and no capabilities are assumed.
+func (s *gossipSpec) Metadata(_ context.Context, _ storepb.StoreClient) (*storepb.InfoResponse, error) {
 	state, ok := s.stateFetcher.PeerState(s.id)
 	if !ok {
-		return nil, 0, 0, errors.Errorf("peer %s is no longer in gossip cluster", s.id)
+		return nil, errors.Errorf("peer %s is no longer in gossip cluster", s.id)
 	}
-	return state.Metadata.Labels, state.Metadata.MinTime, state.Metadata.MaxTime, nil
+	return &storepb.InfoResponse{
+		Labels:  state.Metadata.Labels,
+		MinTime: state.Metadata.MinTime,
+		MaxTime: state.Metadata.MaxTime,
+	}, nil
 }
@@ -31,6 +31,8 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/improbable-eng/thanos/pkg/query"
 	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/tracing"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
@@ -105,6 +107,7 @@ type API struct {
 	rangeQueryDuration     prometheus.Histogram
 	enableAutodownsampling bool
 	enablePartialResponse  bool
+	storeStatuses          func() []query.StoreStatus
 	now                    func() time.Time
 }
 
@@ -116,6 +119,7 @@ func NewAPI(
 	c query.QueryableCreator,
 	enableAutodownsampling bool,
 	enablePartialResponse bool,
+	storeStatuses func() []query.StoreStatus,
 ) *API {
 	instantQueryDuration := prometheus.NewHistogram(prometheus.HistogramOpts{
 		Name: "thanos_query_api_instant_query_duration_seconds",
@@ -144,6 +148,7 @@ func NewAPI(
 		rangeQueryDuration:     rangeQueryDuration,
 		enableAutodownsampling: enableAutodownsampling,
 		enablePartialResponse:  enablePartialResponse,
+		storeStatuses:          storeStatuses,
 
 		now: time.Now,
 	}
@@ -171,8 +176,14 @@ func (api *API) Register(r *route.Router, tracer opentracing.Tracer, logger log.
 	r.Get("/query_range", instr("query_range", api.queryRange))
 
 	r.Get("/label/:name/values", instr("label_values", api.labelValues))
+	r.Get("/labels/values", instr("labels_values", api.multiLabelValues))
 
 	r.Get("/series", instr("series", api.series))
+	r.Get("/series/cost", instr("series_cost", api.seriesCost))
+
+	r.Get("/stores", instr("stores", api.stores))
+	r.Get("/stores/capacity", instr("stores_capacity", api.storesCapacity))
+	r.Get("/metric/:name/stores", instr("metric_stores", api.metricStores))
 }
 
 type queryData struct {
@@ -197,6 +208,47 @@ func (api *API) parseEnableDedupParam(r *http.Request) (enableDeduplication bool
 	return enableDeduplication, nil
 }
 
+func (api *API) parseDedupStrategyParam(r *http.Request) (strategy query.DedupStrategy, _ *apiError) {
+	const dedupStrategyParam = "dedupStrategy"
+	strategy = query.DedupPenalty
+
+	if val := r.FormValue(dedupStrategyParam); val != "" {
+		var err error
+		strategy, err = query.ParseDedupStrategy(val)
+		if err != nil {
+			return "", &apiError{errorBadData, errors.Wrapf(err, "'%s' parameter", dedupStrategyParam)}
+		}
+	}
+	return strategy, nil
+}
+
+func (api *API) parseLabelValuesSortParam(r *http.Request) (sort query.LabelValuesSort, _ *apiError) {
+	const sortParam = "sort"
+	sort = query.LabelValuesSortLexicographic
+
+	if val := r.FormValue(sortParam); val != "" {
+		var err error
+		sort, err = query.ParseLabelValuesSort(val)
+		if err != nil {
+			return "", &apiError{errorBadData, errors.Wrapf(err, "'%s' parameter", sortParam)}
+		}
+	}
+	return sort, nil
+}
+
+func (api *API) parseLabelValuesLimitParam(r *http.Request) (limit int, _ *apiError) {
+	const limitParam = "limit"
+
+	if val := r.FormValue(limitParam); val != "" {
+		var err error
+		limit, err = strconv.Atoi(val)
+		if err != nil || limit < 0 {
+			return 0, &apiError{errorBadData, errors.Errorf("'%s' parameter must be a non-negative integer, got %q", limitParam, val)}
+		}
+	}
+	return limit, nil
+}
+
 func (api *API) parseDownsamplingParam(r *http.Request, step time.Duration) (maxSourceResolution time.Duration, _ *apiError) {
 	const maxSourceResolutionParam = "max_source_resolution"
 	maxSourceResolution = 0 * time.Second
@@ -267,6 +319,11 @@ func (api *API) query(r *http.Request) (interface{}, []error, *apiError) {
 		return nil, nil, apiErr
 	}
 
+	dedupStrategy, apiErr := api.parseDedupStrategyParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
 	enablePartialResponse, apiErr := api.parsePartialResponseParam(r)
 	if apiErr != nil {
 		return nil, nil, apiErr
@@ -285,6 +342,7 @@ func (api *API) query(r *http.Request) (interface{}, []error, *apiError) {
 	// We are starting promQL tracing span here, because we have no control over promQL code.
 	span, ctx := tracing.StartSpan(r.Context(), "promql_instant_query")
 	defer span.Finish()
+	ctx = query.ContextWithDedupStrategy(ctx, dedupStrategy)
 
 	begin := api.now()
 	qry, err := api.queryEngine.NewInstantQuery(api.queryableCreate(enableDedup, 0, enablePartialResponse, warningReporter), r.FormValue("query"), ts)
@@ -360,6 +418,11 @@ func (api *API) queryRange(r *http.Request) (interface{}, []error, *apiError) {
 		return nil, nil, apiErr
 	}
 
+	dedupStrategy, apiErr := api.parseDedupStrategyParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
 	maxSourceResolution, apiErr := api.parseDownsamplingParam(r, step)
 	if apiErr != nil {
 		return nil, nil, apiErr
@@ -383,6 +446,8 @@ func (api *API) queryRange(r *http.Request) (interface{}, []error, *apiError) {
 	// We are starting promQL tracing span here, because we have no control over promQL code.
 	span, ctx := tracing.StartSpan(r.Context(), "promql_range_query")
 	defer span.Finish()
+	ctx = query.ContextWithDedupStrategy(ctx, dedupStrategy)
+	ctx = query.ContextWithQueryStep(ctx, step)
 
 	begin := api.now()
 	qry, err := api.queryEngine.NewRangeQuery(
@@ -427,6 +492,22 @@ func (api *API) labelValues(r *http.Request) (interface{}, []error, *apiError) {
 		return nil, nil, apiErr
 	}
 
+	valuesSort, apiErr := api.parseLabelValuesSortParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	limit, apiErr := api.parseLabelValuesLimitParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	// Optional time range restricts values to stores holding data within it.
+	start, end, apiErr := parseTimeRangeParams(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
 	var (
 		warnmtx  sync.Mutex
 		warnings []error
@@ -437,7 +518,9 @@ func (api *API) labelValues(r *http.Request) (interface{}, []error, *apiError) {
 		warnmtx.Unlock()
 	}
 
-	q, err := api.queryableCreate(true, 0, enablePartialResponse, warningReporter).Querier(ctx, math.MinInt64, math.MaxInt64)
+	ctx = query.ContextWithLabelValuesSort(ctx, valuesSort)
+	ctx = query.ContextWithLabelValuesLimit(ctx, limit)
+	q, err := api.queryableCreate(true, 0, enablePartialResponse, warningReporter).Querier(ctx, timestamp.FromTime(start), timestamp.FromTime(end))
 	if err != nil {
 		return nil, nil, &apiError{errorExec, err}
 	}
@@ -453,6 +536,68 @@ func (api *API) labelValues(r *http.Request) (interface{}, []error, *apiError) {
 	return vals, warnings, nil
 }
 
+// multiLabelValues returns values of each name[] label, like labelValues called for each of them, but with stores
+// asked for all labels in parallel, so tools prefetching values of many labels make a single request.
+func (api *API) multiLabelValues(r *http.Request) (interface{}, []error, *apiError) {
+	if err := r.ParseForm(); err != nil {
+		return nil, nil, &apiError{errorInternal, errors.Wrap(err, "parse form")}
+	}
+
+	names := r.Form["name[]"]
+	if len(names) == 0 {
+		return nil, nil, &apiError{errorBadData, fmt.Errorf("no name[] parameter provided")}
+	}
+	for _, name := range names {
+		if !model.LabelNameRE.MatchString(name) {
+			return nil, nil, &apiError{errorBadData, fmt.Errorf("invalid label name: %q", name)}
+		}
+	}
+
+	enablePartialResponse, apiErr := api.parsePartialResponseParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	valuesSort, apiErr := api.parseLabelValuesSortParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	limit, apiErr := api.parseLabelValuesLimitParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	start, end, apiErr := parseTimeRangeParams(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	var (
+		warnmtx  sync.Mutex
+		warnings []error
+	)
+	warningReporter := func(err error) {
+		warnmtx.Lock()
+		warnings = append(warnings, err)
+		warnmtx.Unlock()
+	}
+
+	ctx := query.ContextWithLabelValuesSort(r.Context(), valuesSort)
+	ctx = query.ContextWithLabelValuesLimit(ctx, limit)
+	q, apiErr := api.querier(ctx, enablePartialResponse, warningReporter, start, end)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	defer runutil.CloseWithLogOnErr(api.logger, q, "queryable multiLabelValues")
+
+	vals, err := q.MultiLabelValues(names)
+	if err != nil {
+		return nil, nil, &apiError{errorExec, err}
+	}
+	return vals, warnings, nil
+}
+
 var (
 	minTime = time.Unix(math.MinInt64/1000+62135596801, 0)
 	maxTime = time.Unix(math.MaxInt64/1000-62135596801, 999999999)
@@ -547,6 +692,217 @@ func (api *API) series(r *http.Request) (interface{}, []error, *apiError) {
 	return metrics, warnings, nil
 }
 
+// parseTimeRangeParams returns time range of the optional start and end params. Missing params leave the range open.
+func parseTimeRangeParams(r *http.Request) (start, end time.Time, _ *apiError) {
+	start, end = minTime, maxTime
+	if t := r.FormValue("start"); t != "" {
+		var err error
+		start, err = parseTime(t)
+		if err != nil {
+			return start, end, &apiError{errorBadData, err}
+		}
+	}
+	if t := r.FormValue("end"); t != "" {
+		var err error
+		end, err = parseTime(t)
+		if err != nil {
+			return start, end, &apiError{errorBadData, err}
+		}
+	}
+	return start, end, nil
+}
+
+// querier returns query.Querier for the given time range. Callers have to close it.
+func (api *API) querier(ctx context.Context, partialResponse bool, warningReporter query.WarningReporter, start, end time.Time) (query.Querier, *apiError) {
+	q, err := api.queryableCreate(true, 0, partialResponse, warningReporter).Querier(ctx, timestamp.FromTime(start), timestamp.FromTime(end))
+	if err != nil {
+		return nil, &apiError{errorExec, err}
+	}
+	qq, ok := q.(query.Querier)
+	if !ok {
+		runutil.CloseWithLogOnErr(api.logger, q, "queryable")
+		return nil, &apiError{errorInternal, errors.New("querier does not support query node methods")}
+	}
+	return qq, nil
+}
+
+// metricStores returns addresses of stores having series of the metric within the optional time range, so operators
+// debugging missing data can see which stores hold it without running a query.
+func (api *API) metricStores(r *http.Request) (interface{}, []error, *apiError) {
+	name := route.Param(r.Context(), "name")
+	if !model.MetricNameRE.MatchString(name) {
+		return nil, nil, &apiError{errorBadData, fmt.Errorf("invalid metric name: %q", name)}
+	}
+
+	enablePartialResponse, apiErr := api.parsePartialResponseParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	start, end, apiErr := parseTimeRangeParams(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	var (
+		warnmtx  sync.Mutex
+		warnings []error
+	)
+	warningReporter := func(err error) {
+		warnmtx.Lock()
+		warnings = append(warnings, err)
+		warnmtx.Unlock()
+	}
+
+	q, apiErr := api.querier(r.Context(), enablePartialResponse, warningReporter, start, end)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	defer runutil.CloseWithLogOnErr(api.logger, q, "queryable metricStores")
+
+	addrs, err := q.StoresWithMetric(name)
+	if err != nil {
+		return nil, nil, &apiError{errorExec, err}
+	}
+	if addrs == nil {
+		addrs = []string{}
+	}
+	return addrs, warnings, nil
+}
+
+// seriesCost returns estimated cost of selecting series of each match[] selector within the optional time range, in
+// order of the selectors, so UIs can warn before running expensive queries.
+func (api *API) seriesCost(r *http.Request) (interface{}, []error, *apiError) {
+	if err := r.ParseForm(); err != nil {
+		return nil, nil, &apiError{errorInternal, errors.Wrap(err, "parse form")}
+	}
+
+	if len(r.Form["match[]"]) == 0 {
+		return nil, nil, &apiError{errorBadData, fmt.Errorf("no match[] parameter provided")}
+	}
+
+	var matcherSets [][]*labels.Matcher
+	for _, s := range r.Form["match[]"] {
+		matchers, err := promql.ParseMetricSelector(s)
+		if err != nil {
+			return nil, nil, &apiError{errorBadData, err}
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+
+	enablePartialResponse, apiErr := api.parsePartialResponseParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	start, end, apiErr := parseTimeRangeParams(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	var (
+		warnmtx  sync.Mutex
+		warnings []error
+	)
+	warningReporter := func(err error) {
+		warnmtx.Lock()
+		warnings = append(warnings, err)
+		warnmtx.Unlock()
+	}
+
+	q, apiErr := api.querier(r.Context(), enablePartialResponse, warningReporter, start, end)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	defer runutil.CloseWithLogOnErr(api.logger, q, "queryable seriesCost")
+
+	estimates := make([]query.CostEstimate, 0, len(matcherSets))
+	for _, ms := range matcherSets {
+		est, err := q.EstimateCost(ms...)
+		if err != nil {
+			return nil, nil, &apiError{errorExec, err}
+		}
+		estimates = append(estimates, est)
+	}
+	return estimates, warnings, nil
+}
+
+// storesCapacity returns totals of blocks, series, chunks, samples and bytes held by each store reporting them and
+// their sum, for capacity dashboards. No data is fetched.
+func (api *API) storesCapacity(r *http.Request) (interface{}, []error, *apiError) {
+	enablePartialResponse, apiErr := api.parsePartialResponseParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	var (
+		warnmtx  sync.Mutex
+		warnings []error
+	)
+	warningReporter := func(err error) {
+		warnmtx.Lock()
+		warnings = append(warnings, err)
+		warnmtx.Unlock()
+	}
+
+	// Stats cover all data of stores, so the time range of the querier does not matter.
+	q, apiErr := api.querier(r.Context(), enablePartialResponse, warningReporter, minTime, maxTime)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	defer runutil.CloseWithLogOnErr(api.logger, q, "queryable storesCapacity")
+
+	stats, err := q.CapacityStats()
+	if err != nil {
+		return nil, nil, &apiError{errorExec, err}
+	}
+	if stats.Stores == nil {
+		stats.Stores = []store.StoreStats{}
+	}
+	return stats, warnings, nil
+}
+
+// storeStatus is the status of a store returned by the stores endpoint.
+type storeStatus struct {
+	Name      string          `json:"name"`
+	StoreType string          `json:"storeType"`
+	Labels    []storepb.Label `json:"labels"`
+	MinTime   int64           `json:"minTime"`
+	MaxTime   int64           `json:"maxTime"`
+	// LastRefresh is nil if the store metadata was never refreshed successfully.
+	LastRefresh *time.Time `json:"lastRefresh"`
+	LastCheck   time.Time  `json:"lastCheck"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+// stores returns status of all stores known to the querier, including the time range of their data, so operators
+// can spot gaps in coverage.
+func (api *API) stores(r *http.Request) (interface{}, []error, *apiError) {
+	res := []storeStatus{}
+	if api.storeStatuses == nil {
+		return res, nil, nil
+	}
+	for _, st := range api.storeStatuses() {
+		s := storeStatus{
+			Name:      st.Name,
+			StoreType: st.StoreType.String(),
+			Labels:    st.Labels,
+			MinTime:   st.MinTime,
+			MaxTime:   st.MaxTime,
+			LastCheck: st.LastCheck,
+		}
+		if !st.LastRefresh.IsZero() {
+			lastRefresh := st.LastRefresh
+			s.LastRefresh = &lastRefresh
+		}
+		if st.LastError != nil {
+			s.LastError = st.LastError.Error()
+		}
+		res = append(res, s)
+	}
+	return res, nil, nil
+}
+
 func respond(w http.ResponseWriter, data interface{}, warnings []error) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -31,6 +31,7 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/improbable-eng/thanos/pkg/query"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/testutil"
 	"github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
@@ -281,6 +282,66 @@ func TestEndpoints(t *testing.T) {
 				"boo",
 			},
 		},
+		{
+			endpoint: api.labelValues,
+			params: map[string]string{
+				"name": "foo",
+			},
+			query: url.Values{
+				"sort": []string{"numeric"},
+			},
+			response: []string{
+				"bar",
+				"boo",
+			},
+		},
+		// Bad sort parameter.
+		{
+			endpoint: api.labelValues,
+			params: map[string]string{
+				"name": "foo",
+			},
+			query: url.Values{
+				"sort": []string{"random"},
+			},
+			errType: errorBadData,
+		},
+		// Bad limit parameter.
+		{
+			endpoint: api.labelValues,
+			params: map[string]string{
+				"name": "foo",
+			},
+			query: url.Values{
+				"limit": []string{"-1"},
+			},
+			errType: errorBadData,
+		},
+		{
+			endpoint: api.labelValues,
+			params: map[string]string{
+				"name": "foo",
+			},
+			query: url.Values{
+				"start": []string{"0"},
+				"end":   []string{"2"},
+			},
+			response: []string{
+				"bar",
+				"boo",
+			},
+		},
+		// Bad start parameter.
+		{
+			endpoint: api.labelValues,
+			params: map[string]string{
+				"name": "foo",
+			},
+			query: url.Values{
+				"start": []string{"boop"},
+			},
+			errType: errorBadData,
+		},
 		// Bad name parameter.
 		{
 			endpoint: api.labelValues,
@@ -444,6 +505,59 @@ func TestEndpoints(t *testing.T) {
 	}
 }
 
+func TestStoresEndpoint(t *testing.T) {
+	refresh := time.Unix(1000, 0).UTC()
+	api := &API{
+		storeStatuses: func() []query.StoreStatus {
+			return []query.StoreStatus{
+				{
+					Name:        "sidecar:10901",
+					StoreType:   storepb.StoreType_SIDECAR,
+					Labels:      []storepb.Label{{Name: "replica", Value: "a"}},
+					MinTime:     100000,
+					MaxTime:     200000,
+					LastCheck:   refresh,
+					LastRefresh: refresh,
+				},
+				{
+					Name:      "store:10901",
+					StoreType: storepb.StoreType_STORE,
+					MinTime:   0,
+					MaxTime:   100000,
+					LastCheck: refresh,
+					LastError: errors.New("connection refused"),
+				},
+			}
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	data, _, apiErr := api.stores(httptest.NewRequest("GET", "/api/v1/stores", nil))
+	testutil.Assert(t, apiErr == nil, "unexpected error %v", apiErr)
+	respond(rec, data, nil)
+
+	var res struct {
+		Status status                   `json:"status"`
+		Data   []map[string]interface{} `json:"data"`
+	}
+	testutil.Ok(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	testutil.Equals(t, statusSuccess, res.Status)
+	testutil.Equals(t, 2, len(res.Data))
+
+	testutil.Equals(t, "sidecar:10901", res.Data[0]["name"])
+	testutil.Equals(t, "SIDECAR", res.Data[0]["storeType"])
+	testutil.Equals(t, 100000.0, res.Data[0]["minTime"])
+	testutil.Equals(t, 200000.0, res.Data[0]["maxTime"])
+	testutil.Equals(t, "1970-01-01T00:16:40Z", res.Data[0]["lastRefresh"])
+
+	testutil.Equals(t, "store:10901", res.Data[1]["name"])
+	testutil.Equals(t, "STORE", res.Data[1]["storeType"])
+	testutil.Equals(t, 0.0, res.Data[1]["minTime"])
+	testutil.Equals(t, 100000.0, res.Data[1]["maxTime"])
+	testutil.Equals(t, nil, res.Data[1]["lastRefresh"])
+	testutil.Equals(t, "connection refused", res.Data[1]["lastError"])
+}
+
 func TestRespondSuccess(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		respond(w, "test", nil)
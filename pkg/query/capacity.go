@@ -0,0 +1,53 @@
+package query
+
+import (
+	"context"
+
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/tracing"
+	"github.com/pkg/errors"
+)
+
+// storeStatsReporter is implemented by proxies that can report totals of data held by each of the underlying stores.
+type storeStatsReporter interface {
+	StoreStats(ctx context.Context, partialResponseDisabled bool) (stats []store.StoreStats, warnings []string, err error)
+}
+
+// CapacityStats are totals of data held by stores, as opposed to Stats of data selected by a query.
+type CapacityStats struct {
+	// Stores holds stats of each store reporting them, sorted by address.
+	Stores []store.StoreStats `json:"stores"`
+	// Total sums stats of all stores.
+	Total storepb.StoreStats `json:"total"`
+}
+
+// CapacityStats asks stores for totals of data they hold, regardless of the querier time range, and aggregates them.
+// No data is fetched, so it is meant to be polled by capacity planning dashboards.
+func (q *querier) CapacityStats() (CapacityStats, error) {
+	span, ctx := tracing.StartSpan(q.ctx, "querier_capacity_stats")
+	defer span.Finish()
+
+	reporter, ok := q.proxy.(storeStatsReporter)
+	if !ok {
+		return CapacityStats{}, errors.New("proxy does not support reporting store stats")
+	}
+
+	stats, warnings, err := reporter.StoreStats(ctx, !q.partialResponse)
+	if err != nil {
+		return CapacityStats{}, errors.Wrap(err, "proxy StoreStats()")
+	}
+	for _, w := range warnings {
+		q.warningReporter(errors.New(w))
+	}
+
+	res := CapacityStats{Stores: stats}
+	for _, s := range stats {
+		res.Total.NumBlocks += s.Stats.NumBlocks
+		res.Total.NumSeries += s.Stats.NumSeries
+		res.Total.NumChunks += s.Stats.NumChunks
+		res.Total.NumSamples += s.Stats.NumSamples
+		res.Total.NumBytes += s.Stats.NumBytes
+	}
+	return res, nil
+}
@@ -0,0 +1,67 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+)
+
+// statsStoreServer reports the given stats if they are requested, or fails Info if err is set.
+type statsStoreServer struct {
+	storeServer
+
+	stats *storepb.StoreStats
+	err   error
+}
+
+func (s *statsStoreServer) Info(_ context.Context, r *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	res := &storepb.InfoResponse{}
+	if r.ReportStats {
+		res.Stats = s.stats
+	}
+	return res, nil
+}
+
+func TestQuerier_CapacityStats(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	clients := []store.Client{
+		store.NewLocalClient(&statsStoreServer{stats: &storepb.StoreStats{NumBlocks: 3, NumSeries: 100, NumChunks: 400, NumSamples: 48000, NumBytes: 96000}}, "b"),
+		store.NewLocalClient(&statsStoreServer{stats: &storepb.StoreStats{NumBlocks: 1, NumSeries: 20, NumChunks: 20, NumSamples: 2000}}, "a"),
+		// Skipped, as it does not report stats.
+		store.NewLocalClient(&statsStoreServer{}, "no-stats"),
+		store.NewLocalClient(&statsStoreServer{err: errors.New("store failure")}, "failing"),
+	}
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) { return clients, nil }, nil, store.StoreLimit{})
+
+	var warns []error
+	q := newQuerier(context.Background(), nil, 0, 10, "", proxy, false, 0, true, func(err error) { warns = append(warns, err) }, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, err := q.CapacityStats()
+	testutil.Ok(t, err)
+	testutil.Equals(t, CapacityStats{
+		Stores: []store.StoreStats{
+			{Addr: "a", Stats: storepb.StoreStats{NumBlocks: 1, NumSeries: 20, NumChunks: 20, NumSamples: 2000}},
+			{Addr: "b", Stats: storepb.StoreStats{NumBlocks: 3, NumSeries: 100, NumChunks: 400, NumSamples: 48000, NumBytes: 96000}},
+		},
+		Total: storepb.StoreStats{NumBlocks: 4, NumSeries: 120, NumChunks: 420, NumSamples: 50000, NumBytes: 96000},
+	}, res)
+	testutil.Equals(t, 1, len(warns))
+
+	// Failures of stores fail the call if partial response is disabled.
+	q2 := newQuerier(context.Background(), nil, 0, 10, "", proxy, false, 0, false, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q2.Close()) }()
+
+	_, err = q2.CapacityStats()
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,117 @@
+package query
+
+import (
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/tracing"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+const (
+	// Assumptions used to estimate cost of series sent without chunks. They match defaults of Prometheus.
+	estimatedScrapeInterval = 15 * time.Second
+	estimatedChunkSamples   = 120
+	estimatedSampleBytes    = 1.5
+)
+
+// CostEstimate is an approximate cost of selecting series.
+type CostEstimate struct {
+	Series  int64 `json:"series"`
+	Chunks  int64 `json:"chunks"`
+	Samples int64 `json:"samples"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// EstimateCost estimates cost of selecting series matching the given matchers within the querier time range, so
+// callers like UIs can warn before running expensive queries. It runs a labels-only Series fanout. Chunks of stores
+// that send them regardless are counted exactly, the cost of series without chunks is estimated assuming a sample
+// every scrape interval, or every downsample window if the querier allows downsampled data.
+func (q *querier) EstimateCost(ms ...*labels.Matcher) (CostEstimate, error) {
+	if q.rangeErr != nil {
+		return CostEstimate{}, q.rangeErr
+	}
+	if err := q.checkRequiredLabels(ms); err != nil {
+		return CostEstimate{}, err
+	}
+	if q.expired {
+		return CostEstimate{}, nil
+	}
+
+	span, ctx := tracing.StartSpan(q.ctx, "querier_estimate_cost")
+	defer span.Finish()
+
+	sms, err := translateMatchers(simplifyMatchers(ms)...)
+	if err != nil {
+		return CostEstimate{}, errors.Wrap(err, "convert matchers")
+	}
+
+	resp := &seriesServer{ctx: ctx, partialResponse: q.partialResponse, duplicateLabels: q.duplicateLabels}
+	if err := q.proxy.Series(&storepb.SeriesRequest{
+		MinTime:                 q.mint,
+		MaxTime:                 q.maxt,
+		Matchers:                sms,
+		MaxResolutionWindow:     q.maxSourceResolution,
+		PartialResponseDisabled: !q.partialResponse,
+		SkipChunks:              true,
+	}, resp); err != nil {
+		return CostEstimate{}, errors.Wrap(err, "proxy Series()")
+	}
+
+	for _, w := range resp.warnings {
+		q.warningReporter(errors.New(w))
+	}
+
+	var c CostEstimate
+	for _, s := range resp.seriesSet {
+		c.Series++
+		if len(s.Chunks) == 0 {
+			c.addEstimatedSeries(q.mint, q.maxt, q.maxSourceResolution)
+			continue
+		}
+		for _, chk := range s.Chunks {
+			c.Chunks++
+			for _, raw := range []*storepb.Chunk{chk.Raw, chk.Count, chk.Sum, chk.Min, chk.Max, chk.Counter} {
+				if raw != nil {
+					c.Bytes += int64(len(raw.Data))
+				}
+			}
+			c.Samples += chunkSamples(chk)
+		}
+	}
+	return c, nil
+}
+
+// addEstimatedSeries adds estimated cost of a series spanning the whole [mint, maxt] range.
+func (c *CostEstimate) addEstimatedSeries(mint, maxt, resolution int64) {
+	interval := int64(estimatedScrapeInterval / time.Millisecond)
+	if resolution > interval {
+		interval = resolution
+	}
+	samples := (maxt-mint)/interval + 1
+
+	c.Samples += samples
+	c.Chunks += (samples + estimatedChunkSamples - 1) / estimatedChunkSamples
+	c.Bytes += int64(float64(samples) * estimatedSampleBytes)
+}
+
+// chunkSamples returns number of samples of the chunk. Downsampled chunks are counted by their count aggregate.
+func chunkSamples(c storepb.AggrChunk) int64 {
+	for _, chk := range []*storepb.Chunk{c.Raw, c.Count, c.Sum, c.Min, c.Max, c.Counter} {
+		if chk == nil || chk.Type != storepb.Chunk_XOR {
+			continue
+		}
+		data, err := chunkData(chk)
+		if err != nil || len(data) < 2 {
+			continue
+		}
+		xc, err := chunkenc.FromData(chunkenc.EncXOR, data)
+		if err != nil {
+			continue
+		}
+		return int64(xc.NumSamples())
+	}
+	return 0
+}
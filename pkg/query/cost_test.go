@@ -0,0 +1,97 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// skipChunksStoreServer omits chunks from series if the request asks for it.
+type skipChunksStoreServer struct {
+	storeServer
+}
+
+func (s *skipChunksStoreServer) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	for _, resp := range s.resps {
+		if r.SkipChunks {
+			resp = storepb.NewSeriesResponse(&storepb.Series{Labels: resp.GetSeries().Labels})
+		}
+		if err := srv.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestQuerier_EstimateCost(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Series scraped every 15s for an hour, cut into chunks of 120 samples like Prometheus does.
+	scraped := func(lset labels.Labels) *storepb.SeriesResponse {
+		var chks [][]sample
+		for i := 0; i < 240; i++ {
+			if i%120 == 0 {
+				chks = append(chks, nil)
+			}
+			chks[len(chks)-1] = append(chks[len(chks)-1], sample{int64(i+1) * 15000, float64(i)})
+		}
+		return storeSeriesResponse(t, lset, chks...)
+	}
+	resps := []*storepb.SeriesResponse{
+		scraped(labels.FromStrings("__name__", "up", "job", "a")),
+		scraped(labels.FromStrings("__name__", "up", "job", "b")),
+	}
+
+	for _, tcase := range []struct {
+		name  string
+		proxy storepb.StoreServer
+		exact bool
+	}{
+		{name: "store sending chunks regardless of skip_chunks", proxy: &storeServer{resps: resps}, exact: true},
+		{name: "store skipping chunks", proxy: &skipChunksStoreServer{storeServer{resps: resps}}},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			ctx := ContextWithChunkRefs(context.Background())
+			q := newQuerier(ctx, nil, 15000, 3600000, "", tcase.proxy, false, 0, true, nil, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			m, err := labels.NewMatcher(labels.MatchEqual, "__name__", "up")
+			testutil.Ok(t, err)
+
+			est, err := q.EstimateCost(m)
+			testutil.Ok(t, err)
+
+			res, _, err := q.Select(&storage.SelectParams{}, m)
+			testutil.Ok(t, err)
+
+			var actual CostEstimate
+			for res.Next() {
+				actual.Series++
+				s := res.At().(ChunkSeries)
+				for _, c := range s.Chunks() {
+					actual.Chunks++
+					actual.Bytes += int64(len(c.Raw.Data))
+				}
+				actual.Samples += int64(len(expandSeries(t, s.Iterator())))
+			}
+			testutil.Ok(t, res.Err())
+			testutil.Equals(t, CostEstimate{Series: 2, Chunks: 4, Samples: 480, Bytes: actual.Bytes}, actual)
+
+			if tcase.exact {
+				testutil.Equals(t, actual, est)
+				return
+			}
+			testutil.Equals(t, actual.Series, est.Series)
+			testutil.Equals(t, actual.Chunks, est.Chunks)
+			testutil.Equals(t, actual.Samples, est.Samples)
+			// Constant increments compress much better than real world data.
+			testutil.Assert(t, est.Bytes >= actual.Bytes, "expected estimated bytes %d to be at least %d", est.Bytes, actual.Bytes)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package query
+
+import (
+	"context"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+// DecodePool bounds the number of chunks decoded concurrently by all queriers of the query node. Decoding is CPU heavy,
+// so without a bound many concurrent queries can saturate CPU and starve the store fanout.
+type DecodePool struct {
+	slots  chan struct{}
+	queued prometheus.Gauge
+}
+
+// NewDecodePool returns DecodePool that allows at most size concurrent chunk decodes.
+func NewDecodePool(reg prometheus.Registerer, size int) *DecodePool {
+	p := &DecodePool{
+		slots: make(chan struct{}, size),
+		queued: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_query_chunk_decodes_queued",
+			Help: "Number of chunk decodes waiting for a free decode slot.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(p.queued)
+	}
+	return p
+}
+
+// decode waits for a free decode slot and fully decodes the given chunk iterator within it. Returned iterator serves
+// already decoded samples. If the context is canceled while waiting, the returned iterator holds the context error.
+func (p *DecodePool) decode(ctx context.Context, it chunkenc.Iterator) chunkenc.Iterator {
+	p.queued.Inc()
+	select {
+	case p.slots <- struct{}{}:
+		p.queued.Dec()
+	case <-ctx.Done():
+		p.queued.Dec()
+		return errSeriesIterator{errors.Wrap(ctx.Err(), "wait for chunk decode slot")}
+	}
+	defer func() { <-p.slots }()
+
+	d := &decodedChunkIterator{i: -1}
+	for it.Next() {
+		t, v := it.At()
+		d.ts = append(d.ts, t)
+		d.vs = append(d.vs, v)
+	}
+	d.err = it.Err()
+	return d
+}
+
+// decodedChunkIterator implements chunkenc.Iterator over eagerly decoded samples.
+type decodedChunkIterator struct {
+	ts  []int64
+	vs  []float64
+	i   int
+	err error
+}
+
+func (it *decodedChunkIterator) At() (int64, float64) {
+	if it.i < 0 || it.i >= len(it.ts) {
+		return 0, 0
+	}
+	return it.ts[it.i], it.vs[it.i]
+}
+
+func (it *decodedChunkIterator) Next() bool {
+	if it.i+1 >= len(it.ts) {
+		it.i = len(it.ts)
+		return false
+	}
+	it.i++
+	return true
+}
+
+func (it *decodedChunkIterator) Err() error { return it.err }
+
+// decodeInParallel returns true if the given number of chunks of the series should be decoded in parallel ahead of
+// iterating it. Only series with many chunks benefit from it, for others the coordination costs more than it saves.
+// Lazily decoded series are never decoded upfront.
+func (s *chunkSeries) decodeInParallel(chunks int) bool {
+	return !s.lazy && s.decodePool != nil && s.parallelDecode > 0 && chunks >= s.parallelDecode
+}
+
+// backgroundChunkIterators decodes the given chunks of the series in the background within the decode pool, by at most
+// the given number of chunks at the same time, and returns iterators over their samples in the order of chunks. Chunks
+// are decoded in order, and iterator of each chunk blocks only until that chunk is decoded, so iteration starts
+// without waiting for the rest of the series.
+func (s *chunkSeries) backgroundChunkIterators(chunks []storepb.AggrChunk, workers int) []chunkenc.Iterator {
+	var (
+		its     = make([]chunkenc.Iterator, len(chunks))
+		pending = make([]*pendingChunkIterator, len(chunks))
+		idx     = make(chan int, len(chunks))
+	)
+	for i := range chunks {
+		pending[i] = &pendingChunkIterator{done: make(chan struct{})}
+		its[i] = pending[i]
+		idx <- i
+	}
+	close(idx)
+
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range idx {
+				pending[i].it = s.chunkIterator(&chunks[i])
+				close(pending[i].done)
+			}
+		}()
+	}
+	return its
+}
+
+// pendingChunkIterator implements chunkenc.Iterator over a chunk decoded in the background. It blocks until the chunk
+// is decoded.
+type pendingChunkIterator struct {
+	done chan struct{}
+	it   chunkenc.Iterator
+}
+
+func (it *pendingChunkIterator) decoded() chunkenc.Iterator {
+	<-it.done
+	return it.it
+}
+
+func (it *pendingChunkIterator) At() (int64, float64) { return it.decoded().At() }
+func (it *pendingChunkIterator) Next() bool           { return it.decoded().Next() }
+func (it *pendingChunkIterator) Err() error           { return it.decoded().Err() }
@@ -0,0 +1,330 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+// trackingIterator records how many iterators are being decoded at the same time.
+type trackingIterator struct {
+	chunkenc.Iterator
+
+	started           bool
+	inFlight, maxSeen *int64
+}
+
+func (it *trackingIterator) Next() bool {
+	if !it.started {
+		it.started = true
+		n := atomic.AddInt64(it.inFlight, 1)
+		for {
+			max := atomic.LoadInt64(it.maxSeen)
+			if n <= max || atomic.CompareAndSwapInt64(it.maxSeen, max, n) {
+				break
+			}
+		}
+	}
+	if it.Iterator.Next() {
+		return true
+	}
+	// Give others chance to decode concurrently if pool would allow it.
+	time.Sleep(5 * time.Millisecond)
+	atomic.AddInt64(it.inFlight, -1)
+	return false
+}
+
+func TestDecodePool_BoundsConcurrentDecodes(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	c := chunkenc.NewXORChunk()
+	a, err := c.Appender()
+	testutil.Ok(t, err)
+	for i := 0; i < 100; i++ {
+		a.Append(int64(i), float64(i))
+	}
+
+	for _, size := range []int{1, 3} {
+		t.Run(fmt.Sprintf("size=%d", size), func(t *testing.T) {
+			p := NewDecodePool(nil, size)
+
+			var (
+				inFlight, maxSeen int64
+				wg                sync.WaitGroup
+				its               = make([]chunkenc.Iterator, 10)
+			)
+			for i := range its {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					its[i] = p.decode(context.Background(), &trackingIterator{Iterator: c.Iterator(), inFlight: &inFlight, maxSeen: &maxSeen})
+				}(i)
+			}
+			wg.Wait()
+
+			for _, it := range its {
+				n := 0
+				for it.Next() {
+					n++
+				}
+				testutil.Ok(t, it.Err())
+				testutil.Equals(t, 100, n)
+			}
+			testutil.Equals(t, int64(size), atomic.LoadInt64(&maxSeen))
+		})
+	}
+}
+
+func TestDecodePool_ContextCanceledWhileWaiting(t *testing.T) {
+	p := NewDecodePool(nil, 1)
+	// Occupy the only slot.
+	p.slots <- struct{}{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	it := p.decode(ctx, chunkenc.NewXORChunk().Iterator())
+	testutil.Assert(t, !it.Next(), "expected no samples")
+	testutil.NotOk(t, it.Err())
+}
+
+func TestQuerier_Select_DecodePool(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{0, 0}, {2, 1}, {3, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{2, 2}, {3, 3}, {4, 4}}, []sample{{5, 5}, {6, 6}}),
+	}}
+	q := newQuerier(context.Background(), nil, 0, 10, "", proxy, false, 0, true, nil, QuerierOpts{DecodePool: NewDecodePool(nil, 1)})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	var got [][]sample
+	for res.Next() {
+		got = append(got, expandSeries(t, res.At().Iterator()))
+	}
+	testutil.Ok(t, res.Err())
+	testutil.Equals(t, [][]sample{{{0, 0}, {2, 1}, {3, 2}}, {{2, 2}, {3, 3}, {4, 4}, {5, 5}, {6, 6}}}, got)
+}
+
+func TestQuerier_Select_ParallelDecode(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Series with 300 chunks of 120 samples, sent out of order like merged responses of multiple stores can be.
+	var (
+		chks [][]sample
+		exp  []sample
+	)
+	for i := 0; i < 300*120; i++ {
+		if i%120 == 0 {
+			chks = append(chks, nil)
+		}
+		s := sample{int64(i) * 15000, float64(i % 17)}
+		chks[len(chks)-1] = append(chks[len(chks)-1], s)
+		exp = append(exp, s)
+	}
+	chks[0], chks[150] = chks[150], chks[0]
+	resp := storeSeriesResponse(t, labels.FromStrings("a", "a"), chks...)
+
+	for _, tcase := range []struct {
+		name string
+		opts QuerierOpts
+	}{
+		{name: "serial", opts: QuerierOpts{DecodePool: NewDecodePool(nil, 4)}},
+		{name: "parallel", opts: QuerierOpts{DecodePool: NewDecodePool(nil, 4), ParallelDecodeMinChunks: 100}},
+		{name: "parallel with single decode slot", opts: QuerierOpts{DecodePool: NewDecodePool(nil, 1), ParallelDecodeMinChunks: 100}},
+		{name: "below min chunks", opts: QuerierOpts{DecodePool: NewDecodePool(nil, 4), ParallelDecodeMinChunks: 1000}},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			q := newQuerier(context.Background(), nil, 0, math.MaxInt64, "", &storeServer{resps: []*storepb.SeriesResponse{resp}}, false, 0, true, nil, tcase.opts)
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+
+			testutil.Assert(t, res.Next(), "expected series")
+			testutil.Equals(t, exp, expandSeries(t, res.At().Iterator()))
+			testutil.Assert(t, !res.Next(), "expected single series")
+			testutil.Ok(t, res.Err())
+		})
+	}
+}
+
+func BenchmarkDecodePool(b *testing.B) {
+	c := chunkenc.NewXORChunk()
+	a, err := c.Appender()
+	testutil.Ok(b, err)
+	for i := 0; i < 120; i++ {
+		a.Append(int64(i*15000), float64(i))
+	}
+
+	for _, size := range []int{0, 1, 4} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			// Size 0 means no pool, decodes are limited only by GOMAXPROCS.
+			var p *DecodePool
+			if size > 0 {
+				p = NewDecodePool(nil, size)
+			}
+			s := &chunkSeries{ctx: context.Background(), decodePool: p}
+			chk := &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					it := s.firstIterator(chk)
+					for it.Next() {
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestChunkSeries_SkipsChunksOutsideRange(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	chunk := func(mint, maxt int64) storepb.AggrChunk {
+		var smpls []sample
+		for ts := mint; ts <= maxt; ts += 10 {
+			smpls = append(smpls, sample{ts, float64(ts)})
+		}
+		return storepb.AggrChunk{MinTime: mint, MaxTime: maxt, Raw: xorChunk(t, smpls)}
+	}
+
+	for _, tcase := range []struct {
+		name string
+		set  func(s *chunkSeries)
+	}{
+		{name: "serial", set: func(*chunkSeries) {}},
+		{name: "lazy", set: func(s *chunkSeries) { s.lazy = true }},
+		{name: "parallel", set: func(s *chunkSeries) {
+			s.ctx, s.decodePool, s.parallelDecode = context.Background(), NewDecodePool(nil, 2), 1
+		}},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			// Only the middle chunk overlaps the series time range.
+			s := newChunkSeries(nil, []storepb.AggrChunk{chunk(0, 90), chunk(1000, 1090), chunk(2000, 2090)}, 500, 1500, resAggrAvg)
+			tcase.set(s)
+
+			// Filter sees every decoded sample, so it records which chunks were decoded.
+			var (
+				mtx     sync.Mutex
+				decoded []int64
+			)
+			s.filter = func(t int64, _ float64) bool {
+				mtx.Lock()
+				defer mtx.Unlock()
+				decoded = append(decoded, t)
+				return true
+			}
+
+			it := s.Iterator()
+			n := 0
+			for it.Next() {
+				n++
+			}
+			testutil.Ok(t, it.Err())
+			testutil.Equals(t, 10, n)
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			for _, ts := range decoded {
+				testutil.Assert(t, ts >= 1000 && ts <= 1090, "decoded sample %d of a chunk outside of the range", ts)
+			}
+		})
+	}
+}
+
+func TestChunkSeries_IteratorDoesNotWaitForDecodes(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var chks []storepb.AggrChunk
+	for i := int64(0); i < 3; i++ {
+		chks = append(chks, storepb.AggrChunk{MinTime: i * 10, MaxTime: i*10 + 1, Raw: xorChunk(t, []sample{{i * 10, 1}, {i*10 + 1, 2}})})
+	}
+
+	for _, tcase := range []struct {
+		name     string
+		parallel int
+	}{
+		{name: "serial"},
+		{name: "parallel", parallel: 1},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			s := newChunkSeries(nil, chks, math.MinInt64, math.MaxInt64, resAggrAvg)
+			s.ctx, s.decodePool, s.parallelDecode = context.Background(), NewDecodePool(nil, 1), tcase.parallel
+
+			// Hold the only decode slot, so no chunk can be decoded.
+			s.decodePool.slots <- struct{}{}
+
+			itc := make(chan storage.SeriesIterator)
+			go func() { itc <- s.Iterator() }()
+
+			var it storage.SeriesIterator
+			select {
+			case it = <-itc:
+			case <-time.After(5 * time.Second):
+				t.Fatal("iterator waits for chunks to be decoded")
+			}
+
+			<-s.decodePool.slots
+			n := 0
+			for it.Next() {
+				n++
+			}
+			testutil.Ok(t, it.Err())
+			testutil.Equals(t, 6, n)
+		})
+	}
+}
+
+func BenchmarkChunkSeries_ParallelDecode(b *testing.B) {
+	// Series of a year scraped every 15s, cut into chunks of 120 samples.
+	var chks []storepb.AggrChunk
+	for i := 0; i < 365*24*2; i++ {
+		c := chunkenc.NewXORChunk()
+		a, err := c.Appender()
+		testutil.Ok(b, err)
+		for j := 0; j < 120; j++ {
+			ts := int64(i*120+j) * 15000
+			a.Append(ts, float64(ts%1000))
+		}
+		chks = append(chks, storepb.AggrChunk{
+			MinTime: int64(i*120) * 15000,
+			MaxTime: int64(i*120+119) * 15000,
+			Raw:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()},
+		})
+	}
+
+	for _, minChunks := range []int{0, 1000} {
+		b.Run(fmt.Sprintf("min-chunks=%d", minChunks), func(b *testing.B) {
+			// Zero min chunks decodes serially.
+			s := newChunkSeries(nil, chks, math.MinInt64, math.MaxInt64, resAggrAvg)
+			s.ctx, s.decodePool, s.parallelDecode = context.Background(), NewDecodePool(nil, runtime.GOMAXPROCS(0)), minChunks
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				it := s.Iterator()
+				for it.Next() {
+				}
+				testutil.Ok(b, it.Err())
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package query
+
+import (
+	"context"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+// samplesPerEncodedChunk is the maximum number of samples of chunks re-encoded from deduplicated series, the same as
+// Prometheus cuts its chunks at.
+const samplesPerEncodedChunk = 120
+
+type dedupChunksKey struct{}
+
+// ContextWithDedupChunks returns a new context.Context that makes deduplicated series returned by queriers created
+// with it implement ChunkSeries, so consumers passing chunks through, like remote read, can use deduplication.
+// Series merged from multiple replicas are re-encoded from their merged samples into XOR chunks, holding values of
+// the aggregate requested for the selection and implement ChunkReplicasSeries. Series without other replicas keep the
+// chunks they were received with, unless they have samples outside of the querier time range. Those are re-encoded
+// too, so chunks hold the same samples as the series iterates.
+func ContextWithDedupChunks(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dedupChunksKey{}, true)
+}
+
+func dedupChunksFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(dedupChunksKey{}).(bool)
+	return v
+}
+
+// ChunkReplicasSeries is implemented by deduplicated series re-encoded into chunks, see ContextWithDedupChunks. It
+// tells which replica each chunk comes from, e.g. to debug quality of replicas.
+type ChunkReplicasSeries interface {
+	ChunkSeries
+	// ChunkReplicas returns, for each chunk returned by Chunks, value of the replica label of the replica that
+	// contributed most samples of the chunk, or empty string if not known. Ties go to the replica ranked first.
+	ChunkReplicas() []string
+}
+
+// encodedSeriesSet makes series of the wrapped set implement ChunkSeries. Series not exposing chunks, or exposing
+// chunks with samples outside of the querier time range, are re-encoded from their iterator once their chunks are
+// requested, so samples are clamped to the time range in a single place, the series iterator. Errors of encoding are
+// returned by the set.
+type encodedSeriesSet struct {
+	set storage.SeriesSet
+
+	cur storage.Series
+	err error
+}
+
+func newEncodedSeriesSet(set storage.SeriesSet) *encodedSeriesSet {
+	return &encodedSeriesSet{set: set}
+}
+
+func (s *encodedSeriesSet) Next() bool {
+	if s.err != nil || !s.set.Next() {
+		return false
+	}
+	series := s.set.At()
+	switch v := series.(type) {
+	case ChunkSeries:
+		if !isClamped(v) {
+			s.cur = v
+			return true
+		}
+	case seriesWithLabels:
+		if cs, ok := v.Series.(ChunkSeries); ok && !isClamped(cs) {
+			s.cur = chunkSeriesWithLabels{ChunkSeries: cs, lset: v.lset}
+			return true
+		}
+	}
+	s.cur = &encodedSeries{Series: series, set: s}
+	return true
+}
+
+func (s *encodedSeriesSet) At() storage.Series { return s.cur }
+
+func (s *encodedSeriesSet) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.set.Err()
+}
+
+// isClamped returns true if the series has samples outside of the querier time range.
+func isClamped(s storage.Series) bool {
+	c, ok := s.(ClampedSeries)
+	return ok && c.Clamped()
+}
+
+// encodeChunks encodes samples of the iterator into XOR chunks of at most samplesPerEncodedChunk samples. If the
+// iterator tracks replicas of its samples, it also returns index of the replica contributing most samples of each
+// chunk, or -1 if not known.
+func encodeChunks(it storage.SeriesIterator) ([]storepb.AggrChunk, []int, error) {
+	var (
+		res      []storepb.AggrChunk
+		majority []int
+		c        *chunkenc.XORChunk
+		app      chunkenc.Appender
+		err      error
+
+		tracker, _ = it.(replicaTracker)
+		counts     = map[int]int{}
+	)
+	for it.Next() {
+		t, v := it.At()
+		if c == nil || c.NumSamples() >= samplesPerEncodedChunk {
+			if c != nil {
+				majority = append(majority, majorityReplica(counts))
+			}
+			c = chunkenc.NewXORChunk()
+			if app, err = c.Appender(); err != nil {
+				return nil, nil, errors.Wrap(err, "create chunk appender")
+			}
+			res = append(res, storepb.AggrChunk{MinTime: t, Raw: &storepb.Chunk{Type: storepb.Chunk_XOR}})
+			counts = map[int]int{}
+		}
+		app.Append(t, v)
+		if tracker != nil {
+			counts[tracker.currentReplica()]++
+		}
+
+		last := &res[len(res)-1]
+		last.MaxTime = t
+		last.Raw.Data = c.Bytes()
+	}
+	if err := it.Err(); err != nil {
+		return nil, nil, err
+	}
+	if c != nil {
+		majority = append(majority, majorityReplica(counts))
+	}
+	return res, majority, nil
+}
+
+// majorityReplica returns the replica with most samples counted, preferring lower indexes on ties, or -1 if no
+// sample has a known replica.
+func majorityReplica(counts map[int]int) int {
+	best := -1
+	for r, n := range counts {
+		if r < 0 {
+			continue
+		}
+		if best < 0 || n > counts[best] || (n == counts[best] && r < best) {
+			best = r
+		}
+	}
+	return best
+}
+
+// chunkSeriesWithLabels is ChunkSeries with labels replaced, e.g. stripped of the replica label.
+type chunkSeriesWithLabels struct {
+	ChunkSeries
+	lset labels.Labels
+}
+
+func (s chunkSeriesWithLabels) Labels() labels.Labels { return s.lset }
+
+// Clamped implements ClampedSeries.
+func (s chunkSeriesWithLabels) Clamped() bool {
+	return isClamped(s.ChunkSeries)
+}
+
+// encodedSeries is series with its samples re-encoded into chunks when they are first requested. Iterating it
+// iterates the wrapped series, so samples are not decoded twice for consumers of either samples or chunks.
+type encodedSeries struct {
+	storage.Series
+	set *encodedSeriesSet
+
+	encoded  bool
+	chunks   []storepb.AggrChunk
+	replicas []string
+}
+
+// encode encodes samples of the series into chunks once. Errors are recorded in the set, as ChunkSeries can't return
+// them.
+func (s *encodedSeries) encode() {
+	if s.encoded {
+		return
+	}
+	s.encoded = true
+
+	chks, majority, err := encodeChunks(s.Series.Iterator())
+	if err != nil {
+		if s.set.err == nil {
+			s.set.err = errors.Wrapf(err, "encode chunks of series %s", s.Labels())
+		}
+		return
+	}
+	s.chunks = chks
+	if ds, ok := s.Series.(*dedupSeries); ok {
+		names := ds.replicaNames()
+		s.replicas = make([]string, 0, len(majority))
+		for _, r := range majority {
+			if r < 0 || r >= len(names) {
+				s.replicas = append(s.replicas, "")
+				continue
+			}
+			s.replicas = append(s.replicas, names[r])
+		}
+	}
+}
+
+// Chunks implements ChunkSeries.
+func (s *encodedSeries) Chunks() []storepb.AggrChunk {
+	s.encode()
+	return s.chunks
+}
+
+// ChunkReplicas implements ChunkReplicasSeries. Series not deduplicated from replicas know no replicas.
+func (s *encodedSeries) ChunkReplicas() []string {
+	s.encode()
+	if s.replicas == nil {
+		return make([]string, len(s.chunks))
+	}
+	return s.replicas
+}
+
+// Clamped implements ClampedSeries.
+func (s *encodedSeries) Clamped() bool {
+	return isClamped(s.Series)
+}
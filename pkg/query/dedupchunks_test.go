@@ -0,0 +1,159 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+func TestQuerier_Select_DedupChunks(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Replicas of the first series have gaps filled by each other, with more samples than fit a single chunk.
+	var r1, r2 []sample
+	for i := int64(0); i < 300; i++ {
+		s := sample{i * 10000, float64(i)}
+		if i < 100 || i >= 200 {
+			r1 = append(r1, s)
+		}
+		if i >= 50 {
+			r2 = append(r2, s)
+		}
+	}
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "1"), r1),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "2"), r2),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "1"), []sample{{10000, 1}, {20000, 2}}),
+	}}
+
+	selectAll := func(ctx context.Context) []storage.Series {
+		q := newQuerier(ctx, nil, 0, 3000000, "replica", proxy, true, 0, true, nil, QuerierOpts{})
+		defer func() { testutil.Ok(t, q.Close()) }()
+
+		res, _, err := q.Select(&storage.SelectParams{})
+		testutil.Ok(t, err)
+		var series []storage.Series
+		for res.Next() {
+			series = append(series, res.At())
+		}
+		testutil.Ok(t, res.Err())
+		return series
+	}
+
+	exp := selectAll(context.Background())
+	_, ok := exp[0].(ChunkSeries)
+	testutil.Assert(t, !ok, "expected deduplicated series not to expose chunks by default")
+
+	got := selectAll(ContextWithDedupChunks(context.Background()))
+	testutil.Equals(t, len(exp), len(got))
+	for i := range got {
+		testutil.Equals(t, exp[i].Labels(), got[i].Labels())
+
+		cs, ok := got[i].(ChunkSeries)
+		testutil.Assert(t, ok, "expected ChunkSeries, got %T", got[i])
+
+		// Chunks decode back to the merged samples.
+		var its []chunkenc.Iterator
+		for _, c := range cs.Chunks() {
+			testutil.Equals(t, storepb.Chunk_XOR, c.Raw.Type)
+			chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Raw.Data)
+			testutil.Ok(t, err)
+			testutil.Assert(t, chk.NumSamples() <= samplesPerEncodedChunk, "expected at most %d samples in chunk", samplesPerEncodedChunk)
+			its = append(its, chk.Iterator())
+		}
+		merged := expandSeries(t, exp[i].Iterator())
+		testutil.Equals(t, merged, expandSeries(t, newChunkSeriesIterator(its)))
+		testutil.Equals(t, merged, expandSeries(t, got[i].Iterator()))
+	}
+	testutil.Equals(t, 3, len(got[0].(ChunkSeries).Chunks()))
+	testutil.Equals(t, int64(0), got[0].(ChunkSeries).Chunks()[0].MinTime)
+	testutil.Equals(t, int64(2990000), got[0].(ChunkSeries).Chunks()[2].MaxTime)
+	// Series without other replicas keeps its chunks.
+	testutil.Equals(t, 1, len(got[1].(ChunkSeries).Chunks()))
+}
+
+func TestQuerier_Select_DedupChunksReplicas(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Replica 1 has a gap filled by replica 2, which is then followed until the end.
+	var r1, r2 []sample
+	for i := int64(0); i < 300; i++ {
+		s := sample{i * 10000, float64(i)}
+		if i < 100 || i >= 200 {
+			r1 = append(r1, s)
+		}
+		if i >= 50 {
+			r2 = append(r2, s)
+		}
+	}
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "1"), r1),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "2"), r2),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "1"), []sample{{10000, 1}, {20000, 2}}),
+	}}
+
+	q := newQuerier(ContextWithDedupChunks(context.Background()), nil, 0, 3000000, "replica", proxy, true, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	testutil.Assert(t, res.Next(), "expected first series")
+	rs, ok := res.At().(ChunkReplicasSeries)
+	testutil.Assert(t, ok, "expected ChunkReplicasSeries, got %T", res.At())
+	// First chunk has 100 samples of replica 1 and 20 of replica 2, the rest come from replica 2 only.
+	testutil.Equals(t, 3, len(rs.Chunks()))
+	testutil.Equals(t, []string{"1", "2", "2"}, rs.ChunkReplicas())
+
+	// Series without other replicas keeps its chunks and knows no replicas.
+	testutil.Assert(t, res.Next(), "expected second series")
+	_, ok = res.At().(ChunkReplicasSeries)
+	testutil.Assert(t, !ok, "expected series not deduplicated not to implement ChunkReplicasSeries")
+
+	testutil.Assert(t, !res.Next(), "expected no more series")
+	testutil.Ok(t, res.Err())
+}
+
+func TestQuerier_Select_DedupChunksClamped(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "1"), []sample{{10000, 1}, {20000, 2}}),
+		// Samples outside of the querier time range are dropped from chunks, like from iterated samples.
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "1"), []sample{{10000, 1}, {20000, 2}, {5000000, 3}}),
+	}}
+
+	q := newQuerier(ContextWithDedupChunks(context.Background()), nil, 0, 3000000, "replica", proxy, true, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	var chunks [][]storepb.AggrChunk
+	for res.Next() {
+		cs, ok := res.At().(ChunkSeries)
+		testutil.Assert(t, ok, "expected ChunkSeries, got %T", res.At())
+		chunks = append(chunks, cs.Chunks())
+
+		var its []chunkenc.Iterator
+		for _, c := range cs.Chunks() {
+			chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Raw.Data)
+			testutil.Ok(t, err)
+			its = append(its, chk.Iterator())
+		}
+		testutil.Equals(t, []sample{{10000, 1}, {20000, 2}}, expandSeries(t, newChunkSeriesIterator(its)))
+		testutil.Equals(t, []sample{{10000, 1}, {20000, 2}}, expandSeries(t, res.At().Iterator()))
+	}
+	testutil.Ok(t, res.Err())
+	testutil.Equals(t, 2, len(chunks))
+	// Series within the time range keeps its chunks.
+	testutil.Equals(t, proxy.resps[0].GetSeries().Chunks, chunks[0])
+	testutil.Equals(t, int64(20000), chunks[1][0].MaxTime)
+}
@@ -0,0 +1,128 @@
+package query
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+)
+
+type timeRange struct {
+	mint, maxt int64
+}
+
+func (r timeRange) overlaps(mint, maxt int64) bool {
+	return r.mint <= maxt && mint <= r.maxt
+}
+
+// fillDownsampledGaps fetches raw data for gaps in downsampled series that are larger than the downsample window and
+// the lookback delta.
+// Such gaps appear when some blocks of a store are not downsampled (yet), while raw data for them is still available.
+// Raw chunks overlapping the gaps are added to the series, so the series iterator uses them in place of missing
+// downsampled data.
+// Raw data of all gaps is fetched by a single request of the select matchers, sent only to stores that returned
+// downsampled chunks. Its chunks count towards the chunk bytes limit of the querier like the ones of the select.
+func (q *querier) fillDownsampledGaps(ctx context.Context, resp *seriesServer, sms []storepb.LabelMatcher, outcomes *store.StoreOutcomes) error {
+	if q.maxSourceResolution <= 0 {
+		return nil
+	}
+	addrs := outcomes.DownsampledStores()
+	if len(addrs) == 0 {
+		return nil
+	}
+	// Shorter gaps are covered by PromQL using the last sample before them.
+	window := q.maxSourceResolution
+	if q.lookbackDelta > window {
+		window = q.lookbackDelta
+	}
+
+	var (
+		gapped = map[string][]timeRange{}
+		series = map[string]*storepb.Series{}
+		all    = timeRange{mint: math.MaxInt64, maxt: math.MinInt64}
+	)
+	for i := range resp.seriesSet {
+		s := &resp.seriesSet[i]
+
+		gaps := downsampledGaps(s.Chunks, window)
+		if len(gaps) == 0 {
+			continue
+		}
+		key := storepb.LabelsToString(s.Labels)
+		gapped[key] = gaps
+		series[key] = s
+		if gaps[0].mint < all.mint {
+			all.mint = gaps[0].mint
+		}
+		if gaps[len(gaps)-1].maxt > all.maxt {
+			all.maxt = gaps[len(gaps)-1].maxt
+		}
+	}
+	if len(gapped) == 0 {
+		return nil
+	}
+
+	raw := &seriesServer{ctx: store.ContextWithStoreAddrs(ctx, addrs...), partialResponse: q.partialResponse, duplicateLabels: q.duplicateLabels}
+	if err := q.proxy.Series(&storepb.SeriesRequest{
+		MinTime:                 all.mint,
+		MaxTime:                 all.maxt,
+		Matchers:                sms,
+		PartialResponseDisabled: !q.partialResponse,
+		ReportQueriedBlocks:     q.reportBlocks,
+	}, raw); err != nil {
+		return errors.Wrapf(err, "fetch raw data for gaps of %d downsampled series", len(gapped))
+	}
+	resp.warnings = append(resp.warnings, raw.warnings...)
+	resp.queriedBlocks = append(resp.queriedBlocks, raw.queriedBlocks...)
+	resp.chunkBytes += raw.chunkBytes
+
+	for _, rs := range raw.seriesSet {
+		key := storepb.LabelsToString(rs.Labels)
+		gaps, ok := gapped[key]
+		if !ok {
+			continue
+		}
+		s := series[key]
+		for _, c := range rs.Chunks {
+			for _, g := range gaps {
+				if g.overlaps(c.MinTime, c.MaxTime) {
+					s.Chunks = append(s.Chunks, c)
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// downsampledGaps returns time ranges between chunks of a downsampled series that are larger than the window.
+// Series made of raw chunks only are not downsampled, so their gaps are genuine lack of data.
+func downsampledGaps(chunks []storepb.AggrChunk, window int64) (gaps []timeRange) {
+	downsampled := false
+	for _, c := range chunks {
+		if c.Raw == nil {
+			downsampled = true
+			break
+		}
+	}
+	if !downsampled || len(chunks) < 2 {
+		return nil
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].MinTime < chunks[j].MinTime
+	})
+
+	maxt := chunks[0].MaxTime
+	for _, c := range chunks[1:] {
+		if c.MinTime-maxt > window {
+			gaps = append(gaps, timeRange{mint: maxt + 1, maxt: c.MinTime - 1})
+		}
+		if c.MaxTime > maxt {
+			maxt = c.MaxTime
+		}
+	}
+	return gaps
+}
@@ -0,0 +1,169 @@
+package query
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+// resolutionStoreServer serves downsampled series if downsampling is allowed by the request and raw ones otherwise.
+type resolutionStoreServer struct {
+	// This field just exist to pseudo-implement the unused methods of the interface.
+	storepb.StoreServer
+
+	downsampled, raw []*storepb.SeriesResponse
+	reqs             []*storepb.SeriesRequest
+}
+
+func (s *resolutionStoreServer) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	return &storepb.InfoResponse{MinTime: math.MinInt64, MaxTime: math.MaxInt64}, nil
+}
+
+func (s *resolutionStoreServer) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	s.reqs = append(s.reqs, r)
+
+	resps := s.raw
+	if r.MaxResolutionWindow > 0 {
+		resps = s.downsampled
+	}
+	for _, resp := range resps {
+		if err := srv.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func xorChunk(t testing.TB, smpls []sample) *storepb.Chunk {
+	c := chunkenc.NewXORChunk()
+	a, err := c.Appender()
+	testutil.Ok(t, err)
+
+	for _, smpl := range smpls {
+		a.Append(smpl.t, smpl.v)
+	}
+	return &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()}
+}
+
+// avgChunk returns downsampled chunk with averages of the given samples, each aggregating 2 raw samples.
+func avgChunk(t testing.TB, smpls []sample) storepb.AggrChunk {
+	var counts, sums []sample
+	for _, s := range smpls {
+		counts = append(counts, sample{s.t, 2})
+		sums = append(sums, sample{s.t, 2 * s.v})
+	}
+	return storepb.AggrChunk{
+		MinTime: smpls[0].t,
+		MaxTime: smpls[len(smpls)-1].t,
+		Count:   xorChunk(t, counts),
+		Sum:     xorChunk(t, sums),
+	}
+}
+
+func TestQuerier_Select_FillsDownsampledGapsWithRawData(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	const window = 300000
+	lsetA := []storepb.Label{{Name: "a", Value: "a"}}
+	lsetB := []storepb.Label{{Name: "a", Value: "b"}}
+
+	gateway := &resolutionStoreServer{
+		downsampled: []*storepb.SeriesResponse{
+			storepb.NewSeriesResponse(&storepb.Series{Labels: lsetA, Chunks: []storepb.AggrChunk{
+				avgChunk(t, []sample{{300000, 1}, {600000, 2}}),
+				// Hole between 600000 and 2100000, e.g. block not downsampled yet.
+				avgChunk(t, []sample{{2100000, 7}, {2400000, 8}}),
+			}}),
+			storepb.NewSeriesResponse(&storepb.Series{Labels: lsetB, Chunks: []storepb.AggrChunk{
+				avgChunk(t, []sample{{300000, 1}, {600000, 2}}),
+				// Hole between 600000 and 1500000.
+				avgChunk(t, []sample{{1500000, 5}, {1800000, 6}}),
+			}}),
+			// Series without holes is not filled.
+			storepb.NewSeriesResponse(&storepb.Series{Labels: []storepb.Label{{Name: "a", Value: "c"}}, Chunks: []storepb.AggrChunk{
+				avgChunk(t, []sample{{300000, 1}, {600000, 2}}),
+				avgChunk(t, []sample{{900000, 3}, {1200000, 4}}),
+			}}),
+		},
+		raw: []*storepb.SeriesResponse{
+			storepb.NewSeriesResponse(&storepb.Series{Labels: lsetA, Chunks: []storepb.AggrChunk{
+				// Raw chunk outside of the hole is ignored.
+				{MinTime: 0, MaxTime: 540000, Raw: xorChunk(t, []sample{{0, 100}, {540000, 100}})},
+				{MinTime: 900000, MaxTime: 1800000, Raw: xorChunk(t, []sample{{900000, 3}, {1200000, 4}, {1500000, 5}, {1800000, 6}})},
+			}}),
+			storepb.NewSeriesResponse(&storepb.Series{Labels: lsetB, Chunks: []storepb.AggrChunk{
+				{MinTime: 900000, MaxTime: 1200000, Raw: xorChunk(t, []sample{{900000, 3}, {1200000, 4}})},
+			}}),
+			storepb.NewSeriesResponse(&storepb.Series{Labels: []storepb.Label{{Name: "a", Value: "c"}}, Chunks: []storepb.AggrChunk{
+				{MinTime: 0, MaxTime: 540000, Raw: xorChunk(t, []sample{{0, 100}, {540000, 100}})},
+			}}),
+		},
+	}
+	// Store with raw data only, e.g. sidecar, has no downsampled data to fill.
+	sidecar := &resolutionStoreServer{}
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) {
+		return []store.Client{store.NewLocalClient(gateway, "gateway"), store.NewLocalClient(sidecar, "sidecar")}, nil
+	}, nil, store.StoreLimit{})
+
+	q := newQuerier(context.Background(), nil, 1, 3000000, "", proxy, false, window, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	m, err := labels.NewMatcher(labels.MatchRegexp, "a", "a|b|c")
+	testutil.Ok(t, err)
+	res, _, err := q.Select(&storage.SelectParams{}, m)
+	testutil.Ok(t, err)
+
+	var got [][]sample
+	for res.Next() {
+		got = append(got, expandSeries(t, res.At().Iterator()))
+	}
+	testutil.Ok(t, res.Err())
+	testutil.Equals(t, [][]sample{
+		{{300000, 1}, {600000, 2}, {900000, 3}, {1200000, 4}, {1500000, 5}, {1800000, 6}, {2100000, 7}, {2400000, 8}},
+		{{300000, 1}, {600000, 2}, {900000, 3}, {1200000, 4}, {1500000, 5}, {1800000, 6}},
+		{{300000, 1}, {600000, 2}, {900000, 3}, {1200000, 4}},
+	}, got)
+
+	// Raw data for holes of all series is requested at once, only from the store that returned downsampled data.
+	testutil.Equals(t, 2, len(gateway.reqs))
+	testutil.Equals(t, int64(0), gateway.reqs[1].MaxResolutionWindow)
+	testutil.Equals(t, int64(600001), gateway.reqs[1].MinTime)
+	testutil.Equals(t, int64(2099999), gateway.reqs[1].MaxTime)
+	testutil.Equals(t, []storepb.LabelMatcher{{Type: storepb.LabelMatcher_RE, Name: "a", Value: "a|b|c"}}, gateway.reqs[1].Matchers)
+	testutil.Equals(t, 1, len(sidecar.reqs))
+}
+
+func TestDownsampledGaps(t *testing.T) {
+	raw := &storepb.Chunk{}
+	for _, tcase := range []struct {
+		chunks []storepb.AggrChunk
+		exp    []timeRange
+	}{
+		{
+			chunks: []storepb.AggrChunk{{MinTime: 0, MaxTime: 100}, {MinTime: 150, MaxTime: 200}},
+		},
+		{
+			chunks: []storepb.AggrChunk{{MinTime: 300, MaxTime: 400}, {MinTime: 0, MaxTime: 100}, {MinTime: 150, MaxTime: 200}},
+			exp:    []timeRange{{201, 299}},
+		},
+		{
+			// Chunk overlapping others is not a gap.
+			chunks: []storepb.AggrChunk{{MinTime: 0, MaxTime: 500}, {MinTime: 150, MaxTime: 200}, {MinTime: 550, MaxTime: 600}},
+		},
+		{
+			// Raw series have no downsampled gaps.
+			chunks: []storepb.AggrChunk{{MinTime: 0, MaxTime: 100, Raw: raw}, {MinTime: 300, MaxTime: 400, Raw: raw}},
+		},
+	} {
+		testutil.Equals(t, tcase.exp, downsampledGaps(tcase.chunks, 50))
+	}
+}
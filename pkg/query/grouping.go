@@ -0,0 +1,279 @@
+package query
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// GroupingOp defines how samples of series in the same group are aggregated.
+type GroupingOp string
+
+const (
+	// GroupingSum sums values of grouped series. It is the default.
+	GroupingSum GroupingOp = "sum"
+	// GroupingMin takes the minimum of values of grouped series.
+	GroupingMin GroupingOp = "min"
+	// GroupingMax takes the maximum of values of grouped series.
+	GroupingMax GroupingOp = "max"
+	// GroupingCount counts grouped series with a current sample.
+	GroupingCount GroupingOp = "count"
+)
+
+// ParseGroupingOp parses GroupingOp from its name.
+func ParseGroupingOp(s string) (GroupingOp, error) {
+	switch GroupingOp(s) {
+	case GroupingSum, GroupingMin, GroupingMax, GroupingCount:
+		return GroupingOp(s), nil
+	}
+	return "", errors.Errorf("unknown grouping op %q", s)
+}
+
+// Grouping specifies series merged into a single one by Select. Series are grouped by the listed labels, or by all
+// other labels if Without is set, like with PromQL aggregation. The metric name is dropped unless grouped by.
+type Grouping struct {
+	Labels  []string
+	Without bool
+	// Op aggregates samples of grouped series. Empty means GroupingSum.
+	Op GroupingOp
+}
+
+type groupingKey struct{}
+
+// ContextWithGrouping returns a new context.Context that makes queriers created with it merge selected series into
+// groups, after deduplication and before relabeling. It is an advanced optimization for heavy selections wrapped
+// only in the same aggregation, e.g. sum by (job) (rate(x[5m])) must not be grouped, as rate has to be applied to
+// every series first, while max by (job) (x) may be. It is never applied implicitly.
+//
+// Grouped series have a sample at every timestamp of any of their series, aggregating the latest sample of each
+// series not older than the lookback delta. Series are buffered in memory to be grouped.
+func ContextWithGrouping(ctx context.Context, g Grouping) context.Context {
+	return context.WithValue(ctx, groupingKey{}, &g)
+}
+
+func groupingFromContext(ctx context.Context) *Grouping {
+	g, _ := ctx.Value(groupingKey{}).(*Grouping)
+	return g
+}
+
+// groupLabels returns labels of the group of series with the given labels.
+func (g *Grouping) groupLabels(lset labels.Labels) labels.Labels {
+	b := labels.NewBuilder(lset)
+	if g.Without {
+		b.Del(labels.MetricName)
+		b.Del(g.Labels...)
+		return b.Labels()
+	}
+	res := make(labels.Labels, 0, len(g.Labels))
+	for _, l := range lset {
+		for _, n := range g.Labels {
+			if l.Name == n {
+				res = append(res, l)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// groupedSeriesSet holds series of the wrapped set merged into groups, ordered by group labels.
+type groupedSeriesSet struct {
+	series []storage.Series
+	i      int
+	err    error
+}
+
+func newGroupedSeriesSet(set storage.SeriesSet, g *Grouping, lookback int64) *groupedSeriesSet {
+	op := g.Op
+	if op == "" {
+		op = GroupingSum
+	}
+	groups := map[uint64][]*groupedSeries{}
+	s := &groupedSeriesSet{i: -1}
+	for set.Next() {
+		series := set.At()
+		lset := g.groupLabels(series.Labels())
+
+		var group *groupedSeries
+		h := lset.Hash()
+		for _, gs := range groups[h] {
+			if labels.Equal(gs.lset, lset) {
+				group = gs
+				break
+			}
+		}
+		if group == nil {
+			group = &groupedSeries{lset: lset, op: op, lookback: lookback}
+			groups[h] = append(groups[h], group)
+			s.series = append(s.series, group)
+		}
+		group.series = append(group.series, series)
+	}
+	s.err = set.Err()
+
+	sort.Slice(s.series, func(i, j int) bool {
+		return labels.Compare(s.series[i].Labels(), s.series[j].Labels()) < 0
+	})
+	return s
+}
+
+func (s *groupedSeriesSet) Next() bool {
+	if s.err != nil || s.i >= len(s.series)-1 {
+		return false
+	}
+	s.i++
+	return true
+}
+
+func (s *groupedSeriesSet) At() storage.Series { return s.series[s.i] }
+func (s *groupedSeriesSet) Err() error         { return s.err }
+
+type groupedSeries struct {
+	lset     labels.Labels
+	series   []storage.Series
+	op       GroupingOp
+	lookback int64
+}
+
+func (s *groupedSeries) Labels() labels.Labels { return s.lset }
+
+func (s *groupedSeries) Iterator() storage.SeriesIterator {
+	its := make([]storage.SeriesIterator, 0, len(s.series))
+	for _, series := range s.series {
+		its = append(its, series.Iterator())
+	}
+	return newGroupedSeriesIterator(its, s.op, s.lookback)
+}
+
+// groupedSeriesIterator aggregates samples of the wrapped iterators at every timestamp any of them has a sample at.
+type groupedSeriesIterator struct {
+	its      []storage.SeriesIterator
+	op       GroupingOp
+	lookback int64
+
+	// ok tells whether the iterator is at a sample not aggregated yet.
+	ok []bool
+	// Latest aggregated sample of each iterator, with hasLast telling whether there is any.
+	lastT   []int64
+	lastV   []float64
+	hasLast []bool
+
+	started bool
+	done    bool
+	t       int64
+	v       float64
+	err     error
+}
+
+func newGroupedSeriesIterator(its []storage.SeriesIterator, op GroupingOp, lookback int64) *groupedSeriesIterator {
+	return &groupedSeriesIterator{
+		its:      its,
+		op:       op,
+		lookback: lookback,
+		ok:       make([]bool, len(its)),
+		lastT:    make([]int64, len(its)),
+		lastV:    make([]float64, len(its)),
+		hasLast:  make([]bool, len(its)),
+	}
+}
+
+func (it *groupedSeriesIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.started {
+		it.started = true
+		for i, sit := range it.its {
+			it.ok[i] = sit.Next()
+		}
+	}
+	for {
+		t, found := int64(math.MaxInt64), false
+		for i, sit := range it.its {
+			if !it.ok[i] {
+				continue
+			}
+			if st, _ := sit.At(); st < t {
+				t, found = st, true
+			}
+		}
+		if !found {
+			it.done = true
+			for _, sit := range it.its {
+				if err := sit.Err(); err != nil {
+					it.err = err
+					return false
+				}
+			}
+			return false
+		}
+		for i, sit := range it.its {
+			if !it.ok[i] {
+				continue
+			}
+			if st, sv := sit.At(); st == t {
+				it.lastT[i], it.lastV[i], it.hasLast[i] = st, sv, true
+				it.ok[i] = sit.Next()
+			}
+		}
+		if v, ok := it.aggregate(t); ok {
+			it.t, it.v = t, v
+			return true
+		}
+		// Only staleness markers at t, nothing to aggregate.
+	}
+}
+
+// aggregate returns aggregate of the latest samples of iterators at t. It returns false if there are none.
+func (it *groupedSeriesIterator) aggregate(t int64) (float64, bool) {
+	var (
+		res float64
+		n   int
+	)
+	for i := range it.its {
+		if !it.hasLast[i] || t-it.lastT[i] > it.lookback || value.IsStaleNaN(it.lastV[i]) {
+			continue
+		}
+		v := it.lastV[i]
+		switch {
+		case n == 0:
+			res = v
+		case it.op == GroupingMin:
+			res = math.Min(res, v)
+		case it.op == GroupingMax:
+			res = math.Max(res, v)
+		default:
+			res += v
+		}
+		n++
+	}
+	if it.op == GroupingCount {
+		res = float64(n)
+	}
+	return res, n > 0
+}
+
+func (it *groupedSeriesIterator) Seek(t int64) bool {
+	if it.done || it.err != nil {
+		return false
+	}
+	if it.started && it.t >= t {
+		return true
+	}
+	// Latest samples before t are needed to aggregate samples at t, so samples can't be skipped.
+	for it.Next() {
+		if it.t >= t {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *groupedSeriesIterator) At() (int64, float64) { return it.t, it.v }
+
+func (it *groupedSeriesIterator) Err() error { return it.err }
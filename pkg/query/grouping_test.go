@@ -0,0 +1,104 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+func TestQuerier_Select_Grouping(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("__name__", "x", "instance", "1", "job", "a", "replica", "A"), []sample{{10000, 1}, {20000, 2}, {30000, 3}}),
+		storeSeriesResponse(t, labels.FromStrings("__name__", "x", "instance", "1", "job", "a", "replica", "B"), []sample{{10000, 1}, {20000, 2}, {30000, 3}}),
+		storeSeriesResponse(t, labels.FromStrings("__name__", "x", "instance", "2", "job", "a", "replica", "A"), []sample{{10000, 10}, {25000, 20}}),
+		storeSeriesResponse(t, labels.FromStrings("__name__", "x", "instance", "3", "job", "b", "replica", "A"), []sample{{10000, 100}}),
+	}}
+
+	for _, tcase := range []struct {
+		grouping Grouping
+		exp      map[string][]sample
+	}{
+		{
+			grouping: Grouping{Labels: []string{"job"}},
+			// Replicas are deduplicated before grouping. Series of instance 2 has no sample at 20000 and 30000, so
+			// its latest one is used.
+			exp: map[string][]sample{
+				`{job="a"}`: {{10000, 11}, {20000, 12}, {25000, 22}, {30000, 23}},
+				`{job="b"}`: {{10000, 100}},
+			},
+		},
+		{
+			grouping: Grouping{Labels: []string{"instance"}, Without: true, Op: GroupingMax},
+			exp: map[string][]sample{
+				`{job="a"}`: {{10000, 10}, {20000, 10}, {25000, 20}, {30000, 20}},
+				`{job="b"}`: {{10000, 100}},
+			},
+		},
+		{
+			grouping: Grouping{Op: GroupingCount},
+			exp: map[string][]sample{
+				`{}`: {{10000, 3}, {20000, 3}, {25000, 3}, {30000, 3}},
+			},
+		},
+	} {
+		t.Run("", func(t *testing.T) {
+			ctx := ContextWithGrouping(context.Background(), tcase.grouping)
+			q := newQuerier(ctx, nil, 1, 100000, "replica", proxy, true, 0, true, nil, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+
+			got := map[string][]sample{}
+			for res.Next() {
+				got[res.At().Labels().String()] = expandSeries(t, res.At().Iterator())
+			}
+			testutil.Ok(t, res.Err())
+			testutil.Equals(t, tcase.exp, got)
+		})
+	}
+}
+
+func TestGroupedSeriesIterator(t *testing.T) {
+	its := []storage.SeriesIterator{
+		xorSeriesIterator(t, []sample{{1000, 1}, {2000, 2}, {9000, 3}}),
+		xorSeriesIterator(t, []sample{{2000, 10}, {3000, 20}}),
+	}
+	// Latest samples are aggregated while not older than lookback, so the sample of the second iterator at 3000 is
+	// not summed at 9000.
+	it := newGroupedSeriesIterator(its, GroupingSum, 5000)
+	testutil.Equals(t, []sample{{1000, 1}, {2000, 12}, {3000, 22}, {9000, 3}}, expandSeries(t, it))
+
+	its = []storage.SeriesIterator{
+		xorSeriesIterator(t, []sample{{1000, 1}, {2000, 2}, {9000, 3}}),
+		xorSeriesIterator(t, []sample{{2000, 10}, {3000, 20}}),
+	}
+	it = newGroupedSeriesIterator(its, GroupingMin, 5000)
+	testutil.Assert(t, it.Seek(2500), "expected sample after seek")
+	tm, v := it.At()
+	testutil.Equals(t, sample{3000, 2}, sample{tm, v})
+	testutil.Assert(t, it.Seek(1000), "expected seek to earlier time to keep the current sample")
+	tm, v = it.At()
+	testutil.Equals(t, sample{3000, 2}, sample{tm, v})
+	testutil.Assert(t, !it.Seek(10000), "expected no sample after the last one")
+	testutil.Ok(t, it.Err())
+}
+
+func xorSeriesIterator(t *testing.T, samples []sample) storage.SeriesIterator {
+	c := chunkenc.NewXORChunk()
+	app, err := c.Appender()
+	testutil.Ok(t, err)
+	for _, s := range samples {
+		app.Append(s.t, s.v)
+	}
+	return newChunkSeriesIterator([]chunkenc.Iterator{c.Iterator()})
+}
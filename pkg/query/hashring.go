@@ -0,0 +1,112 @@
+package query
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/improbable-eng/thanos/pkg/store"
+)
+
+// StoreSelector selects subset of stores that should be used by the query node.
+type StoreSelector interface {
+	SelectStores(stores []store.Client) []store.Client
+}
+
+// SelectedStores returns function returning stores of the given function selected by the selector, to be used as
+// stores of the proxy. Nil selector selects all stores.
+func SelectedStores(sel StoreSelector, stores func(context.Context) ([]store.Client, error)) func(context.Context) ([]store.Client, error) {
+	if sel == nil {
+		return stores
+	}
+	return func(ctx context.Context) ([]store.Client, error) {
+		sts, err := stores(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return sel.SelectStores(sts), nil
+	}
+}
+
+// Number of tokens each query replica owns on the hash ring. More tokens spread stores more evenly.
+const hashringTokensPerReplica = 128
+
+type hashringToken struct {
+	hash    uint64
+	replica string
+}
+
+// HashringSelector is a StoreSelector for horizontally scaled query nodes. Each store is assigned to exactly one
+// query replica using consistent hashing on the store address, so membership changes reshuffle only a minimal
+// amount of stores. Results of all replicas are expected to be aggregated by a higher query tier.
+type HashringSelector struct {
+	self string
+
+	mtx    sync.RWMutex
+	tokens []hashringToken
+}
+
+// NewHashringSelector returns HashringSelector that selects stores assigned to the self replica within
+// the given query replicas.
+func NewHashringSelector(self string, replicas []string) *HashringSelector {
+	s := &HashringSelector{self: self}
+	s.SetReplicas(replicas)
+	return s
+}
+
+// SetReplicas updates the query replicas that stores are distributed across.
+func (s *HashringSelector) SetReplicas(replicas []string) {
+	tokens := make([]hashringToken, 0, len(replicas)*hashringTokensPerReplica)
+	for _, r := range replicas {
+		for i := 0; i < hashringTokensPerReplica; i++ {
+			tokens = append(tokens, hashringToken{hash: hashringHash(fmt.Sprintf("%s-%d", r, i)), replica: r})
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].hash == tokens[j].hash {
+			return tokens[i].replica < tokens[j].replica
+		}
+		return tokens[i].hash < tokens[j].hash
+	})
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.tokens = tokens
+}
+
+// Assign returns query replica the given store address is assigned to. Empty string is returned if there
+// are no replicas.
+func (s *HashringSelector) Assign(addr string) string {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if len(s.tokens) == 0 {
+		return ""
+	}
+	h := hashringHash(addr)
+	i := sort.Search(len(s.tokens), func(i int) bool { return s.tokens[i].hash >= h })
+	if i == len(s.tokens) {
+		// Wrap around the ring.
+		i = 0
+	}
+	return s.tokens[i].replica
+}
+
+// SelectStores returns stores assigned to this query replica.
+func (s *HashringSelector) SelectStores(stores []store.Client) []store.Client {
+	var res []store.Client
+	for _, st := range stores {
+		if s.Assign(st.Addr()) == s.self {
+			res = append(res, st)
+		}
+	}
+	return res
+}
+
+func hashringHash(s string) uint64 {
+	h := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(h[:8])
+}
@@ -0,0 +1,132 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+func TestHashringSelector_MinimalReshuffle(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 1000; i++ {
+		addrs = append(addrs, fmt.Sprintf("10.0.%d.%d:10901", i/256, i%256))
+	}
+
+	s := NewHashringSelector("query-0", []string{"query-0", "query-1", "query-2"})
+	before := map[string]string{}
+	perReplica := map[string]int{}
+	for _, addr := range addrs {
+		before[addr] = s.Assign(addr)
+		perReplica[before[addr]]++
+
+		// Assignment has to be stable.
+		testutil.Equals(t, before[addr], s.Assign(addr))
+	}
+	testutil.Equals(t, 3, len(perReplica))
+	for r, n := range perReplica {
+		testutil.Assert(t, n > 200, "replica %s got only %d stores", r, n)
+	}
+
+	// Adding replica should move stores only to the new replica.
+	s.SetReplicas([]string{"query-0", "query-1", "query-2", "query-3"})
+	moved := 0
+	for _, addr := range addrs {
+		after := s.Assign(addr)
+		if after == before[addr] {
+			continue
+		}
+		testutil.Equals(t, "query-3", after)
+		moved++
+	}
+	testutil.Assert(t, moved > 150 && moved < 350, "expected roughly quarter of stores to move, got %d", moved)
+
+	// Removing it again restores the previous assignment.
+	s.SetReplicas([]string{"query-0", "query-1", "query-2"})
+	for _, addr := range addrs {
+		testutil.Equals(t, before[addr], s.Assign(addr))
+	}
+}
+
+func TestHashringSelector_SelectStores(t *testing.T) {
+	var stores []store.Client
+	for i := 0; i < 20; i++ {
+		stores = append(stores, &storeRef{addr: fmt.Sprintf("store-%d:10901", i)})
+	}
+
+	replicas := []string{"query-0", "query-1"}
+	seen := map[string]int{}
+	for _, r := range replicas {
+		for _, st := range NewHashringSelector(r, replicas).SelectStores(stores) {
+			seen[st.Addr()]++
+		}
+	}
+	// Each store has to be queried by exactly one replica.
+	testutil.Equals(t, len(stores), len(seen))
+	for addr, n := range seen {
+		testutil.Assert(t, n == 1, "store %s selected %d times", addr, n)
+	}
+
+	testutil.Equals(t, 0, len(NewHashringSelector("query-0", nil).SelectStores(stores)))
+}
+
+func TestHashringSelector_Proxy(t *testing.T) {
+	var (
+		servers []*rangeStoreServer
+		clients []store.Client
+	)
+	for i := 0; i < 10; i++ {
+		srv := &rangeStoreServer{
+			storeServer: storeServer{resps: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("store", fmt.Sprintf("%d", i)), []sample{{1, 1}}),
+			}},
+			mint: math.MinInt64,
+			maxt: math.MaxInt64,
+		}
+		servers = append(servers, srv)
+		clients = append(clients, store.NewLocalClient(srv, fmt.Sprintf("store-%d:10901", i)))
+	}
+
+	replicas := []string{"query-0", "query-1"}
+	var all []string
+	for _, r := range replicas {
+		sel := NewHashringSelector(r, replicas)
+		proxy := store.NewProxyStore(nil, nil, SelectedStores(sel, func(context.Context) ([]store.Client, error) {
+			return clients, nil
+		}), nil, store.StoreLimit{})
+
+		q := newQuerier(context.Background(), nil, 0, 10, "", proxy, false, 0, false, nil, QuerierOpts{})
+		res, _, err := q.Select(&storage.SelectParams{})
+		testutil.Ok(t, err)
+
+		var got, exp []string
+		for res.Next() {
+			got = append(got, res.At().Labels().Get("store"))
+		}
+		testutil.Ok(t, res.Err())
+		testutil.Ok(t, q.Close())
+
+		// Only stores assigned to the replica are contacted.
+		for i, c := range clients {
+			if sel.Assign(c.Addr()) == r {
+				exp = append(exp, fmt.Sprintf("%d", i))
+			}
+		}
+		sort.Strings(exp)
+		testutil.Equals(t, exp, got)
+		all = append(all, got...)
+	}
+
+	// Replicas together query every store once.
+	testutil.Equals(t, len(clients), len(all))
+	for _, srv := range servers {
+		testutil.Equals(t, 1, srv.calls)
+	}
+}
@@ -1,12 +1,18 @@
 package query
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
 	"math"
 	"sort"
+	"sync"
 
 	"github.com/improbable-eng/thanos/pkg/compact/downsample"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/tsdb/chunkenc"
@@ -18,6 +24,19 @@ type promSeriesSet struct {
 	set        storepb.SeriesSet
 	mint, maxt int64
 	aggr       resAggr
+
+	// Optional pool bounding concurrent chunk decodes. Context is used to stop waiting for it.
+	ctx        context.Context
+	decodePool *DecodePool
+	// Minimum number of chunks of series decoded in parallel, see QuerierOpts.ParallelDecodeMinChunks.
+	parallelDecode int
+	lazy           bool
+	filter         SampleFilter
+	buckets        stepBuckets
+	// Handling of samples with duplicate timestamps within a series.
+	duplicateSamples DuplicateSamples
+	// If true, series hold only their latest sample, see ContextWithLatestSample.
+	latest bool
 }
 
 func (s promSeriesSet) Next() bool { return s.set.Next() }
@@ -25,7 +44,14 @@ func (s promSeriesSet) Err() error { return s.set.Err() }
 
 func (s promSeriesSet) At() storage.Series {
 	lset, chunks := s.set.At()
-	return newChunkSeries(lset, chunks, s.mint, s.maxt, s.aggr)
+	if s.latest {
+		chunks = latestSampleChunks(chunks, s.maxt)
+	}
+	series := newChunkSeries(lset, chunks, s.mint, s.maxt, s.aggr)
+	series.ctx, series.decodePool, series.lazy, series.filter = s.ctx, s.decodePool, s.lazy, s.filter
+	series.buckets, series.parallelDecode = s.buckets, s.parallelDecode
+	series.duplicateSamples, series.latest = s.duplicateSamples, s.latest
+	return series
 }
 
 func translateMatcher(m *labels.Matcher) (storepb.LabelMatcher, error) {
@@ -85,12 +111,49 @@ func (s storeSeriesSet) At() ([]storepb.Label, []storepb.AggrChunk) {
 	return ser.Labels, ser.Chunks
 }
 
+// ChunkSeries is implemented by series returned by queriers that expose chunks the series is made of. It allows
+// query engines to inspect chunk metadata and decode only the chunks they need, see ContextWithChunkRefs.
+// Deduplicated series merged from multiple replicas implement it only if requested by ContextWithDedupChunks.
+type ChunkSeries interface {
+	storage.Series
+	// Chunks returns chunks of the series ordered by their minimum time, with data decompressed if stores sent it
+	// compressed. They must not be modified.
+	Chunks() []storepb.AggrChunk
+}
+
+// ClampedSeries is implemented by series returned by queriers. It tells whether samples of the series outside of the
+// querier time range were dropped, so callers can tell data clamped at the edges of the range from sparse data.
+type ClampedSeries interface {
+	storage.Series
+	// Clamped returns true if the series has samples outside of the querier time range that are not iterated.
+	Clamped() bool
+}
+
 // chunkSeries implements storage.Series for a series on storepb types.
 type chunkSeries struct {
 	lset       labels.Labels
 	chunks     []storepb.AggrChunk
 	mint, maxt int64
 	aggr       resAggr
+
+	ctx        context.Context
+	decodePool *DecodePool
+	// If the series has at least that many chunks, they are decoded in parallel within the decode pool. Zero disables it.
+	parallelDecode int
+	// If true, chunks are decoded only once the series iterator reaches them.
+	lazy bool
+	// Optional filter of samples.
+	filter SampleFilter
+	// If enabled, raw series are downsampled to the step buckets.
+	buckets stepBuckets
+	// Handling of samples with duplicate timestamps, see DuplicateSamples.
+	duplicateSamples DuplicateSamples
+	// If true, only the last sample is iterated.
+	latest bool
+
+	// Chunks with data decompressed, returned by Chunks.
+	decompressOnce sync.Once
+	decompressed   []storepb.AggrChunk
 }
 
 func newChunkSeries(lset []storepb.Label, chunks []storepb.AggrChunk, mint, maxt int64, aggr resAggr) *chunkSeries {
@@ -112,49 +175,219 @@ func (s *chunkSeries) Labels() labels.Labels {
 }
 
 func (s *chunkSeries) Iterator() storage.SeriesIterator {
+	chunks := s.windowChunks()
+
+	var its []chunkenc.Iterator
+	switch {
+	case s.decodeInParallel(len(chunks)):
+		its = s.backgroundChunkIterators(chunks, cap(s.decodePool.slots))
+	case !s.lazy && s.decodePool != nil:
+		its = s.backgroundChunkIterators(chunks, 1)
+	default:
+		its = make([]chunkenc.Iterator, 0, len(chunks))
+		for i := range chunks {
+			c := &chunks[i]
+			if s.lazy {
+				its = append(its, &lazyChunkIterator{newIt: func() chunkenc.Iterator { return s.chunkIterator(c) }})
+				continue
+			}
+			its = append(its, s.chunkIterator(c))
+		}
+	}
+
 	var sit storage.SeriesIterator
-	its := make([]chunkenc.Iterator, 0, len(s.chunks))
+	switch s.aggr {
+	case resAggrCount, resAggrSum, resAggrMin, resAggrMax, resAggrAvg:
+		sit = newChunkSeriesIterator(its)
+		if cit, ok := sit.(*chunkSeriesIterator); ok {
+			cit.strictDuplicates = s.duplicateSamples == DuplicateSamplesError
+		}
+	case resAggrCounter:
+		// Counter iterator handles samples with duplicate timestamps on its own, as downsampled counter chunks end
+		// with one holding the true last value.
+		sit = downsample.NewCounterSeriesIterator(its...)
+	default:
+		return errSeriesIterator{err: errors.Errorf("unexpected result aggreagte type %v", s.aggr)}
+	}
+	sit = newBoundedSeriesIterator(sit, s.mint, s.maxt)
+	if s.buckets.enabled() && s.raw() {
+		sit = newStepAggrIterator(sit, s.buckets, s.aggr)
+	}
+	if s.latest {
+		sit = newLatestSampleIterator(sit)
+	}
+	return sit
+}
 
+// windowChunks returns chunks of the series overlapping its time range. Chunks span their first and last sample, so
+// all samples of other chunks would be dropped by bounding the series iterator. Skipping them avoids decoding chunks
+// of series spanning far beyond the queried range just to discard them. Chunks without time range are always kept.
+func (s *chunkSeries) windowChunks() []storepb.AggrChunk {
+	var res []storepb.AggrChunk
+	for i, c := range s.chunks {
+		if (c.MinTime == 0 && c.MaxTime == 0) || (c.MaxTime >= s.mint && c.MinTime <= s.maxt) {
+			if res != nil {
+				res = append(res, c)
+			}
+			continue
+		}
+		if res == nil {
+			res = make([]storepb.AggrChunk, i, len(s.chunks))
+			copy(res, s.chunks[:i])
+		}
+	}
+	if res == nil {
+		return s.chunks
+	}
+	return res
+}
+
+// Clamped implements ClampedSeries. Chunks span their first and last sample, so it needs no decoding.
+func (s *chunkSeries) Clamped() bool {
+	for _, c := range s.chunks {
+		if c.MinTime < s.mint || c.MaxTime > s.maxt {
+			return true
+		}
+	}
+	return false
+}
+
+// raw returns true if all chunks of the series hold raw data.
+func (s *chunkSeries) raw() bool {
+	for _, c := range s.chunks {
+		if c.Raw == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Chunks implements ChunkSeries. Data of chunks compressed by stores is decompressed on the first call.
+func (s *chunkSeries) Chunks() []storepb.AggrChunk {
+	s.decompressOnce.Do(func() {
+		s.decompressed = decompressChunks(s.chunks)
+	})
+	return s.decompressed
+}
+
+// decompressChunks returns the chunks with their data decompressed, or the given chunks if none is compressed.
+// Chunks failing to decompress are left out, iterating their series returns the error.
+func decompressChunks(chks []storepb.AggrChunk) []storepb.AggrChunk {
+	compressed := false
+	for _, c := range chks {
+		for _, raw := range []*storepb.Chunk{c.Raw, c.Count, c.Sum, c.Min, c.Max, c.Counter} {
+			if raw != nil && raw.Compression != storepb.Chunk_NONE {
+				compressed = true
+			}
+		}
+	}
+	if !compressed {
+		return chks
+	}
+
+	res := make([]storepb.AggrChunk, 0, len(chks))
+chunks:
+	for _, c := range chks {
+		for _, raw := range []**storepb.Chunk{&c.Raw, &c.Count, &c.Sum, &c.Min, &c.Max, &c.Counter} {
+			if *raw == nil || (*raw).Compression == storepb.Chunk_NONE {
+				continue
+			}
+			data, err := chunkData(*raw)
+			if err != nil {
+				continue chunks
+			}
+			*raw = &storepb.Chunk{Type: (*raw).Type, Data: data}
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// chunkIterator returns iterator over samples of the chunk, filtered if the series has a filter.
+func (s *chunkSeries) chunkIterator(c *storepb.AggrChunk) chunkenc.Iterator {
+	it := s.aggrIterator(c)
+	if s.filter == nil {
+		return it
+	}
+	return &filteringChunkIterator{it: it, filter: s.filter}
+}
+
+// aggrIterator returns iterator over the aggregate of the chunk requested for the series.
+func (s *chunkSeries) aggrIterator(c *storepb.AggrChunk) chunkenc.Iterator {
 	switch s.aggr {
 	case resAggrCount:
-		for _, c := range s.chunks {
-			its = append(its, getFirstIterator(c.Count, c.Raw))
-		}
-		sit = newChunkSeriesIterator(its)
+		return s.firstIterator(c.Count, c.Raw)
 	case resAggrSum:
-		for _, c := range s.chunks {
-			its = append(its, getFirstIterator(c.Sum, c.Raw))
-		}
-		sit = newChunkSeriesIterator(its)
+		return s.firstIterator(c.Sum, c.Raw)
 	case resAggrMin:
-		for _, c := range s.chunks {
-			its = append(its, getFirstIterator(c.Min, c.Raw))
-		}
-		sit = newChunkSeriesIterator(its)
+		return s.firstIterator(c.Min, c.Raw)
 	case resAggrMax:
-		for _, c := range s.chunks {
-			its = append(its, getFirstIterator(c.Max, c.Raw))
-		}
-		sit = newChunkSeriesIterator(its)
+		return s.firstIterator(c.Max, c.Raw)
 	case resAggrCounter:
-		for _, c := range s.chunks {
-			its = append(its, getFirstIterator(c.Counter, c.Raw))
-		}
-		sit = downsample.NewCounterSeriesIterator(its...)
+		return s.firstIterator(c.Counter, c.Raw)
 	case resAggrAvg:
-		for _, c := range s.chunks {
-			if c.Raw != nil {
-				its = append(its, getFirstIterator(c.Raw))
-			} else {
-				sum, cnt := getFirstIterator(c.Sum), getFirstIterator(c.Count)
-				its = append(its, downsample.NewAverageChunkIterator(cnt, sum))
-			}
+		if c.Raw != nil {
+			return s.firstIterator(c.Raw)
 		}
-		sit = newChunkSeriesIterator(its)
-	default:
-		return errSeriesIterator{err: errors.Errorf("unexpected result aggreagte type %v", s.aggr)}
+		sum, cnt := s.firstIterator(c.Sum), s.firstIterator(c.Count)
+		return downsample.NewAverageChunkIterator(cnt, sum)
+	}
+	return errSeriesIterator{err: errors.Errorf("unexpected result aggreagte type %v", s.aggr)}
+}
+
+// lazyChunkIterator creates the underlying chunk iterator only when the first sample is requested, so chunks
+// that are never reached by the series iterator are not decoded.
+type lazyChunkIterator struct {
+	newIt func() chunkenc.Iterator
+	it    chunkenc.Iterator
+}
+
+func (it *lazyChunkIterator) At() (int64, float64) {
+	if it.it == nil {
+		return 0, 0
+	}
+	return it.it.At()
+}
+
+func (it *lazyChunkIterator) Next() bool {
+	if it.it == nil {
+		it.it = it.newIt()
 	}
-	return newBoundedSeriesIterator(sit, s.mint, s.maxt)
+	return it.it.Next()
+}
+
+func (it *lazyChunkIterator) Err() error {
+	if it.it == nil {
+		return nil
+	}
+	return it.it.Err()
+}
+
+// filteringChunkIterator skips samples of the wrapped iterator rejected by the filter.
+type filteringChunkIterator struct {
+	it     chunkenc.Iterator
+	filter SampleFilter
+}
+
+func (it *filteringChunkIterator) At() (int64, float64) { return it.it.At() }
+func (it *filteringChunkIterator) Err() error           { return it.it.Err() }
+
+func (it *filteringChunkIterator) Next() bool {
+	for it.it.Next() {
+		if it.filter(it.it.At()) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstIterator returns iterator of the first non-nil chunk. If decode pool is configured, the chunk is decoded within it.
+func (s *chunkSeries) firstIterator(cs ...*storepb.Chunk) chunkenc.Iterator {
+	it := getFirstIterator(cs...)
+	if s.decodePool == nil {
+		return it
+	}
+	return s.decodePool.decode(s.ctx, it)
 }
 
 func getFirstIterator(cs ...*storepb.Chunk) chunkenc.Iterator {
@@ -162,7 +395,26 @@ func getFirstIterator(cs ...*storepb.Chunk) chunkenc.Iterator {
 		if c == nil {
 			continue
 		}
-		chk, err := chunkenc.FromData(chunkEncoding(c.Type), c.Data)
+		data, err := chunkData(c)
+		if err != nil {
+			return errSeriesIterator{err}
+		}
+		switch c.Type {
+		case storepb.Chunk_XOR:
+			// Decoding XOR chunk requires its 2 bytes header holding number of samples. Chunk without any data
+			// has no samples. Stores should not send such chunks, but it must not fail the query.
+			if len(data) == 0 {
+				return errSeriesIterator{}
+			}
+			if len(data) < 2 {
+				return errSeriesIterator{errors.Errorf("XOR chunk too short: %d bytes", len(data))}
+			}
+		case storepb.Chunk_DELTA:
+			return newDeltaIterator(data)
+		case storepb.Chunk_DOUBLE_DELTA:
+			return newDoubleDeltaIterator(data)
+		}
+		chk, err := chunkenc.FromData(chunkEncoding(c.Type), data)
 		if err != nil {
 			return errSeriesIterator{err}
 		}
@@ -171,6 +423,26 @@ func getFirstIterator(cs ...*storepb.Chunk) chunkenc.Iterator {
 	return errSeriesIterator{errors.New("no valid chunk found")}
 }
 
+// chunkData returns data of the chunk, decompressed if the store sent it compressed. Chunks are decompressed only
+// when decoded, so data of chunks outside of the queried range stays compressed.
+func chunkData(c *storepb.Chunk) ([]byte, error) {
+	switch c.Compression {
+	case storepb.Chunk_NONE:
+		return c.Data, nil
+	case storepb.Chunk_GZIP:
+		r, err := gzip.NewReader(bytes.NewReader(c.Data))
+		if err != nil {
+			return nil, errors.Wrap(err, "read gzip chunk")
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "decompress gzip chunk")
+		}
+		return b, nil
+	}
+	return nil, errors.Errorf("unknown chunk compression %s", c.Compression)
+}
+
 func chunkEncoding(e storepb.Chunk_Encoding) chunkenc.Encoding {
 	switch e {
 	case storepb.Chunk_XOR:
@@ -236,9 +508,16 @@ func (it *boundedSeriesIterator) Err() error {
 
 // chunkSeriesIterator implements a series iterator on top
 // of a list of time-sorted, non-overlapping chunks.
+// Samples not after the previous one are dropped, so the series never goes back in time and samples with duplicate
+// timestamps, e.g. from overlapping chunks, are iterated once with the first value.
 type chunkSeriesIterator struct {
 	chunks []chunkenc.Iterator
 	i      int
+
+	// If true, a sample with the same timestamp as the previous one but a different value fails the iteration.
+	strictDuplicates bool
+	started          bool
+	err              error
 }
 
 func newChunkSeriesIterator(cs []chunkenc.Iterator) storage.SeriesIterator {
@@ -270,30 +549,50 @@ func (it *chunkSeriesIterator) At() (t int64, v float64) {
 }
 
 func (it *chunkSeriesIterator) Next() bool {
-	lastT, _ := it.At()
-
-	if it.chunks[it.i].Next() {
-		return true
-	}
-	if it.Err() != nil {
+	if it.err != nil {
 		return false
 	}
-	if it.i >= len(it.chunks)-1 {
-		return false
+	lastT, lastV := it.At()
+
+	for {
+		if !it.chunks[it.i].Next() {
+			if it.chunks[it.i].Err() != nil || it.i >= len(it.chunks)-1 {
+				return false
+			}
+			// Chunks are guaranteed to be ordered but not generally guaranteed to not overlap, e.g at the seam
+			// of the same series served by different stores for complementary time ranges.
+			// We must ensure to skip any overlapping range between adjacent chunks.
+			it.i++
+			continue
+		}
+		t, v := it.chunks[it.i].At()
+		if !it.started || t > lastT {
+			it.started = true
+			return true
+		}
+		if t == lastT && it.strictDuplicates && math.Float64bits(v) != math.Float64bits(lastV) {
+			it.err = errors.Errorf("samples with duplicate timestamp %d have different values %v and %v", t, lastV, v)
+			return false
+		}
 	}
-	// Chunks are guaranteed to be ordered but not generally guaranteed to not overlap.
-	// We must ensure to skip any overlapping range between adjacent chunks.
-	it.i++
-	return it.Seek(lastT + 1)
 }
 
 func (it *chunkSeriesIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
 	return it.chunks[it.i].Err()
 }
 
 type dedupSeriesSet struct {
 	set          storage.SeriesSet
 	replicaLabel string
+	strategy     DedupStrategy
+	smoothing    float64
+	lookback     int64
+	stats        *dedupStats
+	// Rank of replica label values preferred by deduplication, lower first.
+	priority map[string]int
 
 	replicas []storage.Series
 	lset     labels.Labels
@@ -301,8 +600,22 @@ type dedupSeriesSet struct {
 	ok       bool
 }
 
-func newDedupSeriesSet(set storage.SeriesSet, replicaLabel string) storage.SeriesSet {
-	s := &dedupSeriesSet{set: set, replicaLabel: replicaLabel}
+// newDedupSeriesSet returns series set deduplicating series along the replicaLabel. If smoothing is positive, values
+// at replica switches differing by at most that relative tolerance are blended. If lookback is positive, samples of
+// other replicas further than lookback after the last sample of the followed one are never skipped by penalty. If
+// stats is not nil, per replica sample contribution of deduplicated series is recorded in it. Replicas with replica label
+// values listed in priority are preferred in the listed order over other replicas, including for samples at equal
+// timestamps, otherwise replicas are preferred in order of their labels.
+func newDedupSeriesSet(set storage.SeriesSet, replicaLabel string, strategy DedupStrategy, smoothing float64, lookback int64, stats *dedupStats, priority []string) storage.SeriesSet {
+	s := &dedupSeriesSet{set: set, replicaLabel: replicaLabel, strategy: strategy, smoothing: smoothing, lookback: lookback, stats: stats}
+	if len(priority) > 0 {
+		s.priority = make(map[string]int, len(priority))
+		for i, v := range priority {
+			if _, ok := s.priority[v]; !ok {
+				s.priority[v] = i
+			}
+		}
+	}
 	s.ok = s.set.Next()
 	if s.ok {
 		s.peek = s.set.At()
@@ -351,6 +664,8 @@ func (s *dedupSeriesSet) next() bool {
 }
 
 func (s *dedupSeriesSet) At() storage.Series {
+	// Series without counterpart to deduplicate against are passed through as they are, without any
+	// deduplication bookkeeping.
 	if len(s.replicas) == 1 {
 		return seriesWithLabels{Series: s.replicas[0], lset: s.lset}
 	}
@@ -358,13 +673,69 @@ func (s *dedupSeriesSet) At() storage.Series {
 	// before advancing.
 	repl := make([]storage.Series, len(s.replicas))
 	copy(repl, s.replicas)
-	return newDedupSeries(s.lset, repl...)
+	if s.priority != nil {
+		sort.SliceStable(repl, func(i, j int) bool {
+			return s.rank(repl[i]) < s.rank(repl[j])
+		})
+	}
+	series := newDedupSeries(s.lset, s.strategy, repl...)
+	series.replicaLabel, series.smoothing, series.lookback, series.stats = s.replicaLabel, s.smoothing, s.lookback, s.stats
+	return series
+}
+
+// rank returns rank of the replica in the priority list. Replicas not listed rank after all listed ones.
+func (s *dedupSeriesSet) rank(r storage.Series) int {
+	if i, ok := s.priority[r.Labels().Get(s.replicaLabel)]; ok {
+		return i
+	}
+	return len(s.priority)
 }
 
 func (s *dedupSeriesSet) Err() error {
 	return s.set.Err()
 }
 
+// hashOrderedSeriesSet holds all series of the wrapped set ordered by hash of their labels.
+// Series with colliding hashes are ordered by their labels.
+type hashOrderedSeriesSet struct {
+	series []hashedSeries
+	i      int
+	err    error
+}
+
+type hashedSeries struct {
+	hash uint64
+	storage.Series
+}
+
+func newHashOrderedSeriesSet(set storage.SeriesSet) *hashOrderedSeriesSet {
+	s := &hashOrderedSeriesSet{i: -1}
+	for set.Next() {
+		series := set.At()
+		s.series = append(s.series, hashedSeries{hash: series.Labels().Hash(), Series: series})
+	}
+	s.err = set.Err()
+
+	sort.Slice(s.series, func(i, j int) bool {
+		if s.series[i].hash != s.series[j].hash {
+			return s.series[i].hash < s.series[j].hash
+		}
+		return labels.Compare(s.series[i].Labels(), s.series[j].Labels()) < 0
+	})
+	return s
+}
+
+func (s *hashOrderedSeriesSet) Next() bool {
+	if s.err != nil || s.i >= len(s.series)-1 {
+		return false
+	}
+	s.i++
+	return true
+}
+
+func (s *hashOrderedSeriesSet) At() storage.Series { return s.series[s.i].Series }
+func (s *hashOrderedSeriesSet) Err() error         { return s.err }
+
 type seriesWithLabels struct {
 	storage.Series
 	lset labels.Labels
@@ -374,25 +745,278 @@ func (s seriesWithLabels) Labels() labels.Labels { return s.lset }
 
 type dedupSeries struct {
 	lset     labels.Labels
+	strategy DedupStrategy
 	replicas []storage.Series
+
+	replicaLabel string
+	smoothing    float64
+	lookback     int64
+	stats        *dedupStats
 }
 
-func newDedupSeries(lset labels.Labels, replicas ...storage.Series) *dedupSeries {
-	return &dedupSeries{lset: lset, replicas: replicas}
+func newDedupSeries(lset labels.Labels, strategy DedupStrategy, replicas ...storage.Series) *dedupSeries {
+	return &dedupSeries{lset: lset, strategy: strategy, replicas: replicas}
 }
 
 func (s *dedupSeries) Labels() labels.Labels {
 	return s.lset
 }
 
-func (s *dedupSeries) Iterator() (it storage.SeriesIterator) {
-	it = s.replicas[0].Iterator()
-	for _, o := range s.replicas[1:] {
-		it = newDedupSeriesIterator(it, o.Iterator())
+// Clamped implements ClampedSeries. Deduplicated series is clamped if any of its replicas is.
+func (s *dedupSeries) Clamped() bool {
+	for _, r := range s.replicas {
+		if c, ok := r.(ClampedSeries); ok && c.Clamped() {
+			return true
+		}
+	}
+	return false
+}
+
+// Iterator returns iterator of the deduplicated series. Replicas covering disjoint time windows, e.g. one restarted
+// with only recent data, are stitched one after another, so samples following a gap between windows are never skipped
+// as too close to the other replica. Replicas with overlapping windows are deduplicated.
+func (s *dedupSeries) Iterator() storage.SeriesIterator {
+	windows := s.windows()
+	if len(windows) == 1 {
+		return s.dedupIterator(windows[0], s.stats != nil)
+	}
+	var counters *seriesDedupCounters
+	if s.stats != nil {
+		counters = s.stats.newSeries(s.lset, s.replicaNames)
+	}
+	its := make([]storage.SeriesIterator, 0, len(windows))
+	for _, w := range windows {
+		it := s.dedupIterator(w, counters == nil && s.stats != nil)
+		if counters != nil {
+			if dit, ok := it.(*dedupSeriesIterator); ok {
+				dit.counters = counters
+			} else {
+				it = &countingSeriesIterator{SeriesIterator: it, counters: counters, replica: w[0]}
+			}
+		}
+		its = append(its, it)
+	}
+	return &stitchedSeriesIterator{its: its}
+}
+
+// windows groups replicas into time windows of overlapping replicas, ordered by time. Replicas within a window are
+// kept in their order. All replicas are in a single window if time range of any of them is not known.
+func (s *dedupSeries) windows() [][]int {
+	type window struct {
+		mint, maxt int64
+		replicas   []int
+	}
+	ranges := make([]window, 0, len(s.replicas))
+	for i, r := range s.replicas {
+		mint, maxt, ok := seriesTimeRange(r)
+		if !ok {
+			all := make([]int, 0, len(s.replicas))
+			for i := range s.replicas {
+				all = append(all, i)
+			}
+			return [][]int{all}
+		}
+		ranges = append(ranges, window{mint: mint, maxt: maxt, replicas: []int{i}})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].mint < ranges[j].mint })
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.mint > last.maxt {
+			merged = append(merged, r)
+			continue
+		}
+		last.replicas = append(last.replicas, r.replicas...)
+		if r.maxt > last.maxt {
+			last.maxt = r.maxt
+		}
+	}
+	res := make([][]int, 0, len(merged))
+	for _, w := range merged {
+		sort.Ints(w.replicas)
+		res = append(res, w.replicas)
+	}
+	return res
+}
+
+// dedupIterator returns iterator deduplicating the given replicas, counting samples of the outermost iterator if
+// count is true.
+func (s *dedupSeries) dedupIterator(replicas []int, count bool) (it storage.SeriesIterator) {
+	it = s.replicaIterator(replicas[0])
+	maxt, known := seriesMaxTime(s.replicas[replicas[0]])
+
+	var dit *dedupSeriesIterator
+	for _, i := range replicas[1:] {
+		o := s.replicas[i]
+		dit = newDedupSeriesIterator(it, s.replicaIterator(i))
+		dit.smoothing, dit.lookback = s.smoothing, s.lookback
+		it = dit
+
+		if s.strategy != DedupFreshest || !known {
+			continue
+		}
+		omaxt, ok := seriesMaxTime(o)
+		if !ok {
+			known = false
+			continue
+		}
+		// Trailing edge starts after the last sample of the staler side.
+		if maxt >= omaxt {
+			dit.edge, dit.freshA = omaxt, true
+			continue
+		}
+		dit.edge, dit.freshA = maxt, false
+		maxt = omaxt
+	}
+	if count && dit != nil {
+		// Count samples on the outermost iterator only, as only its samples make it to the output.
+		dit.counters = s.stats.newSeries(s.lset, s.replicaNames)
+		if dit.counters == nil {
+			dit.rescues = s.stats.rescuedSamples()
+		}
 	}
 	return it
 }
 
+func (s *dedupSeries) replicaIterator(i int) storage.SeriesIterator {
+	if s.stats == nil {
+		return s.replicas[i].Iterator()
+	}
+	return replicaSeriesIterator{SeriesIterator: s.replicas[i].Iterator(), replica: i}
+}
+
+func (s *dedupSeries) replicaNames() []string {
+	names := make([]string, 0, len(s.replicas))
+	for _, r := range s.replicas {
+		names = append(names, r.Labels().Get(s.replicaLabel))
+	}
+	return names
+}
+
+// seriesTimeRange returns bounds of sample timestamps of the series based on its chunks, if known.
+func seriesTimeRange(s storage.Series) (mint, maxt int64, ok bool) {
+	cs, ok := s.(*chunkSeries)
+	if !ok || len(cs.chunks) == 0 {
+		return 0, 0, false
+	}
+	mint = cs.chunks[0].MinTime
+	for _, c := range cs.chunks[1:] {
+		if c.MinTime < mint {
+			mint = c.MinTime
+		}
+	}
+	if mint < cs.mint {
+		mint = cs.mint
+	}
+	maxt, _ = seriesMaxTime(s)
+	return mint, maxt, true
+}
+
+// seriesMaxTime returns the upper bound of sample timestamps of the series based on its chunks, if known.
+func seriesMaxTime(s storage.Series) (int64, bool) {
+	cs, ok := s.(*chunkSeries)
+	if !ok || len(cs.chunks) == 0 {
+		return 0, false
+	}
+	maxt := cs.chunks[0].MaxTime
+	for _, c := range cs.chunks[1:] {
+		if c.MaxTime > maxt {
+			maxt = c.MaxTime
+		}
+	}
+	if maxt > cs.maxt {
+		maxt = cs.maxt
+	}
+	return maxt, true
+}
+
+// stitchedSeriesIterator iterates the given iterators with disjoint time ranges one after another.
+type stitchedSeriesIterator struct {
+	its []storage.SeriesIterator
+	i   int
+}
+
+func (it *stitchedSeriesIterator) Next() bool {
+	for ; it.i < len(it.its); it.i++ {
+		if it.its[it.i].Next() {
+			return true
+		}
+		if it.its[it.i].Err() != nil {
+			return false
+		}
+	}
+	return false
+}
+
+func (it *stitchedSeriesIterator) Seek(t int64) bool {
+	for ; it.i < len(it.its); it.i++ {
+		if it.its[it.i].Seek(t) {
+			return true
+		}
+		if it.its[it.i].Err() != nil {
+			return false
+		}
+	}
+	return false
+}
+
+func (it *stitchedSeriesIterator) At() (int64, float64) {
+	if it.i >= len(it.its) {
+		return 0, 0
+	}
+	return it.its[it.i].At()
+}
+
+func (it *stitchedSeriesIterator) Err() error {
+	if it.i >= len(it.its) {
+		return nil
+	}
+	return it.its[it.i].Err()
+}
+
+// currentReplica implements replicaTracker.
+func (it *stitchedSeriesIterator) currentReplica() int {
+	if it.i >= len(it.its) {
+		return -1
+	}
+	if r, ok := it.its[it.i].(replicaTracker); ok {
+		return r.currentReplica()
+	}
+	return -1
+}
+
+// countingSeriesIterator counts returned samples of a single replica.
+type countingSeriesIterator struct {
+	storage.SeriesIterator
+	counters *seriesDedupCounters
+	replica  int
+	started  bool
+}
+
+func (it *countingSeriesIterator) Next() bool {
+	if !it.SeriesIterator.Next() {
+		return false
+	}
+	it.started = true
+	it.counters.inc(it.replica)
+	return true
+}
+
+func (it *countingSeriesIterator) Seek(t int64) bool {
+	for {
+		if ts, _ := it.At(); it.started && ts >= t {
+			return true
+		}
+		if !it.Next() {
+			return false
+		}
+	}
+}
+
+// currentReplica implements replicaTracker.
+func (it *countingSeriesIterator) currentReplica() int { return it.replica }
+
 type dedupSeriesIterator struct {
 	a, b storage.SeriesIterator
 	i    int
@@ -401,6 +1025,32 @@ type dedupSeriesIterator struct {
 	lastT      int64
 	penA, penB int64
 	useA       bool
+	// Scrape interval of the series estimated from spacing of consecutive samples of the same iterator.
+	// Zero if not known yet.
+	interval int64
+
+	// Samples after edge are present only in the fresher of the two iterators, a if freshA is true.
+	// They are never skipped by penalty.
+	edge   int64
+	freshA bool
+
+	// Samples are current for lookback after their timestamp, so penalty never exceeds it. Zero disables the limit.
+	lookback int64
+
+	// Optional per replica counters of returned samples.
+	counters *seriesDedupCounters
+	// Optional counter of rescued samples, used if per replica counters are not recorded.
+	rescues prometheus.Counter
+	// Replica of the previous returned sample, used to count rescued samples.
+	lastReplica int
+
+	// Maximum relative difference of values blended at switches between a and b. Zero disables smoothing.
+	smoothing float64
+	// Value of the previous sample as returned by a or b.
+	lastV float64
+	// True if the current sample is blended and its value is smoothedV.
+	smoothed  bool
+	smoothedV float64
 }
 
 func newDedupSeriesIterator(a, b storage.SeriesIterator) *dedupSeriesIterator {
@@ -410,29 +1060,96 @@ func newDedupSeriesIterator(a, b storage.SeriesIterator) *dedupSeriesIterator {
 		lastT: math.MinInt64,
 		aok:   true,
 		bok:   true,
+		edge:  math.MaxInt64,
+
+		lastReplica: -1,
 	}
 }
 
 func (it *dedupSeriesIterator) Next() bool {
+	started, prevUseA := it.lastT != math.MinInt64, it.useA
+	if !it.next() {
+		return false
+	}
+	if it.smoothing > 0 {
+		it.smooth(started && prevUseA != it.useA)
+	}
+	if it.counters != nil || it.rescues != nil {
+		r := it.currentReplica()
+		if it.counters != nil {
+			it.counters.inc(r)
+		}
+		// Deduplication switches replicas only if the followed one has a gap, so the sample after the switch is
+		// rescued by the other replica.
+		if it.lastReplica >= 0 && r >= 0 && r != it.lastReplica {
+			if it.counters != nil {
+				it.counters.incRescued()
+			} else {
+				it.rescues.Inc()
+			}
+		}
+		it.lastReplica = r
+	}
+	return true
+}
+
+// smooth blends the current sample with the previous one if it was picked after a switch and its value is within
+// the smoothing tolerance.
+func (it *dedupSeriesIterator) smooth(switched bool) {
+	cur := it.b
+	if it.useA {
+		cur = it.a
+	}
+	_, v := cur.At()
+	it.smoothed = false
+	// NaN values, like staleness markers, are never within tolerance.
+	if switched && math.Abs(v-it.lastV) <= it.smoothing*math.Max(math.Abs(v), math.Abs(it.lastV)) {
+		it.smoothed, it.smoothedV = true, (v+it.lastV)/2
+	}
+	it.lastV = v
+}
+
+// currentReplica returns index of the replica the current sample comes from or -1 if unknown.
+func (it *dedupSeriesIterator) currentReplica() int {
+	cur := it.b
+	if it.useA {
+		cur = it.a
+	}
+	if r, ok := cur.(replicaTracker); ok {
+		return r.currentReplica()
+	}
+	return -1
+}
+
+func (it *dedupSeriesIterator) next() bool {
 	// Advance both iterators to at least the next highest timestamp plus the potential penalty.
+	seekA, seekB := it.lastT+1+it.penA, it.lastT+1+it.penB
+	if it.edge != math.MaxInt64 {
+		// Penalty must not skip samples of the fresher iterator past the trailing edge.
+		if it.freshA {
+			seekA = boundPenalizedSeek(seekA, it.lastT, it.edge)
+		} else {
+			seekB = boundPenalizedSeek(seekB, it.lastT, it.edge)
+		}
+	}
 	if it.aok {
-		it.aok = it.a.Seek(it.lastT + 1 + it.penA)
+		it.aok = it.a.Seek(seekA)
 	}
 	if it.bok {
-		it.bok = it.b.Seek(it.lastT + 1 + it.penB)
+		it.bok = it.b.Seek(seekB)
 	}
 	// Handle basic cases where one iterator is exhausted before the other.
 	if !it.aok {
-		it.useA = false
 		if it.bok {
-			it.lastT, _ = it.b.At()
+			tb, _ := it.b.At()
+			it.pick(false, tb)
 			it.penB = 0
 		}
 		return it.bok
 	}
 	if !it.bok {
-		it.useA = true
-		it.lastT, _ = it.a.At()
+		ta, _ := it.a.At()
+		it.pick(true, ta)
 		it.penA = 0
 		return true
 	}
@@ -443,35 +1160,65 @@ func (it *dedupSeriesIterator) Next() bool {
 	ta, _ := it.a.At()
 	tb, _ := it.b.At()
 
-	it.useA = ta <= tb
-
-	// For the series we didn't pick, add a penalty twice as high as the delta of the last two
-	// samples to the next seek against it.
+	// For the series we didn't pick, add a penalty twice as high as the estimated scrape interval
+	// to the next seek against it.
 	// This ensures that we don't pick a sample too close, which would increase the overall
 	// sample frequency. It also guards against clock drift and inaccuracies during
 	// timestamp assignment.
+	lastT := it.lastT
+	if ta <= tb {
+		it.pick(true, ta)
+		it.penA, it.penB = 0, it.penalty(lastT)
+		return true
+	}
+	it.pick(false, tb)
+	it.penA, it.penB = it.penalty(lastT), 0
+	return true
+}
+
+// penalty returns penalty of the iterator that was not picked for the current sample, which followed lastT. It is
+// capped by the lookback delta, as a longer gap of the picked iterator is visible to PromQL.
+func (it *dedupSeriesIterator) penalty(lastT int64) int64 {
+	p := it.estimatedPenalty(lastT)
+	if it.lookback > 0 && p > it.lookback {
+		return it.lookback
+	}
+	return p
+}
+
+func (it *dedupSeriesIterator) estimatedPenalty(lastT int64) int64 {
+	// If we don't know the interval yet, we use the delta of the last two samples.
 	// If we don't know a delta yet, we pick 5000 as a constant, which is based on the knowledge
 	// that timestamps are in milliseconds and sampling frequencies typically multiple seconds long.
 	const initialPenality = 5000
 
-	if it.useA {
-		if it.lastT != math.MinInt64 {
-			it.penB = 2 * (ta - it.lastT)
-		} else {
-			it.penB = initialPenality
-		}
-		it.penA = 0
-		it.lastT = ta
-		return true
+	if it.interval > 0 {
+		return 2 * it.interval
 	}
-	if it.lastT != math.MinInt64 {
-		it.penA = 2 * (tb - it.lastT)
-	} else {
-		it.penA = initialPenality
+	if lastT != math.MinInt64 {
+		return 2 * (it.lastT - lastT)
 	}
-	it.penB = 0
-	it.lastT = tb
-	return true
+	return initialPenality
+}
+
+// pick makes the sample at t of a or b the current one. Spacing of consecutive samples of the same iterator
+// updates the estimated scrape interval. Spacing across a switch is not used, as switching happens on gaps.
+func (it *dedupSeriesIterator) pick(useA bool, t int64) {
+	if it.lastT != math.MinInt64 && useA == it.useA {
+		it.interval = t - it.lastT
+	}
+	it.useA = useA
+	it.lastT = t
+}
+
+func boundPenalizedSeek(t, lastT, edge int64) int64 {
+	if t <= edge+1 {
+		return t
+	}
+	if lastT >= edge {
+		return lastT + 1
+	}
+	return edge + 1
 }
 
 func (it *dedupSeriesIterator) Seek(t int64) bool {
@@ -487,6 +1234,9 @@ func (it *dedupSeriesIterator) Seek(t int64) bool {
 }
 
 func (it *dedupSeriesIterator) At() (int64, float64) {
+	if it.smoothed {
+		return it.lastT, it.smoothedV
+	}
 	if it.useA {
 		return it.a.At()
 	}
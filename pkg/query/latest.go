@@ -0,0 +1,79 @@
+package query
+
+import (
+	"context"
+	"math"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/prometheus/prometheus/storage"
+)
+
+type latestSampleKey struct{}
+
+// ContextWithLatestSample returns a new context.Context that makes series selected by queriers created with it hold
+// only their latest sample up to the end of the selection. It is a fast path for instant queries, which only use that
+// sample of each selected series. Chunks of series that can't hold it are never decoded. It must not be used for
+// selections of range vectors or subqueries, which need all samples in the range.
+func ContextWithLatestSample(ctx context.Context) context.Context {
+	return context.WithValue(ctx, latestSampleKey{}, true)
+}
+
+func latestSampleFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(latestSampleKey{}).(bool)
+	return v
+}
+
+// latestSampleChunks returns the chunks that may hold the latest sample up to maxt. Chunks span their first and last
+// sample, so the latest sample is in a chunk ending at or before maxt with the latest end, unless a chunk starting
+// before and ending after maxt holds a later one.
+func latestSampleChunks(chunks []storepb.AggrChunk, maxt int64) []storepb.AggrChunk {
+	latest := int64(math.MinInt64)
+	for _, c := range chunks {
+		if c.MaxTime <= maxt && c.MaxTime > latest {
+			latest = c.MaxTime
+		}
+	}
+	res := make([]storepb.AggrChunk, 0, 1)
+	for _, c := range chunks {
+		if c.MinTime <= maxt && c.MaxTime >= latest {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// latestSampleIterator iterates only the last sample of the wrapped iterator.
+type latestSampleIterator struct {
+	it storage.SeriesIterator
+
+	done, ok bool
+	t        int64
+	v        float64
+}
+
+func newLatestSampleIterator(it storage.SeriesIterator) *latestSampleIterator {
+	return &latestSampleIterator{it: it}
+}
+
+func (it *latestSampleIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	it.done = true
+	for it.it.Next() {
+		it.t, it.v = it.it.At()
+		it.ok = true
+	}
+	return it.ok
+}
+
+func (it *latestSampleIterator) Seek(t int64) bool {
+	if !it.done {
+		it.Next()
+	}
+	return it.ok && it.t >= t
+}
+
+func (it *latestSampleIterator) At() (int64, float64) { return it.t, it.v }
+
+func (it *latestSampleIterator) Err() error { return it.it.Err() }
@@ -0,0 +1,60 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+func TestQuerier_Select_LatestSample(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}, {2, 2}, {3, 3}}, []sample{{4, 4}, {5, 5}, {6, 6}}, []sample{{7, 7}, {8, 8}, {9, 9}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{1, 1}, {2, 2}}, []sample{{3, 3}, {4, 4}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "3"), []sample{{9, 9}, {10, 10}}),
+	}}
+
+	q := newQuerier(ContextWithChunkRefs(ContextWithLatestSample(context.Background())), nil, 0, 10, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	// Selection ends before the querier, like selections with offset do.
+	res, _, err := q.Select(&storage.SelectParams{Start: 0, End: 8})
+	testutil.Ok(t, err)
+
+	for _, exp := range []struct {
+		lset    labels.Labels
+		chunks  int
+		samples []sample
+	}{
+		// The chunk ending before the end holds a sample, but the one spanning it may hold a later one.
+		{lset: labels.FromStrings("a", "1"), chunks: 2, samples: []sample{{8, 8}}},
+		{lset: labels.FromStrings("a", "2"), chunks: 1, samples: []sample{{4, 4}}},
+		{lset: labels.FromStrings("a", "3"), chunks: 0},
+	} {
+		testutil.Assert(t, res.Next(), "expected series")
+		s, ok := res.At().(ChunkSeries)
+		testutil.Assert(t, ok, "expected ChunkSeries, got %T", res.At())
+		testutil.Equals(t, exp.lset, s.Labels())
+		testutil.Equals(t, exp.chunks, len(s.Chunks()))
+		testutil.Equals(t, exp.samples, expandSeries(t, s.Iterator()))
+	}
+	testutil.Assert(t, !res.Next(), "expected no more series")
+	testutil.Ok(t, res.Err())
+}
+
+func TestLatestSampleIterator_Seek(t *testing.T) {
+	it := newLatestSampleIterator(xorSeriesIterator(t, []sample{{1, 1}, {2, 2}, {3, 3}}))
+	testutil.Assert(t, it.Seek(2), "expected sample")
+	ts, v := it.At()
+	testutil.Equals(t, sample{3, 3}, sample{ts, v})
+	testutil.Assert(t, !it.Next(), "expected no more samples")
+	testutil.Assert(t, !it.Seek(4), "expected no sample after the last one")
+	testutil.Ok(t, it.Err())
+}
@@ -0,0 +1,264 @@
+package query
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+// Decoders of chunk encodings used by Prometheus 1.x storage. Both encodings store samples in fixed width
+// slots after the header. Widths of time and value slots are defined per chunk and can be 0 (value only), 1, 2, 4
+// or 8 bytes. Slots of 8 bytes hold absolute values instead of differences.
+//
+// The 21 bytes header of a delta encoded chunk looks like:
+//
+// - time delta bytes:  1 byte
+// - value delta bytes: 1 byte
+// - is integer:        1 byte
+// - base time:         8 bytes
+// - base value:        8 bytes
+// - used buf bytes:    2 bytes
+//
+// The 37 bytes header of a double-delta encoded chunk looks like:
+//
+// - used buf bytes:           2 bytes
+// - time double-delta bytes:  1 byte
+// - value double-delta bytes: 1 byte
+// - is integer:               1 byte
+// - base time:                8 bytes
+// - base value:               8 bytes
+// - base time delta:          8 bytes
+// - base value delta:         8 bytes
+//
+// Double-delta chunk with a single sample has the header truncated to 21 bytes.
+const (
+	deltaHeaderBytes = 21
+
+	deltaHeaderTimeBytesOffset  = 0
+	deltaHeaderValueBytesOffset = 1
+	deltaHeaderIsIntOffset      = 2
+	deltaHeaderBaseTimeOffset   = 3
+	deltaHeaderBaseValueOffset  = 11
+	deltaHeaderBufLenOffset     = 19
+
+	doubleDeltaHeaderBytes    = 37
+	doubleDeltaHeaderMinBytes = 21
+
+	doubleDeltaHeaderBufLenOffset         = 0
+	doubleDeltaHeaderTimeBytesOffset      = 2
+	doubleDeltaHeaderValueBytesOffset     = 3
+	doubleDeltaHeaderIsIntOffset          = 4
+	doubleDeltaHeaderBaseTimeOffset       = 5
+	doubleDeltaHeaderBaseValueOffset      = 13
+	doubleDeltaHeaderBaseTimeDeltaOffset  = 21
+	doubleDeltaHeaderBaseValueDeltaOffset = 29
+)
+
+// legacySampleAccessor gives random access to samples of Prometheus 1.x encoded chunk.
+type legacySampleAccessor interface {
+	len() int
+	at(i int) (int64, float64)
+}
+
+// legacyChunkIterator implements chunkenc.Iterator on top of legacySampleAccessor.
+type legacyChunkIterator struct {
+	acc legacySampleAccessor
+	i   int
+}
+
+func (it *legacyChunkIterator) Err() error { return nil }
+
+func (it *legacyChunkIterator) At() (int64, float64) {
+	// Like other chunk iterators, return zero values if not positioned at a sample.
+	if it.i < 0 || it.i >= it.acc.len() {
+		return 0, 0
+	}
+	return it.acc.at(it.i)
+}
+
+func (it *legacyChunkIterator) Next() bool {
+	if it.i+1 >= it.acc.len() {
+		it.i = it.acc.len()
+		return false
+	}
+	it.i++
+	return true
+}
+
+func validLegacyWidths(tBytes, vBytes byte, isInt bool) error {
+	switch tBytes {
+	case 1, 2, 4, 8:
+	default:
+		return errors.Errorf("invalid time bytes %d", tBytes)
+	}
+	switch {
+	case isInt && (vBytes == 0 || vBytes == 1 || vBytes == 2 || vBytes == 4):
+	case !isInt && (vBytes == 4 || vBytes == 8):
+	default:
+		return errors.Errorf("invalid value bytes %d for integer %t values", vBytes, isInt)
+	}
+	return nil
+}
+
+// readLegacyTime reads time slot of the given width. Slots narrower than 8 bytes hold an unsigned difference when
+// signed is false and signed one otherwise.
+func readLegacyTime(b []byte, width byte, signed bool) int64 {
+	switch width {
+	case 1:
+		if signed {
+			return int64(int8(b[0]))
+		}
+		return int64(b[0])
+	case 2:
+		if signed {
+			return int64(int16(binary.LittleEndian.Uint16(b)))
+		}
+		return int64(binary.LittleEndian.Uint16(b))
+	case 4:
+		if signed {
+			return int64(int32(binary.LittleEndian.Uint32(b)))
+		}
+		return int64(binary.LittleEndian.Uint32(b))
+	}
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+// readLegacyValue reads value slot of the given width, which is validated upfront.
+func readLegacyValue(b []byte, width byte, isInt bool) float64 {
+	if isInt {
+		switch width {
+		case 0:
+			return 0
+		case 1:
+			return float64(int8(b[0]))
+		case 2:
+			return float64(int16(binary.LittleEndian.Uint16(b)))
+		}
+		return float64(int32(binary.LittleEndian.Uint32(b)))
+	}
+	if width == 4 {
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+type deltaAccessor struct {
+	b              []byte
+	tBytes, vBytes byte
+	isInt          bool
+	baseT          int64
+	baseV          float64
+	n              int
+}
+
+func newDeltaIterator(b []byte) chunkenc.Iterator {
+	if len(b) < deltaHeaderBytes {
+		return errSeriesIterator{errors.Errorf("delta chunk too short: %d bytes", len(b))}
+	}
+	a := &deltaAccessor{
+		b:      b,
+		tBytes: b[deltaHeaderTimeBytesOffset],
+		vBytes: b[deltaHeaderValueBytesOffset],
+		isInt:  b[deltaHeaderIsIntOffset] == 1,
+		baseT:  int64(binary.LittleEndian.Uint64(b[deltaHeaderBaseTimeOffset:])),
+		baseV:  math.Float64frombits(binary.LittleEndian.Uint64(b[deltaHeaderBaseValueOffset:])),
+	}
+	if err := validLegacyWidths(a.tBytes, a.vBytes, a.isInt); err != nil {
+		return errSeriesIterator{errors.Wrap(err, "delta chunk")}
+	}
+	bufLen := int(binary.LittleEndian.Uint16(b[deltaHeaderBufLenOffset:]))
+	if bufLen < deltaHeaderBytes || bufLen > len(b) {
+		return errSeriesIterator{errors.Errorf("delta chunk: invalid used buffer length %d of %d bytes", bufLen, len(b))}
+	}
+	a.n = (bufLen - deltaHeaderBytes) / int(a.tBytes+a.vBytes)
+	return &legacyChunkIterator{acc: a, i: -1}
+}
+
+func (a *deltaAccessor) len() int { return a.n }
+
+func (a *deltaAccessor) at(i int) (t int64, v float64) {
+	off := deltaHeaderBytes + i*int(a.tBytes+a.vBytes)
+
+	t = readLegacyTime(a.b[off:], a.tBytes, false)
+	if a.tBytes != 8 {
+		t += a.baseT
+	}
+	v = readLegacyValue(a.b[off+int(a.tBytes):], a.vBytes, a.isInt)
+	if a.vBytes != 8 {
+		v += a.baseV
+	}
+	return t, v
+}
+
+type doubleDeltaAccessor struct {
+	b              []byte
+	tBytes, vBytes byte
+	isInt          bool
+	baseT, baseDT  int64
+	baseV, baseDV  float64
+	n              int
+}
+
+func newDoubleDeltaIterator(b []byte) chunkenc.Iterator {
+	if len(b) < doubleDeltaHeaderMinBytes {
+		return errSeriesIterator{errors.Errorf("double-delta chunk too short: %d bytes", len(b))}
+	}
+	a := &doubleDeltaAccessor{
+		b:      b,
+		tBytes: b[doubleDeltaHeaderTimeBytesOffset],
+		vBytes: b[doubleDeltaHeaderValueBytesOffset],
+		isInt:  b[doubleDeltaHeaderIsIntOffset] == 1,
+		baseT:  int64(binary.LittleEndian.Uint64(b[doubleDeltaHeaderBaseTimeOffset:])),
+		baseV:  math.Float64frombits(binary.LittleEndian.Uint64(b[doubleDeltaHeaderBaseValueOffset:])),
+	}
+	if err := validLegacyWidths(a.tBytes, a.vBytes, a.isInt); err != nil {
+		return errSeriesIterator{errors.Wrap(err, "double-delta chunk")}
+	}
+	bufLen := int(binary.LittleEndian.Uint16(b[doubleDeltaHeaderBufLenOffset:]))
+	if bufLen > len(b) {
+		return errSeriesIterator{errors.Errorf("double-delta chunk: invalid used buffer length %d of %d bytes", bufLen, len(b))}
+	}
+	switch {
+	case bufLen < doubleDeltaHeaderMinBytes:
+		a.n = 0
+	case bufLen < doubleDeltaHeaderBytes:
+		a.n = 1
+	default:
+		a.baseDT = int64(binary.LittleEndian.Uint64(b[doubleDeltaHeaderBaseTimeDeltaOffset:]))
+		a.baseDV = math.Float64frombits(binary.LittleEndian.Uint64(b[doubleDeltaHeaderBaseValueDeltaOffset:]))
+		a.n = (bufLen-doubleDeltaHeaderBytes)/int(a.tBytes+a.vBytes) + 2
+	}
+	return &legacyChunkIterator{acc: a, i: -1}
+}
+
+func (a *doubleDeltaAccessor) len() int { return a.n }
+
+func (a *doubleDeltaAccessor) at(i int) (t int64, v float64) {
+	switch i {
+	case 0:
+		return a.baseT, a.baseV
+	case 1:
+		// Second sample is encoded by the base deltas. They hold absolute values when slots are 8 bytes wide.
+		t, v = a.baseDT, a.baseDV
+		if a.tBytes != 8 {
+			t += a.baseT
+		}
+		if a.vBytes != 8 {
+			v += a.baseV
+		}
+		return t, v
+	}
+	off := doubleDeltaHeaderBytes + (i-2)*int(a.tBytes+a.vBytes)
+
+	t = readLegacyTime(a.b[off:], a.tBytes, true)
+	if a.tBytes != 8 {
+		t += a.baseT + int64(i)*a.baseDT
+	}
+	v = readLegacyValue(a.b[off+int(a.tBytes):], a.vBytes, a.isInt)
+	if a.vBytes != 8 {
+		v += a.baseV + float64(i)*a.baseDV
+	}
+	return t, v
+}
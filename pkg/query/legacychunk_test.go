@@ -0,0 +1,179 @@
+package query
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+func putLegacyTime(b []byte, width byte, t int64) {
+	switch width {
+	case 1:
+		b[0] = byte(t)
+	case 2:
+		binary.LittleEndian.PutUint16(b, uint16(t))
+	case 4:
+		binary.LittleEndian.PutUint32(b, uint32(t))
+	default:
+		binary.LittleEndian.PutUint64(b, uint64(t))
+	}
+}
+
+func putLegacyValue(b []byte, width byte, isInt bool, v float64) {
+	switch {
+	case isInt && width == 1:
+		b[0] = byte(int8(v))
+	case isInt && width == 2:
+		binary.LittleEndian.PutUint16(b, uint16(int16(v)))
+	case isInt && width == 4:
+		binary.LittleEndian.PutUint32(b, uint32(int32(v)))
+	case !isInt && width == 4:
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+	case width == 8:
+		binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+	}
+}
+
+// encodeDelta encodes samples the way Prometheus 1.x delta chunks do.
+func encodeDelta(samples []sample, tBytes, vBytes byte, isInt bool) []byte {
+	b := make([]byte, deltaHeaderBytes+len(samples)*int(tBytes+vBytes))
+	b[deltaHeaderTimeBytesOffset] = tBytes
+	b[deltaHeaderValueBytesOffset] = vBytes
+	if isInt {
+		b[deltaHeaderIsIntOffset] = 1
+	}
+	binary.LittleEndian.PutUint64(b[deltaHeaderBaseTimeOffset:], uint64(samples[0].t))
+	binary.LittleEndian.PutUint64(b[deltaHeaderBaseValueOffset:], math.Float64bits(samples[0].v))
+	binary.LittleEndian.PutUint16(b[deltaHeaderBufLenOffset:], uint16(len(b)))
+
+	for i, s := range samples {
+		off := deltaHeaderBytes + i*int(tBytes+vBytes)
+
+		t, v := s.t, s.v
+		if tBytes != 8 {
+			t -= samples[0].t
+		}
+		if vBytes != 8 {
+			v -= samples[0].v
+		}
+		putLegacyTime(b[off:], tBytes, t)
+		putLegacyValue(b[off+int(tBytes):], vBytes, isInt, v)
+	}
+	return b
+}
+
+// encodeDoubleDelta encodes samples the way Prometheus 1.x double-delta chunks do.
+func encodeDoubleDelta(samples []sample, tBytes, vBytes byte, isInt bool) []byte {
+	size := doubleDeltaHeaderMinBytes
+	if len(samples) > 1 {
+		size = doubleDeltaHeaderBytes + (len(samples)-2)*int(tBytes+vBytes)
+	}
+	b := make([]byte, size)
+	binary.LittleEndian.PutUint16(b[doubleDeltaHeaderBufLenOffset:], uint16(size))
+	b[doubleDeltaHeaderTimeBytesOffset] = tBytes
+	b[doubleDeltaHeaderValueBytesOffset] = vBytes
+	if isInt {
+		b[doubleDeltaHeaderIsIntOffset] = 1
+	}
+	baseT, baseV := samples[0].t, samples[0].v
+	binary.LittleEndian.PutUint64(b[doubleDeltaHeaderBaseTimeOffset:], uint64(baseT))
+	binary.LittleEndian.PutUint64(b[doubleDeltaHeaderBaseValueOffset:], math.Float64bits(baseV))
+	if len(samples) == 1 {
+		return b
+	}
+
+	baseDT, baseDV := samples[1].t-baseT, samples[1].v-baseV
+	dt, dv := baseDT, baseDV
+	if tBytes == 8 {
+		dt = samples[1].t
+	}
+	if vBytes == 8 {
+		dv = samples[1].v
+	}
+	binary.LittleEndian.PutUint64(b[doubleDeltaHeaderBaseTimeDeltaOffset:], uint64(dt))
+	binary.LittleEndian.PutUint64(b[doubleDeltaHeaderBaseValueDeltaOffset:], math.Float64bits(dv))
+
+	for i, s := range samples[2:] {
+		idx := i + 2
+		off := doubleDeltaHeaderBytes + i*int(tBytes+vBytes)
+
+		t, v := s.t, s.v
+		if tBytes != 8 {
+			t -= baseT + int64(idx)*baseDT
+		}
+		if vBytes != 8 {
+			v -= baseV + float64(idx)*baseDV
+		}
+		putLegacyTime(b[off:], tBytes, t)
+		putLegacyValue(b[off+int(tBytes):], vBytes, isInt, v)
+	}
+	return b
+}
+
+func TestLegacyChunks_RoundTrip(t *testing.T) {
+	intSamples := []sample{{1000, 10}, {2000, 12}, {3010, 13}, {3990, 20}, {5000, 19}}
+	floatSamples := []sample{{1000, 0.5}, {2000, 1.25}, {3010, 1.5}, {3990, 8.75}, {5000, -2}}
+
+	for _, tcase := range []struct {
+		enc            storepb.Chunk_Encoding
+		samples        []sample
+		tBytes, vBytes byte
+		isInt          bool
+	}{
+		{enc: storepb.Chunk_DELTA, samples: intSamples, tBytes: 2, vBytes: 1, isInt: true},
+		{enc: storepb.Chunk_DELTA, samples: []sample{{1000, 7}, {2000, 7}}, tBytes: 2, vBytes: 0, isInt: true},
+		{enc: storepb.Chunk_DELTA, samples: floatSamples, tBytes: 4, vBytes: 4},
+		{enc: storepb.Chunk_DELTA, samples: floatSamples, tBytes: 8, vBytes: 8},
+		{enc: storepb.Chunk_DOUBLE_DELTA, samples: intSamples, tBytes: 1, vBytes: 2, isInt: true},
+		{enc: storepb.Chunk_DOUBLE_DELTA, samples: []sample{{1000, 1}, {2000, 2}, {3000, 3}}, tBytes: 1, vBytes: 0, isInt: true},
+		{enc: storepb.Chunk_DOUBLE_DELTA, samples: floatSamples, tBytes: 2, vBytes: 4},
+		{enc: storepb.Chunk_DOUBLE_DELTA, samples: floatSamples, tBytes: 8, vBytes: 8},
+		{enc: storepb.Chunk_DOUBLE_DELTA, samples: []sample{{1000, 3.5}}, tBytes: 1, vBytes: 4},
+	} {
+		t.Run(fmt.Sprintf("%s/t%d/v%d/int=%t/n=%d", tcase.enc, tcase.tBytes, tcase.vBytes, tcase.isInt, len(tcase.samples)), func(t *testing.T) {
+			var data []byte
+			if tcase.enc == storepb.Chunk_DELTA {
+				data = encodeDelta(tcase.samples, tcase.tBytes, tcase.vBytes, tcase.isInt)
+			} else {
+				data = encodeDoubleDelta(tcase.samples, tcase.tBytes, tcase.vBytes, tcase.isInt)
+			}
+			// Legacy chunks have fixed size with unused tail.
+			data = append(data, make([]byte, 64)...)
+
+			s := newChunkSeries(nil, []storepb.AggrChunk{{
+				MinTime: tcase.samples[0].t,
+				MaxTime: tcase.samples[len(tcase.samples)-1].t,
+				Raw:     &storepb.Chunk{Type: tcase.enc, Data: data},
+			}}, 0, math.MaxInt64, resAggrAvg)
+
+			testutil.Equals(t, tcase.samples, expandSeries(t, s.Iterator()))
+		})
+	}
+}
+
+func TestLegacyChunks_Invalid(t *testing.T) {
+	badTimeBytes := encodeDelta([]sample{{1, 1}}, 1, 1, true)
+	badTimeBytes[deltaHeaderTimeBytesOffset] = 3
+
+	badBufLen := encodeDelta([]sample{{1, 1}}, 1, 1, true)
+	binary.LittleEndian.PutUint16(badBufLen[deltaHeaderBufLenOffset:], 100)
+
+	intD8 := encodeDoubleDelta([]sample{{1, 1}, {2, 2}}, 1, 1, true)
+	intD8[doubleDeltaHeaderValueBytesOffset] = 8
+
+	for _, c := range []*storepb.Chunk{
+		{Type: storepb.Chunk_DELTA, Data: nil},
+		{Type: storepb.Chunk_DELTA, Data: badTimeBytes},
+		{Type: storepb.Chunk_DELTA, Data: badBufLen},
+		{Type: storepb.Chunk_DOUBLE_DELTA, Data: make([]byte, 10)},
+		{Type: storepb.Chunk_DOUBLE_DELTA, Data: intD8},
+	} {
+		it := getFirstIterator(c)
+		testutil.Assert(t, !it.Next(), "expected no samples")
+		testutil.NotOk(t, it.Err())
+	}
+}
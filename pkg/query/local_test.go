@@ -0,0 +1,65 @@
+package query
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	tlabels "github.com/prometheus/tsdb/labels"
+)
+
+func TestQuerier_Select_LocalTSDB(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	db, err := testutil.NewTSDB()
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(db.Dir())) }()
+	defer func() { testutil.Ok(t, db.Close()) }()
+
+	app := db.Appender()
+	for i := int64(1); i <= 3; i++ {
+		_, err := app.Add(tlabels.FromStrings("__name__", "up", "job", "a"), i*1000, float64(i))
+		testutil.Ok(t, err)
+		_, err = app.Add(tlabels.FromStrings("__name__", "up", "job", "b"), i*1000, float64(i*10))
+		testutil.Ok(t, err)
+	}
+	testutil.Ok(t, app.Commit())
+
+	tsdbStore := store.NewTSDBStore(nil, nil, db, tlabels.FromStrings("ext", "1"))
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) {
+		return []store.Client{store.NewLocalClient(tsdbStore, "tsdb")}, nil
+	}, nil, store.StoreLimit{})
+
+	q := newQuerier(context.Background(), nil, 1, 10000, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	m, err := labels.NewMatcher(labels.MatchEqual, "__name__", "up")
+	testutil.Ok(t, err)
+	res, _, err := q.Select(&storage.SelectParams{}, m)
+	testutil.Ok(t, err)
+
+	var (
+		lsets   []labels.Labels
+		samples [][]sample
+	)
+	for res.Next() {
+		lsets = append(lsets, res.At().Labels())
+		samples = append(samples, expandSeries(t, res.At().Iterator()))
+	}
+	testutil.Ok(t, res.Err())
+	testutil.Equals(t, []labels.Labels{
+		labels.FromStrings("__name__", "up", "ext", "1", "job", "a"),
+		labels.FromStrings("__name__", "up", "ext", "1", "job", "b"),
+	}, lsets)
+	testutil.Equals(t, [][]sample{{{1000, 1}, {2000, 2}, {3000, 3}}, {{1000, 10}, {2000, 20}, {3000, 30}}}, samples)
+
+	vals, err := q.LabelValues("job")
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"a", "b"}, vals)
+}
@@ -0,0 +1,106 @@
+package query
+
+import (
+	"regexp/syntax"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// simplifyMatchers returns matchers selecting the same series as the given ones, in a form stores resolve more
+// efficiently using their index. Regexps are fully anchored, so explicit anchors at their ends are dropped, and
+// regexps matching a single literal value are converted to equality matchers. Duplicates and matchers implied by an
+// equality matcher of the same label are removed. Matchers of the given slice are not modified.
+func simplifyMatchers(ms []*labels.Matcher) []*labels.Matcher {
+	var (
+		simplified = make([]*labels.Matcher, 0, len(ms))
+		eq         = map[string]string{}
+	)
+	for _, m := range ms {
+		m = simplifyMatcher(m)
+		if _, ok := eq[m.Name]; !ok && m.Type == labels.MatchEqual {
+			eq[m.Name] = m.Value
+		}
+		simplified = append(simplified, m)
+	}
+
+	res := simplified[:0]
+	type matcherKey struct {
+		t    labels.MatchType
+		n, v string
+	}
+	seen := map[matcherKey]struct{}{}
+	for _, m := range simplified {
+		k := matcherKey{t: m.Type, n: m.Name, v: m.Value}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+
+		// Matchers of a label with an equality matcher are implied by it if they match its value. Otherwise no
+		// series are selected, which stores resolve just as well.
+		if v, ok := eq[m.Name]; ok && (m.Type != labels.MatchEqual || m.Value != v) && m.Matches(v) {
+			continue
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+// simplifyMatcher returns regexp matcher without redundant anchors, or equality matcher if the regexp matches a single
+// literal value. Other matchers are returned as they are.
+func simplifyMatcher(m *labels.Matcher) *labels.Matcher {
+	if m.Type != labels.MatchRegexp && m.Type != labels.MatchNotRegexp {
+		return m
+	}
+	// Parse like regexp.Compile does, so ^ and $ match only at ends of the value.
+	re, err := syntax.Parse(m.Value, syntax.Perl)
+	if err != nil {
+		return m
+	}
+	re, stripped := stripAnchors(re)
+
+	eqType := labels.MatchEqual
+	if m.Type == labels.MatchNotRegexp {
+		eqType = labels.MatchNotEqual
+	}
+	switch {
+	case re.Op == syntax.OpEmptyMatch:
+		return &labels.Matcher{Type: eqType, Name: m.Name}
+	case re.Op == syntax.OpLiteral && re.Flags&syntax.FoldCase == 0:
+		return &labels.Matcher{Type: eqType, Name: m.Name, Value: string(re.Rune)}
+	case !stripped:
+		return m
+	}
+	sm, err := labels.NewMatcher(m.Type, m.Name, re.String())
+	if err != nil {
+		return m
+	}
+	return sm
+}
+
+// stripAnchors drops anchors at the start and end of the regexp. It returns true if any was dropped.
+func stripAnchors(re *syntax.Regexp) (*syntax.Regexp, bool) {
+	switch re.Op {
+	case syntax.OpBeginText, syntax.OpEndText:
+		return &syntax.Regexp{Op: syntax.OpEmptyMatch}, true
+	case syntax.OpConcat:
+	default:
+		return re, false
+	}
+	subs := re.Sub
+	if len(subs) > 0 && subs[0].Op == syntax.OpBeginText {
+		subs = subs[1:]
+	}
+	if len(subs) > 0 && subs[len(subs)-1].Op == syntax.OpEndText {
+		subs = subs[:len(subs)-1]
+	}
+	switch len(subs) {
+	case len(re.Sub):
+		return re, false
+	case 0:
+		return &syntax.Regexp{Op: syntax.OpEmptyMatch}, true
+	case 1:
+		return subs[0], true
+	}
+	return &syntax.Regexp{Op: syntax.OpConcat, Flags: re.Flags, Sub: subs}, true
+}
@@ -0,0 +1,89 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+func TestSimplifyMatchers(t *testing.T) {
+	m := func(mt labels.MatchType, name, value string) *labels.Matcher {
+		res, err := labels.NewMatcher(mt, name, value)
+		testutil.Ok(t, err)
+		return res
+	}
+
+	for _, tcase := range []struct {
+		name string
+		in   []*labels.Matcher
+		exp  []string
+	}{
+		{
+			name: "literal regexp",
+			in:   []*labels.Matcher{m(labels.MatchRegexp, "job", "x"), m(labels.MatchNotRegexp, "env", `prod\.eu`)},
+			exp:  []string{"job=x", "env!=prod.eu"},
+		},
+		{
+			name: "anchored literal regexp",
+			in:   []*labels.Matcher{m(labels.MatchRegexp, "job", "^x$"), m(labels.MatchRegexp, "env", "^$")},
+			exp:  []string{"job=x", "env="},
+		},
+		{
+			name: "anchored regexp",
+			in:   []*labels.Matcher{m(labels.MatchRegexp, "job", "^x.*$"), m(labels.MatchRegexp, "env", "^a|b$")},
+			exp:  []string{"job=~(?-s:x.*)", "env=~^a|b$"},
+		},
+		{
+			name: "non literal regexp",
+			in:   []*labels.Matcher{m(labels.MatchRegexp, "job", "x|y"), m(labels.MatchRegexp, "env", "(?i)prod")},
+			exp:  []string{"job=~x|y", "env=~(?i)prod"},
+		},
+		{
+			name: "redundant regexp",
+			in:   []*labels.Matcher{m(labels.MatchEqual, "job", "x"), m(labels.MatchRegexp, "job", "x")},
+			exp:  []string{"job=x"},
+		},
+		{
+			name: "implied by equality",
+			in: []*labels.Matcher{
+				m(labels.MatchRegexp, "job", "x|y"),
+				m(labels.MatchNotEqual, "job", "z"),
+				m(labels.MatchEqual, "job", "x"),
+				m(labels.MatchRegexp, "env", "a.*"),
+				m(labels.MatchRegexp, "env", "a.*"),
+			},
+			exp: []string{"job=x", "env=~a.*"},
+		},
+		{
+			name: "contradicting equality",
+			in:   []*labels.Matcher{m(labels.MatchEqual, "job", "x"), m(labels.MatchEqual, "job", "y"), m(labels.MatchNotRegexp, "job", "x|z")},
+			exp:  []string{"job=x", "job=y", "job!~x|z"},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			res := simplifyMatchers(tcase.in)
+
+			var got []string
+			for _, sm := range res {
+				got = append(got, sm.String())
+			}
+			testutil.Equals(t, tcase.exp, got)
+
+			// Simplified matchers select the same series.
+			for _, v := range []string{"", "x", "y", "z", "x1", "a", "ab", "b", "prod", "PROD", "prod.eu", "prodxeu"} {
+				lset := labels.FromStrings("job", v, "env", v)
+				testutil.Equals(t, matchesAll(tcase.in, lset), matchesAll(res, lset))
+			}
+		})
+	}
+}
+
+func matchesAll(ms []*labels.Matcher, lset labels.Labels) bool {
+	for _, m := range ms {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
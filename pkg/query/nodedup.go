@@ -0,0 +1,92 @@
+package query
+
+import (
+	"context"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+type noDedupMatchersKey struct{}
+
+// ContextWithNoDedupMatchers returns a new context.Context that makes deduplicating queriers created with it return
+// series matching all the given matchers as they are, while other series of the same select are still deduplicated.
+// It is meant for series already deduplicated upstream, like recording rule results of Thanos Ruler, which are
+// matched e.g. by a name with a colon, __name__=~".+:.+", or by an external label of the rulers. Deduplicating them
+// again is wasteful and may merge series that only look like replicas.
+func ContextWithNoDedupMatchers(ctx context.Context, ms ...*labels.Matcher) context.Context {
+	return context.WithValue(ctx, noDedupMatchersKey{}, ms)
+}
+
+func noDedupMatchersFromContext(ctx context.Context) []*labels.Matcher {
+	ms, _ := ctx.Value(noDedupMatchersKey{}).([]*labels.Matcher)
+	return ms
+}
+
+// splitNoDedup splits the given series into ones to deduplicate and ones matching all the given matchers, keeping
+// their order.
+func splitNoDedup(set []storepb.Series, ms []*labels.Matcher) (dedup, noDedup []storepb.Series) {
+	dedup = set[:0:0]
+	for _, s := range set {
+		if matchesSeries(s.Labels, ms) {
+			noDedup = append(noDedup, s)
+			continue
+		}
+		dedup = append(dedup, s)
+	}
+	return dedup, noDedup
+}
+
+func matchesSeries(lset []storepb.Label, ms []*labels.Matcher) bool {
+	for _, m := range ms {
+		v := ""
+		for _, l := range lset {
+			if l.Name == m.Name {
+				v = l.Value
+				break
+			}
+		}
+		if !m.Matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedMergeSeriesSet merges two series sets sorted by the same comparator into one. Series comparing as equal are
+// returned one after another, the one of the first set first.
+type sortedMergeSeriesSet struct {
+	a, b     storage.SeriesSet
+	cmp      func(a, b labels.Labels) int
+	aok, bok bool
+
+	cur storage.Series
+}
+
+func newSortedMergeSeriesSet(a, b storage.SeriesSet, cmp func(a, b labels.Labels) int) *sortedMergeSeriesSet {
+	return &sortedMergeSeriesSet{a: a, b: b, cmp: cmp, aok: a.Next(), bok: b.Next()}
+}
+
+func (s *sortedMergeSeriesSet) Next() bool {
+	switch {
+	case !s.aok && !s.bok:
+		return false
+	case !s.bok || s.aok && s.cmp(s.a.At().Labels(), s.b.At().Labels()) <= 0:
+		s.cur = s.a.At()
+		s.aok = s.a.Next()
+	default:
+		s.cur = s.b.At()
+		s.bok = s.b.Next()
+	}
+	return true
+}
+
+func (s *sortedMergeSeriesSet) At() storage.Series { return s.cur }
+
+func (s *sortedMergeSeriesSet) Err() error {
+	if err := s.a.Err(); err != nil {
+		return err
+	}
+	return s.b.Err()
+}
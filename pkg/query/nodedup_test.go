@@ -0,0 +1,52 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+func TestQuerier_Select_NoDedupMatchers(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		// Recording rule results of two rulers with the same external labels as Prometheus replicas.
+		storeSeriesResponse(t, labels.FromStrings("__name__", "job:up:sum", "replica", "A"), []sample{{10000, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("__name__", "job:up:sum", "replica", "B"), []sample{{20000, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("__name__", "up", "a", "1", "replica", "A"), []sample{{10000, 1}, {20000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("__name__", "up", "a", "1", "replica", "B"), []sample{{10000, 1}, {20000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("__name__", "up", "a", "2", "replica", "A"), []sample{{10000, 1}}),
+	}}
+
+	m, err := labels.NewMatcher(labels.MatchRegexp, "__name__", ".+:.+")
+	testutil.Ok(t, err)
+	ctx := ContextWithNoDedupMatchers(context.Background(), m)
+	q := newQuerier(ctx, nil, 1, 100000, "replica", proxy, true, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	for _, exp := range []struct {
+		lset    labels.Labels
+		samples []sample
+	}{
+		// Rule results are returned as they are, raw series are deduplicated.
+		{lset: labels.FromStrings("__name__", "job:up:sum", "replica", "A"), samples: []sample{{10000, 2}}},
+		{lset: labels.FromStrings("__name__", "job:up:sum", "replica", "B"), samples: []sample{{20000, 2}}},
+		{lset: labels.FromStrings("__name__", "up", "a", "1"), samples: []sample{{10000, 1}, {20000, 1}}},
+		{lset: labels.FromStrings("__name__", "up", "a", "2"), samples: []sample{{10000, 1}}},
+	} {
+		testutil.Assert(t, res.Next(), "expected series")
+		testutil.Equals(t, exp.lset, res.At().Labels())
+		testutil.Equals(t, exp.samples, expandSeries(t, res.At().Iterator()))
+	}
+	testutil.Assert(t, !res.Next(), "expected no more series")
+	testutil.Ok(t, res.Err())
+}
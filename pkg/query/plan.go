@@ -0,0 +1,125 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+)
+
+// QueryPlan describes how selects of a querier were executed: the time range after pruning, the resolution and
+// aggregates requested from stores and the stores contacted or skipped with reasons. It is machine-readable, e.g.
+// to be marshaled to JSON and returned alongside results. It is safe to use concurrently, but must not be marshaled
+// while the querier is in use.
+type QueryPlan struct {
+	mtx sync.Mutex
+
+	// RequestedMinTime and RequestedMaxTime are the time range the querier was created for.
+	RequestedMinTime int64 `json:"requestedMinTime"`
+	RequestedMaxTime int64 `json:"requestedMaxTime"`
+	// MinTime and MaxTime are the time range data is fetched for, after pruning.
+	MinTime int64 `json:"minTime"`
+	MaxTime int64 `json:"maxTime"`
+	// TimePruning lists reasons of pruning the requested time range, if it was pruned.
+	TimePruning []string `json:"timePruning,omitempty"`
+	// MaxSourceResolution is the maximum resolution window of data fetched from stores in milliseconds. Zero means
+	// raw data only.
+	MaxSourceResolution int64  `json:"maxSourceResolution"`
+	Deduplicate         bool   `json:"deduplicate"`
+	ReplicaLabel        string `json:"replicaLabel,omitempty"`
+	PartialResponse     bool   `json:"partialResponse"`
+	// Selects describes each select of the querier, in the order they were made.
+	Selects []SelectPlan `json:"selects"`
+}
+
+// SelectPlan describes how a single select was executed.
+type SelectPlan struct {
+	// Matchers are matchers sent to stores, after simplification.
+	Matchers []string `json:"matchers"`
+	MinTime  int64    `json:"minTime"`
+	MaxTime  int64    `json:"maxTime"`
+	// Resolution is the maximum resolution window requested from stores in milliseconds.
+	Resolution int64 `json:"resolution"`
+	// Aggregates are downsampling aggregates requested from stores.
+	Aggregates []string `json:"aggregates"`
+	// Stores are outcomes of stores for the select, sorted by their names.
+	Stores []StorePlan `json:"stores"`
+}
+
+// StorePlan describes a store in a select.
+type StorePlan struct {
+	Name      string `json:"name"`
+	Contacted bool   `json:"contacted"`
+	// SkipReason tells why the store was not contacted, e.g. "out of time range".
+	SkipReason string `json:"skipReason,omitempty"`
+	Failure    string `json:"failure,omitempty"`
+	Series     int    `json:"series"`
+}
+
+type queryPlanKey struct{}
+
+// ContextWithQueryPlan returns a new context.Context that makes queriers created with it describe how their selects
+// are executed in the given QueryPlan. Stores are described only for selects fetching from a store.ProxyStore.
+func ContextWithQueryPlan(ctx context.Context, p *QueryPlan) context.Context {
+	return context.WithValue(ctx, queryPlanKey{}, p)
+}
+
+func queryPlanFromContext(ctx context.Context) *QueryPlan {
+	p, _ := ctx.Value(queryPlanKey{}).(*QueryPlan)
+	return p
+}
+
+// addSelect records the select made with the given request and outcomes of stores. Nil plan records nothing.
+func (p *QueryPlan) addSelect(r *storepb.SeriesRequest, outcomes *store.StoreOutcomes) {
+	if p == nil {
+		return
+	}
+	sp := SelectPlan{
+		Matchers:   make([]string, 0, len(r.Matchers)),
+		MinTime:    r.MinTime,
+		MaxTime:    r.MaxTime,
+		Resolution: r.MaxResolutionWindow,
+		Aggregates: make([]string, 0, len(r.Aggregates)),
+		Stores:     []StorePlan{},
+	}
+	for _, m := range r.Matchers {
+		sp.Matchers = append(sp.Matchers, matcherString(m))
+	}
+	for _, a := range r.Aggregates {
+		sp.Aggregates = append(sp.Aggregates, a.String())
+	}
+	for name, o := range outcomes.Outcomes() {
+		sp.Stores = append(sp.Stores, StorePlan{
+			Name:       name,
+			Contacted:  o.SkipReason == "",
+			SkipReason: o.SkipReason,
+			Failure:    o.Failure,
+			Series:     o.Series,
+		})
+	}
+	sort.Slice(sp.Stores, func(i, j int) bool { return sp.Stores[i].Name < sp.Stores[j].Name })
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.Selects = append(p.Selects, sp)
+}
+
+// matcherString returns the matcher in PromQL syntax, e.g. job=~"api.*". Case insensitive equality is written as the
+// equivalent regexp.
+func matcherString(m storepb.LabelMatcher) string {
+	switch m.Type {
+	case storepb.LabelMatcher_NEQ:
+		return fmt.Sprintf("%s!=%q", m.Name, m.Value)
+	case storepb.LabelMatcher_RE:
+		return fmt.Sprintf("%s=~%q", m.Name, m.Value)
+	case storepb.LabelMatcher_NRE:
+		return fmt.Sprintf("%s!~%q", m.Name, m.Value)
+	case storepb.LabelMatcher_EQ_CI:
+		return fmt.Sprintf("%s=~%q", m.Name, "(?i)"+regexp.QuoteMeta(m.Value))
+	}
+	return fmt.Sprintf("%s=%q", m.Name, m.Value)
+}
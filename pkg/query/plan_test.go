@@ -0,0 +1,66 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+)
+
+func TestQuerier_Select_QueryPlan(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	clients := []store.Client{
+		store.NewLocalClient(&rangeStoreServer{storeServer: storeServer{resps: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}),
+		}}, mint: math.MinInt64, maxt: math.MaxInt64}, "recent"),
+		store.NewLocalClient(&rangeStoreServer{mint: -200, maxt: -100}, "old"),
+	}
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) { return clients, nil }, nil, store.StoreLimit{})
+
+	var (
+		plan = &QueryPlan{}
+		maxt = timestamp.FromTime(time.Now().Add(time.Hour))
+	)
+	q := newQuerier(ContextWithQueryPlan(context.Background(), plan), nil, 0, maxt, "", proxy, false, 300000, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	m, err := labels.NewMatcher(labels.MatchEqual, "a", "1")
+	testutil.Ok(t, err)
+	res, _, err := q.Select(&storage.SelectParams{Func: "max_over_time"}, m)
+	testutil.Ok(t, err)
+	testutil.Assert(t, res.Next(), "expected series")
+
+	// Time range ending too far in the future is pruned.
+	testutil.Equals(t, maxt, plan.RequestedMaxTime)
+	testutil.Assert(t, plan.MaxTime < maxt, "expected pruned end, got %d", plan.MaxTime)
+	testutil.Equals(t, 1, len(plan.TimePruning))
+	testutil.Equals(t, int64(300000), plan.MaxSourceResolution)
+
+	testutil.Equals(t, 1, len(plan.Selects))
+	sel := plan.Selects[0]
+	testutil.Equals(t, []string{`a="1"`}, sel.Matchers)
+	testutil.Equals(t, int64(300000), sel.Resolution)
+	testutil.Equals(t, []string{storepb.Aggr_MAX.String()}, sel.Aggregates)
+	testutil.Equals(t, []StorePlan{
+		{Name: "old", SkipReason: "out of time range"},
+		{Name: "recent", Contacted: true, Series: 1},
+	}, sel.Stores)
+
+	// Plan is machine-readable.
+	b, err := json.Marshal(plan)
+	testutil.Ok(t, err)
+	for _, exp := range []string{`"resolution":300000`, `"name":"old","contacted":false,"skipReason":"out of time range"`, `"name":"recent","contacted":true`} {
+		testutil.Assert(t, strings.Contains(string(b), exp), "expected %s in plan %s", exp, b)
+	}
+}
@@ -2,17 +2,25 @@ package query
 
 import (
 	"context"
+	"fmt"
 	"sort"
+	"strconv"
 	"strings"
-
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/store"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/tracing"
 	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/prometheus/storage"
+	"golang.org/x/sync/errgroup"
 )
 
 // WarningReporter allows to report warnings to frontend layer.
@@ -28,8 +36,107 @@ type WarningReporter func(error)
 // partialResponse controls `partialResponseDisabled` option of StoreAPI and partial response behaviour of proxy.
 type QueryableCreator func(deduplicate bool, maxSourceResolution time.Duration, partialResponse bool, r WarningReporter) storage.Queryable
 
+// Querier is storage.Querier of the query node, with methods answering questions about stores and data beyond
+// selecting series. Queriers of queryables created by NewQueryableCreator implement it.
+type Querier interface {
+	storage.Querier
+
+	// StoresWithMetric returns addresses of stores that have series of the given metric within the querier time range.
+	StoresWithMetric(metric string) ([]string, error)
+	// Stats returns statistics of series selected by the querier.
+	Stats() Stats
+	// EstimateCost estimates cost of selecting series matching the given matchers within the querier time range.
+	EstimateCost(ms ...*labels.Matcher) (CostEstimate, error)
+	// CapacityStats returns totals of data held by stores, regardless of the querier time range.
+	CapacityStats() (CapacityStats, error)
+	// SelectStream selects series like Select, but calls f for every merged and deduplicated series as soon as it is
+	// complete instead of holding all of them.
+	SelectStream(params *storage.SelectParams, f func(labels.Labels, storage.SeriesIterator) error, ms ...*labels.Matcher) error
+	// MultiLabelValues returns values of each of the given label names, fetching them from stores in parallel.
+	MultiLabelValues(names []string) (map[string][]string, error)
+}
+
+var _ Querier = &querier{}
+
+// maxExplainedStores is the maximum number of stores listed by warnings explaining empty results of selects.
+const maxExplainedStores = 10
+
+// QuerierOpts holds query node wide options of queriers.
+type QuerierOpts struct {
+	// MaxQueryRange is the maximum allowed time range (maxt - mint) of a single Select. Zero means no limit.
+	// For PromQL queries it is the end - start of the query widened by the lookback delta, range selector widths
+	// and offsets, not only the end - start. It can be overridden per request by ContextWithMaxQueryRange.
+	MaxQueryRange time.Duration
+	// DecodePool optionally bounds concurrent chunk decodes of all queriers.
+	DecodePool *DecodePool
+	// ParallelDecodeMinChunks is the minimum number of chunks of a series for its chunks to be decoded in parallel
+	// within DecodePool before iterating it. Zero disables parallel decoding, as does missing DecodePool.
+	ParallelDecodeMinChunks int
+	// DedupMetrics optionally counts deduplication statistics of all queriers.
+	DedupMetrics *DedupMetrics
+	// SeriesBatchSize is the number of series above which Series requests to stores reporting their estimated number
+	// of series are split into shards of about that many series. Zero disables splitting.
+	SeriesBatchSize int64
+	// ShadowDedup optionally compares results of deduplicated selects with another deduplication strategy.
+	ShadowDedup *ShadowDedup
+	// ChunkCache optionally holds raw chunks fetched by all queriers, so repeated queries reuse them.
+	ChunkCache *store.ChunkCache
+	// MaxStoreChunkBytes is the maximum number of bytes of chunks a select receives from a single store. Receiving from
+	// stores over it stops with a warning, or fails the select if partial response is disabled. Zero disables the limit.
+	MaxStoreChunkBytes int64
+	// ReplicaLabelIgnoreCase makes deduplication treat labels with name equal to the replica label ignoring case as
+	// the replica label, so replicas whose configs differ by its casing are still deduplicated.
+	ReplicaLabelIgnoreCase bool
+	// ReplicaPriority lists replica label values whose replicas deduplication prefers, in the listed order, over
+	// other replicas. Replicas not listed are preferred in order of their labels.
+	ReplicaPriority []string
+	// MaxSeriesChunks is the maximum number of chunks of a single series fetched by a select. Series with more chunks
+	// are handled according to ExcessChunks. Zero disables the limit.
+	MaxSeriesChunks int
+	// ExcessChunks defines handling of series over MaxSeriesChunks, SeriesPolicyWarn if empty.
+	ExcessChunks SeriesPolicy
+	// MaxStaleness is the maximum age of the freshest sample of a series, relative to the end of the querier time range
+	// or now, whichever is earlier. Series with older freshest sample in all replicas are handled according to StaleSeries, so
+	// silently stale series are surfaced. Zero disables the bound.
+	MaxStaleness time.Duration
+	// StaleSeries defines handling of series over MaxStaleness, SeriesPolicyWarn if empty.
+	StaleSeries SeriesPolicy
+	// FutureGrace is how far in the future querier time range may end. Later end is clamped to it, as no data is
+	// expected there and stores would process the range for nothing. Samples within it are kept, so the freshest samples
+	// of replicas with clocks slightly ahead of the querier are not dropped. Nil means the default of 5m, zero clamps
+	// the end to now.
+	FutureGrace *time.Duration
+	// MaxDataAge is the maximum age of data fetched by queriers. Querier time range starting earlier is clamped to
+	// start at now minus MaxDataAge and a warning is returned, so accidental wide queries don't reach expensive
+	// historical stores. Querier time range ending earlier returns no data with a warning. Zero disables the limit.
+	MaxDataAge time.Duration
+	// ExplainEmptyResults makes selects with no series return a warning listing outcome of stores, up to 10 of them:
+	// skipped and why, failed or contacted with no series. It helps telling missing data from unavailable stores.
+	ExplainEmptyResults bool
+	// ResponseSizeWarning is the number of bytes of chunks received by a querier over which a warning is returned
+	// and the query is logged, to help noticing expensive queries. Queries are not limited by it. Zero disables the
+	// warning.
+	ResponseSizeWarning int64
+	// RetryBudget is the maximum number of retries of failed store calls by a single querier, across all stores and
+	// selects. Once it is exhausted, failures are not retried, so retries don't amplify load of stores during an
+	// incident. Zero disables the budget.
+	RetryBudget int
+	// RequiredLabels are label names every select must match by an equality matcher with non-empty value, or a regexp
+	// matcher of such single literal value, e.g. the namespace in shared environments. Selects without such matchers are rejected before any fanout, so accidental
+	// cluster wide scans never reach stores.
+	RequiredLabels []string
+	// RelabelConfigs are applied to labels of series returned by selects after merging and deduplication, e.g. to
+	// rename metrics or labels for presentation. Series dropped by them are not returned.
+	RelabelConfigs []*relabel.Config
+	// LabelValuesConcurrency is the maximum number of concurrent LabelValues calls of stores made by a single
+	// MultiLabelValues call, across all its labels. Zero means no limit.
+	LabelValuesConcurrency int
+}
+
 // NewQueryableCreator creates QueryableCreator.
-func NewQueryableCreator(logger log.Logger, proxy storepb.StoreServer, replicaLabel string) QueryableCreator {
+// Created queryables are cheap: store connections and metadata are owned by the long-lived proxy and shared
+// across queries, so only the Select state is kept per query.
+func NewQueryableCreator(logger log.Logger, proxy storepb.StoreServer, replicaLabel string, opts QuerierOpts) QueryableCreator {
 	return func(deduplicate bool, maxSourceResolution time.Duration, partialResponse bool, r WarningReporter) storage.Queryable {
 		return &queryable{
 			logger:              logger,
@@ -39,10 +146,375 @@ func NewQueryableCreator(logger log.Logger, proxy storepb.StoreServer, replicaLa
 			maxSourceResolution: maxSourceResolution,
 			partialResponse:     partialResponse,
 			warningReporter:     r,
+			opts:                opts,
 		}
 	}
 }
 
+type maxQueryRangeKey struct{}
+
+// ContextWithMaxQueryRange returns a new context.Context that overrides QuerierOpts.MaxQueryRange for queriers
+// created with it. Zero disables the limit. It is meant to be used only for trusted callers.
+func ContextWithMaxQueryRange(ctx context.Context, maxQueryRange time.Duration) context.Context {
+	return context.WithValue(ctx, maxQueryRangeKey{}, maxQueryRange)
+}
+
+func maxQueryRangeFromContext(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(maxQueryRangeKey{}).(time.Duration)
+	return d, ok
+}
+
+type replicaLabelKey struct{}
+
+// ContextWithReplicaLabel returns a new context.Context that makes queriers created with it deduplicate series along
+// the given replica label instead of the configured one. Empty label disables deduplication.
+func ContextWithReplicaLabel(ctx context.Context, replicaLabel string) context.Context {
+	return context.WithValue(ctx, replicaLabelKey{}, replicaLabel)
+}
+
+func replicaLabelFromContext(ctx context.Context) (string, bool) {
+	l, ok := ctx.Value(replicaLabelKey{}).(string)
+	return l, ok
+}
+
+type queryStepKey struct{}
+
+// ContextWithQueryStep returns a new context.Context that tells queriers created with it the step of the range query
+// they serve. Selections of the query are hinted with it to stores when PromQL does not pass it, and buckets of query
+// time downsampling are aligned to its multiples. Without it, selections rely on the step passed by PromQL.
+func ContextWithQueryStep(ctx context.Context, step time.Duration) context.Context {
+	return context.WithValue(ctx, queryStepKey{}, step)
+}
+
+func queryStepFromContext(ctx context.Context) int64 {
+	d, _ := ctx.Value(queryStepKey{}).(time.Duration)
+	return int64(d / time.Millisecond)
+}
+
+// DedupStrategy defines how samples of series replicas are merged during deduplication.
+type DedupStrategy string
+
+const (
+	// DedupPenalty follows single replica and switches to other one only when there is a gap in data.
+	// Switching is penalized to not increase sampling frequency. It is the default strategy.
+	DedupPenalty DedupStrategy = "penalty"
+	// DedupFreshest works like DedupPenalty, but at the trailing edge of the query, where only some replicas have data,
+	// samples of the replica with the most recent data are used without penalty. This minimizes staleness of live data.
+	DedupFreshest DedupStrategy = "freshest"
+)
+
+// ParseDedupStrategy parses DedupStrategy from its name.
+func ParseDedupStrategy(s string) (DedupStrategy, error) {
+	switch DedupStrategy(s) {
+	case DedupPenalty, DedupFreshest:
+		return DedupStrategy(s), nil
+	}
+	return "", errors.Errorf("unknown dedup strategy %q", s)
+}
+
+type dedupStrategyKey struct{}
+
+// ContextWithDedupStrategy returns a new context.Context that sets DedupStrategy for queriers created with it.
+func ContextWithDedupStrategy(ctx context.Context, strategy DedupStrategy) context.Context {
+	return context.WithValue(ctx, dedupStrategyKey{}, strategy)
+}
+
+func dedupStrategyFromContext(ctx context.Context) DedupStrategy {
+	if s, ok := ctx.Value(dedupStrategyKey{}).(DedupStrategy); ok {
+		return s
+	}
+	return DedupPenalty
+}
+
+// DuplicateLabels defines how series with multiple labels of the same name are handled. Such series are sent only
+// by malformed stores and their label set is ambiguous.
+type DuplicateLabels string
+
+const (
+	// DuplicateLabelsError fails the query, or drops the series with a warning if partial response is enabled.
+	// It is the default.
+	DuplicateLabelsError DuplicateLabels = "error"
+	// DuplicateLabelsKeepFirst keeps the first of labels with the same name.
+	DuplicateLabelsKeepFirst DuplicateLabels = "keep-first"
+	// DuplicateLabelsKeepLast keeps the last of labels with the same name.
+	DuplicateLabelsKeepLast DuplicateLabels = "keep-last"
+)
+
+type duplicateLabelsKey struct{}
+
+// ContextWithDuplicateLabels returns a new context.Context that sets handling of series with duplicate label names
+// for queriers created with it.
+func ContextWithDuplicateLabels(ctx context.Context, handling DuplicateLabels) context.Context {
+	return context.WithValue(ctx, duplicateLabelsKey{}, handling)
+}
+
+func duplicateLabelsFromContext(ctx context.Context) DuplicateLabels {
+	if h, ok := ctx.Value(duplicateLabelsKey{}).(DuplicateLabels); ok {
+		return h
+	}
+	return DuplicateLabelsError
+}
+
+// DuplicateSamples defines how samples of a series with the same timestamp are handled. Stores may send them e.g. in
+// overlapping chunks of a single series. The first of them is always kept, as PromQL expects timestamps of a series to
+// increase. Series iterated for counter functions handle them on their own.
+type DuplicateSamples string
+
+const (
+	// DuplicateSamplesKeepFirst drops samples with the same timestamp as the previous one. It is the default.
+	DuplicateSamplesKeepFirst DuplicateSamples = "keep-first"
+	// DuplicateSamplesError fails iteration of the series if samples with the same timestamp have different values.
+	// Exact duplicates are dropped.
+	DuplicateSamplesError DuplicateSamples = "error"
+)
+
+// SeriesPolicy defines how series flagged by a check of the querier are handled, e.g. series with more chunks than the
+// querier limit or stale series. An abnormal number of chunks, often tiny ones, points at an unhealthy TSDB, e.g. a
+// Prometheus restarting in a loop, and is expensive to query. Stale series are often silently stale, e.g. of targets
+// no longer scraped or of stores lagging behind.
+type SeriesPolicy string
+
+const (
+	// SeriesPolicyWarn keeps flagged series and returns a warning with their number and labels of a few of them. It is
+	// the default.
+	SeriesPolicyWarn SeriesPolicy = "warn"
+	// SeriesPolicySkip drops flagged series from the result and returns the warning.
+	SeriesPolicySkip SeriesPolicy = "skip"
+)
+
+// maxFlaggedSeriesExamples is the maximum number of series whose labels are listed by a warning about flagged series.
+const maxFlaggedSeriesExamples = 3
+
+// flaggedSeries counts series flagged by a check of the querier, so a select reports them with a single warning
+// instead of one per series.
+type flaggedSeries struct {
+	count    int
+	examples []string
+}
+
+func (f *flaggedSeries) add(lset []storepb.Label) {
+	f.addKey(storepb.LabelsToPromLabels(lset).String())
+}
+
+// addKey flags the series identified by the given string of its labels.
+func (f *flaggedSeries) addKey(key string) {
+	f.count++
+	if len(f.examples) < maxFlaggedSeriesExamples {
+		f.examples = append(f.examples, key)
+	}
+}
+
+// warning returns the warning about flagged series with the given description of the check, or nil if no series was
+// flagged.
+func (f *flaggedSeries) warning(policy SeriesPolicy, desc string) error {
+	if f.count == 0 {
+		return nil
+	}
+	examples := strings.Join(f.examples, ", ")
+	if f.count > len(f.examples) {
+		examples += ", ..."
+	}
+	err := errors.Errorf("%d series %s: %s", f.count, desc, examples)
+	if policy == SeriesPolicySkip {
+		return errors.Wrap(err, "skipped series")
+	}
+	return err
+}
+
+type duplicateSamplesKey struct{}
+
+// ContextWithDuplicateSamples returns a new context.Context that sets handling of samples with duplicate timestamps
+// within a series for queriers created with it.
+func ContextWithDuplicateSamples(ctx context.Context, handling DuplicateSamples) context.Context {
+	return context.WithValue(ctx, duplicateSamplesKey{}, handling)
+}
+
+func duplicateSamplesFromContext(ctx context.Context) DuplicateSamples {
+	if h, ok := ctx.Value(duplicateSamplesKey{}).(DuplicateSamples); ok {
+		return h
+	}
+	return DuplicateSamplesKeepFirst
+}
+
+type dedupSmoothingKey struct{}
+
+// ContextWithDedupSmoothing returns a new context.Context that makes queriers created with it smooth replica switches
+// of deduplicated series. Replicas of a gauge often differ slightly due to scrape timing, which shows up as jumps
+// where deduplication switches between them. If the first sample after a switch differs from the previous one by at
+// most tolerance relative to the larger of their absolute values, it is replaced by their mean. Larger differences
+// are kept as real changes. Selections for counter functions like rate are never smoothed, as it would break them.
+func ContextWithDedupSmoothing(ctx context.Context, tolerance float64) context.Context {
+	return context.WithValue(ctx, dedupSmoothingKey{}, tolerance)
+}
+
+func dedupSmoothingFromContext(ctx context.Context) float64 {
+	v, _ := ctx.Value(dedupSmoothingKey{}).(float64)
+	return v
+}
+
+// defaultLookbackDelta is the lookback delta used when none is set on the context, the same as the Prometheus default.
+const defaultLookbackDelta = 5 * time.Minute
+
+type lookbackDeltaKey struct{}
+
+// ContextWithLookbackDelta returns a new context.Context that sets lookback delta for queriers created with it. It
+// is how long a sample is considered current, like for PromQL evaluation. Deduplication never skips samples of other
+// replicas further than that after the last sample of the followed one, so longer gaps are always filled. Gaps of
+// downsampled series are filled with raw data only if they are longer than both the downsample window and the lookback
+// delta, as shorter ones are covered by PromQL. It defaults to 5m.
+func ContextWithLookbackDelta(ctx context.Context, delta time.Duration) context.Context {
+	return context.WithValue(ctx, lookbackDeltaKey{}, delta)
+}
+
+func lookbackDeltaFromContext(ctx context.Context) int64 {
+	d, ok := ctx.Value(lookbackDeltaKey{}).(time.Duration)
+	if !ok {
+		d = defaultLookbackDelta
+	}
+	return int64(d / time.Millisecond)
+}
+
+// SeriesOrder defines the order of series returned by Select.
+type SeriesOrder string
+
+const (
+	// SeriesOrderLabels orders series lexicographically by their labels. It is the default order.
+	SeriesOrderLabels SeriesOrder = "labels"
+	// SeriesOrderHash orders series by stable hash of their labels, so consumers sharding series by hash
+	// can range-partition the result. Series are buffered in memory to be sorted.
+	SeriesOrderHash SeriesOrder = "hash"
+)
+
+type seriesOrderKey struct{}
+
+// ContextWithSeriesOrder returns a new context.Context that sets SeriesOrder for queriers created with it.
+func ContextWithSeriesOrder(ctx context.Context, order SeriesOrder) context.Context {
+	return context.WithValue(ctx, seriesOrderKey{}, order)
+}
+
+func seriesOrderFromContext(ctx context.Context) SeriesOrder {
+	if o, ok := ctx.Value(seriesOrderKey{}).(SeriesOrder); ok {
+		return o
+	}
+	return SeriesOrderLabels
+}
+
+type seriesComparatorKey struct{}
+
+// ContextWithSeriesComparator returns a new context.Context that makes queriers created with it order series by the
+// given comparator instead of their labels, e.g. by a specific label first with storepb.CompareLabelsBy. Received
+// series are sorted by it before deduplication, which orders replicas by it too, so it must order series differing
+// only in the replica label next to each other. SeriesOrderHash takes precedence over it and SelectStream ignores it.
+func ContextWithSeriesComparator(ctx context.Context, cmp storepb.LabelsComparator) context.Context {
+	return context.WithValue(ctx, seriesComparatorKey{}, cmp)
+}
+
+func seriesComparatorFromContext(ctx context.Context) storepb.LabelsComparator {
+	cmp, _ := ctx.Value(seriesComparatorKey{}).(storepb.LabelsComparator)
+	return cmp
+}
+
+// LabelValuesSort defines order of values returned by LabelValues.
+type LabelValuesSort string
+
+const (
+	// LabelValuesSortLexicographic sorts values as strings. It is the default order.
+	LabelValuesSortLexicographic LabelValuesSort = "lexicographic"
+	// LabelValuesSortNumeric sorts values as numbers if all of them are numbers, like values of `le`, `quantile`
+	// or port labels. Otherwise values are sorted lexicographically.
+	LabelValuesSortNumeric LabelValuesSort = "numeric"
+)
+
+// ParseLabelValuesSort parses LabelValuesSort from its name.
+func ParseLabelValuesSort(s string) (LabelValuesSort, error) {
+	switch LabelValuesSort(s) {
+	case LabelValuesSortLexicographic, LabelValuesSortNumeric:
+		return LabelValuesSort(s), nil
+	}
+	return "", errors.Errorf("unknown label values sort %q", s)
+}
+
+type labelValuesSortKey struct{}
+
+// ContextWithLabelValuesSort returns a new context.Context that sets LabelValuesSort for queriers created with it.
+func ContextWithLabelValuesSort(ctx context.Context, sort LabelValuesSort) context.Context {
+	return context.WithValue(ctx, labelValuesSortKey{}, sort)
+}
+
+func labelValuesSortFromContext(ctx context.Context) LabelValuesSort {
+	if s, ok := ctx.Value(labelValuesSortKey{}).(LabelValuesSort); ok {
+		return s
+	}
+	return LabelValuesSortLexicographic
+}
+
+type labelValuesLimitKey struct{}
+
+// ContextWithLabelValuesLimit returns a new context.Context that makes queriers created with it return at most limit
+// label values. Values beyond the limit in lexicographic order are dropped by stores and the merge, and a warning
+// is reported. Numeric sort is applied to the kept values only.
+func ContextWithLabelValuesLimit(ctx context.Context, limit int) context.Context {
+	return context.WithValue(ctx, labelValuesLimitKey{}, limit)
+}
+
+func labelValuesLimitFromContext(ctx context.Context) int {
+	v, _ := ctx.Value(labelValuesLimitKey{}).(int)
+	return v
+}
+
+type chunkRefsKey struct{}
+
+// ContextWithChunkRefs returns a new context.Context that makes queriers created with it return series implementing
+// ChunkSeries. Chunks of such series are decoded only once their samples are iterated, which lets query engines that
+// evaluate lazily skip decoding of chunks they don't use.
+func ContextWithChunkRefs(ctx context.Context) context.Context {
+	return context.WithValue(ctx, chunkRefsKey{}, true)
+}
+
+func chunkRefsFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(chunkRefsKey{}).(bool)
+	return v
+}
+
+// SampleFilter reports whether the sample should be kept.
+type SampleFilter func(t int64, v float64) bool
+
+// ValueRangeFilter returns SampleFilter keeping samples with values within [min, max]. NaN values, like staleness
+// markers, are kept.
+func ValueRangeFilter(min, max float64) SampleFilter {
+	return func(_ int64, v float64) bool {
+		return !(v < min || v > max)
+	}
+}
+
+type sampleFilterKey struct{}
+
+// ContextWithSampleFilter returns a new context.Context that makes queriers created with it drop samples rejected by
+// the filter while iterating chunks, before they reach the query engine. It helps to hide invalid values of noisy
+// sources, e.g. negative readings of faulty sensors.
+func ContextWithSampleFilter(ctx context.Context, f SampleFilter) context.Context {
+	return context.WithValue(ctx, sampleFilterKey{}, f)
+}
+
+func sampleFilterFromContext(ctx context.Context) SampleFilter {
+	f, _ := ctx.Value(sampleFilterKey{}).(SampleFilter)
+	return f
+}
+
+type chunkEncodingKey struct{}
+
+// ContextWithChunkEncoding returns a new context.Context that makes queriers created with it ask stores for chunks in
+// the given encoding, e.g. downsampled aggregates even for recent data to reduce bytes received, or raw chunks only.
+// Stores not holding data in the preferred encoding send it in another one, which is decoded as usual.
+func ContextWithChunkEncoding(ctx context.Context, e storepb.SeriesRequest_ChunkEncoding) context.Context {
+	return context.WithValue(ctx, chunkEncodingKey{}, e)
+}
+
+func chunkEncodingFromContext(ctx context.Context) storepb.SeriesRequest_ChunkEncoding {
+	e, _ := ctx.Value(chunkEncodingKey{}).(storepb.SeriesRequest_ChunkEncoding)
+	return e
+}
+
 type queryable struct {
 	logger              log.Logger
 	replicaLabel        string
@@ -51,11 +523,13 @@ type queryable struct {
 	maxSourceResolution time.Duration
 	partialResponse     bool
 	warningReporter     WarningReporter
+	opts                QuerierOpts
 }
 
 // Querier returns a new storage querier against the underlying proxy store API.
+// It can be called many times on the same queryable. Closing the returned querier cancels only its own requests.
 func (q *queryable) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
-	return newQuerier(ctx, q.logger, mint, maxt, q.replicaLabel, q.proxy, q.deduplicate, int64(q.maxSourceResolution/time.Millisecond), q.partialResponse, q.warningReporter), nil
+	return newQuerier(ctx, q.logger, mint, maxt, q.replicaLabel, q.proxy, q.deduplicate, int64(q.maxSourceResolution/time.Millisecond), q.partialResponse, q.warningReporter, q.opts), nil
 }
 
 type querier struct {
@@ -63,16 +537,68 @@ type querier struct {
 	logger              log.Logger
 	cancel              func()
 	mint, maxt          int64
+	step                int64
 	replicaLabel        string
 	proxy               storepb.StoreServer
 	deduplicate         bool
 	maxSourceResolution int64
 	partialResponse     bool
 	warningReporter     WarningReporter
+	maxQueryRange       time.Duration
+	decodePool          *DecodePool
+	parallelDecode      int
+	dedupStrategy       DedupStrategy
+	dedupSmoothing      float64
+	stats               *dedupStats
+	transfer            *transferStats
+	seriesOrder         SeriesOrder
+	chunkRefs           bool
+	labelValuesSort     LabelValuesSort
+	labelValuesLimit    int
+	labelValuesParallel int
+	sampleFilter        SampleFilter
+	queriedBlocks       *queriedBlocks
+	reportBlocks        bool
+	storeOutcomes       *store.StoreOutcomes
+	plan                *QueryPlan
+	stepDownsampling    bool
+	storeHealthSeries   bool
+	duplicateLabels     DuplicateLabels
+	duplicateSamples    DuplicateSamples
+	dedupChunks         bool
+	chunkEncoding       storepb.SeriesRequest_ChunkEncoding
+	lookbackDelta       int64
+	shadowDedup         *ShadowDedup
+	replicaIgnoreCase   bool
+	replicaPriority     []string
+	maxSeriesChunks     int
+	excessChunks        SeriesPolicy
+	maxStaleness        time.Duration
+	staleSeries         SeriesPolicy
+	responseSizeWarning int64
+	explainEmpty        bool
+	requiredLabels      []string
+	relabelConfigs      []*relabel.Config
+	grouping            *Grouping
+	latestSample        bool
+	// Optional order of series other than by their labels.
+	seriesComparator storepb.LabelsComparator
+	noDedupMatchers  []*labels.Matcher
+	// rangeErr is returned by methods fetching data if the querier time range is invalid.
+	rangeErr error
+	// rangeWarning is reported once by the first select if the querier time range was clamped by the data age floor.
+	rangeWarning     error
+	rangeWarningOnce sync.Once
+	// expired is true if the whole querier time range is older than the data age floor. Methods fetching data return
+	// no data then, without asking stores.
+	expired bool
 }
 
+// defaultFutureGrace is how far in the future querier time range may end by default, see QuerierOpts.FutureGrace.
+const defaultFutureGrace = 5 * time.Minute
+
 // newQuerier creates implementation of storage.Querier that fetches data from the proxy
-// store API endpoints.
+// store API endpoints. Time range ending before it starts makes all data fetching methods fail.
 func newQuerier(
 	ctx context.Context,
 	logger log.Logger,
@@ -83,6 +609,7 @@ func newQuerier(
 	maxSourceResolution int64,
 	partialResponse bool,
 	warningReporter WarningReporter,
+	opts QuerierOpts,
 ) *querier {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -90,20 +617,153 @@ func newQuerier(
 	if warningReporter == nil {
 		warningReporter = func(error) {}
 	}
-	ctx, cancel := context.WithCancel(ctx)
+	maxQueryRange := opts.MaxQueryRange
+	if d, ok := maxQueryRangeFromContext(ctx); ok {
+		maxQueryRange = d
+	}
+	if l, ok := replicaLabelFromContext(ctx); ok {
+		replicaLabel = l
+	}
+	futureGrace := defaultFutureGrace
+	if opts.FutureGrace != nil {
+		futureGrace = *opts.FutureGrace
+	}
+	plan := queryPlanFromContext(ctx)
+	if plan != nil {
+		plan.RequestedMinTime, plan.RequestedMaxTime = mint, maxt
+	}
+	var rangeErr error
+	if maxt < mint {
+		rangeErr = errors.Errorf("invalid query time range, end %d is before start %d", maxt, mint)
+	} else if limit := timestamp.FromTime(time.Now().Add(futureGrace)); maxt > limit {
+		if plan != nil {
+			plan.TimePruning = append(plan.TimePruning, fmt.Sprintf("end clamped to future grace period of %s", futureGrace))
+		}
+		maxt = limit
+		if maxt < mint {
+			maxt = mint
+		}
+	}
+	var (
+		rangeWarning error
+		expired      bool
+	)
+	if opts.MaxDataAge > 0 && rangeErr == nil {
+		floor := timestamp.FromTime(time.Now().Add(-opts.MaxDataAge))
+		switch {
+		case maxt < floor:
+			// Nothing is left after clamping, so stores are not asked at all.
+			rangeWarning = errors.Errorf("query ends before the maximum data age of %s, no data is returned", opts.MaxDataAge)
+			if plan != nil {
+				plan.TimePruning = append(plan.TimePruning, fmt.Sprintf("whole range older than maximum data age of %s", opts.MaxDataAge))
+			}
+			expired = true
+		case mint < floor:
+			rangeWarning = errors.Errorf("query starts before the maximum data age of %s, only data from %s on is returned",
+				opts.MaxDataAge, timestamp.Time(floor).UTC().Format(time.RFC3339))
+			if plan != nil {
+				plan.TimePruning = append(plan.TimePruning, fmt.Sprintf("start clamped to maximum data age of %s", opts.MaxDataAge))
+			}
+			mint = floor
+		}
+	}
+	if plan != nil {
+		plan.MinTime, plan.MaxTime = mint, maxt
+		plan.MaxSourceResolution = maxSourceResolution
+		plan.Deduplicate, plan.ReplicaLabel, plan.PartialResponse = deduplicate, replicaLabel, partialResponse
+	}
+	if opts.SeriesBatchSize > 0 {
+		ctx = store.ContextWithSeriesBatchSize(ctx, opts.SeriesBatchSize)
+	}
+	if opts.ChunkCache != nil {
+		ctx = store.ContextWithChunkCache(ctx, opts.ChunkCache)
+	}
+	if opts.MaxStoreChunkBytes > 0 {
+		ctx = store.ContextWithStoreChunkBytesLimit(ctx, opts.MaxStoreChunkBytes)
+	}
+	if opts.RetryBudget > 0 {
+		ctx = store.ContextWithRetryBudget(ctx, store.NewRetryBudget(opts.RetryBudget))
+	}
+	storeOutcomes := store.NewStoreOutcomes()
+	ctx = store.ContextWithStoreOutcomes(ctx, storeOutcomes)
+	transfer := &transferStats{}
+	ctx, cancel := context.WithCancel(contextWithTransferStats(ctx, transfer))
 	return &querier{
 		ctx:                 ctx,
 		logger:              logger,
 		cancel:              cancel,
 		mint:                mint,
 		maxt:                maxt,
+		step:                queryStepFromContext(ctx),
 		replicaLabel:        replicaLabel,
 		proxy:               proxy,
 		deduplicate:         deduplicate,
 		maxSourceResolution: maxSourceResolution,
 		partialResponse:     partialResponse,
 		warningReporter:     warningReporter,
+		maxQueryRange:       maxQueryRange,
+		decodePool:          opts.DecodePool,
+		parallelDecode:      opts.ParallelDecodeMinChunks,
+		dedupStrategy:       dedupStrategyFromContext(ctx),
+		dedupSmoothing:      dedupSmoothingFromContext(ctx),
+		stats:               &dedupStats{metrics: opts.DedupMetrics, perSeries: dedupStatsFromContext(ctx)},
+		transfer:            transfer,
+		seriesOrder:         seriesOrderFromContext(ctx),
+		chunkRefs:           chunkRefsFromContext(ctx),
+		labelValuesSort:     labelValuesSortFromContext(ctx),
+		labelValuesLimit:    labelValuesLimitFromContext(ctx),
+		labelValuesParallel: opts.LabelValuesConcurrency,
+		sampleFilter:        sampleFilterFromContext(ctx),
+		queriedBlocks:       &queriedBlocks{},
+		reportBlocks:        queriedBlocksFromContext(ctx),
+		storeOutcomes:       storeOutcomes,
+		plan:                plan,
+		stepDownsampling:    stepDownsamplingFromContext(ctx),
+		storeHealthSeries:   storeHealthSeriesFromContext(ctx),
+		duplicateLabels:     duplicateLabelsFromContext(ctx),
+		duplicateSamples:    duplicateSamplesFromContext(ctx),
+		dedupChunks:         dedupChunksFromContext(ctx),
+		chunkEncoding:       chunkEncodingFromContext(ctx),
+		lookbackDelta:       lookbackDeltaFromContext(ctx),
+		shadowDedup:         opts.ShadowDedup,
+		replicaIgnoreCase:   opts.ReplicaLabelIgnoreCase,
+		replicaPriority:     opts.ReplicaPriority,
+		maxSeriesChunks:     opts.MaxSeriesChunks,
+		excessChunks:        opts.ExcessChunks,
+		maxStaleness:        opts.MaxStaleness,
+		staleSeries:         opts.StaleSeries,
+		responseSizeWarning: opts.ResponseSizeWarning,
+		explainEmpty:        opts.ExplainEmptyResults,
+		requiredLabels:      opts.RequiredLabels,
+		relabelConfigs:      opts.RelabelConfigs,
+		grouping:            groupingFromContext(ctx),
+		latestSample:        latestSampleFromContext(ctx),
+		seriesComparator:    seriesComparatorFromContext(ctx),
+		noDedupMatchers:     noDedupMatchersFromContext(ctx),
+		rangeErr:            rangeErr,
+		rangeWarning:        rangeWarning,
+		expired:             expired,
+	}
+}
+
+// reportRangeWarning reports the warning about clamped time range of the querier, if any, once.
+func (q *querier) reportRangeWarning() {
+	if q.rangeWarning == nil {
+		return
 	}
+	q.rangeWarningOnce.Do(func() { q.warningReporter(q.rangeWarning) })
+}
+
+// addChunkBytes accounts bytes of chunks received by a select to transfer stats of the querier. It returns a warning
+// if they make the querier cross the response size warning threshold, so the warning is returned only once.
+func (q *querier) addChunkBytes(n int64) error {
+	total := atomic.AddInt64(&q.transfer.chunkBytes, n)
+	if q.responseSizeWarning <= 0 || total <= q.responseSizeWarning || total-n > q.responseSizeWarning {
+		return nil
+	}
+	level.Info(q.logger).Log("msg", "query response exceeds size warning threshold", "bytes", total,
+		"threshold", q.responseSizeWarning, "mint", q.mint, "maxt", q.maxt)
+	return errors.Errorf("query response has %d bytes of chunks, over the size warning threshold of %d bytes", total, q.responseSizeWarning)
 }
 
 func (q *querier) isDedupEnabled() bool {
@@ -113,10 +773,24 @@ func (q *querier) isDedupEnabled() bool {
 type seriesServer struct {
 	// This field just exist to pseudo-implement the unused methods of the interface.
 	storepb.Store_SeriesServer
-	ctx context.Context
+	ctx             context.Context
+	partialResponse bool
+	duplicateLabels DuplicateLabels
+
+	seriesSet     []storepb.Series
+	warnings      []string
+	queriedBlocks []storepb.QueriedBlocks
+	// Bytes of chunks of received series.
+	chunkBytes int64
+	// True if series were received not ordered by labels, from a misbehaving store.
+	outOfOrder bool
+	// Series with more chunks than the querier limit and series over the staleness bound.
+	excessChunks flaggedSeries
+	staleSeries  flaggedSeries
 
-	seriesSet []storepb.Series
-	warnings  []string
+	// Label names and values are mostly repeated across series. Interning them lets strings of each received
+	// response be garbage collected instead of being held until the query finishes.
+	interner stringInterner
 }
 
 func (s *seriesServer) Send(r *storepb.SeriesResponse) error {
@@ -124,14 +798,179 @@ func (s *seriesServer) Send(r *storepb.SeriesResponse) error {
 		s.warnings = append(s.warnings, r.GetWarning())
 		return nil
 	}
+	if qb := r.GetQueriedBlocks(); qb != nil {
+		s.queriedBlocks = append(s.queriedBlocks, *qb)
+		return nil
+	}
 
 	if r.GetSeries() == nil {
 		return errors.New("no seriesSet")
 	}
-	s.seriesSet = append(s.seriesSet, *r.GetSeries())
+
+	series := *r.GetSeries()
+	if err := s.validateLabels(&series); err != nil {
+		if !s.partialResponse {
+			return err
+		}
+		s.warnings = append(s.warnings, err.Error())
+		return nil
+	}
+	if err := s.validateChunks(&series); err != nil {
+		return err
+	}
+	if len(series.Chunks) == 0 && len(r.GetSeries().Chunks) > 0 {
+		// All chunks were invalid, nothing left to query.
+		return nil
+	}
+	for _, c := range series.Chunks {
+		s.chunkBytes += int64(c.Size())
+	}
+	// Stores may split a series into multiple consecutive responses with successive chunks. Coalesce them,
+	// so the series is not seen as duplicated.
+	if n := len(s.seriesSet); n > 0 && storepb.CompareLabels(s.seriesSet[n-1].Labels, series.Labels) == 0 {
+		// Chunks may share backing array with the received response, so don't append in place.
+		prev := s.seriesSet[n-1].Chunks
+		s.seriesSet[n-1].Chunks = append(prev[:len(prev):len(prev)], series.Chunks...)
+		return nil
+	}
+	if n := len(s.seriesSet); n > 0 && storepb.CompareLabels(series.Labels, s.seriesSet[n-1].Labels) < 0 {
+		s.outOfOrder = true
+	}
+	if s.interner == nil {
+		s.interner = stringInterner{}
+	}
+	for i, l := range series.Labels {
+		series.Labels[i].Name, series.Labels[i].Value = s.interner.intern(l.Name), s.interner.intern(l.Value)
+	}
+	s.seriesSet = append(s.seriesSet, series)
+	return nil
+}
+
+// stringInterner deduplicates strings, so equal strings share backing storage. It is not safe for concurrent use.
+type stringInterner map[string]string
+
+func (in stringInterner) intern(s string) string {
+	if is, ok := in[s]; ok {
+		return is
+	}
+	in[s] = s
+	return s
+}
+
+// validateLabels resolves labels of the series with the same name according to the duplicate labels handling of the
+// server. Labels are sorted by name, as labels.Labels requires.
+func (s *seriesServer) validateLabels(series *storepb.Series) error {
+	if sortedUniqueLabelNames(series.Labels) {
+		return nil
+	}
+	lset := make([]storepb.Label, len(series.Labels))
+	copy(lset, series.Labels)
+	sort.SliceStable(lset, func(i, j int) bool { return lset[i].Name < lset[j].Name })
+
+	res := lset[:1]
+	for _, l := range lset[1:] {
+		last := &res[len(res)-1]
+		if l.Name != last.Name {
+			res = append(res, l)
+			continue
+		}
+		switch s.duplicateLabels {
+		case DuplicateLabelsKeepFirst:
+		case DuplicateLabelsKeepLast:
+			*last = l
+		default:
+			return errors.Errorf("duplicate label name %q in series %s", l.Name, storepb.LabelsToString(series.Labels))
+		}
+	}
+	series.Labels = res
 	return nil
 }
 
+func sortedUniqueLabelNames(lset []storepb.Label) bool {
+	for i := 1; i < len(lset); i++ {
+		if lset[i].Name <= lset[i-1].Name {
+			return false
+		}
+	}
+	return true
+}
+
+// validateChunks drops chunks with inverted time ranges before they are decoded, as those would break
+// time range pruning and sample clamping. If partial response is disabled, such chunk fails the request.
+func (s *seriesServer) validateChunks(series *storepb.Series) error {
+	// Chunks are copied only once one is dropped, so the received message is never modified in place.
+	var chks []storepb.AggrChunk
+	for i, c := range series.Chunks {
+		if c.MinTime <= c.MaxTime {
+			if chks != nil {
+				chks = append(chks, c)
+			}
+			continue
+		}
+
+		err := errors.Errorf("chunk with inverted time range [%d, %d] for series %s", c.MinTime, c.MaxTime, storepb.LabelsToString(series.Labels))
+		if !s.partialResponse {
+			return err
+		}
+		s.warnings = append(s.warnings, err.Error())
+		if chks == nil {
+			chks = make([]storepb.AggrChunk, i, len(series.Chunks)-1)
+			copy(chks, series.Chunks[:i])
+		}
+	}
+	if chks != nil {
+		series.Chunks = chks
+	}
+	return nil
+}
+
+// limitChunks flags series with more than max chunks and handles them according to the given policy. Series split
+// across responses are already coalesced, so all their chunks are counted.
+func (s *seriesServer) limitChunks(max int, policy SeriesPolicy) {
+	res := s.seriesSet[:0]
+	for _, series := range s.seriesSet {
+		if len(series.Chunks) > max {
+			s.excessChunks.add(series.Labels)
+			if policy == SeriesPolicySkip {
+				continue
+			}
+		}
+		res = append(res, series)
+	}
+	s.seriesSet = res
+}
+
+// flaggedWarnings returns warnings about series flagged by checks of the querier during the select.
+func (q *querier) flaggedWarnings(s *seriesServer) []string {
+	var res []string
+	if err := s.excessChunks.warning(q.excessChunks, fmt.Sprintf("with more chunks than the limit of %d", q.maxSeriesChunks)); err != nil {
+		res = append(res, err.Error())
+	}
+	if err := s.staleSeries.warning(q.staleSeries, fmt.Sprintf("without samples within %s before the end of the query or now", q.maxStaleness)); err != nil {
+		res = append(res, err.Error())
+	}
+	return res
+}
+
+// sortSeries orders received series by labels, coalescing chunks of series with the same labels, as if they were
+// received in order.
+func (s *seriesServer) sortSeries() {
+	sort.SliceStable(s.seriesSet, func(i, j int) bool {
+		return storepb.CompareLabels(s.seriesSet[i].Labels, s.seriesSet[j].Labels) < 0
+	})
+	res := s.seriesSet[:0]
+	for _, series := range s.seriesSet {
+		if n := len(res); n > 0 && storepb.CompareLabels(res[n-1].Labels, series.Labels) == 0 {
+			prev := res[n-1].Chunks
+			res[n-1].Chunks = append(prev[:len(prev):len(prev)], series.Chunks...)
+			continue
+		}
+		res = append(res, series)
+	}
+	s.seriesSet = res
+	s.outOfOrder = false
+}
+
 func (s *seriesServer) Context() context.Context {
 	return s.ctx
 }
@@ -170,27 +1009,93 @@ func aggrsFromFunc(f string) ([]storepb.Aggr, resAggr) {
 }
 
 func (q *querier) Select(params *storage.SelectParams, ms ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	if q.rangeErr != nil {
+		return nil, nil, q.rangeErr
+	}
+	if err := q.checkQueryRange(); err != nil {
+		return nil, nil, err
+	}
+	if err := q.checkRequiredLabels(ms); err != nil {
+		return nil, nil, err
+	}
+
+	q.reportRangeWarning()
+	if q.expired {
+		return promSeriesSet{set: newStoreSeriesSet(nil)}, nil, nil
+	}
+
 	span, ctx := tracing.StartSpan(q.ctx, "querier_select")
 	defer span.Finish()
 
-	sms, err := translateMatchers(ms...)
+	sms, err := translateMatchers(simplifyMatchers(ms)...)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "convert matchers")
 	}
 
 	queryAggrs, resAggr := aggrsFromFunc(params.Func)
 
-	resp := &seriesServer{ctx: ctx}
-	if err := q.proxy.Series(&storepb.SeriesRequest{
-		MinTime:                 q.mint,
-		MaxTime:                 q.maxt,
-		Matchers:                sms,
-		MaxResolutionWindow:     q.maxSourceResolution,
-		Aggregates:              queryAggrs,
-		PartialResponseDisabled: !q.partialResponse,
-	}, resp); err != nil {
+	var health *store.StoreHealth
+	if q.storeHealthSeries && selectsStoreUp(ms) {
+		health = store.NewStoreHealth()
+		ctx = store.ContextWithStoreHealth(ctx, health)
+	}
+
+	// Outcomes of stores for this select explain its result if it is empty.
+	outcomes := store.NewStoreOutcomes()
+	ctx = store.ContextWithStoreOutcomes(ctx, outcomes)
+
+	resp := &seriesServer{ctx: ctx, partialResponse: q.partialResponse, duplicateLabels: q.duplicateLabels}
+	req := q.seriesRequest(params, sms, queryAggrs)
+	err = q.proxy.Series(req, resp)
+	q.storeOutcomes.Merge(outcomes)
+	q.plan.addSelect(req, outcomes)
+	if err != nil {
 		return nil, nil, errors.Wrap(err, "proxy Series()")
 	}
+	if len(resp.seriesSet) == 0 && q.explainEmpty {
+		if msg := outcomes.Summary(maxExplainedStores); msg != "" {
+			resp.warnings = append(resp.warnings, errors.Errorf("no series selected, outcome of stores: %s", msg).Error())
+		}
+	}
+	if resp.outOfOrder {
+		resp.sortSeries()
+	}
+	if err := q.fillDownsampledGaps(ctx, resp, sms, outcomes); err != nil {
+		return nil, nil, err
+	}
+	q.queriedBlocks.add(resp.queriedBlocks)
+	if err := q.addChunkBytes(resp.chunkBytes); err != nil {
+		resp.warnings = append(resp.warnings, err.Error())
+	}
+	if q.maxSeriesChunks > 0 {
+		resp.limitChunks(q.maxSeriesChunks, q.excessChunks)
+	}
+	if q.maxStaleness > 0 {
+		resp.seriesSet = q.limitStaleness(resp.seriesSet, &resp.staleSeries)
+	}
+	resp.warnings = append(resp.warnings, q.flaggedWarnings(resp)...)
+	if q.isDedupEnabled() && len(resp.seriesSet) > 0 && !hasReplicaLabel(resp.seriesSet, q.replicaLabel, q.replicaIgnoreCase) {
+		// Deduplication silently does nothing if the replica label is misconfigured, e.g. misspelled, so make it visible.
+		q.stats.incMissingReplicaLabel()
+		err := errors.Errorf("none of %d selected series has replica label %q, deduplication had no effect", len(resp.seriesSet), q.replicaLabel)
+		resp.warnings = append(resp.warnings, err.Error())
+	}
+	if health != nil {
+		hs, err := storeHealthSeries(health, params, q.maxt, ms)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "create store health series")
+		}
+		resp.seriesSet = append(resp.seriesSet, hs...)
+		sort.Slice(resp.seriesSet, func(i, j int) bool {
+			return storepb.CompareLabels(resp.seriesSet[i].Labels, resp.seriesSet[j].Labels) < 0
+		})
+	}
+	if q.seriesComparator != nil && !q.isDedupEnabled() {
+		// Deduplicated series are sorted with the replica label moved to the end.
+		sort.SliceStable(resp.seriesSet, func(i, j int) bool {
+			return q.seriesComparator(resp.seriesSet[i].Labels, resp.seriesSet[j].Labels) < 0
+		})
+	}
 
 	for _, w := range resp.warnings {
 		// NOTE(bwplotka): We could use warnings return arguments here, however need reporter anyway for LabelValues and LabelNames method,
@@ -198,36 +1103,159 @@ func (q *querier) Select(params *storage.SelectParams, ms ...*labels.Matcher) (s
 		q.warningReporter(errors.New(w))
 	}
 
+	var buckets stepBuckets
+	if q.stepDownsampling {
+		buckets = newStepBuckets(params, resAggr, q.step)
+	}
+
 	if !q.isDedupEnabled() {
 		// Return data without any deduplication.
-		return promSeriesSet{
-			mint: q.mint,
-			maxt: q.maxt,
-			set:  newStoreSeriesSet(resp.seriesSet),
-			aggr: resAggr,
-		}, nil, nil
+		return q.ordered(q.promSeriesSet(params, resp.seriesSet, resAggr, buckets)), nil, nil
 	}
 
+	var noDedup []storepb.Series
+	if len(q.noDedupMatchers) > 0 {
+		resp.seriesSet, noDedup = splitNoDedup(resp.seriesSet, q.noDedupMatchers)
+	}
+	if q.replicaIgnoreCase {
+		normalizeReplicaLabelName(resp.seriesSet, q.replicaLabel)
+	}
 	// TODO(fabxc): this could potentially pushed further down into the store API
 	// to make true streaming possible.
-	sortDedupLabels(resp.seriesSet, q.replicaLabel)
+	cmp := q.seriesComparator
+	if cmp == nil {
+		cmp = storepb.CompareLabels
+	}
+	sortDedupLabels(resp.seriesSet, q.replicaLabel, cmp)
+
+	set := q.promSeriesSet(params, resp.seriesSet, resAggr, buckets)
 
-	set := promSeriesSet{
-		mint: q.mint,
-		maxt: q.maxt,
-		set:  newStoreSeriesSet(resp.seriesSet),
-		aggr: resAggr,
+	smoothing := q.dedupSmoothing
+	if resAggr == resAggrCounter {
+		smoothing = 0
+	}
+	if q.shadowDedup != nil && q.shadowDedup.strategy != q.dedupStrategy {
+		q.compareShadowDedup(set, resp.seriesSet, smoothing)
 	}
 
 	// The merged series set assembles all potentially-overlapping time ranges
 	// of the same series into a single one. The series are ordered so that equal series
 	// from different replicas are sequential. We can now deduplicate those.
-	return newDedupSeriesSet(set, q.replicaLabel), nil, nil
+	dedupSet := newDedupSeriesSet(set, q.replicaLabel, q.dedupStrategy, smoothing, q.lookbackDelta, q.stats, q.replicaPriority)
+	if len(noDedup) > 0 {
+		dedupSet = newSortedMergeSeriesSet(dedupSet, q.promSeriesSet(params, noDedup, resAggr, buckets), q.compareSeries)
+	}
+	if q.dedupChunks {
+		dedupSet = newEncodedSeriesSet(dedupSet)
+	}
+	return q.ordered(dedupSet), nil, nil
+}
+
+// compareSeries compares labels of series in the order requested for the querier.
+func (q *querier) compareSeries(a, b labels.Labels) int {
+	if q.seriesComparator == nil {
+		return labels.Compare(a, b)
+	}
+	return q.seriesComparator(storepb.PromLabelsToLabels(a), storepb.PromLabelsToLabels(b))
+}
+
+// checkQueryRange returns an error if the querier time range exceeds the maximum allowed one.
+func (q *querier) checkQueryRange() error {
+	if q.maxQueryRange > 0 && time.Duration(q.maxt-q.mint)*time.Millisecond > q.maxQueryRange {
+		return errors.Errorf("selected time range %s exceeds maximum allowed range %s",
+			time.Duration(q.maxt-q.mint)*time.Millisecond, q.maxQueryRange)
+	}
+	return nil
+}
+
+// checkRequiredLabels returns an error if the matchers don't select any of the required labels by an equality matcher
+// with non-empty value. Regexp matchers of a single literal value, e.g. namespace=~"prod", count as equality matchers,
+// like they are sent to stores.
+func (q *querier) checkRequiredLabels(ms []*labels.Matcher) error {
+	for _, name := range q.requiredLabels {
+		found := false
+		for _, m := range ms {
+			if m = simplifyMatcher(m); m.Name == name && m.Type == labels.MatchEqual && m.Value != "" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Errorf("query must select label %q by an equality matcher", name)
+		}
+	}
+	return nil
 }
 
-// sortDedupLabels resorts the set so that the same series with different replica
+// seriesRequest returns the Series request to the proxy for the given select.
+func (q *querier) seriesRequest(params *storage.SelectParams, sms []storepb.LabelMatcher, aggrs []storepb.Aggr) *storepb.SeriesRequest {
+	hintStep := params.Step
+	if hintStep == 0 {
+		hintStep = q.step
+	}
+	return &storepb.SeriesRequest{
+		MinTime:                 q.mint,
+		MaxTime:                 q.maxt,
+		Matchers:                sms,
+		MaxResolutionWindow:     q.maxSourceResolution,
+		Aggregates:              aggrs,
+		PartialResponseDisabled: !q.partialResponse,
+		ReportQueriedBlocks:     q.reportBlocks,
+		ChunkEncoding:           q.chunkEncoding,
+		// Chunks are decompressed whenever their data is decoded or exposed, see chunkData.
+		AcceptCompressedChunks: true,
+		// PromQL does not pass grouping of the wrapping aggregation to Select, so it is never hinted.
+		Hints: &storepb.SeriesHints{
+			StartTime: params.Start,
+			EndTime:   params.End,
+			Step:      hintStep,
+			Func:      params.Func,
+		},
+	}
+}
+
+// promSeriesSet returns set of the given series received from the proxy for the select with the given parameters,
+// decoded according to the querier options.
+func (q *querier) promSeriesSet(params *storage.SelectParams, series []storepb.Series, aggr resAggr, buckets stepBuckets) promSeriesSet {
+	maxt := q.maxt
+	if q.latestSample && params != nil && params.End > 0 && params.End < maxt {
+		// Selections with offset end before the querier.
+		maxt = params.End
+	}
+	return promSeriesSet{
+		mint:             q.mint,
+		maxt:             maxt,
+		set:              newStoreSeriesSet(series),
+		aggr:             aggr,
+		ctx:              q.ctx,
+		decodePool:       q.decodePool,
+		parallelDecode:   q.parallelDecode,
+		lazy:             q.chunkRefs,
+		filter:           q.sampleFilter,
+		buckets:          buckets,
+		duplicateSamples: q.duplicateSamples,
+		latest:           q.latestSample,
+	}
+}
+
+// ordered returns the given set grouped and relabeled as requested for the querier, in the series order requested for
+// the querier.
+func (q *querier) ordered(set storage.SeriesSet) storage.SeriesSet {
+	if q.grouping != nil {
+		set = newGroupedSeriesSet(set, q.grouping, q.lookbackDelta)
+	}
+	if len(q.relabelConfigs) > 0 {
+		set = newRelabeledSeriesSet(set, q.relabelConfigs)
+	}
+	if q.seriesOrder != SeriesOrderHash {
+		return set
+	}
+	return newHashOrderedSeriesSet(set)
+}
+
+// sortDedupLabels resorts the set by the given comparator so that the same series with different replica
 // labels are coming right after each other.
-func sortDedupLabels(set []storepb.Series, replicaLabel string) {
+func sortDedupLabels(set []storepb.Series, replicaLabel string, cmp storepb.LabelsComparator) {
 	for _, s := range set {
 		// Move the replica label to the very end.
 		sort.Slice(s.Labels, func(i, j int) bool {
@@ -243,33 +1271,200 @@ func sortDedupLabels(set []storepb.Series, replicaLabel string) {
 	// With the re-ordered label sets, re-sorting all series aligns the same series
 	// from different replicas sequentially.
 	sort.Slice(set, func(i, j int) bool {
-		return storepb.CompareLabels(set[i].Labels, set[j].Labels) < 0
+		return cmp(set[i].Labels, set[j].Labels) < 0
 	})
 }
 
+// hasReplicaLabel returns true if any of the series has the replica label, or a label with name equal to it ignoring
+// case if ignoreCase is true.
+func hasReplicaLabel(set []storepb.Series, replicaLabel string, ignoreCase bool) bool {
+	for _, s := range set {
+		for _, l := range s.Labels {
+			if l.Name == replicaLabel || ignoreCase && strings.EqualFold(l.Name, replicaLabel) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeReplicaLabelName renames labels with name equal to the replica label ignoring case to the replica label.
+// Series that already have the replica label are left as they are, so no series gets duplicate label names.
+func normalizeReplicaLabelName(set []storepb.Series, replicaLabel string) {
+	for _, s := range set {
+		i := -1
+		for j, l := range s.Labels {
+			if l.Name == replicaLabel {
+				i = -1
+				break
+			}
+			if i < 0 && strings.EqualFold(l.Name, replicaLabel) {
+				i = j
+			}
+		}
+		if i >= 0 {
+			s.Labels[i].Name = replicaLabel
+		}
+	}
+}
+
 // LabelValues returns all potential values for a label name.
 func (q *querier) LabelValues(name string) ([]string, error) {
 	span, ctx := tracing.StartSpan(q.ctx, "querier_label_values")
 	defer span.Finish()
 
-	resp, err := q.proxy.LabelValues(ctx, &storepb.LabelValuesRequest{Label: name, PartialResponseDisabled: !q.partialResponse})
+	resp, err := q.labelValues(ctx, name)
 	if err != nil {
-		return nil, errors.Wrap(err, "proxy LabelValues()")
+		return nil, err
 	}
-
 	for _, w := range resp.Warnings {
 		q.warningReporter(errors.New(w))
 	}
-
 	return resp.Values, nil
 }
 
+// MultiLabelValues returns values of each of the given label names, like LabelValues called for each of them. Stores
+// are asked for all labels at once, so LabelValues calls of all (store, label) pairs run in parallel, at most
+// QuerierOpts.LabelValuesConcurrency of them at a time. It is meant for tools prefetching values of multiple labels.
+func (q *querier) MultiLabelValues(names []string) (map[string][]string, error) {
+	span, ctx := tracing.StartSpan(q.ctx, "querier_multi_label_values")
+	defer span.Finish()
+
+	if q.labelValuesParallel > 0 {
+		ctx = store.ContextWithLabelValuesSlots(ctx, make(chan struct{}, q.labelValuesParallel))
+	}
+
+	var (
+		g, gctx = errgroup.WithContext(ctx)
+		resps   = make([]*storepb.LabelValuesResponse, len(names))
+	)
+	for i, name := range names {
+		i, name := i, name
+		g.Go(func() (err error) {
+			resps[i], err = q.labelValues(gctx, name)
+			return errors.Wrapf(err, "label %s", name)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	res := make(map[string][]string, len(names))
+	for i, resp := range resps {
+		// Warnings are reported in order of labels, as the reporter may not be safe to use concurrently.
+		for _, w := range resp.Warnings {
+			q.warningReporter(errors.New(w))
+		}
+		res[names[i]] = resp.Values
+	}
+	return res, nil
+}
+
+// labelValues fetches values of the label from stores, sorted as configured.
+func (q *querier) labelValues(ctx context.Context, name string) (*storepb.LabelValuesResponse, error) {
+	// Values are the union of all stores holding any data within the querier time range.
+	ctx = store.ContextWithLabelValuesTimeRange(ctx, q.mint, q.maxt)
+
+	resp, err := q.proxy.LabelValues(ctx, &storepb.LabelValuesRequest{
+		Label:                   name,
+		PartialResponseDisabled: !q.partialResponse,
+		Limit:                   int64(q.labelValuesLimit),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "proxy LabelValues()")
+	}
+	if resp.Truncated {
+		resp.Warnings = append(resp.Warnings, fmt.Sprintf("label values truncated to the first %d values", q.labelValuesLimit))
+	}
+	if q.labelValuesSort == LabelValuesSortNumeric {
+		sortNumeric(resp.Values)
+	}
+	return resp, nil
+}
+
+// sortNumeric sorts the values as numbers if all of them parse as floats. Otherwise values are left untouched.
+func sortNumeric(vals []string) {
+	nums := make([]float64, len(vals))
+	for i, v := range vals {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return
+		}
+		nums[i] = f
+	}
+	// Values are sorted lexicographically already, so equal numbers like "1" and "1.0" keep that order.
+	sort.Stable(numericValues{vals: vals, nums: nums})
+}
+
+type numericValues struct {
+	vals []string
+	nums []float64
+}
+
+func (v numericValues) Len() int           { return len(v.vals) }
+func (v numericValues) Less(i, j int) bool { return v.nums[i] < v.nums[j] }
+func (v numericValues) Swap(i, j int) {
+	v.vals[i], v.vals[j] = v.vals[j], v.vals[i]
+	v.nums[i], v.nums[j] = v.nums[j], v.nums[i]
+}
+
 // LabelNames returns all the unique label names present in the block in sorted order.
 // TODO(bwplotka): Consider adding labelNames to thanos Query API https://github.com/improbable-eng/thanos/issues/702.
 func (q *querier) LabelNames() ([]string, error) {
 	return nil, errors.New("not implemented")
 }
 
+// Stats returns statistics of series selected by the querier. Sample counts reflect samples iterated so far.
+// It is safe to call it concurrently with iterating the series.
+func (q *querier) Stats() Stats {
+	var outcomes map[string]store.StoreOutcome
+	if o := q.storeOutcomes.Outcomes(); len(o) > 0 {
+		outcomes = o
+	}
+	return Stats{Dedup: q.stats.get(), Transfer: q.transfer.get(), QueriedBlocks: q.queriedBlocks.get(), StoreOutcomes: outcomes}
+}
+
+// seriesStoresProber is implemented by proxies that can tell which of the underlying stores have matching series.
+type seriesStoresProber interface {
+	SeriesStores(ctx context.Context, r *storepb.SeriesRequest) (addrs []string, warnings []string, err error)
+}
+
+// StoresWithMetric returns addresses of stores that have series of the given metric within the querier time range.
+// It runs only a labels-only Series fanout, so it is much lighter than a query. It is meant for debugging missing data.
+func (q *querier) StoresWithMetric(metric string) ([]string, error) {
+	if q.rangeErr != nil {
+		return nil, q.rangeErr
+	}
+	if q.expired {
+		return nil, nil
+	}
+
+	span, ctx := tracing.StartSpan(q.ctx, "querier_stores_with_metric")
+	defer span.Finish()
+
+	prober, ok := q.proxy.(seriesStoresProber)
+	if !ok {
+		return nil, errors.New("proxy does not support probing stores")
+	}
+
+	addrs, warnings, err := prober.SeriesStores(ctx, &storepb.SeriesRequest{
+		MinTime:                 q.mint,
+		MaxTime:                 q.maxt,
+		Matchers:                []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: labels.MetricName, Value: metric}},
+		MaxResolutionWindow:     q.maxSourceResolution,
+		PartialResponseDisabled: !q.partialResponse,
+		SkipChunks:              true,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "proxy SeriesStores()")
+	}
+
+	for _, w := range warnings {
+		q.warningReporter(errors.New(w))
+	}
+	return addrs, nil
+}
+
 func (q *querier) Close() error {
 	q.cancel()
 	return nil
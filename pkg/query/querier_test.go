@@ -1,20 +1,29 @@
 package query
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"math/rand"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"time"
+	"unsafe"
 
 	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/testutil"
 	"github.com/pkg/errors"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/tsdb/chunkenc"
 )
@@ -33,7 +42,7 @@ func TestQuerier_Series(t *testing.T) {
 
 	// Querier clamps the range to [1,300], which should drop some samples of the result above.
 	// The store API allows endpoints to send more data then initially requested.
-	q := newQuerier(context.Background(), nil, 1, 300, "", testProxy, false, 0, true, nil)
+	q := newQuerier(context.Background(), nil, 1, 300, "", testProxy, false, 0, true, nil, QuerierOpts{})
 	defer func() { testutil.Ok(t, q.Close()) }()
 
 	res, _, err := q.Select(&storage.SelectParams{})
@@ -42,10 +51,12 @@ func TestQuerier_Series(t *testing.T) {
 	expected := []struct {
 		lset    labels.Labels
 		samples []sample
+		clamped bool
 	}{
 		{
 			lset:    labels.FromStrings("a", "a"),
 			samples: []sample{{2, 1}, {3, 2}},
+			clamped: true,
 		},
 		{
 			lset:    labels.FromStrings("a", "b"),
@@ -55,6 +66,7 @@ func TestQuerier_Series(t *testing.T) {
 			lset: labels.FromStrings("a", "c"),
 
 			samples: []sample{{100, 1}, {300, 3}},
+			clamped: true,
 		},
 	}
 
@@ -67,6 +79,10 @@ func TestQuerier_Series(t *testing.T) {
 		samples := expandSeries(t, res.At().Iterator())
 		testutil.Equals(t, expected[i].samples, samples)
 
+		s, ok := res.At().(ClampedSeries)
+		testutil.Assert(t, ok, "expected ClampedSeries, got %T", res.At())
+		testutil.Equals(t, expected[i].clamped, s.Clamped())
+
 		i++
 	}
 	testutil.Ok(t, res.Err())
@@ -74,6 +90,687 @@ func TestQuerier_Series(t *testing.T) {
 	testutil.Equals(t, len(expected), i)
 }
 
+func TestQuerier_Select_DedupClamped(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "1"), []sample{{10, 1}, {20, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "2"), []sample{{10, 1}, {20, 1}, {400, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "1"), []sample{{10, 1}, {20, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "2"), []sample{{10, 1}, {20, 1}}),
+	}}
+	q := newQuerier(context.Background(), nil, 1, 300, "replica", proxy, true, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	var clamped []bool
+	for res.Next() {
+		s, ok := res.At().(ClampedSeries)
+		testutil.Assert(t, ok, "expected ClampedSeries, got %T", res.At())
+		clamped = append(clamped, s.Clamped())
+	}
+	testutil.Ok(t, res.Err())
+	// Deduplicated series is clamped if any of its replicas is.
+	testutil.Equals(t, []bool{true, false}, clamped)
+}
+
+func TestQuerier_Select_DedupDisabledKeepsReplicas(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Proxy merges series of all stores sorted by their labels, so replicas of the same series are adjacent.
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "1"), []sample{{10000, 1}, {20000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "2"), []sample{{10000, 2}, {20000, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "1"), []sample{{10000, 3}}),
+	}}
+
+	for _, tcase := range []struct {
+		deduplicate bool
+		exp         []labels.Labels
+	}{
+		{
+			deduplicate: true,
+			exp:         []labels.Labels{labels.FromStrings("a", "1"), labels.FromStrings("a", "2")},
+		},
+		{
+			// Replica label is configured, but deduplication is disabled for this query.
+			deduplicate: false,
+			exp: []labels.Labels{
+				labels.FromStrings("a", "1", "replica", "1"),
+				labels.FromStrings("a", "1", "replica", "2"),
+				labels.FromStrings("a", "2", "replica", "1"),
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("deduplicate=%v", tcase.deduplicate), func(t *testing.T) {
+			q := newQuerier(context.Background(), nil, 1, 100000, "replica", proxy, tcase.deduplicate, 0, true, nil, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+
+			var got []labels.Labels
+			for res.Next() {
+				got = append(got, res.At().Labels())
+			}
+			testutil.Ok(t, res.Err())
+			testutil.Equals(t, tcase.exp, got)
+		})
+	}
+}
+
+func TestQuerier_Select_ReplicaLabelOverride(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "1", "zone", "x"), []sample{{10000, 1}, {20000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "1", "zone", "y"), []sample{{10000, 2}, {20000, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "2", "zone", "x"), []sample{{10000, 3}, {20000, 3}}),
+	}}
+
+	for _, tcase := range []struct {
+		name string
+		ctx  context.Context
+		exp  []labels.Labels
+	}{
+		{
+			name: "configured replica label",
+			ctx:  context.Background(),
+			exp:  []labels.Labels{labels.FromStrings("a", "1", "zone", "x"), labels.FromStrings("a", "1", "zone", "y")},
+		},
+		{
+			name: "overridden replica label",
+			ctx:  ContextWithReplicaLabel(context.Background(), "zone"),
+			exp:  []labels.Labels{labels.FromStrings("a", "1", "replica", "1"), labels.FromStrings("a", "1", "replica", "2")},
+		},
+		{
+			name: "empty replica label",
+			ctx:  ContextWithReplicaLabel(context.Background(), ""),
+			exp: []labels.Labels{
+				labels.FromStrings("a", "1", "replica", "1", "zone", "x"),
+				labels.FromStrings("a", "1", "replica", "1", "zone", "y"),
+				labels.FromStrings("a", "1", "replica", "2", "zone", "x"),
+			},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			q := newQuerier(tcase.ctx, nil, 1, 100000, "replica", proxy, true, 0, true, nil, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+
+			var got []labels.Labels
+			for res.Next() {
+				got = append(got, res.At().Labels())
+			}
+			testutil.Ok(t, res.Err())
+			testutil.Equals(t, tcase.exp, got)
+		})
+	}
+}
+
+func TestQuerier_Select_ReplicaLabelIgnoreCase(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	for _, tcase := range []struct {
+		ignoreCase bool
+		exp        []labels.Labels
+	}{
+		{
+			ignoreCase: true,
+			exp:        []labels.Labels{labels.FromStrings("Replica", "1", "a", "2"), labels.FromStrings("a", "1")},
+		},
+		{
+			ignoreCase: false,
+			exp: []labels.Labels{
+				labels.FromStrings("Replica", "1", "a", "2"),
+				labels.FromStrings("Replica", "A", "a", "1"),
+				labels.FromStrings("a", "1"),
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("ignoreCase=%v", tcase.ignoreCase), func(t *testing.T) {
+			// Replicas of series a=1 differ only by casing of the replica label name. Series with both names keep the
+			// one with other casing.
+			proxy := &storeServer{resps: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("Replica", "A", "a", "1"), []sample{{10000, 1}, {20000, 1}}),
+				storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "B"), []sample{{10000, 1}, {20000, 1}, {30000, 1}}),
+				storeSeriesResponse(t, labels.FromStrings("Replica", "1", "a", "2", "replica", "2"), []sample{{10000, 3}}),
+			}}
+
+			q := newQuerier(context.Background(), nil, 1, 100000, "replica", proxy, true, 0, true, nil, QuerierOpts{ReplicaLabelIgnoreCase: tcase.ignoreCase})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+
+			var got []labels.Labels
+			for res.Next() {
+				got = append(got, res.At().Labels())
+			}
+			testutil.Ok(t, res.Err())
+			testutil.Equals(t, tcase.exp, got)
+		})
+	}
+}
+
+func TestQuerier_Select_ReplicaPriority(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	for _, tcase := range []struct {
+		priority []string
+		exp      []sample
+	}{
+		{
+			// Replicas are preferred in order of their labels by default.
+			exp: []sample{{10000, 1}, {20000, 1}, {30000, 1}},
+		},
+		{
+			priority: []string{"B", "A"},
+			exp:      []sample{{10000, 2}, {20000, 2}, {30000, 2}},
+		},
+		{
+			// Listed replicas are preferred over replicas not listed.
+			priority: []string{"C", "B"},
+			exp:      []sample{{10000, 2}, {20000, 2}, {30000, 2}},
+		},
+	} {
+		t.Run(fmt.Sprintf("priority=%v", tcase.priority), func(t *testing.T) {
+			proxy := &storeServer{resps: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "A"), []sample{{10000, 1}, {20000, 1}, {30000, 1}}),
+				storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "B"), []sample{{10000, 2}, {20000, 2}, {30000, 2}}),
+			}}
+
+			q := newQuerier(context.Background(), nil, 1, 100000, "replica", proxy, true, 0, true, nil, QuerierOpts{ReplicaPriority: tcase.priority})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+
+			testutil.Assert(t, res.Next(), "expected series")
+			testutil.Equals(t, labels.FromStrings("a", "1"), res.At().Labels())
+			testutil.Equals(t, tcase.exp, expandSeries(t, res.At().Iterator()))
+			testutil.Assert(t, !res.Next(), "expected single series")
+			testutil.Ok(t, res.Err())
+		})
+	}
+}
+
+func TestQuerier_Select_FlaggedSeries(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var (
+		now   = timestamp.FromTime(time.Now())
+		fresh = []sample{{now - 30000, 1}}
+		stale = []sample{{now - 3600000, 1}}
+		// Only the series stale in all of its replicas is flagged.
+		staleResps = []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "1"), stale),
+			storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "1"), stale),
+			storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "2"), fresh),
+			storeSeriesResponse(t, labels.FromStrings("a", "3", "replica", "1"), fresh),
+		}
+	)
+
+	for _, tcase := range []struct {
+		name         string
+		resps        []*storepb.SeriesResponse
+		mint, maxt   int64
+		replicaLabel string
+		opts         QuerierOpts
+		exp          []labels.Labels
+		expWarn      string
+	}{
+		{
+			name: "excess chunks warn",
+			resps: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}, []sample{{2, 2}}, []sample{{3, 3}}),
+				storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{1, 1}}, []sample{{2, 2}}),
+			},
+			mint:    1,
+			maxt:    10,
+			opts:    QuerierOpts{MaxSeriesChunks: 2, ExcessChunks: SeriesPolicyWarn},
+			exp:     []labels.Labels{labels.FromStrings("a", "1"), labels.FromStrings("a", "2")},
+			expWarn: `1 series with more chunks than the limit of 2: {a="1"}`,
+		},
+		{
+			name: "excess chunks skip",
+			resps: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}, []sample{{2, 2}}, []sample{{3, 3}}),
+				storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{1, 1}}, []sample{{2, 2}}),
+			},
+			mint:    1,
+			maxt:    10,
+			opts:    QuerierOpts{MaxSeriesChunks: 2, ExcessChunks: SeriesPolicySkip},
+			exp:     []labels.Labels{labels.FromStrings("a", "2")},
+			expWarn: `skipped series: 1 series with more chunks than the limit of 2: {a="1"}`,
+		},
+		{
+			name: "excess chunks of many series",
+			resps: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}, []sample{{2, 2}}),
+				storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{1, 1}}, []sample{{2, 2}}),
+				storeSeriesResponse(t, labels.FromStrings("a", "3"), []sample{{1, 1}}, []sample{{2, 2}}),
+				storeSeriesResponse(t, labels.FromStrings("a", "4"), []sample{{1, 1}}, []sample{{2, 2}}),
+			},
+			mint:    1,
+			maxt:    10,
+			opts:    QuerierOpts{MaxSeriesChunks: 1, ExcessChunks: SeriesPolicySkip},
+			expWarn: `skipped series: 4 series with more chunks than the limit of 1: {a="1"}, {a="2"}, {a="3"}, ...`,
+		},
+		{
+			name:         "stale series warn",
+			resps:        staleResps,
+			mint:         now - 7200000,
+			maxt:         now,
+			replicaLabel: "replica",
+			opts:         QuerierOpts{MaxStaleness: 10 * time.Minute, StaleSeries: SeriesPolicyWarn},
+			exp:          []labels.Labels{labels.FromStrings("a", "1"), labels.FromStrings("a", "2"), labels.FromStrings("a", "3")},
+			expWarn:      `1 series without samples within 10m0s before the end of the query or now: {a="1"}`,
+		},
+		{
+			name:         "stale series skip",
+			resps:        staleResps,
+			mint:         now - 7200000,
+			maxt:         now,
+			replicaLabel: "replica",
+			opts:         QuerierOpts{MaxStaleness: 10 * time.Minute, StaleSeries: SeriesPolicySkip},
+			exp:          []labels.Labels{labels.FromStrings("a", "2"), labels.FromStrings("a", "3")},
+			expWarn:      `skipped series: 1 series without samples within 10m0s before the end of the query or now: {a="1"}`,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			proxy := &storeServer{resps: tcase.resps}
+
+			var warns []error
+			q := newQuerier(context.Background(), nil, tcase.mint, tcase.maxt, tcase.replicaLabel, proxy, tcase.replicaLabel != "", 0, true, func(err error) { warns = append(warns, err) }, tcase.opts)
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+
+			var got []labels.Labels
+			for res.Next() {
+				got = append(got, res.At().Labels())
+			}
+			testutil.Ok(t, res.Err())
+			testutil.Equals(t, tcase.exp, got)
+			// Flagged series are reported with a single warning.
+			testutil.Equals(t, 1, len(warns))
+			testutil.Equals(t, tcase.expWarn, warns[0].Error())
+		})
+	}
+}
+
+func TestQuerier_Select_CompressedChunks(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	resp := storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}, {2, 2}}, []sample{{3, 3}, {4, 4}})
+	// Compress only the first chunk, so both compressed and plain chunks are decoded.
+	raw := resp.GetSeries().Chunks[0].Raw
+	plain := raw.Data
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(raw.Data)
+	testutil.Ok(t, err)
+	testutil.Ok(t, w.Close())
+	raw.Data, raw.Compression = buf.Bytes(), storepb.Chunk_GZIP
+
+	// Compression survives the wire.
+	b, err := resp.Marshal()
+	testutil.Ok(t, err)
+	var received storepb.SeriesResponse
+	testutil.Ok(t, received.Unmarshal(b))
+	testutil.Equals(t, storepb.Chunk_GZIP, received.GetSeries().Chunks[0].Raw.Compression)
+	testutil.Equals(t, storepb.Chunk_NONE, received.GetSeries().Chunks[1].Raw.Compression)
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{&received}}
+	q := newQuerier(context.Background(), nil, 1, 10, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	// Querier decompresses chunks, so it lets stores compress them.
+	testutil.Assert(t, proxy.lastReq.AcceptCompressedChunks, "expected compressed chunks to be accepted")
+	testutil.Assert(t, res.Next(), "expected series")
+	testutil.Equals(t, []sample{{1, 1}, {2, 2}, {3, 3}, {4, 4}}, expandSeries(t, res.At().Iterator()))
+	// Chunks are exposed decompressed too.
+	chks := res.At().(ChunkSeries).Chunks()
+	testutil.Equals(t, storepb.Chunk_NONE, chks[0].Raw.Compression)
+	testutil.Equals(t, plain, chks[0].Raw.Data)
+	testutil.Assert(t, !res.Next(), "expected single series")
+	testutil.Ok(t, res.Err())
+
+	// Corrupted compressed data fails iteration of the series.
+	received.GetSeries().Chunks[0].Raw.Data = []byte("not gzip")
+	res, _, err = q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, res.Next(), "expected series")
+	it := res.At().Iterator()
+	for it.Next() {
+	}
+	testutil.NotOk(t, it.Err())
+}
+
+func TestQuerier_Select_SeriesSpanningStores(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Proxy concatenates chunks of the same series from all stores, so the series below is what querier gets when
+	// sidecar holds the recent half of the timeline and store gateway the historical one. Both hold the sample
+	// at the seam and gateway has also leftover chunks overlapping with the sidecar range.
+	sidecar := [][]sample{{{50, 5}, {60, 6}, {70, 7}, {80, 8}, {90, 9}}}
+	gateway := [][]sample{{{10, 1}, {20, 2}, {30, 3}}, {{40, 4}, {50, 5}}, {{60, 6}, {70, 7}}, {{85, 8.5}, {95, 9.5}}}
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "a"), append(sidecar, gateway...)...),
+	}}
+	q := newQuerier(context.Background(), nil, 1, 100, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	testutil.Assert(t, res.Next(), "expected series")
+	testutil.Equals(t, labels.FromStrings("a", "a"), res.At().Labels())
+	testutil.Equals(t, []sample{{10, 1}, {20, 2}, {30, 3}, {40, 4}, {50, 5}, {60, 6}, {70, 7}, {80, 8}, {90, 9}, {95, 9.5}}, expandSeries(t, res.At().Iterator()))
+	testutil.Assert(t, !res.Next(), "expected single series")
+	testutil.Ok(t, res.Err())
+}
+
+func TestQuerier_Select_SeriesOrderHash(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var resps []*storepb.SeriesResponse
+	for i := 0; i < 20; i++ {
+		for _, r := range []string{"r1", "r2"} {
+			resps = append(resps, storeSeriesResponse(t, labels.FromStrings("a", fmt.Sprintf("%02d", i), "replica", r), []sample{{1, float64(i)}}))
+		}
+	}
+	proxy := &storeServer{resps: resps}
+
+	selectLabels := func() []labels.Labels {
+		q := newQuerier(ContextWithSeriesOrder(context.Background(), SeriesOrderHash), nil, 1, 10, "replica", proxy, true, 0, true, nil, QuerierOpts{})
+		defer func() { testutil.Ok(t, q.Close()) }()
+
+		res, _, err := q.Select(&storage.SelectParams{})
+		testutil.Ok(t, err)
+
+		var lsets []labels.Labels
+		for res.Next() {
+			lsets = append(lsets, res.At().Labels())
+			testutil.Equals(t, 1, len(expandSeries(t, res.At().Iterator())))
+		}
+		testutil.Ok(t, res.Err())
+		return lsets
+	}
+
+	got := selectLabels()
+	testutil.Equals(t, 20, len(got))
+	for i := 1; i < len(got); i++ {
+		testutil.Assert(t, got[i-1].Hash() < got[i].Hash(), "series %d not ordered by hash", i)
+	}
+	// Ordering must be deterministic across queries.
+	testutil.Equals(t, got, selectLabels())
+
+	seen := map[string]struct{}{}
+	for _, lset := range got {
+		seen[lset.String()] = struct{}{}
+	}
+	for i := 0; i < 20; i++ {
+		_, ok := seen[labels.FromStrings("a", fmt.Sprintf("%02d", i)).String()]
+		testutil.Assert(t, ok, "series %d missing", i)
+	}
+}
+
+func TestQuerier_Select_EmptyChunks(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	withoutData := storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}, {2, 2}}, []sample{{3, 3}}, []sample{{4, 4}})
+	withoutData.GetSeries().Chunks[1].Raw.Data = nil
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		// Chunk with header only, holding zero samples.
+		storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{}),
+		withoutData,
+		storeSeriesResponse(t, labels.FromStrings("a", "c"), []sample{{1, 1}}, []sample{}, []sample{{5, 5}}),
+	}}
+	q := newQuerier(context.Background(), nil, 1, 10, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	var got [][]sample
+	for res.Next() {
+		got = append(got, expandSeries(t, res.At().Iterator()))
+	}
+	testutil.Ok(t, res.Err())
+	testutil.Equals(t, [][]sample{nil, {{1, 1}, {2, 2}, {4, 4}}, {{1, 1}, {5, 5}}}, got)
+}
+
+// stepHintStoreServer serves a single series. Given the step hint, it cuts chunks of the series at step boundaries,
+// without changing the returned samples.
+type stepHintStoreServer struct {
+	// This field just exist to pseudo-implement the unused methods of the interface.
+	storepb.StoreServer
+
+	t       testing.TB
+	lset    labels.Labels
+	samples []sample
+	lastReq *storepb.SeriesRequest
+	chunks  int
+}
+
+func (s *stepHintStoreServer) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	// Pass the request through the wire format, as a remote store would get it.
+	b, err := r.Marshal()
+	if err != nil {
+		return err
+	}
+	s.lastReq = &storepb.SeriesRequest{}
+	if err := s.lastReq.Unmarshal(b); err != nil {
+		return err
+	}
+
+	chks := [][]sample{s.samples}
+	if h := s.lastReq.Hints; h != nil && h.Step > 0 {
+		chks = nil
+		for i, smpl := range s.samples {
+			if i == 0 || (smpl.t-h.StartTime)/h.Step != (s.samples[i-1].t-h.StartTime)/h.Step {
+				chks = append(chks, nil)
+			}
+			chks[len(chks)-1] = append(chks[len(chks)-1], smpl)
+		}
+	}
+	s.chunks = len(chks)
+	return srv.Send(storeSeriesResponse(s.t, s.lset, chks...))
+}
+
+func TestQuerier_Select_Hints(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var smpls []sample
+	for ts := int64(0); ts <= 110; ts += 10 {
+		smpls = append(smpls, sample{ts, float64(ts)})
+	}
+	proxy := &stepHintStoreServer{t: t, lset: labels.FromStrings("a", "a"), samples: smpls}
+
+	for _, tcase := range []struct {
+		params    *storage.SelectParams
+		expChunks int
+	}{
+		{params: &storage.SelectParams{}, expChunks: 1},
+		{params: &storage.SelectParams{Start: 1, End: 100, Step: 20}, expChunks: 6},
+		{params: &storage.SelectParams{Start: 1, End: 100, Step: 20, Func: "max_over_time"}, expChunks: 6},
+	} {
+		t.Run(fmt.Sprintf("%+v", *tcase.params), func(t *testing.T) {
+			q := newQuerier(context.Background(), nil, 1, 100, "", proxy, false, 0, true, nil, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(tcase.params)
+			testutil.Ok(t, err)
+
+			testutil.Equals(t, &storepb.SeriesHints{
+				StartTime: tcase.params.Start,
+				EndTime:   tcase.params.End,
+				Step:      tcase.params.Step,
+				Func:      tcase.params.Func,
+			}, proxy.lastReq.Hints)
+			testutil.Equals(t, tcase.expChunks, proxy.chunks)
+
+			// Hints are advisory, so the result is the same with or without them.
+			testutil.Assert(t, res.Next(), "expected series")
+			testutil.Equals(t, []sample{{10, 10}, {20, 20}, {30, 30}, {40, 40}, {50, 50}, {60, 60}, {70, 70}, {80, 80}, {90, 90}, {100, 100}}, expandSeries(t, res.At().Iterator()))
+			testutil.Assert(t, !res.Next(), "expected single series")
+			testutil.Ok(t, res.Err())
+		})
+	}
+}
+
+func TestQuerier_Select_MetricNameRegexp(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("__name__", "http_requests_total"), []sample{{1, 1}}),
+	}}
+	q := newQuerier(context.Background(), nil, 1, 10, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	name, err := labels.NewMatcher(labels.MatchRegexp, "__name__", "http_.*")
+	testutil.Ok(t, err)
+	job, err := labels.NewMatcher(labels.MatchEqual, "job", "api")
+	testutil.Ok(t, err)
+
+	res, _, err := q.Select(&storage.SelectParams{}, name, job)
+	testutil.Ok(t, err)
+	for res.Next() {
+	}
+	testutil.Ok(t, res.Err())
+
+	// Metric name regexp is forwarded as a distinct regexp matcher, so stores can use their index to resolve it.
+	testutil.Equals(t, []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_RE, Name: "__name__", Value: "http_.*"},
+		{Type: storepb.LabelMatcher_EQ, Name: "job", Value: "api"},
+	}, proxy.lastReq.Matchers)
+}
+
+type labelValuesStoreServer struct {
+	// This field just exist to pseudo-implement the unused methods of the interface.
+	storepb.StoreServer
+
+	values []string
+}
+
+func (s *labelValuesStoreServer) LabelValues(context.Context, *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	return &storepb.LabelValuesResponse{Values: append([]string(nil), s.values...)}, nil
+}
+
+func TestQuerier_LabelValues_Sort(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	for _, tcase := range []struct {
+		sort     LabelValuesSort
+		values   []string
+		expected []string
+	}{
+		{
+			sort:     LabelValuesSortLexicographic,
+			values:   []string{"1", "10", "2"},
+			expected: []string{"1", "10", "2"},
+		},
+		{
+			sort:     LabelValuesSortNumeric,
+			values:   []string{"1", "10", "2"},
+			expected: []string{"1", "2", "10"},
+		},
+		{
+			sort:     LabelValuesSortNumeric,
+			values:   []string{"+Inf", "0.005", "0.5", "1", "10", "2.5"},
+			expected: []string{"0.005", "0.5", "1", "2.5", "10", "+Inf"},
+		},
+		{
+			// Not all values are numbers, so they stay sorted lexicographically.
+			sort:     LabelValuesSortNumeric,
+			values:   []string{"1", "10", "2", "a"},
+			expected: []string{"1", "10", "2", "a"},
+		},
+	} {
+		t.Run(fmt.Sprintf("%s %v", tcase.sort, tcase.values), func(t *testing.T) {
+			ctx := ContextWithLabelValuesSort(context.Background(), tcase.sort)
+			q := newQuerier(ctx, nil, 0, 10, "", &labelValuesStoreServer{values: tcase.values}, false, 0, true, nil, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			vals, err := q.LabelValues("le")
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, vals)
+		})
+	}
+}
+
+// multiLabelStoreServer serves values of multiple labels and tracks LabelValues calls in flight across stores sharing
+// the counters.
+type multiLabelStoreServer struct {
+	rangeStoreServer
+
+	values                map[string][]string
+	inflight, maxInflight *int64
+}
+
+func (s *multiLabelStoreServer) LabelValues(_ context.Context, r *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	n := atomic.AddInt64(s.inflight, 1)
+	defer atomic.AddInt64(s.inflight, -1)
+	for {
+		max := atomic.LoadInt64(s.maxInflight)
+		if n <= max || atomic.CompareAndSwapInt64(s.maxInflight, max, n) {
+			break
+		}
+	}
+	// Let other calls start while this one is in flight.
+	time.Sleep(10 * time.Millisecond)
+	return &storepb.LabelValuesResponse{Values: append([]string(nil), s.values[r.Label]...)}, nil
+}
+
+func TestQuerier_MultiLabelValues(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var inflight, maxInflight int64
+	newStore := func(values map[string][]string) *multiLabelStoreServer {
+		return &multiLabelStoreServer{
+			rangeStoreServer: rangeStoreServer{mint: math.MinInt64, maxt: math.MaxInt64},
+			values:           values,
+			inflight:         &inflight,
+			maxInflight:      &maxInflight,
+		}
+	}
+	clients := []store.Client{
+		store.NewLocalClient(newStore(map[string][]string{"job": {"api", "db"}, "env": {"prod"}}), "a"),
+		store.NewLocalClient(newStore(map[string][]string{"job": {"api", "web"}, "env": {"dev"}, "zone": {"eu"}}), "b"),
+	}
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) { return clients, nil }, nil, store.StoreLimit{})
+
+	q := newQuerier(context.Background(), nil, 0, 10, "", proxy, false, 0, true, nil, QuerierOpts{LabelValuesConcurrency: 2})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	names := []string{"job", "env", "zone"}
+	got, err := q.MultiLabelValues(names)
+	testutil.Ok(t, err)
+	testutil.Assert(t, maxInflight <= 2, "expected at most 2 concurrent calls of stores, got %d", maxInflight)
+
+	exp := map[string][]string{}
+	for _, name := range names {
+		vals, err := q.LabelValues(name)
+		testutil.Ok(t, err)
+		exp[name] = vals
+	}
+	testutil.Equals(t, exp, got)
+	testutil.Equals(t, []string{"api", "db", "web"}, got["job"])
+	testutil.Equals(t, []string{"dev", "prod"}, got["env"])
+	testutil.Equals(t, []string{"eu"}, got["zone"])
+}
+
 func TestSortReplicaLabel(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
@@ -101,7 +798,7 @@ func TestSortReplicaLabel(t *testing.T) {
 		}},
 	}
 
-	sortDedupLabels(set, "b")
+	sortDedupLabels(set, "b", storepb.CompareLabels)
 
 	exp := []storepb.Series{
 		{Labels: []storepb.Label{
@@ -215,7 +912,7 @@ func TestDedupSeriesSet(t *testing.T) {
 		maxt: math.MaxInt64,
 		set:  newStoreSeriesSet(series),
 	}
-	dedupSet := newDedupSeriesSet(set, "replica")
+	dedupSet := newDedupSeriesSet(set, "replica", DedupPenalty, 0, 0, nil, nil)
 
 	i := 0
 	for dedupSet.Next() {
@@ -228,6 +925,34 @@ func TestDedupSeriesSet(t *testing.T) {
 	testutil.Ok(t, dedupSet.Err())
 }
 
+func TestDedupSeriesSet_SingleReplicaPassThrough(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var series []storepb.Series
+	for i := 0; i < 5; i++ {
+		lset := labels.FromStrings("a", fmt.Sprintf("%d", i), "replica", fmt.Sprintf("replica-%d", i%2))
+		series = append(series, *storeSeriesResponse(t, lset, []sample{{10000, float64(i)}, {20000, float64(i)}, {25000, float64(i)}}).GetSeries())
+	}
+
+	raw := promSeriesSet{mint: 1, maxt: math.MaxInt64, set: newStoreSeriesSet(series)}
+	dedupSet := newDedupSeriesSet(promSeriesSet{mint: 1, maxt: math.MaxInt64, set: newStoreSeriesSet(series)}, "replica", DedupPenalty, 0, 0, nil, nil)
+
+	for raw.Next() {
+		testutil.Assert(t, dedupSet.Next(), "expected series in deduplicated set")
+
+		exp := raw.At()
+		got := dedupSet.At()
+		_, isDedup := got.(*dedupSeries)
+		testutil.Assert(t, !isDedup, "expected single replica series to be passed through")
+
+		testutil.Equals(t, exp.Labels()[:len(exp.Labels())-1], got.Labels())
+		testutil.Equals(t, expandSeries(t, exp.Iterator()), expandSeries(t, got.Iterator()))
+	}
+	testutil.Ok(t, raw.Err())
+	testutil.Assert(t, !dedupSet.Next(), "unexpected series in deduplicated set")
+	testutil.Ok(t, dedupSet.Err())
+}
+
 func TestDedupSeriesIterator(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
@@ -274,8 +999,246 @@ func TestDedupSeriesIterator(t *testing.T) {
 	}
 }
 
-func BenchmarkDedupSeriesIterator(b *testing.B) {
-	run := func(b *testing.B, s1, s2 []sample) {
+func TestQuerier_Select_DedupMixedScrapeIntervals(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// In both series replica "a" has a gap that "b" fills until it stops reporting. The penalty applied to "a" after
+	// the switch follows the scrape interval of each series rather than the gap, so "a" is resumed once "b" stops.
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		// Scraped every 15s.
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "a"), []sample{{15000, 1}, {30000, 1}, {45000, 1}, {105000, 1}, {120000, 1}, {135000, 1}, {150000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "b"), []sample{{82500, 2}, {97500, 2}}),
+		// Scraped every 60s.
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "a"), []sample{{60000, 1}, {120000, 1}, {180000, 1}, {420000, 1}, {480000, 1}, {540000, 1}, {600000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "b"), []sample{{270000, 2}, {330000, 2}, {390000, 2}}),
+	}}
+	q := newQuerier(context.Background(), nil, 1, 1000000, "replica", proxy, true, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	var got [][]sample
+	for res.Next() {
+		got = append(got, expandSeries(t, res.At().Iterator()))
+	}
+	testutil.Ok(t, res.Err())
+	testutil.Equals(t, [][]sample{
+		{{15000, 1}, {30000, 1}, {45000, 1}, {82500, 2}, {97500, 2}, {135000, 1}, {150000, 1}},
+		{{60000, 1}, {120000, 1}, {180000, 1}, {330000, 2}, {390000, 2}, {540000, 1}, {600000, 1}},
+	}, got)
+}
+
+func TestQuerier_Select_DedupSmoothing(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Replica "a" has a gap that "b" fills. Values of "b" drift slightly, or a lot for the second series.
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "a"), []sample{{15000, 100}, {30000, 100}, {45000, 100}, {105000, 100}, {120000, 100}, {135000, 100}, {150000, 100}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "b"), []sample{{82500, 101}, {97500, 101}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "a"), []sample{{15000, 100}, {30000, 100}, {45000, 100}, {105000, 100}, {120000, 100}, {135000, 100}, {150000, 100}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "b"), []sample{{82500, 150}, {97500, 150}}),
+	}}
+	unsmoothed := [][]sample{
+		{{15000, 100}, {30000, 100}, {45000, 100}, {82500, 101}, {97500, 101}, {135000, 100}, {150000, 100}},
+		{{15000, 100}, {30000, 100}, {45000, 100}, {82500, 150}, {97500, 150}, {135000, 100}, {150000, 100}},
+	}
+
+	for _, tcase := range []struct {
+		name     string
+		ctx      context.Context
+		params   *storage.SelectParams
+		expected [][]sample
+	}{
+		{name: "disabled", ctx: context.Background(), params: &storage.SelectParams{}, expected: unsmoothed},
+		{
+			name:   "enabled",
+			ctx:    ContextWithDedupSmoothing(context.Background(), 0.02),
+			params: &storage.SelectParams{},
+			// Only samples right after switches within tolerance are blended.
+			expected: [][]sample{
+				{{15000, 100}, {30000, 100}, {45000, 100}, {82500, 100.5}, {97500, 101}, {135000, 100.5}, {150000, 100}},
+				unsmoothed[1],
+			},
+		},
+		{name: "counter", ctx: ContextWithDedupSmoothing(context.Background(), 0.02), params: &storage.SelectParams{Func: "rate"}, expected: unsmoothed},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			q := newQuerier(tcase.ctx, nil, 1, 1000000, "replica", proxy, true, 0, true, nil, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(tcase.params)
+			testutil.Ok(t, err)
+
+			var got [][]sample
+			for res.Next() {
+				got = append(got, expandSeries(t, res.At().Iterator()))
+			}
+			testutil.Ok(t, res.Err())
+			testutil.Equals(t, tcase.expected, got)
+		})
+	}
+}
+
+func TestQuerier_Select_EmptyVsFailedStores(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	newProxy := func(servers ...*rangeStoreServer) *store.ProxyStore {
+		var clients []store.Client
+		for i, srv := range servers {
+			srv.mint, srv.maxt = math.MinInt64, math.MaxInt64
+			clients = append(clients, store.NewLocalClient(srv, fmt.Sprintf("store-%d", i)))
+		}
+		return store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) { return clients, nil }, nil, store.StoreLimit{})
+	}
+	var (
+		empty  = newProxy(&rangeStoreServer{}, &rangeStoreServer{})
+		failed = newProxy(&rangeStoreServer{err: errors.New("failure")}, &rangeStoreServer{err: errors.New("failure")})
+		mixed  = newProxy(&rangeStoreServer{}, &rangeStoreServer{err: errors.New("failure")})
+	)
+
+	for _, tcase := range []struct {
+		name            string
+		ctx             context.Context
+		proxy           storepb.StoreServer
+		partialResponse bool
+		expWarns        int
+		expErr          bool
+	}{
+		{name: "all stores empty", ctx: context.Background(), proxy: empty, partialResponse: true},
+		{name: "all stores empty with all stores failed error", ctx: store.ContextWithAllStoresFailedError(context.Background()), proxy: empty, partialResponse: true},
+		{name: "all stores failed with partial response", ctx: context.Background(), proxy: failed, partialResponse: true, expWarns: 2},
+		{name: "all stores failed with all stores failed error", ctx: store.ContextWithAllStoresFailedError(context.Background()), proxy: failed, partialResponse: true, expErr: true},
+		{name: "all stores failed without partial response", ctx: context.Background(), proxy: failed, expErr: true},
+		{name: "some stores failed with all stores failed error", ctx: store.ContextWithAllStoresFailedError(context.Background()), proxy: mixed, partialResponse: true, expWarns: 1},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			var warns []error
+			q := newQuerier(tcase.ctx, nil, 1, 10, "", tcase.proxy, false, 0, tcase.partialResponse, func(err error) { warns = append(warns, err) }, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			if tcase.expErr {
+				testutil.NotOk(t, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Assert(t, !res.Next(), "expected no series")
+			testutil.Ok(t, res.Err())
+			testutil.Equals(t, tcase.expWarns, len(warns))
+		})
+	}
+}
+
+func TestQuerier_Select_EmptyResultStoreOutcomes(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	clients := []store.Client{
+		store.NewLocalClient(&rangeStoreServer{mint: math.MinInt64, maxt: math.MaxInt64}, "empty"),
+		store.NewLocalClient(&rangeStoreServer{err: errors.New("disk failure"), mint: math.MinInt64, maxt: math.MaxInt64}, "failed"),
+		store.NewLocalClient(&rangeStoreServer{mint: 100, maxt: 200}, "skipped"),
+	}
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) { return clients, nil }, nil, store.StoreLimit{})
+
+	var warns []error
+	q := newQuerier(context.Background(), nil, 1, 10, "", proxy, false, 0, true, func(err error) { warns = append(warns, err) }, QuerierOpts{ExplainEmptyResults: true})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, !res.Next(), "expected no series")
+	testutil.Ok(t, res.Err())
+
+	outcomes := q.Stats().StoreOutcomes
+	testutil.Equals(t, 3, len(outcomes))
+	testutil.Equals(t, store.StoreOutcome{}, outcomes["empty"])
+	testutil.Equals(t, store.StoreOutcome{SkipReason: "out of time range"}, outcomes["skipped"])
+	testutil.Assert(t, strings.Contains(outcomes["failed"].Failure, "disk failure"), "unexpected outcome %v", outcomes["failed"])
+
+	// Besides the failure of the store, the empty result is explained by outcome of each store.
+	testutil.Equals(t, 2, len(warns))
+	explained := warns[1].Error()
+	for _, exp := range []string{"empty: contacted, no series", "failed: failed, ", "skipped: skipped, out of time range"} {
+		testutil.Assert(t, strings.Contains(explained, exp), "expected %q in warning %q", exp, explained)
+	}
+}
+
+func TestQuerier_Select_EmptyResultStoresCapped(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var clients []store.Client
+	for i := 0; i < maxExplainedStores+2; i++ {
+		clients = append(clients, store.NewLocalClient(&rangeStoreServer{mint: math.MinInt64, maxt: math.MaxInt64}, fmt.Sprintf("store-%02d", i)))
+	}
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) { return clients, nil }, nil, store.StoreLimit{})
+
+	var warns []error
+	q := newQuerier(context.Background(), nil, 1, 10, "", proxy, false, 0, true, func(err error) { warns = append(warns, err) }, QuerierOpts{ExplainEmptyResults: true})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, !res.Next(), "expected no series")
+	testutil.Ok(t, res.Err())
+
+	testutil.Equals(t, 1, len(warns))
+	explained := warns[0].Error()
+	testutil.Assert(t, strings.Contains(explained, fmt.Sprintf("store-%02d: contacted, no series", maxExplainedStores-1)), "unexpected warning %q", explained)
+	testutil.Assert(t, !strings.Contains(explained, fmt.Sprintf("store-%02d:", maxExplainedStores)), "unexpected warning %q", explained)
+	testutil.Assert(t, strings.HasSuffix(explained, "; 2 more stores"), "unexpected warning %q", explained)
+}
+
+func TestQuerier_Select_DedupLookbackDelta(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Both replicas are scraped every 60s. Replica "a" is followed and has a gap after 300000 that "b" fills.
+	var a, b []sample
+	for ts := int64(60000); ts <= 900000; ts += 60000 {
+		if ts <= 300000 || ts >= 600000 {
+			a = append(a, sample{ts, 1})
+		}
+		if ts >= 150000 {
+			b = append(b, sample{ts + 30000, 2})
+		}
+	}
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "a"), a),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "b"), b),
+	}}
+
+	for _, tcase := range []struct {
+		name string
+		ctx  context.Context
+		// First sample of "b" after the gap.
+		expSwitch int64
+	}{
+		// Penalty of twice the scrape interval is shorter than the default lookback delta.
+		{name: "default", ctx: context.Background(), expSwitch: 450000},
+		{name: "shorter than penalty", ctx: ContextWithLookbackDelta(context.Background(), 75*time.Second), expSwitch: 390000},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			q := newQuerier(tcase.ctx, nil, 1, 1000000, "replica", proxy, true, 0, true, nil, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+
+			exp := append([]sample{}, a[:5]...)
+			for _, s := range b {
+				if s.t >= tcase.expSwitch {
+					exp = append(exp, s)
+				}
+			}
+			testutil.Assert(t, res.Next(), "expected series")
+			testutil.Equals(t, exp, expandSeries(t, res.At().Iterator()))
+			testutil.Assert(t, !res.Next(), "expected single series")
+			testutil.Ok(t, res.Err())
+		})
+	}
+}
+
+func BenchmarkDedupSeriesIterator(b *testing.B) {
+	run := func(b *testing.B, s1, s2 []sample) {
 		it := newDedupSeriesIterator(
 			&SampleIterator{l: s1, i: -1},
 			&SampleIterator{l: s2, i: -1},
@@ -324,6 +1287,41 @@ func BenchmarkDedupSeriesIterator(b *testing.B) {
 	})
 }
 
+func BenchmarkDedupSeriesSet_SingleReplica(b *testing.B) {
+	var series []storepb.Series
+	for i := 0; i < 1000; i++ {
+		var smpls []sample
+		for j := 0; j < 120; j++ {
+			smpls = append(smpls, sample{t: int64(j*15000) + 1, v: float64(j)})
+		}
+		lset := labels.FromStrings("a", fmt.Sprintf("%d", i), "replica", "replica-1")
+		series = append(series, *storeSeriesResponse(b, lset, smpls).GetSeries())
+	}
+
+	run := func(b *testing.B, iterator func(storage.Series) storage.SeriesIterator) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			set := newDedupSeriesSet(promSeriesSet{mint: 1, maxt: math.MaxInt64, set: newStoreSeriesSet(series)}, "replica", DedupPenalty, 0, 0, nil, nil)
+			for set.Next() {
+				it := iterator(set.At())
+				for it.Next() {
+				}
+			}
+		}
+	}
+	b.Run("pass-through", func(b *testing.B) {
+		run(b, func(s storage.Series) storage.SeriesIterator { return s.Iterator() })
+	})
+	// Iterate the same series through the deduplication iterator against an empty replica, which is what every
+	// series would pay for without the pass-through.
+	b.Run("dedup-iterator", func(b *testing.B) {
+		run(b, func(s storage.Series) storage.SeriesIterator {
+			return newDedupSeriesIterator(s.Iterator(), &SampleIterator{i: -1})
+		})
+	})
+}
+
 type sample struct {
 	t int64
 	v float64
@@ -369,10 +1367,14 @@ type storeServer struct {
 	// This field just exist to pseudo-implement the unused methods of the interface.
 	storepb.StoreServer
 
-	resps []*storepb.SeriesResponse
+	resps   []*storepb.SeriesResponse
+	calls   int
+	lastReq *storepb.SeriesRequest
 }
 
 func (s *storeServer) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	s.calls++
+	s.lastReq = r
 	for _, resp := range s.resps {
 		err := srv.Send(resp)
 		if err != nil {
@@ -397,11 +1399,1038 @@ func storeSeriesResponse(t testing.TB, lset labels.Labels, smplChunks ...[]sampl
 		for _, smpl := range smpls {
 			a.Append(smpl.t, smpl.v)
 		}
-		s.Chunks = append(s.Chunks, storepb.AggrChunk{
-			MinTime: smpls[0].t,
-			MaxTime: smpls[len(smpls)-1].t,
-			Raw:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()},
-		})
+		ac := storepb.AggrChunk{Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()}}
+		if len(smpls) > 0 {
+			ac.MinTime, ac.MaxTime = smpls[0].t, smpls[len(smpls)-1].t
+		}
+		s.Chunks = append(s.Chunks, ac)
 	}
 	return storepb.NewSeriesResponse(&s)
 }
+
+func TestQuerier_Series_InvertedChunk(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	inverted := storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}, {2, 2}}, []sample{{3, 3}, {4, 4}})
+	inverted.GetSeries().Chunks[0].MinTime, inverted.GetSeries().Chunks[0].MaxTime = 2, 1
+
+	newProxy := func() *storeServer {
+		return &storeServer{
+			resps: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}, {2, 2}}),
+				inverted,
+			},
+		}
+	}
+
+	t.Run("partial response enabled", func(t *testing.T) {
+		var warns []error
+		q := newQuerier(context.Background(), nil, 0, 10, "", newProxy(), false, 0, true, func(err error) { warns = append(warns, err) }, QuerierOpts{})
+		defer func() { testutil.Ok(t, q.Close()) }()
+
+		res, _, err := q.Select(&storage.SelectParams{})
+		testutil.Ok(t, err)
+
+		var got [][]sample
+		for res.Next() {
+			got = append(got, expandSeries(t, res.At().Iterator()))
+		}
+		testutil.Ok(t, res.Err())
+		testutil.Equals(t, [][]sample{{{1, 1}, {2, 2}}, {{3, 3}, {4, 4}}}, got)
+		testutil.Equals(t, 1, len(warns))
+
+		// The received response is left as is.
+		testutil.Equals(t, 2, len(inverted.GetSeries().Chunks))
+		testutil.Equals(t, int64(2), inverted.GetSeries().Chunks[0].MinTime)
+	})
+	t.Run("partial response disabled", func(t *testing.T) {
+		q := newQuerier(context.Background(), nil, 0, 10, "", newProxy(), false, 0, false, nil, QuerierOpts{})
+		defer func() { testutil.Ok(t, q.Close()) }()
+
+		_, _, err := q.Select(&storage.SelectParams{})
+		testutil.NotOk(t, err)
+	})
+}
+
+func TestQuerier_Select_MaxQueryRange(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	hour := int64(time.Hour / time.Millisecond)
+	for _, tcase := range []struct {
+		name       string
+		ctx        context.Context
+		mint, maxt int64
+		rejected   bool
+	}{
+		{name: "in range", ctx: context.Background(), mint: 0, maxt: 2 * hour},
+		{name: "over range", ctx: context.Background(), mint: 0, maxt: 2*hour + 1, rejected: true},
+		{name: "override with longer range", ctx: ContextWithMaxQueryRange(context.Background(), 24*time.Hour), mint: 0, maxt: 10 * hour},
+		{name: "override with no limit", ctx: ContextWithMaxQueryRange(context.Background(), 0), mint: 0, maxt: 1000 * hour},
+		{name: "override with shorter range", ctx: ContextWithMaxQueryRange(context.Background(), time.Hour), mint: 0, maxt: 2 * hour, rejected: true},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			proxy := &storeServer{resps: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{0, 0}, {1, 1}}),
+			}}
+			q := newQuerier(tcase.ctx, nil, tcase.mint, tcase.maxt, "", proxy, false, 0, true, nil, QuerierOpts{MaxQueryRange: 2 * time.Hour})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			if tcase.rejected {
+				testutil.NotOk(t, err)
+				// Request has to be rejected before fanout.
+				testutil.Equals(t, 0, proxy.calls)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equals(t, 1, proxy.calls)
+			testutil.Assert(t, res.Next(), "expected series")
+		})
+	}
+}
+
+func TestQuerier_Select_TimeRangeValidation(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{0, 0}, {1, 1}}),
+	}}
+
+	// Inverted range is rejected before fanout.
+	q := newQuerier(context.Background(), nil, 10, 5, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	_, _, err := q.Select(&storage.SelectParams{})
+	testutil.NotOk(t, err)
+	_, err = q.EstimateCost()
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 0, proxy.calls)
+
+	// Range ending far in the future is clamped.
+	now := timestamp.FromTime(time.Now())
+	q = newQuerier(context.Background(), nil, 0, math.MaxInt64, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, res.Next(), "expected series")
+	testutil.Equals(t, int64(0), proxy.lastReq.MinTime)
+	testutil.Assert(t, proxy.lastReq.MaxTime >= now && proxy.lastReq.MaxTime <= now+int64(2*defaultFutureGrace/time.Millisecond),
+		"expected end clamped close to now, got %d", proxy.lastReq.MaxTime)
+
+	// Range entirely in the future is clamped to its start.
+	future := now + int64(time.Hour/time.Millisecond)
+	q = newQuerier(context.Background(), nil, future, future+1000, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	_, _, err = q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, future, proxy.lastReq.MinTime)
+	testutil.Equals(t, future, proxy.lastReq.MaxTime)
+}
+
+func TestQuerier_Select_FutureGrace(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Replica clock is ahead of the querier.
+	now := timestamp.FromTime(time.Now())
+	minute := int64(time.Minute / time.Millisecond)
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{now - minute, 1}, {now + minute, 2}, {now + 3*minute, 3}}),
+	}}
+
+	for _, tcase := range []struct {
+		name  string
+		grace time.Duration
+		exp   []sample
+	}{
+		{
+			// Sample within grace is kept, the one beyond it is dropped.
+			name:  "grace",
+			grace: 2 * time.Minute,
+			exp:   []sample{{now - minute, 1}, {now + minute, 2}},
+		},
+		{
+			// Zero grace clamps the end to now.
+			name:  "no grace",
+			grace: 0,
+			exp:   []sample{{now - minute, 1}},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			grace := tcase.grace
+			q := newQuerier(context.Background(), nil, 0, math.MaxInt64, "", proxy, false, 0, true, nil, QuerierOpts{FutureGrace: &grace})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+			testutil.Assert(t, res.Next(), "expected series")
+			testutil.Equals(t, tcase.exp, expandSeries(t, res.At().Iterator()))
+			testutil.Assert(t, !res.Next(), "expected no more series")
+			testutil.Ok(t, res.Err())
+		})
+	}
+}
+
+func TestQuerier_Select_MaxDataAge(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	now := timestamp.FromTime(time.Now())
+	old := now - int64(2*time.Hour/time.Millisecond)
+	recent := now - int64(30*time.Minute/time.Millisecond)
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{old, 1}, {recent, 2}}),
+	}}
+
+	var warns []error
+	q := newQuerier(context.Background(), nil, 0, now, "", proxy, false, 0, true, func(err error) { warns = append(warns, err) }, QuerierOpts{MaxDataAge: time.Hour})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	for i := 0; i < 2; i++ {
+		res, _, err := q.Select(&storage.SelectParams{})
+		testutil.Ok(t, err)
+		testutil.Assert(t, res.Next(), "expected series")
+		// Sample older than the floor is dropped, even though the store returned it.
+		testutil.Equals(t, []sample{{recent, 2}}, expandSeries(t, res.At().Iterator()))
+		testutil.Assert(t, !res.Next(), "expected no more series")
+	}
+	floor := now - int64(time.Hour/time.Millisecond)
+	testutil.Assert(t, proxy.lastReq.MinTime >= floor && proxy.lastReq.MinTime < recent,
+		"expected start clamped close to the floor, got %d", proxy.lastReq.MinTime)
+	testutil.Equals(t, now, proxy.lastReq.MaxTime)
+	// Clamping is reported once per querier.
+	testutil.Equals(t, 1, len(warns))
+
+	// Range entirely before the floor returns no series without asking stores.
+	warns = nil
+	proxy.lastReq = nil
+	q = newQuerier(context.Background(), nil, 0, old, "", proxy, false, 0, true, func(err error) { warns = append(warns, err) }, QuerierOpts{MaxDataAge: time.Hour})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, !res.Next(), "expected no series")
+	testutil.Ok(t, res.Err())
+	testutil.Assert(t, proxy.lastReq == nil, "expected no request to stores, got %v", proxy.lastReq)
+	testutil.Equals(t, 1, len(warns))
+	testutil.Assert(t, strings.Contains(warns[0].Error(), "no data is returned"), "unexpected warning %v", warns[0])
+}
+
+func TestQuerier_Select_ResponseSizeWarning(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var large []*storepb.SeriesResponse
+	for i := 0; i < 50; i++ {
+		var samples []sample
+		for ts := int64(0); ts < 100; ts++ {
+			samples = append(samples, sample{ts * 15000, float64(i*100) + float64(ts)})
+		}
+		large = append(large, storeSeriesResponse(t, labels.FromStrings("a", fmt.Sprint(i)), samples))
+	}
+	const threshold = 2000
+
+	for _, tcase := range []struct {
+		name  string
+		resps []*storepb.SeriesResponse
+		warn  bool
+	}{
+		{
+			name: "small",
+			resps: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{0, 1}, {15000, 2}, {30000, 3}}),
+			},
+		},
+		{
+			name:  "large",
+			resps: large,
+			warn:  true,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			var warns []error
+			q := newQuerier(context.Background(), nil, 0, 1500000, "", &storeServer{resps: tcase.resps}, false, 0, true,
+				func(err error) { warns = append(warns, err) }, QuerierOpts{ResponseSizeWarning: threshold})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+			for res.Next() {
+			}
+			testutil.Ok(t, res.Err())
+
+			chunkBytes := q.Stats().Transfer.ChunkBytes
+			testutil.Assert(t, chunkBytes > 0, "expected chunk bytes accounted")
+			testutil.Equals(t, tcase.warn, chunkBytes > threshold)
+			if !tcase.warn {
+				testutil.Equals(t, 0, len(warns))
+				return
+			}
+			testutil.Equals(t, 1, len(warns))
+			testutil.Assert(t, strings.Contains(warns[0].Error(), "size warning threshold"), "unexpected warning %v", warns[0])
+		})
+	}
+}
+
+func TestQuerier_Select_RequiredLabels(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "namespace", "ns"), []sample{{1, 1}}),
+	}}
+	q := newQuerier(context.Background(), nil, 0, 10, "", proxy, false, 0, true, nil, QuerierOpts{RequiredLabels: []string{"namespace"}})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	matcher := func(mt labels.MatchType, name, value string) *labels.Matcher {
+		m, err := labels.NewMatcher(mt, name, value)
+		testutil.Ok(t, err)
+		return m
+	}
+
+	for _, ms := range [][]*labels.Matcher{
+		{matcher(labels.MatchEqual, "a", "1")},
+		{matcher(labels.MatchRegexp, "namespace", "ns.*")},
+		{matcher(labels.MatchEqual, "namespace", "")},
+		{matcher(labels.MatchRegexp, "namespace", "")},
+	} {
+		_, _, err := q.Select(&storage.SelectParams{}, ms...)
+		testutil.NotOk(t, err)
+		testutil.Assert(t, strings.Contains(err.Error(), `label "namespace"`), "unexpected error %v", err)
+	}
+	// Rejected selects never reach stores.
+	testutil.Equals(t, 0, proxy.calls)
+
+	// Regexp of a single literal value selects the label just like an equality matcher.
+	for i, m := range []*labels.Matcher{
+		matcher(labels.MatchEqual, "namespace", "ns"),
+		matcher(labels.MatchRegexp, "namespace", "ns"),
+		matcher(labels.MatchRegexp, "namespace", "^ns$"),
+	} {
+		res, _, err := q.Select(&storage.SelectParams{}, matcher(labels.MatchEqual, "a", "1"), m)
+		testutil.Ok(t, err)
+		testutil.Assert(t, res.Next(), "expected series")
+		testutil.Equals(t, labels.FromStrings("a", "1", "namespace", "ns"), res.At().Labels())
+		testutil.Equals(t, i+1, proxy.calls)
+	}
+}
+
+func TestQuerier_Select_DedupFreshest(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Replica "a" is 30s behind replica "b".
+	var lagging, fresh []sample
+	for ts := int64(15000); ts <= 105000; ts += 15000 {
+		if ts <= 75000 {
+			lagging = append(lagging, sample{ts, 1})
+		}
+		fresh = append(fresh, sample{ts, 2})
+	}
+	newProxy := func() *storeServer {
+		return &storeServer{resps: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "a"), lagging),
+			storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "b"), fresh),
+		}}
+	}
+
+	for _, tcase := range []struct {
+		strategy DedupStrategy
+		exp      []sample
+	}{
+		{
+			// Penalty of switching replicas skips the trailing samples of the fresher replica.
+			strategy: DedupPenalty,
+			exp:      lagging,
+		},
+		{
+			strategy: DedupFreshest,
+			exp:      append(append([]sample{}, lagging...), sample{90000, 2}, sample{105000, 2}),
+		},
+	} {
+		t.Run(string(tcase.strategy), func(t *testing.T) {
+			ctx := ContextWithDedupStrategy(context.Background(), tcase.strategy)
+			q := newQuerier(ctx, nil, 1, 200000, "replica", newProxy(), true, 0, true, nil, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+
+			testutil.Assert(t, res.Next(), "expected series")
+			testutil.Equals(t, labels.FromStrings("a", "1"), res.At().Labels())
+			testutil.Equals(t, tcase.exp, expandSeries(t, res.At().Iterator()))
+			testutil.Assert(t, !res.Next(), "expected single series")
+			testutil.Ok(t, res.Err())
+		})
+	}
+}
+
+func TestQuerier_Select_DedupDisjointReplicas(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Replica "b" restarted and has only recent data, replica "a" stopped before it.
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "a"), []sample{{0, 1}, {10, 1}, {20, 1}, {30, 1}, {40, 1}, {50, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "b"), []sample{{60, 2}, {70, 2}, {80, 2}, {90, 2}, {100, 2}}),
+	}}
+	q := newQuerier(ContextWithDedupStats(context.Background()), nil, 0, 100, "replica", proxy, true, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, res.Next(), "expected series")
+	testutil.Equals(t, labels.FromStrings("a", "1"), res.At().Labels())
+	// Windows are stitched, no sample after the gap is skipped as too close to the other replica.
+	testutil.Equals(t, []sample{
+		{0, 1}, {10, 1}, {20, 1}, {30, 1}, {40, 1}, {50, 1},
+		{60, 2}, {70, 2}, {80, 2}, {90, 2}, {100, 2},
+	}, expandSeries(t, res.At().Iterator()))
+	testutil.Assert(t, !res.Next(), "expected no more series")
+	testutil.Ok(t, res.Err())
+
+	// Stitching is not a switch between replicas, so no sample is rescued.
+	testutil.Equals(t, []SeriesDedupStats{
+		{Labels: labels.FromStrings("a", "1"), ReplicaSamples: map[string]int64{"a": 6, "b": 5}},
+	}, q.Stats().Dedup)
+}
+
+func TestParseDedupStrategy(t *testing.T) {
+	s, err := ParseDedupStrategy("freshest")
+	testutil.Ok(t, err)
+	testutil.Equals(t, DedupFreshest, s)
+
+	_, err = ParseDedupStrategy("newest")
+	testutil.NotOk(t, err)
+}
+
+type probingStoreServer struct {
+	storeServer
+
+	addrs    []string
+	warnings []string
+	lastReq  *storepb.SeriesRequest
+}
+
+func (s *probingStoreServer) SeriesStores(_ context.Context, r *storepb.SeriesRequest) ([]string, []string, error) {
+	s.lastReq = r
+	return s.addrs, s.warnings, nil
+}
+
+func TestQuerier_StoresWithMetric(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &probingStoreServer{addrs: []string{"store-1", "store-3"}, warnings: []string{"store-2 unavailable"}}
+
+	var warns []error
+	q := newQuerier(context.Background(), nil, 10, 20, "", proxy, false, 0, true, func(err error) { warns = append(warns, err) }, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	addrs, err := q.StoresWithMetric("up")
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"store-1", "store-3"}, addrs)
+	testutil.Equals(t, 1, len(warns))
+
+	testutil.Equals(t, &storepb.SeriesRequest{
+		MinTime:    10,
+		MaxTime:    20,
+		Matchers:   []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"}},
+		SkipChunks: true,
+	}, proxy.lastReq)
+	testutil.Equals(t, 0, proxy.calls)
+
+	// Proxies without probing support are not supported.
+	q2 := newQuerier(context.Background(), nil, 10, 20, "", &storeServer{}, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q2.Close()) }()
+
+	_, err = q2.StoresWithMetric("up")
+	testutil.NotOk(t, err)
+}
+
+func TestQuerier_Stats_DedupReplicaSamples(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Replica "b" misses scrapes in the middle, so "a" contributes all samples of the first series. For the second
+	// series "a" stops reporting and "b" takes over once the penalty applied on the switch passes.
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "a"), []sample{{10000, 1}, {20000, 1}, {30000, 1}, {40000, 1}, {50000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "b"), []sample{{10000, 2}, {50000, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "a"), []sample{{10000, 1}, {20000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "b"), []sample{{10000, 2}, {20000, 2}, {30000, 2}, {40000, 2}, {50000, 2}, {60000, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "3", "replica", "a"), []sample{{10000, 1}}),
+	}}
+	q := newQuerier(ContextWithDedupStats(context.Background()), nil, 1, 100000, "replica", proxy, true, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	for res.Next() {
+		expandSeries(t, res.At().Iterator())
+	}
+	testutil.Ok(t, res.Err())
+
+	// Series with a single replica is not deduplicated, so it is not reported.
+	testutil.Equals(t, []SeriesDedupStats{
+		{Labels: labels.FromStrings("a", "1"), ReplicaSamples: map[string]int64{"a": 5, "b": 0}},
+		{Labels: labels.FromStrings("a", "2"), ReplicaSamples: map[string]int64{"a": 2, "b": 2}, RescuedSamples: 1},
+	}, q.Stats().Dedup)
+}
+
+func TestQuerier_Stats_DedupRescuedSamples(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Replica "a" misses scrapes between 30000 and 60000, which "b" scraping with an offset fills.
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "a"), []sample{{10000, 1}, {20000, 1}, {30000, 1}, {60000, 1}, {70000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "b"), []sample{{15000, 2}, {25000, 2}, {35000, 2}, {45000, 2}, {55000, 2}, {65000, 2}, {75000, 2}}),
+	}}
+	metrics := NewDedupMetrics(nil)
+	q := newQuerier(ContextWithDedupStats(context.Background()), nil, 1, 100000, "replica", proxy, true, 0, true, nil, QuerierOpts{DedupMetrics: metrics})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	testutil.Assert(t, res.Next(), "expected deduplicated series")
+	testutil.Equals(t, []sample{{10000, 1}, {20000, 1}, {30000, 1}, {55000, 2}, {65000, 2}, {75000, 2}}, expandSeries(t, res.At().Iterator()))
+	testutil.Assert(t, !res.Next(), "expected single series")
+	testutil.Ok(t, res.Err())
+
+	// Deduplication follows "b" after the gap, so only the sample at the switch is rescued.
+	testutil.Equals(t, []SeriesDedupStats{
+		{Labels: labels.FromStrings("a", "1"), ReplicaSamples: map[string]int64{"a": 3, "b": 3}, RescuedSamples: 1},
+	}, q.Stats().Dedup)
+	testutil.Equals(t, 1, int(promtestutil.ToFloat64(metrics.rescuedSamples)))
+
+	// Without per series stats requested, rescued samples are still counted by metrics.
+	q2 := newQuerier(context.Background(), nil, 1, 100000, "replica", proxy, true, 0, true, nil, QuerierOpts{DedupMetrics: metrics})
+	defer func() { testutil.Ok(t, q2.Close()) }()
+
+	res, _, err = q2.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, res.Next(), "expected deduplicated series")
+	testutil.Equals(t, []sample{{10000, 1}, {20000, 1}, {30000, 1}, {55000, 2}, {65000, 2}, {75000, 2}}, expandSeries(t, res.At().Iterator()))
+	testutil.Ok(t, res.Err())
+
+	testutil.Equals(t, []SeriesDedupStats(nil), q2.Stats().Dedup)
+	testutil.Equals(t, 2, int(promtestutil.ToFloat64(metrics.rescuedSamples)))
+}
+
+func TestQuerier_Stats_TransferChunkBytes(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	resps := []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "a"), []sample{{10000, 1}, {20000, 1}, {30000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "b"), []sample{{15000, 2}, {25000, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "a"), []sample{{10000, 1}}),
+	}
+	var exp int64
+	for _, r := range resps {
+		for _, c := range r.GetSeries().Chunks {
+			exp += int64(c.Size())
+		}
+	}
+
+	q := newQuerier(context.Background(), nil, 1, 100000, "replica", &storeServer{resps: resps}, true, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	for res.Next() {
+	}
+	testutil.Ok(t, res.Err())
+
+	// Chunks of all replicas are accounted as received, not only the ones deduplication picked.
+	testutil.Equals(t, exp, q.Stats().Transfer.ChunkBytes)
+}
+
+func TestQuerier_Select_MissingReplicaLabel(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "A"), []sample{{10000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "B"), []sample{{10000, 1}}),
+	}}
+
+	for _, tcase := range []struct {
+		replicaLabel string
+		expWarns     []error
+	}{
+		{replicaLabel: "replica"},
+		{
+			// Misspelled replica label.
+			replicaLabel: "replcia",
+			expWarns:     []error{errors.New(`none of 2 selected series has replica label "replcia", deduplication had no effect`)},
+		},
+	} {
+		t.Run(tcase.replicaLabel, func(t *testing.T) {
+			var warns []error
+			metrics := NewDedupMetrics(nil)
+			q := newQuerier(context.Background(), nil, 1, 100000, tcase.replicaLabel, proxy, true, 0, true, func(err error) { warns = append(warns, err) }, QuerierOpts{DedupMetrics: metrics})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			_, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+			testutil.Equals(t, len(tcase.expWarns), len(warns))
+			for i := range tcase.expWarns {
+				testutil.Equals(t, tcase.expWarns[i].Error(), warns[i].Error())
+			}
+			testutil.Equals(t, len(tcase.expWarns), int(promtestutil.ToFloat64(metrics.missingReplicaLabel)))
+		})
+	}
+}
+
+func TestQuerier_Select_SeriesComparator(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "c", "2", "replica", "A"), []sample{{10000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "c", "2", "replica", "B"), []sample{{10000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "c", "1", "replica", "A"), []sample{{10000, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "3", "replica", "A"), []sample{{10000, 3}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "3", "c", "1", "replica", "B"), []sample{{10000, 4}}),
+	}}
+	ctx := ContextWithSeriesComparator(context.Background(), storepb.CompareLabelsBy("c"))
+
+	for _, tcase := range []struct {
+		dedup bool
+		exp   []labels.Labels
+	}{
+		{
+			dedup: true,
+			exp: []labels.Labels{
+				labels.FromStrings("a", "3"),
+				labels.FromStrings("a", "2", "c", "1"),
+				labels.FromStrings("a", "3", "c", "1"),
+				labels.FromStrings("a", "1", "c", "2"),
+			},
+		},
+		{
+			dedup: false,
+			exp: []labels.Labels{
+				labels.FromStrings("a", "3", "replica", "A"),
+				labels.FromStrings("a", "2", "c", "1", "replica", "A"),
+				labels.FromStrings("a", "3", "c", "1", "replica", "B"),
+				labels.FromStrings("a", "1", "c", "2", "replica", "A"),
+				labels.FromStrings("a", "1", "c", "2", "replica", "B"),
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("dedup=%v", tcase.dedup), func(t *testing.T) {
+			q := newQuerier(ctx, nil, 1, 100000, "replica", proxy, tcase.dedup, 0, true, nil, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+
+			var got []labels.Labels
+			for res.Next() {
+				got = append(got, res.At().Labels())
+			}
+			testutil.Ok(t, res.Err())
+			testutil.Equals(t, tcase.exp, got)
+		})
+	}
+}
+
+func TestQuerier_Select_OutOfOrderStore(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	clients := []store.Client{
+		// Misbehaving store sending series out of label order.
+		store.NewLocalClient(&rangeStoreServer{storeServer: storeServer{resps: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "3"), []sample{{1, 3}}),
+			storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}),
+		}}, mint: math.MinInt64, maxt: math.MaxInt64}, "store-1"),
+		store.NewLocalClient(&rangeStoreServer{storeServer: storeServer{resps: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{2, 1}}),
+			storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{1, 2}}),
+		}}, mint: math.MinInt64, maxt: math.MaxInt64}, "store-2"),
+	}
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) { return clients, nil }, nil, store.StoreLimit{})
+
+	q := newQuerier(context.Background(), nil, 1, 10, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	// Series are sorted and chunks of the same series from both stores are merged.
+	for _, exp := range []struct {
+		lset    labels.Labels
+		samples []sample
+	}{
+		{lset: labels.FromStrings("a", "1"), samples: []sample{{1, 1}, {2, 1}}},
+		{lset: labels.FromStrings("a", "2"), samples: []sample{{1, 2}}},
+		{lset: labels.FromStrings("a", "3"), samples: []sample{{1, 3}}},
+	} {
+		testutil.Assert(t, res.Next(), "expected series")
+		testutil.Equals(t, exp.lset, res.At().Labels())
+		testutil.Equals(t, exp.samples, expandSeries(t, res.At().Iterator()))
+	}
+	testutil.Assert(t, !res.Next(), "expected no more series")
+	testutil.Ok(t, res.Err())
+}
+
+func TestSeriesServer_InternsLabels(t *testing.T) {
+	var msgs [][]byte
+	for _, job := range []string{"a", "b"} {
+		resp := storeSeriesResponse(t, labels.FromStrings("__name__", "up", "job", job), []sample{{1, 1}})
+		b, err := resp.Marshal()
+		testutil.Ok(t, err)
+		msgs = append(msgs, b)
+	}
+
+	// Unmarshal each response separately, so the label strings are allocated for each of them like for gRPC messages.
+	s := &seriesServer{ctx: context.Background()}
+	for _, b := range msgs {
+		var r storepb.SeriesResponse
+		testutil.Ok(t, r.Unmarshal(b))
+		testutil.Ok(t, s.Send(&r))
+	}
+	testutil.Equals(t, 2, len(s.seriesSet))
+
+	data := func(s string) uintptr { return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data }
+	a, b := s.seriesSet[0].Labels, s.seriesSet[1].Labels
+	testutil.Equals(t, data(a[0].Name), data(b[0].Name))
+	testutil.Equals(t, data(a[0].Value), data(b[0].Value))
+	testutil.Equals(t, data(a[1].Name), data(b[1].Name))
+	testutil.Equals(t, "a", a[1].Value)
+	testutil.Equals(t, "b", b[1].Value)
+}
+
+func TestQuerier_Select_DuplicateLabels(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.Labels{{Name: "a", Value: "1"}, {Name: "b", Value: "1"}, {Name: "a", Value: "2"}}, []sample{{1, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "3"), []sample{{1, 1}}),
+	}}
+
+	for _, tcase := range []struct {
+		handling        DuplicateLabels
+		partialResponse bool
+		exp             []labels.Labels
+		expWarns        int
+		expErr          bool
+	}{
+		{handling: DuplicateLabelsError, expErr: true},
+		{handling: DuplicateLabelsError, partialResponse: true, exp: []labels.Labels{labels.FromStrings("a", "3")}, expWarns: 1},
+		{handling: DuplicateLabelsKeepFirst, exp: []labels.Labels{labels.FromStrings("a", "1", "b", "1"), labels.FromStrings("a", "3")}},
+		{handling: DuplicateLabelsKeepLast, exp: []labels.Labels{labels.FromStrings("a", "2", "b", "1"), labels.FromStrings("a", "3")}},
+	} {
+		t.Run(fmt.Sprintf("%s,partial=%v", tcase.handling, tcase.partialResponse), func(t *testing.T) {
+			var warns []error
+			ctx := ContextWithDuplicateLabels(context.Background(), tcase.handling)
+			q := newQuerier(ctx, nil, 0, 10, "", proxy, false, 0, tcase.partialResponse, func(err error) { warns = append(warns, err) }, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			if tcase.expErr {
+				testutil.NotOk(t, err)
+				return
+			}
+			testutil.Ok(t, err)
+
+			var got []labels.Labels
+			for res.Next() {
+				got = append(got, res.At().Labels())
+			}
+			testutil.Ok(t, res.Err())
+			testutil.Equals(t, tcase.exp, got)
+			testutil.Equals(t, tcase.expWarns, len(warns))
+		})
+	}
+}
+
+func TestQuerier_Select_DuplicateSamples(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	for _, tcase := range []struct {
+		name     string
+		handling DuplicateSamples
+		chunks   [][]sample
+
+		exp    []sample
+		expErr bool
+	}{
+		{
+			name:   "overlapping chunks with exact duplicates",
+			chunks: [][]sample{{{10, 1}, {20, 2}, {30, 3}}, {{20, 2}, {30, 3}, {40, 4}}},
+			exp:    []sample{{10, 1}, {20, 2}, {30, 3}, {40, 4}},
+		},
+		{
+			name:   "duplicates with different values keep the first",
+			chunks: [][]sample{{{10, 1}, {20, 2}, {20, 5}}, {{20, 6}, {30, 3}}},
+			exp:    []sample{{10, 1}, {20, 2}, {30, 3}},
+		},
+		{
+			name:     "exact duplicates are dropped in error mode",
+			handling: DuplicateSamplesError,
+			chunks:   [][]sample{{{10, 1}, {20, 2}, {20, 2}}, {{20, 2}, {30, 3}}},
+			exp:      []sample{{10, 1}, {20, 2}, {30, 3}},
+		},
+		{
+			name:     "duplicates with different values fail in error mode",
+			handling: DuplicateSamplesError,
+			chunks:   [][]sample{{{10, 1}, {20, 2}}, {{20, 6}, {30, 3}}},
+			expErr:   true,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tcase.handling != "" {
+				ctx = ContextWithDuplicateSamples(ctx, tcase.handling)
+			}
+			proxy := &storeServer{resps: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "a"), tcase.chunks...),
+			}}
+			q := newQuerier(ctx, nil, 0, 100, "", proxy, false, 0, true, nil, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+			testutil.Assert(t, res.Next(), "expected series")
+
+			it := res.At().Iterator()
+			var got []sample
+			for it.Next() {
+				ts, v := it.At()
+				got = append(got, sample{ts, v})
+			}
+			if tcase.expErr {
+				testutil.NotOk(t, it.Err())
+				return
+			}
+			testutil.Ok(t, it.Err())
+			testutil.Equals(t, tcase.exp, got)
+		})
+	}
+}
+
+func BenchmarkSeriesServer_Send(b *testing.B) {
+	var resps [][]byte
+	for i := 0; i < 1000; i++ {
+		resp := storeSeriesResponse(b, labels.FromStrings("__name__", "http_requests_total", "job", "api", "instance", fmt.Sprintf("host-%d", i%10)), []sample{{1, 1}})
+		m, err := resp.Marshal()
+		testutil.Ok(b, err)
+		resps = append(resps, m)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := &seriesServer{ctx: context.Background()}
+		for _, m := range resps {
+			var r storepb.SeriesResponse
+			testutil.Ok(b, r.Unmarshal(m))
+			testutil.Ok(b, s.Send(&r))
+		}
+	}
+}
+
+func TestQuerier_Select_ChunkRefs(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}, {2, 2}}, []sample{{3, 3}, {4, 4}}),
+	}}
+	// Occupy the only decode slot, so any attempt to decode a chunk blocks.
+	p := NewDecodePool(nil, 1)
+	p.slots <- struct{}{}
+
+	q := newQuerier(ContextWithChunkRefs(context.Background()), nil, 1, 10, "", proxy, false, 0, true, nil, QuerierOpts{DecodePool: p})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, res.Next(), "expected series")
+
+	s, ok := res.At().(ChunkSeries)
+	testutil.Assert(t, ok, "expected ChunkSeries, got %T", res.At())
+	testutil.Equals(t, labels.FromStrings("a", "a"), s.Labels())
+	testutil.Equals(t, 2, len(s.Chunks()))
+	testutil.Equals(t, int64(1), s.Chunks()[0].MinTime)
+	testutil.Equals(t, int64(4), s.Chunks()[1].MaxTime)
+
+	itc := make(chan storage.SeriesIterator)
+	go func() { itc <- s.Iterator() }()
+
+	var it storage.SeriesIterator
+	select {
+	case it = <-itc:
+	case <-time.After(5 * time.Second):
+		t.Fatal("creating series iterator decoded chunks")
+	}
+
+	// Free the slot, so chunks can be decoded once iterated.
+	<-p.slots
+	testutil.Equals(t, []sample{{1, 1}, {2, 2}, {3, 3}, {4, 4}}, expandSeries(t, it))
+
+	testutil.Assert(t, !res.Next(), "expected single series")
+	testutil.Ok(t, res.Err())
+}
+
+func TestQuerier_Select_SampleFilter(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		// Negative values are sensor errors.
+		storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}, {2, -1}, {3, 3}}, []sample{{4, -5}, {5, -2}}, []sample{{6, 6}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, -1}, {2, math.NaN()}}),
+	}}
+	ctx := ContextWithSampleFilter(context.Background(), ValueRangeFilter(0, math.Inf(1)))
+	q := newQuerier(ctx, nil, 1, 10, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	testutil.Assert(t, res.Next(), "expected series")
+	testutil.Equals(t, []sample{{1, 1}, {3, 3}, {6, 6}}, expandSeries(t, res.At().Iterator()))
+
+	// NaN values are kept.
+	testutil.Assert(t, res.Next(), "expected series")
+	smpls := expandSeries(t, res.At().Iterator())
+	testutil.Equals(t, 1, len(smpls))
+	testutil.Equals(t, int64(2), smpls[0].t)
+	testutil.Assert(t, math.IsNaN(smpls[0].v), "expected NaN, got %v", smpls[0].v)
+
+	testutil.Assert(t, !res.Next(), "expected two series")
+	testutil.Ok(t, res.Err())
+}
+
+func TestQuerier_Select_CoalescesSplitSeries(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Store sends chunks of series "a" in two consecutive responses.
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}, {2, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{3, 3}}, []sample{{4, 4}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}}),
+	}}
+	q := newQuerier(context.Background(), nil, 1, 10, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	var (
+		lsets   []labels.Labels
+		samples [][]sample
+	)
+	for res.Next() {
+		lsets = append(lsets, res.At().Labels())
+		samples = append(samples, expandSeries(t, res.At().Iterator()))
+	}
+	testutil.Ok(t, res.Err())
+	testutil.Equals(t, []labels.Labels{labels.FromStrings("a", "a"), labels.FromStrings("a", "b")}, lsets)
+	testutil.Equals(t, [][]sample{{{1, 1}, {2, 2}, {3, 3}, {4, 4}}, {{1, 1}}}, samples)
+
+	// Responses of the store are not modified.
+	testutil.Equals(t, 1, len(proxy.resps[0].GetSeries().Chunks))
+}
+
+// blocksStoreServer reports the blocks it queried if the request asks for it, like the store gateway does.
+type blocksStoreServer struct {
+	storeServer
+
+	blocks []string
+}
+
+func (s *blocksStoreServer) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	return &storepb.InfoResponse{MinTime: math.MinInt64, MaxTime: math.MaxInt64}, nil
+}
+
+func (s *blocksStoreServer) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	if err := s.storeServer.Series(r, srv); err != nil {
+		return err
+	}
+	if !r.ReportQueriedBlocks {
+		return nil
+	}
+	return srv.Send(storepb.NewQueriedBlocksSeriesResponse(s.blocks))
+}
+
+func TestQuerier_Stats_QueriedBlocks(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	clients := []store.Client{
+		store.NewLocalClient(&blocksStoreServer{
+			storeServer: storeServer{resps: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}}),
+			}},
+			blocks: []string{"01D2ZQ5YBN5AB5Q3ZZPDNPCNYD", "01D2ZQ5YBN5AB5Q3ZZPDNPCNYC"},
+		}, "store-1"),
+		store.NewLocalClient(&blocksStoreServer{
+			storeServer: storeServer{resps: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}}),
+			}},
+			blocks: []string{"01D2ZQ5YBN5AB5Q3ZZPDNPCNYE"},
+		}, "store-2"),
+	}
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) { return clients, nil }, nil, store.StoreLimit{})
+
+	q := newQuerier(ContextWithQueriedBlocks(context.Background()), nil, 1, 10, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	testutil.Equals(t, map[string][]string(nil), q.Stats().QueriedBlocks)
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	var lsets []labels.Labels
+	for res.Next() {
+		lsets = append(lsets, res.At().Labels())
+	}
+	testutil.Ok(t, res.Err())
+	testutil.Equals(t, []labels.Labels{labels.FromStrings("a", "a"), labels.FromStrings("a", "b")}, lsets)
+
+	testutil.Equals(t, map[string][]string{
+		"store-1": {"01D2ZQ5YBN5AB5Q3ZZPDNPCNYC", "01D2ZQ5YBN5AB5Q3ZZPDNPCNYD"},
+		"store-2": {"01D2ZQ5YBN5AB5Q3ZZPDNPCNYE"},
+	}, q.Stats().QueriedBlocks)
+
+	// Stores are not asked to report blocks unless requested.
+	q2 := newQuerier(context.Background(), nil, 1, 10, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q2.Close()) }()
+
+	res, _, err = q2.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	for res.Next() {
+	}
+	testutil.Ok(t, res.Err())
+	testutil.Equals(t, map[string][]string(nil), q2.Stats().QueriedBlocks)
+}
+
+func TestQuerier_Select_ChunkEncoding(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Store honors the preference for older data and sends raw chunks of recent data, not downsampled yet.
+	lset := labels.FromStrings("a", "1")
+	recent := storeSeriesResponse(t, lset, []sample{{300, 6}, {400, 8}})
+	aggr := avgChunk(t, []sample{{100, 2}, {200, 4}})
+	recent.GetSeries().Chunks = append([]storepb.AggrChunk{aggr}, recent.GetSeries().Chunks...)
+
+	srv := &rangeStoreServer{storeServer: storeServer{resps: []*storepb.SeriesResponse{recent}}, mint: math.MinInt64, maxt: math.MaxInt64}
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) {
+		return []store.Client{store.NewLocalClient(srv, "store")}, nil
+	}, nil, store.StoreLimit{})
+
+	q := newQuerier(ContextWithChunkEncoding(context.Background(), storepb.SeriesRequest_AGGREGATED), nil, 0, 500, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	m, err := labels.NewMatcher(labels.MatchEqual, "a", "1")
+	testutil.Ok(t, err)
+	res, _, err := q.Select(&storage.SelectParams{}, m)
+	testutil.Ok(t, err)
+
+	testutil.Assert(t, res.Next(), "expected series")
+	testutil.Equals(t, []sample{{100, 2}, {200, 4}, {300, 6}, {400, 8}}, expandSeries(t, res.At().Iterator()))
+	testutil.Assert(t, !res.Next(), "expected single series")
+	testutil.Ok(t, res.Err())
+
+	// Preference is forwarded by the proxy and survives the wire.
+	testutil.Equals(t, storepb.SeriesRequest_AGGREGATED, srv.lastReq.ChunkEncoding)
+	b, err := srv.lastReq.Marshal()
+	testutil.Ok(t, err)
+	var received storepb.SeriesRequest
+	testutil.Ok(t, received.Unmarshal(b))
+	testutil.Equals(t, storepb.SeriesRequest_AGGREGATED, received.ChunkEncoding)
+}
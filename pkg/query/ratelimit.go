@@ -0,0 +1,57 @@
+package query
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket limits rate of calls. It holds up to burst tokens, refilled at rate tokens per second, and every call
+// takes one.
+type tokenBucket struct {
+	mtx    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes a token, waiting until it is available or the context is done. Tokens are reserved in order of calls,
+// so waiting calls are not starved by later ones. It returns true if the call had to wait.
+func (b *tokenBucket) wait(ctx context.Context) (bool, error) {
+	b.mtx.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	missing := -b.tokens
+	b.mtx.Unlock()
+
+	if missing <= 0 {
+		return false, nil
+	}
+
+	t := time.NewTimer(time.Duration(missing / b.rate * float64(time.Second)))
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true, nil
+	case <-ctx.Done():
+		// Return the reserved token, so the canceled call does not delay the next ones.
+		b.mtx.Lock()
+		b.tokens++
+		b.mtx.Unlock()
+		return true, ctx.Err()
+	}
+}
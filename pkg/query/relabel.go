@@ -0,0 +1,41 @@
+package query
+
+import (
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// relabeledSeriesSet applies relabel rules to labels of series of the wrapped set, dropping series the rules drop.
+// Rules are applied to merged and deduplicated series, so they affect only how series are presented, never which
+// series are deduplicated together.
+type relabeledSeriesSet struct {
+	set  storage.SeriesSet
+	cfgs []*relabel.Config
+
+	cur storage.Series
+}
+
+func newRelabeledSeriesSet(set storage.SeriesSet, cfgs []*relabel.Config) *relabeledSeriesSet {
+	return &relabeledSeriesSet{set: set, cfgs: cfgs}
+}
+
+func (s *relabeledSeriesSet) Next() bool {
+	for s.set.Next() {
+		series := s.set.At()
+		lset := relabel.Process(series.Labels(), s.cfgs...)
+		if lset == nil {
+			continue
+		}
+		if cs, ok := series.(ChunkSeries); ok {
+			s.cur = chunkSeriesWithLabels{ChunkSeries: cs, lset: lset}
+			return true
+		}
+		s.cur = seriesWithLabels{Series: series, lset: lset}
+		return true
+	}
+	return false
+}
+
+func (s *relabeledSeriesSet) At() storage.Series { return s.cur }
+
+func (s *relabeledSeriesSet) Err() error { return s.set.Err() }
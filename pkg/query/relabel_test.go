@@ -0,0 +1,49 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/storage"
+)
+
+func TestQuerier_Select_Relabel(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "A"), []sample{{10000, 1}, {20000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "B"), []sample{{10000, 1}, {20000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "A"), []sample{{10000, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "3", "replica", "A"), []sample{{10000, 3}}),
+	}}
+
+	// Rename label a to b and drop series with a=3.
+	cfgs := []*relabel.Config{
+		{Action: relabel.Drop, SourceLabels: model.LabelNames{"a"}, Regex: relabel.MustNewRegexp("3"), Separator: ";"},
+		{Action: relabel.Replace, SourceLabels: model.LabelNames{"a"}, Regex: relabel.MustNewRegexp("(.*)"), Separator: ";", TargetLabel: "b", Replacement: "$1"},
+		{Action: relabel.LabelDrop, Regex: relabel.MustNewRegexp("a")},
+	}
+
+	q := newQuerier(context.Background(), nil, 1, 100000, "replica", proxy, true, 0, true, nil, QuerierOpts{RelabelConfigs: cfgs})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	// Replicas are deduplicated by their original labels before the rename.
+	testutil.Assert(t, res.Next(), "expected series")
+	testutil.Equals(t, labels.FromStrings("b", "1"), res.At().Labels())
+	testutil.Equals(t, []sample{{10000, 1}, {20000, 1}}, expandSeries(t, res.At().Iterator()))
+	testutil.Assert(t, res.Next(), "expected series")
+	testutil.Equals(t, labels.FromStrings("b", "2"), res.At().Labels())
+	testutil.Equals(t, []sample{{10000, 2}}, expandSeries(t, res.At().Iterator()))
+	testutil.Assert(t, !res.Next(), "expected no more series")
+	testutil.Ok(t, res.Err())
+}
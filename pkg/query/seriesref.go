@@ -0,0 +1,107 @@
+package query
+
+import (
+	"encoding/base64"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/tracing"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// SeriesRef is an opaque reference of a series returned by Select, which fetches the series again with SeriesByRef.
+// It is URL safe, so drill-down UIs can pass it around in links.
+type SeriesRef string
+
+// RefSeries is implemented by series returned by Select that can be fetched again by their reference. Series merged
+// from multiple replicas by deduplication do not implement it.
+type RefSeries interface {
+	storage.Series
+	Ref() SeriesRef
+}
+
+// newSeriesRef returns reference of the series with the given labels. It holds just the labels, as stores need no
+// other identity of the series. It is computed only when asked for, so it costs nothing to series never referenced.
+func newSeriesRef(lset []storepb.Label) (SeriesRef, error) {
+	b, err := (&storepb.Series{Labels: lset}).Marshal()
+	if err != nil {
+		return "", errors.Wrap(err, "marshal series ref")
+	}
+	return SeriesRef(base64.RawURLEncoding.EncodeToString(b)), nil
+}
+
+// parseSeriesRef returns labels of the referenced series.
+func parseSeriesRef(ref SeriesRef) ([]storepb.Label, error) {
+	b, err := base64.RawURLEncoding.DecodeString(string(ref))
+	if err != nil {
+		return nil, errors.Wrap(err, "decode series ref")
+	}
+	var s storepb.Series
+	if err := s.Unmarshal(b); err != nil {
+		return nil, errors.Wrap(err, "unmarshal series ref")
+	}
+	if len(s.Labels) == 0 || len(s.Chunks) > 0 {
+		return nil, errors.New("invalid series ref")
+	}
+	return s.Labels, nil
+}
+
+// Ref implements RefSeries.
+func (s *chunkSeries) Ref() SeriesRef {
+	// Labels are already validated, so the reference can't fail to encode.
+	ref, _ := newSeriesRef(storepb.PromLabelsToLabels(s.lset))
+	return ref
+}
+
+// SeriesByRef fetches raw data of the referenced series within the querier time range, without matching series by
+// the selector of the query that returned it. Only stores with external labels of the series are asked for it and
+// stores supporting shards return only that series. It returns nil if the series has no data within the range.
+func (q *querier) SeriesByRef(ref SeriesRef) (storage.Series, error) {
+	if q.rangeErr != nil {
+		return nil, q.rangeErr
+	}
+	lset, err := parseSeriesRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	if q.expired {
+		return nil, nil
+	}
+
+	span, ctx := tracing.StartSpan(q.ctx, "querier_series_by_ref")
+	defer span.Finish()
+
+	ms := make([]storepb.LabelMatcher, 0, len(lset))
+	for _, l := range lset {
+		ms = append(ms, storepb.LabelMatcher{Type: storepb.LabelMatcher_EQ, Name: l.Name, Value: l.Value})
+	}
+	queryAggrs, resAggr := aggrsFromFunc("")
+
+	resp := &seriesServer{ctx: ctx, partialResponse: q.partialResponse, duplicateLabels: q.duplicateLabels}
+	if err := q.proxy.Series(&storepb.SeriesRequest{
+		MinTime:                 q.mint,
+		MaxTime:                 q.maxt,
+		Matchers:                ms,
+		MaxResolutionWindow:     q.maxSourceResolution,
+		Aggregates:              queryAggrs,
+		PartialResponseDisabled: !q.partialResponse,
+		Shard:                   storepb.SeriesRefShard(storepb.LabelsHash(lset)),
+	}, resp); err != nil {
+		return nil, errors.Wrap(err, "proxy Series()")
+	}
+	for _, w := range resp.warnings {
+		q.warningReporter(errors.New(w))
+	}
+
+	// Equality matchers also select series with more labels.
+	for _, s := range resp.seriesSet {
+		if storepb.CompareLabels(s.Labels, lset) != 0 {
+			continue
+		}
+		series := newChunkSeries(s.Labels, s.Chunks, q.mint, q.maxt, resAggr)
+		series.ctx, series.decodePool, series.parallelDecode = q.ctx, q.decodePool, q.parallelDecode
+		series.lazy, series.filter, series.duplicateSamples = q.chunkRefs, q.sampleFilter, q.duplicateSamples
+		return series, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,68 @@
+package query
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+func TestQuerier_SeriesByRef(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// The store does not support shards, so it returns all series for every request.
+	srv := &rangeStoreServer{
+		storeServer: storeServer{resps: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "1", "b", "x"), []sample{{1, 1}, {2, 2}}),
+			storeSeriesResponse(t, labels.FromStrings("a", "1", "b", "x", "c", "y"), []sample{{1, 3}}),
+			storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{1, 4}}),
+		}},
+		mint: math.MinInt64,
+		maxt: math.MaxInt64,
+	}
+	clients := []store.Client{store.NewLocalClient(srv, "store")}
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) { return clients, nil }, nil, store.StoreLimit{})
+
+	q := newQuerier(context.Background(), nil, 1, 10, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, res.Next(), "expected series")
+	first := res.At().(RefSeries)
+	testutil.Equals(t, labels.FromStrings("a", "1", "b", "x"), first.Labels())
+	ref := first.Ref()
+
+	// Fetch the series in a later query by its reference only.
+	q2 := newQuerier(context.Background(), nil, 1, 10, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q2.Close()) }()
+
+	s, err := q2.SeriesByRef(ref)
+	testutil.Ok(t, err)
+	testutil.Equals(t, labels.FromStrings("a", "1", "b", "x"), s.Labels())
+	testutil.Equals(t, []sample{{1, 1}, {2, 2}}, expandSeries(t, s.Iterator()))
+
+	lset := []storepb.Label{{Name: "a", Value: "1"}, {Name: "b", Value: "x"}}
+	testutil.Equals(t, []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
+		{Type: storepb.LabelMatcher_EQ, Name: "b", Value: "x"},
+	}, srv.lastReq.Matchers)
+	testutil.Equals(t, storepb.SeriesRefShard(storepb.LabelsHash(lset)), srv.lastReq.Shard)
+
+	// Reference of a series without data within the querier time range.
+	missing, err := newSeriesRef([]storepb.Label{{Name: "a", Value: "3"}})
+	testutil.Ok(t, err)
+	s, err = q2.SeriesByRef(missing)
+	testutil.Ok(t, err)
+	testutil.Assert(t, s == nil, "expected no series")
+
+	_, err = q2.SeriesByRef("invalid")
+	testutil.NotOk(t, err)
+}
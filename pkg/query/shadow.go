@@ -0,0 +1,206 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// ShadowDedup validates a deduplication strategy against the one used for queries before rolling it out. Queriers
+// with it deduplicate the data of a sample of Selects also with the shadow strategy and compare the results, logging
+// and counting divergences. Comparisons run in the background, one at a time, so they never delay queries. Selects
+// sampled while a comparison is running are skipped. Returned results are never affected.
+type ShadowDedup struct {
+	logger log.Logger
+	// newSet deduplicates the given set along the replica label with the shadow strategy.
+	newSet func(set storage.SeriesSet, replicaLabel string, smoothing float64, lookback int64, priority []string) storage.SeriesSet
+	// Strategy of the shadow deduplication. Selects deduplicated with it are not compared.
+	strategy DedupStrategy
+	// sample returns true for Selects to compare.
+	sample func() bool
+
+	// running holds a token while a comparison runs. wg tracks running comparisons.
+	running chan struct{}
+	wg      sync.WaitGroup
+
+	comparisons prometheus.Counter
+	divergences prometheus.Counter
+	failures    prometheus.Counter
+	skipped     prometheus.Counter
+}
+
+// NewShadowDedup returns ShadowDedup with the given strategy comparing the given fraction of Selects, with metrics
+// registered in the given registerer.
+func NewShadowDedup(logger log.Logger, reg prometheus.Registerer, strategy DedupStrategy, sampleRate float64) *ShadowDedup {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	d := &ShadowDedup{
+		logger: log.With(logger, "component", "shadow-dedup"),
+		newSet: func(set storage.SeriesSet, replicaLabel string, smoothing float64, lookback int64, priority []string) storage.SeriesSet {
+			return newDedupSeriesSet(set, replicaLabel, strategy, smoothing, lookback, &dedupStats{}, priority)
+		},
+		strategy: strategy,
+		sample:   func() bool { return rand.Float64() < sampleRate },
+		running:  make(chan struct{}, 1),
+		comparisons: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_shadow_dedup_comparisons_total",
+			Help: "Total number of deduplicated selects compared with the shadow deduplication strategy.",
+		}),
+		divergences: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_shadow_dedup_divergences_total",
+			Help: "Total number of deduplicated selects whose result differed from the one of the shadow deduplication strategy.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_shadow_dedup_failures_total",
+			Help: "Total number of deduplicated selects that could not be compared with the shadow deduplication strategy.",
+		}),
+		skipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_shadow_dedup_skipped_total",
+			Help: "Total number of sampled deduplicated selects not compared with the shadow deduplication strategy, as another comparison was running.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(d.comparisons, d.divergences, d.failures, d.skipped)
+	}
+	return d
+}
+
+// compareAsync runs the given comparison in the background if no other comparison is running.
+func (d *ShadowDedup) compareAsync(compare func()) {
+	select {
+	case d.running <- struct{}{}:
+	default:
+		d.skipped.Inc()
+		return
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer func() { <-d.running }()
+		compare()
+	}()
+}
+
+// compare compares series of the primary and shadow deduplicated sets. It counts the select as divergent if any series
+// is missing in either set or has different samples, and logs the first divergence.
+func (d *ShadowDedup) compare(primary, shadow storage.SeriesSet) {
+	err := compareSeriesSets(primary, shadow)
+	switch errors.Cause(err).(type) {
+	case nil:
+		d.comparisons.Inc()
+	case seriesDivergence:
+		d.comparisons.Inc()
+		d.divergences.Inc()
+		level.Warn(d.logger).Log("msg", "deduplicated series differ from shadow deduplication", "strategy", d.strategy, "err", err)
+	default:
+		d.failures.Inc()
+		level.Debug(d.logger).Log("msg", "shadow deduplication comparison failed", "err", err)
+	}
+}
+
+// seriesDivergence is an error describing difference between the primary and shadow sets.
+type seriesDivergence string
+
+func (e seriesDivergence) Error() string { return string(e) }
+
+func compareSeriesSets(primary, shadow storage.SeriesSet) error {
+	for {
+		pok, sok := primary.Next(), shadow.Next()
+		if err := primary.Err(); err != nil {
+			return errors.Wrap(err, "primary")
+		}
+		if err := shadow.Err(); err != nil {
+			return errors.Wrap(err, "shadow")
+		}
+		switch {
+		case !pok && !sok:
+			return nil
+		case !sok:
+			return seriesDivergence(fmt.Sprintf("series %s missing in shadow", primary.At().Labels()))
+		case !pok:
+			return seriesDivergence(fmt.Sprintf("series %s missing in primary", shadow.At().Labels()))
+		}
+		p, s := primary.At(), shadow.At()
+		if c := labels.Compare(p.Labels(), s.Labels()); c != 0 {
+			if c < 0 {
+				return seriesDivergence(fmt.Sprintf("series %s missing in shadow", p.Labels()))
+			}
+			return seriesDivergence(fmt.Sprintf("series %s missing in primary", s.Labels()))
+		}
+		if err := compareSeries(p.Iterator(), s.Iterator()); err != nil {
+			if d, ok := err.(seriesDivergence); ok {
+				return seriesDivergence(fmt.Sprintf("series %s: %s", p.Labels(), d))
+			}
+			return errors.Wrapf(err, "series %s", p.Labels())
+		}
+	}
+}
+
+func compareSeries(primary, shadow storage.SeriesIterator) error {
+	for {
+		pok, sok := primary.Next(), shadow.Next()
+		if err := primary.Err(); err != nil {
+			return errors.Wrap(err, "primary")
+		}
+		if err := shadow.Err(); err != nil {
+			return errors.Wrap(err, "shadow")
+		}
+		switch {
+		case !pok && !sok:
+			return nil
+		case !sok:
+			t, _ := primary.At()
+			return seriesDivergence(fmt.Sprintf("sample at %d missing in shadow", t))
+		case !pok:
+			t, _ := shadow.At()
+			return seriesDivergence(fmt.Sprintf("sample at %d missing in primary", t))
+		}
+		pt, pv := primary.At()
+		st, sv := shadow.At()
+		if pt != st {
+			return seriesDivergence(fmt.Sprintf("sample at %d in primary, at %d in shadow", pt, st))
+		}
+		if pv != sv && !(math.IsNaN(pv) && math.IsNaN(sv)) {
+			return seriesDivergence(fmt.Sprintf("sample at %d has value %v in primary, %v in shadow", pt, pv, sv))
+		}
+	}
+}
+
+// compareShadowDedup deduplicates the given series with both the querier and the shadow strategy and compares the
+// results in the background, if the Select is sampled. Chunks are decoded without the decode pool, so comparisons do
+// not hold back decodes of queries.
+func (q *querier) compareShadowDedup(set promSeriesSet, series []storepb.Series, smoothing float64) {
+	if !q.shadowDedup.sample() {
+		return
+	}
+	set.decodePool = nil
+	// Building series sorts their chunks in place, so the comparison works on its own copy of the chunk slices. Chunk
+	// data is only read.
+	series = append([]storepb.Series(nil), series...)
+	for i := range series {
+		series[i].Chunks = append([]storepb.AggrChunk(nil), series[i].Chunks...)
+	}
+
+	var (
+		strategy, replicaLabel = q.dedupStrategy, q.replicaLabel
+		lookback, priority     = q.lookbackDelta, q.replicaPriority
+	)
+	q.shadowDedup.compareAsync(func() {
+		primary, shadow := set, set
+		primary.set, shadow.set = newStoreSeriesSet(series), newStoreSeriesSet(series)
+		q.shadowDedup.compare(
+			newDedupSeriesSet(primary, replicaLabel, strategy, smoothing, lookback, &dedupStats{}, priority),
+			q.shadowDedup.newSet(shadow, replicaLabel, smoothing, lookback, priority),
+		)
+	})
+}
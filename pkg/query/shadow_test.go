@@ -0,0 +1,130 @@
+package query
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// droppingSeriesSet drops series with the given labels from the wrapped set.
+type droppingSeriesSet struct {
+	storage.SeriesSet
+	drop labels.Labels
+}
+
+func (s droppingSeriesSet) Next() bool {
+	for s.SeriesSet.Next() {
+		if !labels.Equal(s.At().Labels(), s.drop) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestQuerier_Select_ShadowDedup(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "a"), []sample{{10000, 1}, {20000, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "b"), []sample{{10000, 1}, {20000, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "a"), []sample{{10000, 3}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "b"), []sample{{10000, 3}}),
+	}}
+	exp := []struct {
+		lset    labels.Labels
+		samples []sample
+	}{
+		{lset: labels.FromStrings("a", "1"), samples: []sample{{10000, 1}, {20000, 2}}},
+		{lset: labels.FromStrings("a", "2"), samples: []sample{{10000, 3}}},
+	}
+
+	for _, tcase := range []struct {
+		name string
+		ctx  context.Context
+		// Optional divergence injected into the shadow deduplication.
+		drop labels.Labels
+
+		expComparisons, expDivergences float64
+	}{
+		{name: "same results", ctx: context.Background(), expComparisons: 1},
+		{name: "divergence", ctx: context.Background(), drop: labels.FromStrings("a", "2"), expComparisons: 1, expDivergences: 1},
+		{name: "same strategy", ctx: ContextWithDedupStrategy(context.Background(), DedupFreshest), drop: labels.FromStrings("a", "2")},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			shadow := NewShadowDedup(log.NewLogfmtLogger(&logs), nil, DedupFreshest, 1)
+			if tcase.drop != nil {
+				newSet := shadow.newSet
+				shadow.newSet = func(set storage.SeriesSet, replicaLabel string, smoothing float64, lookback int64, priority []string) storage.SeriesSet {
+					return droppingSeriesSet{SeriesSet: newSet(set, replicaLabel, smoothing, lookback, priority), drop: tcase.drop}
+				}
+			}
+
+			q := newQuerier(tcase.ctx, nil, 1, 100000, "replica", proxy, true, 0, true, nil, QuerierOpts{ShadowDedup: shadow})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{})
+			testutil.Ok(t, err)
+
+			// Result of the querier strategy is returned regardless of the shadow one.
+			for _, s := range exp {
+				testutil.Assert(t, res.Next(), "expected series %s", s.lset)
+				got := res.At()
+				testutil.Equals(t, s.lset, got.Labels())
+				testutil.Equals(t, s.samples, expandSeries(t, got.Iterator()))
+			}
+			testutil.Assert(t, !res.Next(), "expected no more series")
+			testutil.Ok(t, res.Err())
+
+			// Comparison runs in the background.
+			shadow.wg.Wait()
+			testutil.Equals(t, tcase.expComparisons, promtestutil.ToFloat64(shadow.comparisons))
+			testutil.Equals(t, tcase.expDivergences, promtestutil.ToFloat64(shadow.divergences))
+			testutil.Equals(t, 0.0, promtestutil.ToFloat64(shadow.failures))
+			testutil.Equals(t, tcase.expDivergences > 0, strings.Contains(logs.String(), `series {a=\"2\"} missing in shadow`))
+		})
+	}
+}
+
+func TestShadowDedup_Sampling(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "a"), []sample{{10000, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "b"), []sample{{10000, 1}}),
+	}}
+	selectAll := func(shadow *ShadowDedup) {
+		q := newQuerier(context.Background(), nil, 1, 100000, "replica", proxy, true, 0, true, nil, QuerierOpts{ShadowDedup: shadow})
+		defer func() { testutil.Ok(t, q.Close()) }()
+
+		_, _, err := q.Select(&storage.SelectParams{})
+		testutil.Ok(t, err)
+	}
+
+	// Selects not sampled are not compared.
+	shadow := NewShadowDedup(nil, nil, DedupFreshest, 0)
+	selectAll(shadow)
+	shadow.wg.Wait()
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(shadow.comparisons))
+
+	// Sampled Selects are skipped while another comparison runs.
+	shadow = NewShadowDedup(nil, nil, DedupFreshest, 1)
+	shadow.running <- struct{}{}
+	selectAll(shadow)
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(shadow.skipped))
+	<-shadow.running
+
+	selectAll(shadow)
+	shadow.wg.Wait()
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(shadow.comparisons))
+}
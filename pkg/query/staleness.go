@@ -0,0 +1,75 @@
+package query
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+)
+
+// limitStaleness flags series whose freshest sample is older than maxStaleness before the end of the querier time
+// range, or before now if it ends in the future, and handles them according to the stale series policy. With
+// deduplication, replicas of a series are stale only if all of them are, and they are flagged as a single series. The
+// freshest sample of a series is known from its chunks, so none are decoded.
+func (q *querier) limitStaleness(series []storepb.Series, flagged *flaggedSeries) []storepb.Series {
+	maxt := q.maxt
+	if now := timestamp.FromTime(time.Now()); maxt > now {
+		maxt = now
+	}
+	bound := maxt - int64(q.maxStaleness/time.Millisecond)
+
+	// Freshest sample of each series, of all its replicas with deduplication.
+	freshest := make(map[string]int64, len(series))
+	keys := make([]string, 0, len(series))
+	for _, s := range series {
+		key := q.stalenessKey(s.Labels)
+		keys = append(keys, key)
+
+		t, ok := freshest[key]
+		if !ok {
+			t = math.MinInt64
+		}
+		for _, c := range s.Chunks {
+			if c.MaxTime > t {
+				t = c.MaxTime
+			}
+		}
+		freshest[key] = t
+	}
+
+	res := series[:0]
+	flaggedKeys := map[string]struct{}{}
+	for i, s := range series {
+		key := keys[i]
+		if freshest[key] >= bound {
+			res = append(res, s)
+			continue
+		}
+		if _, ok := flaggedKeys[key]; !ok {
+			flaggedKeys[key] = struct{}{}
+			flagged.addKey(key)
+		}
+		if q.staleSeries != SeriesPolicySkip {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// stalenessKey returns labels identifying the series for staleness, without the replica label if deduplication is
+// enabled.
+func (q *querier) stalenessKey(lset []storepb.Label) string {
+	if !q.isDedupEnabled() {
+		return storepb.LabelsToPromLabels(lset).String()
+	}
+	res := make([]storepb.Label, 0, len(lset))
+	for _, l := range lset {
+		if l.Name == q.replicaLabel || q.replicaIgnoreCase && strings.EqualFold(l.Name, q.replicaLabel) {
+			continue
+		}
+		res = append(res, l)
+	}
+	return storepb.LabelsToPromLabels(res).String()
+}
@@ -0,0 +1,316 @@
+package query
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+)
+
+// Stats holds statistics of the series selected by a querier.
+type Stats struct {
+	// Dedup holds sample contribution of replicas for each series that was deduplicated from more than one replica.
+	// It is recorded only by queriers created with ContextWithDedupStats.
+	Dedup []SeriesDedupStats
+	// Transfer holds number of bytes received from stores. Bytes on the wire and of messages are reported only if store
+	// connections use StoreTransferStats.
+	Transfer TransferStats
+	// QueriedBlocks maps stores to sorted IDs of blocks they queried. Only stores reporting queried blocks, like
+	// the store gateway, are included. It helps to correlate slow queries with specific blocks. It is recorded only by
+	// queriers created with ContextWithQueriedBlocks.
+	QueriedBlocks map[string][]string
+	// StoreOutcomes maps names of stores to their outcome in selects of the querier: whether they were skipped,
+	// failed or which number of series they returned. It is reported only for selects fetching from a store.ProxyStore
+	// and it explains empty results.
+	StoreOutcomes map[string]store.StoreOutcome
+}
+
+// TransferStats holds number of bytes of store responses received by a querier.
+type TransferStats struct {
+	// WireBytes is the number of bytes received on the wire, so compressed if compression is used.
+	WireBytes int64
+	// Bytes is the number of bytes of uncompressed messages.
+	Bytes int64
+	// ChunkBytes is the number of bytes of chunks of selected series, as received from stores. It is reported for all
+	// store connections.
+	ChunkBytes int64
+}
+
+// SeriesDedupStats describes how many samples each replica contributed to the deduplicated series.
+// It helps to tune the deduplication and identify chronically lagging replicas.
+type SeriesDedupStats struct {
+	// Labels of the deduplicated series, without the replica label.
+	Labels labels.Labels
+	// ReplicaSamples maps replica label value to the number of samples of the merged series it contributed.
+	ReplicaSamples map[string]int64
+	// RescuedSamples is the number of samples taken from another replica because the one followed until then had a
+	// gap. It quantifies how much data deduplication of HA replicas saved.
+	RescuedSamples int64
+}
+
+// DedupMetrics holds deduplication metrics of all queriers of the query node.
+type DedupMetrics struct {
+	rescuedSamples      prometheus.Counter
+	missingReplicaLabel prometheus.Counter
+}
+
+// NewDedupMetrics returns DedupMetrics registered in the given registerer.
+func NewDedupMetrics(reg prometheus.Registerer) *DedupMetrics {
+	m := &DedupMetrics{
+		rescuedSamples: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_dedup_rescued_samples_total",
+			Help: "Total number of deduplicated samples taken from another replica because the one followed until then had a gap.",
+		}),
+		missingReplicaLabel: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_dedup_missing_replica_label_total",
+			Help: "Total number of deduplicated selects returning series none of which had the replica label, which usually means the replica label is misconfigured.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(m.rescuedSamples, m.missingReplicaLabel)
+	}
+	return m
+}
+
+type dedupStatsKey struct{}
+
+// ContextWithDedupStats returns a new context.Context that makes queriers created with it record per replica sample
+// contribution of deduplicated series, reported by Stats. Every returned sample is counted, so it is meant for tuning
+// deduplication rather than for every query.
+func ContextWithDedupStats(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dedupStatsKey{}, true)
+}
+
+func dedupStatsFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(dedupStatsKey{}).(bool)
+	return v
+}
+
+// dedupStats collects per replica sample counts of deduplicated series. It is safe to use concurrently.
+type dedupStats struct {
+	// Optional.
+	metrics *DedupMetrics
+	// perSeries enables per replica sample counts of each series. Otherwise only metrics are recorded.
+	perSeries bool
+
+	mtx    sync.Mutex
+	series []*seriesDedupCounters
+}
+
+type seriesDedupCounters struct {
+	lset     labels.Labels
+	replicas []string
+	metrics  *DedupMetrics
+	// Samples contributed by each replica and rescued ones. Updated atomically.
+	samples []int64
+	rescued int64
+}
+
+// newSeries returns counters of the given deduplicated series, or nil if per series counts are not recorded.
+func (s *dedupStats) newSeries(lset labels.Labels, replicas func() []string) *seriesDedupCounters {
+	if !s.perSeries {
+		return nil
+	}
+	names := replicas()
+	c := &seriesDedupCounters{lset: lset, replicas: names, metrics: s.metrics, samples: make([]int64, len(names))}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.series = append(s.series, c)
+	return c
+}
+
+func (c *seriesDedupCounters) inc(replica int) {
+	if replica < 0 || replica >= len(c.samples) {
+		return
+	}
+	atomic.AddInt64(&c.samples[replica], 1)
+}
+
+func (c *seriesDedupCounters) incRescued() {
+	atomic.AddInt64(&c.rescued, 1)
+	if c.metrics != nil {
+		c.metrics.rescuedSamples.Inc()
+	}
+}
+
+// rescuedSamples returns the counter of rescued samples of all queriers, or nil if metrics are not recorded.
+func (s *dedupStats) rescuedSamples() prometheus.Counter {
+	if s.metrics == nil {
+		return nil
+	}
+	return s.metrics.rescuedSamples
+}
+
+// incMissingReplicaLabel counts a select whose series have no replica label.
+func (s *dedupStats) incMissingReplicaLabel() {
+	if s.metrics != nil {
+		s.metrics.missingReplicaLabel.Inc()
+	}
+}
+
+// get returns snapshot of statistics sorted by series labels. Counters of the same series iterated multiple times
+// are summed.
+func (s *dedupStats) get() []SeriesDedupStats {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var (
+		res   []SeriesDedupStats
+		index = map[string]int{}
+	)
+	for _, c := range s.series {
+		key := c.lset.String()
+		i, ok := index[key]
+		if !ok {
+			i = len(res)
+			index[key] = i
+			res = append(res, SeriesDedupStats{Labels: c.lset, ReplicaSamples: map[string]int64{}})
+		}
+		for r, name := range c.replicas {
+			res[i].ReplicaSamples[name] += atomic.LoadInt64(&c.samples[r])
+		}
+		res[i].RescuedSamples += atomic.LoadInt64(&c.rescued)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return labels.Compare(res[i].Labels, res[j].Labels) < 0
+	})
+	return res
+}
+
+// replicaTracker is implemented by iterators that know which replica the current sample comes from.
+type replicaTracker interface {
+	currentReplica() int
+}
+
+// replicaSeriesIterator marks samples of the wrapped iterator as coming from the given replica.
+type replicaSeriesIterator struct {
+	storage.SeriesIterator
+	replica int
+}
+
+func (it replicaSeriesIterator) currentReplica() int { return it.replica }
+
+type queriedBlocksKey struct{}
+
+// ContextWithQueriedBlocks returns a new context.Context that makes queriers created with it ask stores to report
+// blocks they queried, reported by Stats.
+func ContextWithQueriedBlocks(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queriedBlocksKey{}, true)
+}
+
+func queriedBlocksFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(queriedBlocksKey{}).(bool)
+	return v
+}
+
+// queriedBlocks collects blocks queried by stores for a single querier. It is safe to use concurrently.
+type queriedBlocks struct {
+	mtx     sync.Mutex
+	byStore map[string]map[string]struct{}
+}
+
+func (b *queriedBlocks) add(qbs []storepb.QueriedBlocks) {
+	if len(qbs) == 0 {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.byStore == nil {
+		b.byStore = map[string]map[string]struct{}{}
+	}
+	for _, qb := range qbs {
+		ids, ok := b.byStore[qb.Store]
+		if !ok {
+			ids = map[string]struct{}{}
+			b.byStore[qb.Store] = ids
+		}
+		for _, id := range qb.Ids {
+			ids[id] = struct{}{}
+		}
+	}
+}
+
+// get returns snapshot of queried blocks or nil if no store reported any.
+func (b *queriedBlocks) get() map[string][]string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if len(b.byStore) == 0 {
+		return nil
+	}
+	res := make(map[string][]string, len(b.byStore))
+	for store, ids := range b.byStore {
+		for id := range ids {
+			res[store] = append(res[store], id)
+		}
+		sort.Strings(res[store])
+	}
+	return res
+}
+
+// transferStats collects bytes received by a single querier. It is safe to use concurrently.
+type transferStats struct {
+	wireBytes, bytes, chunkBytes int64
+}
+
+func (s *transferStats) get() TransferStats {
+	return TransferStats{
+		WireBytes:  atomic.LoadInt64(&s.wireBytes),
+		Bytes:      atomic.LoadInt64(&s.bytes),
+		ChunkBytes: atomic.LoadInt64(&s.chunkBytes),
+	}
+}
+
+type transferStatsKey struct{}
+
+func contextWithTransferStats(ctx context.Context, s *transferStats) context.Context {
+	return context.WithValue(ctx, transferStatsKey{}, s)
+}
+
+// StoreTransferStats accounts bytes of messages received from stores to Stats of the querier that requested them.
+// Both bytes on the wire and of decoded messages are counted, which helps to evaluate compression of store responses.
+type StoreTransferStats struct{}
+
+// NewStoreTransferStats returns new StoreTransferStats.
+func NewStoreTransferStats() *StoreTransferStats {
+	return &StoreTransferStats{}
+}
+
+// DialOptions returns dial options for the StoreSet that install accounting of received bytes. Only one stats handler
+// can be installed per connection.
+func (s *StoreTransferStats) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{grpc.WithStatsHandler(s)}
+}
+
+func (s *StoreTransferStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (s *StoreTransferStats) HandleRPC(ctx context.Context, rs stats.RPCStats) {
+	p, ok := rs.(*stats.InPayload)
+	if !ok || !p.IsClient() {
+		return
+	}
+	t, ok := ctx.Value(transferStatsKey{}).(*transferStats)
+	if !ok {
+		return
+	}
+	atomic.AddInt64(&t.wireBytes, int64(p.WireLength))
+	atomic.AddInt64(&t.bytes, int64(p.Length))
+}
+
+func (s *StoreTransferStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (s *StoreTransferStats) HandleConn(context.Context, stats.ConnStats) {}
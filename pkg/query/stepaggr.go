@@ -0,0 +1,170 @@
+package query
+
+import (
+	"context"
+	"math"
+
+	"github.com/prometheus/prometheus/storage"
+)
+
+type stepDownsamplingKey struct{}
+
+// ContextWithStepDownsampling returns a new context.Context that makes queriers created with it downsample raw
+// series on the fly to the step of range queries. Samples within each step are aggregated into a single one, so much
+// fewer samples reach PromQL for long ranges without pre-downsampled blocks. It is meant for dashboards, as results
+// are approximate. Samples are averaged for plain selections and functions without a dedicated aggregate. Minimum
+// and maximum are used for min_* and max_* functions. For counter functions like rate, the first and the last value of
+// the counter adjusted for resets are kept, so rates over ranges of whole steps, e.g. rate(x[1m]) with step 1m, are
+// exact. Selections wrapped in count_* and sum_* functions and series made of downsampled chunks are never downsampled.
+func ContextWithStepDownsampling(ctx context.Context) context.Context {
+	return context.WithValue(ctx, stepDownsamplingKey{}, true)
+}
+
+func stepDownsamplingFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(stepDownsamplingKey{}).(bool)
+	return v
+}
+
+// stepBuckets divides time into buckets of step length ending at end, like range query evaluation steps do.
+// Zero value disables downsampling.
+type stepBuckets struct {
+	step, end int64
+}
+
+// newStepBuckets returns stepBuckets for selection with the given parameters if it can be downsampled. If step of the
+// query is known, buckets are aligned to its multiples. Otherwise they end at the end of the selection.
+func newStepBuckets(params *storage.SelectParams, aggr resAggr, queryStep int64) stepBuckets {
+	if params == nil {
+		return stepBuckets{}
+	}
+	b := stepBuckets{step: params.Step, end: params.End}
+	if queryStep > 0 {
+		b = stepBuckets{step: queryStep, end: alignUp(params.End, queryStep)}
+	}
+	if b.step <= 0 {
+		return stepBuckets{}
+	}
+	switch aggr {
+	case resAggrAvg, resAggrMin, resAggrMax, resAggrCounter:
+		return b
+	}
+	return stepBuckets{}
+}
+
+// alignUp returns the smallest multiple of step not before t.
+func alignUp(t, step int64) int64 {
+	r := t % step
+	switch {
+	case r > 0:
+		return t + step - r
+	case r < 0:
+		return t - r
+	}
+	return t
+}
+
+func (b stepBuckets) enabled() bool { return b.step > 0 }
+
+// bucket returns index of the bucket holding t. Bucket k spans (end-(k+1)*step, end-k*step].
+func (b stepBuckets) bucket(t int64) int64 {
+	if d := b.end - t; d >= 0 {
+		return d / b.step
+	}
+	return -((t - b.end - 1) / b.step) - 1
+}
+
+// stepAggrIterator aggregates samples of the wrapped iterator within each step bucket into a single sample with
+// timestamp of the last sample in the bucket. Counters keep both the first and the last sample of each bucket instead,
+// as rate needs two samples within its range.
+type stepAggrIterator struct {
+	it      storage.SeriesIterator
+	buckets stepBuckets
+	aggr    resAggr
+
+	// True if the wrapped iterator holds a sample that was not aggregated yet.
+	pending bool
+	started bool
+	valid   bool
+	t       int64
+	v       float64
+
+	// Last sample of the current bucket of a counter, returned after its first one.
+	hasLast bool
+	lastT   int64
+	lastV   float64
+}
+
+func newStepAggrIterator(it storage.SeriesIterator, buckets stepBuckets, aggr resAggr) *stepAggrIterator {
+	return &stepAggrIterator{it: it, buckets: buckets, aggr: aggr}
+}
+
+func (it *stepAggrIterator) Next() bool {
+	if !it.started {
+		it.started = true
+		it.pending = it.it.Next()
+	}
+	if it.hasLast {
+		it.t, it.v, it.hasLast = it.lastT, it.lastV, false
+		return true
+	}
+	if !it.pending {
+		it.valid = false
+		return false
+	}
+
+	t, v := it.it.At()
+	var (
+		b        = it.buckets.bucket(t)
+		n        = 1
+		sum      = v
+		min, max = v, v
+		firstT   = t
+		firstV   = v
+	)
+	for {
+		it.t, it.v = t, v
+		if it.pending = it.it.Next(); !it.pending {
+			break
+		}
+		t, v = it.it.At()
+		if it.buckets.bucket(t) != b {
+			break
+		}
+		n++
+		sum += v
+		min, max = math.Min(min, v), math.Max(max, v)
+	}
+
+	switch it.aggr {
+	case resAggrMin:
+		it.v = min
+	case resAggrMax:
+		it.v = max
+	case resAggrCounter:
+		if n > 1 {
+			it.lastT, it.lastV, it.hasLast = it.t, it.v, true
+			it.t, it.v = firstT, firstV
+		}
+	default:
+		it.v = sum / float64(n)
+	}
+	it.valid = true
+	return true
+}
+
+func (it *stepAggrIterator) Seek(t int64) bool {
+	for !it.valid || it.t < t {
+		if !it.Next() {
+			return false
+		}
+	}
+	return true
+}
+
+func (it *stepAggrIterator) At() (int64, float64) {
+	return it.t, it.v
+}
+
+func (it *stepAggrIterator) Err() error {
+	return it.it.Err()
+}
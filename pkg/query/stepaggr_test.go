@@ -0,0 +1,224 @@
+package query
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+func TestQuerier_Select_StepDownsampling(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	const (
+		step = 60000
+		end  = 600000
+	)
+	// Raw series scraped every 15s, cut into chunks of 20 samples.
+	var raw []sample
+	for i := int64(1); i <= 40; i++ {
+		raw = append(raw, sample{i * 15000, float64(i%7) + float64(i)})
+	}
+	resp := storeSeriesResponse(t, labels.FromStrings("a", "a"), raw[:20], raw[20:])
+
+	// aggregate computes expected downsampled samples from the given ones, one for every step ending at end.
+	aggregate := func(in []sample, f func(vs []float64) float64) (res []sample) {
+		for bend := int64(end - 9*step); bend <= end; bend += step {
+			var (
+				ts int64
+				vs []float64
+			)
+			for _, s := range in {
+				if s.t > bend-step && s.t <= bend {
+					ts = s.t
+					vs = append(vs, s.v)
+				}
+			}
+			res = append(res, sample{ts, f(vs)})
+		}
+		return res
+	}
+	avg := func(vs []float64) float64 {
+		var sum float64
+		for _, v := range vs {
+			sum += v
+		}
+		return sum / float64(len(vs))
+	}
+	min := func(vs []float64) float64 {
+		m := vs[0]
+		for _, v := range vs[1:] {
+			m = math.Min(m, v)
+		}
+		return m
+	}
+	max := func(vs []float64) float64 {
+		m := vs[0]
+		for _, v := range vs[1:] {
+			m = math.Max(m, v)
+		}
+		return m
+	}
+	// firstLast returns the first and the last of the given samples in every step ending at end.
+	firstLast := func(in []sample) (res []sample) {
+		for bend := int64(end - 9*step); bend <= end; bend += step {
+			var bucket []sample
+			for _, s := range in {
+				if s.t > bend-step && s.t <= bend {
+					bucket = append(bucket, s)
+				}
+			}
+			res = append(res, bucket[0])
+			if len(bucket) > 1 {
+				res = append(res, bucket[len(bucket)-1])
+			}
+		}
+		return res
+	}
+
+	// Counter functions see raw series adjusted for counter resets.
+	counter := make([]sample, 0, len(raw))
+	for i, s := range raw {
+		if i == 0 {
+			counter = append(counter, s)
+			continue
+		}
+		v := counter[i-1].v
+		if s.v >= raw[i-1].v {
+			v += s.v - raw[i-1].v
+		} else {
+			v += s.v
+		}
+		counter = append(counter, sample{s.t, v})
+	}
+
+	for _, tcase := range []struct {
+		name       string
+		downsample bool
+		params     *storage.SelectParams
+		exp        []sample
+	}{
+		{name: "disabled", params: &storage.SelectParams{Step: step, End: end}, exp: raw},
+		{name: "instant query", downsample: true, params: &storage.SelectParams{End: end}, exp: raw},
+		{name: "avg", downsample: true, params: &storage.SelectParams{Step: step, End: end}, exp: aggregate(raw, avg)},
+		{name: "min", downsample: true, params: &storage.SelectParams{Step: step, End: end, Func: "min_over_time"}, exp: aggregate(raw, min)},
+		{name: "max", downsample: true, params: &storage.SelectParams{Step: step, End: end, Func: "max_over_time"}, exp: aggregate(raw, max)},
+		{name: "counter", downsample: true, params: &storage.SelectParams{Step: step, End: end, Func: "rate"}, exp: firstLast(counter)},
+		{name: "count is not downsampled", downsample: true, params: &storage.SelectParams{Step: step, End: end, Func: "count_over_time"}, exp: raw},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tcase.downsample {
+				ctx = ContextWithStepDownsampling(ctx)
+			}
+			q := newQuerier(ctx, nil, 1, end, "", &storeServer{resps: []*storepb.SeriesResponse{resp}}, false, 0, true, nil, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(tcase.params)
+			testutil.Ok(t, err)
+
+			testutil.Assert(t, res.Next(), "expected series")
+			testutil.Equals(t, tcase.exp, expandSeries(t, res.At().Iterator()))
+			testutil.Assert(t, !res.Next(), "expected single series")
+			testutil.Ok(t, res.Err())
+		})
+	}
+}
+
+func TestQuerier_Select_StepDownsamplingAlignedToQueryStep(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	const step = 60000
+	var raw []sample
+	for i := int64(1); i <= 40; i++ {
+		raw = append(raw, sample{i * 15000, float64(i)})
+	}
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "a"), raw)}}
+
+	for _, tcase := range []struct {
+		name      string
+		queryStep time.Duration
+		params    *storage.SelectParams
+		exp       []int64
+		expHint   int64
+	}{
+		{
+			// Buckets end at the selection end, so they are shifted against step multiples. Samples after it form their own bucket.
+			name:    "unknown query step",
+			params:  &storage.SelectParams{Start: 50000, End: 590000, Step: step},
+			exp:     []int64{45000, 105000, 165000, 225000, 285000, 345000, 405000, 465000, 525000, 585000, 600000},
+			expHint: step,
+		},
+		{
+			name:      "query step",
+			queryStep: step * time.Millisecond,
+			params:    &storage.SelectParams{Start: 50000, End: 590000, Step: step},
+			exp:       []int64{60000, 120000, 180000, 240000, 300000, 360000, 420000, 480000, 540000, 600000},
+			expHint:   step,
+		},
+		{
+			name:      "query step of selection without step",
+			queryStep: step * time.Millisecond,
+			params:    &storage.SelectParams{Start: 50000, End: 590000},
+			exp:       []int64{60000, 120000, 180000, 240000, 300000, 360000, 420000, 480000, 540000, 600000},
+			expHint:   step,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			ctx := ContextWithStepDownsampling(context.Background())
+			if tcase.queryStep > 0 {
+				ctx = ContextWithQueryStep(ctx, tcase.queryStep)
+			}
+			q := newQuerier(ctx, nil, 0, 600000, "", proxy, false, 0, true, nil, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(tcase.params)
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expHint, proxy.lastReq.Hints.Step)
+
+			testutil.Assert(t, res.Next(), "expected series")
+			var ts []int64
+			for _, s := range expandSeries(t, res.At().Iterator()) {
+				ts = append(ts, s.t)
+			}
+			testutil.Equals(t, tcase.exp, ts)
+			testutil.Ok(t, res.Err())
+		})
+	}
+}
+
+func TestStepAggrIterator_Seek(t *testing.T) {
+	c := chunkenc.NewXORChunk()
+	app, err := c.Appender()
+	testutil.Ok(t, err)
+	for _, s := range []sample{{10, 1}, {20, 3}, {30, 5}, {40, 7}, {50, 9}} {
+		app.Append(s.t, s.v)
+	}
+	it := newStepAggrIterator(newChunkSeriesIterator([]chunkenc.Iterator{c.Iterator()}), stepBuckets{step: 20, end: 50}, resAggrAvg)
+
+	// Buckets are (10, 30] and (30, 50], with (-10, 10] before them.
+	testutil.Assert(t, it.Seek(15), "expected sample")
+	ts, v := it.At()
+	testutil.Equals(t, int64(30), ts)
+	testutil.Equals(t, float64(4), v)
+
+	// Seeking to the current sample keeps it.
+	testutil.Assert(t, it.Seek(30), "expected sample")
+	ts, _ = it.At()
+	testutil.Equals(t, int64(30), ts)
+
+	testutil.Assert(t, it.Seek(31), "expected sample")
+	ts, v = it.At()
+	testutil.Equals(t, int64(50), ts)
+	testutil.Equals(t, float64(8), v)
+
+	testutil.Assert(t, !it.Seek(51), "expected no more samples")
+	testutil.Ok(t, it.Err())
+}
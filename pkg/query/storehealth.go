@@ -0,0 +1,93 @@
+package query
+
+import (
+	"context"
+	"sort"
+
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+// StoreUpMetric is the metric name of synthetic series added by ContextWithStoreHealthSeries.
+const StoreUpMetric = "thanos_query_store_up"
+
+type storeHealthSeriesKey struct{}
+
+// ContextWithStoreHealthSeries returns a new context.Context that makes queriers created with it answer selectors of
+// StoreUpMetric, e.g. `thanos_query_store_up{store="host:10901"}`, with a synthetic series for every store known to the
+// proxy, with value 1 if the store responded successfully to the selection and 0 if it failed or was skipped. Series
+// are labeled by the store address, so dashboards can track health of the query fanout. Selects of other metrics are
+// not affected.
+func ContextWithStoreHealthSeries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, storeHealthSeriesKey{}, true)
+}
+
+func storeHealthSeriesFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(storeHealthSeriesKey{}).(bool)
+	return v
+}
+
+// selectsStoreUp returns true if the given matchers select StoreUpMetric by its name.
+func selectsStoreUp(ms []*labels.Matcher) bool {
+	for _, m := range ms {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual && m.Value == StoreUpMetric {
+			return true
+		}
+	}
+	return false
+}
+
+// storeHealthSeries returns synthetic series of stores recorded in the given StoreHealth that match all the given
+// matchers, sorted by labels. Samples are placed at every step of the selection, or at its end for instant selections.
+func storeHealthSeries(h *store.StoreHealth, params *storage.SelectParams, maxt int64, ms []*labels.Matcher) ([]storepb.Series, error) {
+	var ts []int64
+	switch {
+	case params != nil && params.Step > 0:
+		for t := params.Start; t <= params.End; t += params.Step {
+			ts = append(ts, t)
+		}
+	case params != nil && params.End > 0:
+		ts = append(ts, params.End)
+	default:
+		ts = append(ts, maxt)
+	}
+
+	var res []storepb.Series
+stores:
+	for addr, up := range h.Stores() {
+		lset := labels.FromStrings(labels.MetricName, StoreUpMetric, "store", addr)
+		for _, m := range ms {
+			if !m.Matches(lset.Get(m.Name)) {
+				continue stores
+			}
+		}
+		v := 0.0
+		if up {
+			v = 1
+		}
+		c := chunkenc.NewXORChunk()
+		app, err := c.Appender()
+		if err != nil {
+			return nil, errors.Wrap(err, "create chunk appender")
+		}
+		for _, t := range ts {
+			app.Append(t, v)
+		}
+		res = append(res, storepb.Series{
+			Labels: storepb.PromLabelsToLabels(lset),
+			Chunks: []storepb.AggrChunk{{
+				MinTime: ts[0],
+				MaxTime: ts[len(ts)-1],
+				Raw:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()},
+			}},
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return storepb.CompareLabels(res[i].Labels, res[j].Labels) < 0
+	})
+	return res, nil
+}
@@ -0,0 +1,146 @@
+package query
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// rangeStoreServer serves data for the given time range and optionally fails every Series request.
+type rangeStoreServer struct {
+	storeServer
+
+	mint, maxt int64
+	err        error
+}
+
+func (s *rangeStoreServer) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	return &storepb.InfoResponse{MinTime: s.mint, MaxTime: s.maxt}, nil
+}
+
+func (s *rangeStoreServer) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.storeServer.Series(r, srv)
+}
+
+// metricStoreServer serves its series only to requests that don't select another metric by name.
+type metricStoreServer struct {
+	rangeStoreServer
+
+	metric string
+}
+
+func (s *metricStoreServer) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	for _, m := range r.Matchers {
+		if m.Name == labels.MetricName && m.Type == storepb.LabelMatcher_EQ && m.Value != s.metric {
+			return nil
+		}
+	}
+	return s.rangeStoreServer.Series(r, srv)
+}
+
+func TestQuerier_Select_StoreHealthSeries(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Stores are referenced like by StoreSet, so their names differ from their addresses.
+	clients := []store.Client{
+		&storeRef{
+			StoreClient: store.NewLocalClient(&metricStoreServer{
+				rangeStoreServer: rangeStoreServer{storeServer: storeServer{resps: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("__name__", "up"), []sample{{100, 1}, {200, 2}}),
+				}}},
+				metric: "up",
+			}, "ok"),
+			addr:    "ok:10901",
+			minTime: math.MinInt64,
+			maxTime: math.MaxInt64,
+		},
+		&storeRef{
+			StoreClient: store.NewLocalClient(&rangeStoreServer{err: errors.New("store failure")}, "failing"),
+			addr:        "failing:10901",
+			minTime:     math.MinInt64,
+			maxTime:     math.MaxInt64,
+		},
+		// Skipped, as it holds no data for the query time range.
+		&storeRef{
+			StoreClient: store.NewLocalClient(&rangeStoreServer{}, "old"),
+			addr:        "old:10901",
+			minTime:     0,
+			maxTime:     10,
+		},
+	}
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) { return clients, nil }, nil, store.StoreLimit{})
+
+	storeUp := &labels.Matcher{Type: labels.MatchEqual, Name: labels.MetricName, Value: StoreUpMetric}
+	for _, tcase := range []struct {
+		name string
+		ctx  context.Context
+		ms   []*labels.Matcher
+		exp  []labels.Labels
+	}{
+		{
+			name: "disabled",
+			ctx:  context.Background(),
+			ms:   []*labels.Matcher{storeUp},
+		},
+		{
+			name: "other metric",
+			ctx:  ContextWithStoreHealthSeries(context.Background()),
+			ms:   []*labels.Matcher{&labels.Matcher{Type: labels.MatchEqual, Name: labels.MetricName, Value: "up"}},
+			exp:  []labels.Labels{labels.FromStrings("__name__", "up")},
+		},
+		{
+			name: "all stores",
+			ctx:  ContextWithStoreHealthSeries(context.Background()),
+			ms:   []*labels.Matcher{storeUp},
+			exp: []labels.Labels{
+				labels.FromStrings("__name__", StoreUpMetric, "store", "failing:10901"),
+				labels.FromStrings("__name__", StoreUpMetric, "store", "ok:10901"),
+				labels.FromStrings("__name__", StoreUpMetric, "store", "old:10901"),
+			},
+		},
+		{
+			name: "single store",
+			ctx:  ContextWithStoreHealthSeries(context.Background()),
+			ms:   []*labels.Matcher{storeUp, &labels.Matcher{Type: labels.MatchEqual, Name: "store", Value: "ok:10901"}},
+			exp:  []labels.Labels{labels.FromStrings("__name__", StoreUpMetric, "store", "ok:10901")},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			q := newQuerier(tcase.ctx, nil, 100, 200, "", proxy, false, 0, true, func(error) {}, QuerierOpts{})
+			defer func() { testutil.Ok(t, q.Close()) }()
+
+			res, _, err := q.Select(&storage.SelectParams{Start: 100, End: 200, Step: 50}, tcase.ms...)
+			testutil.Ok(t, err)
+
+			var (
+				lsets   []labels.Labels
+				samples = map[string][]sample{}
+			)
+			for res.Next() {
+				lsets = append(lsets, res.At().Labels())
+				samples[res.At().Labels().Get("store")] = expandSeries(t, res.At().Iterator())
+			}
+			testutil.Ok(t, res.Err())
+			testutil.Equals(t, tcase.exp, lsets)
+
+			if tcase.name != "all stores" {
+				return
+			}
+			testutil.Equals(t, []sample{{100, 0}, {150, 0}, {200, 0}}, samples["failing:10901"])
+			testutil.Equals(t, []sample{{100, 1}, {150, 1}, {200, 1}}, samples["ok:10901"])
+			testutil.Equals(t, []sample{{100, 0}, {150, 0}, {200, 0}}, samples["old:10901"])
+		})
+	}
+}
@@ -3,12 +3,15 @@ package query
 import (
 	"context"
 	"fmt"
+	"io"
+	"math"
 	"sort"
 	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/improbable-eng/thanos/pkg/store"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
@@ -16,22 +19,25 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/tsdb/labels"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	unhealthyStoreMessage = "removing store because it's unhealthy or does not exist"
 	droppingStoreMessage  = "dropping store, external labels are not unique"
+	duplicateStoreMessage = "dropping store, it has the same external labels and type as another store"
 )
 
 type StoreSpec interface {
 	// Addr returns StoreAPI Address for the store spec. It is used as ID for store.
 	Addr() string
-	// Metadata returns current labels and min, max ranges for store.
-	// It can change for every call for this method.
+	// Metadata returns current info of the store: labels, min, max ranges, estimated number of series, or zero if
+	// unknown, and capabilities. It can change for every call for this method.
 	// If metadata call fails we assume that store is no longer accessible and we should not use it.
 	// NOTE: It is implementation responsibility to retry until context timeout, but a caller responsibility to manage
 	// given store connection.
-	Metadata(ctx context.Context, client storepb.StoreClient) (labels []storepb.Label, mint int64, maxt int64, err error)
+	Metadata(ctx context.Context, client storepb.StoreClient) (*storepb.InfoResponse, error)
 }
 
 type StoreStatus struct {
@@ -41,6 +47,14 @@ type StoreStatus struct {
 	MinTime   int64
 	MaxTime   int64
 	Labels    []storepb.Label
+	// LastCallError is the last error of any call to the store, including queries and health checks. Unlike
+	// LastError, it is kept until another call fails, so errors of stores that are otherwise healthy are visible.
+	LastCallError     error
+	LastCallErrorTime time.Time
+	// StoreType is the type reported by the store when connected.
+	StoreType storepb.StoreType
+	// LastRefresh is the time of the last successful refresh of the store metadata.
+	LastRefresh time.Time
 }
 
 type grpcStoreSpec struct {
@@ -60,12 +74,43 @@ func (s *grpcStoreSpec) Addr() string {
 
 // Metadata method for gRPC store API tries to reach host Info method until context timeout. If we are unable to get metadata after
 // that time, we assume that the host is unhealthy and return error.
-func (s *grpcStoreSpec) Metadata(ctx context.Context, client storepb.StoreClient) (labels []storepb.Label, mint int64, maxt int64, err error) {
-	resp, err := client.Info(ctx, &storepb.InfoRequest{}, grpc.FailFast(false))
+func (s *grpcStoreSpec) Metadata(ctx context.Context, client storepb.StoreClient) (*storepb.InfoResponse, error) {
+	resp, err := storeInfo(ctx, client)
 	if err != nil {
-		return nil, 0, 0, errors.Wrapf(err, "fetching store info from %s", s.addr)
+		return nil, errors.Wrapf(err, "fetching store info from %s", s.addr)
+	}
+	return resp, nil
+}
+
+// storeInfo calls Info of the store. Stores that do not implement it, e.g. of older versions, are assumed to have no
+// external labels and data of all time, so they are contacted for every request instead of being excluded.
+func storeInfo(ctx context.Context, client storepb.StoreClient) (*storepb.InfoResponse, error) {
+	resp, err := client.Info(ctx, &storepb.InfoRequest{}, grpc.FailFast(false))
+	if status.Code(err) == codes.Unimplemented {
+		return &storepb.InfoResponse{MinTime: math.MinInt64, MaxTime: math.MaxInt64}, nil
+	}
+	return resp, err
+}
+
+// StoreInterceptors holds gRPC client interceptors applied to all calls of every store connection, e.g. for tracing,
+// metrics or auth.
+type StoreInterceptors struct {
+	Unary  []grpc.UnaryClientInterceptor
+	Stream []grpc.StreamClientInterceptor
+}
+
+// DialOptions returns dial options for the StoreSet that install all interceptors. Interceptors are chained, so
+// the first one is the outermost. Only one set of interceptors can be installed per connection, so all of them
+// have to be passed in a single StoreInterceptors.
+func (i StoreInterceptors) DialOptions() []grpc.DialOption {
+	var opts []grpc.DialOption
+	if len(i.Unary) > 0 {
+		opts = append(opts, grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(i.Unary...)))
+	}
+	if len(i.Stream) > 0 {
+		opts = append(opts, grpc.WithStreamInterceptor(grpc_middleware.ChainStreamClient(i.Stream...)))
 	}
-	return resp.Labels, resp.MinTime, resp.MaxTime, nil
+	return opts
 }
 
 // StoreSet maintains a set of active stores. It is backed up by Store Specifications that are dynamically fetched on
@@ -85,6 +130,14 @@ type StoreSet struct {
 	storeNodeConnections prometheus.Gauge
 	externalLabelStores  map[string]int
 	storeStatuses        map[string]*StoreStatus
+	// Addresses of stores dropped as duplicates of another store, mapped to the address of the kept store. They are
+	// not dialed again while the kept store is in the set and discovered.
+	droppedDuplicates map[string]string
+
+	seriesRate           float64
+	seriesBurst          int
+	seriesRateLimit      prometheus.Gauge
+	seriesThrottledCalls prometheus.Counter
 }
 
 type storeSetNodeCollector struct {
@@ -125,8 +178,16 @@ func NewStoreSet(
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
+	seriesRateLimit := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_query_store_series_rate_limit",
+		Help: "Configured maximum rate of Series calls per second to a single store. Zero means no limit.",
+	})
+	seriesThrottledCalls := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_query_store_series_throttled_total",
+		Help: "Total number of Series calls to stores that waited for the per store rate limit.",
+	})
 	if reg != nil {
-		reg.MustRegister(storeNodeConnections)
+		reg.MustRegister(storeNodeConnections, seriesRateLimit, seriesThrottledCalls)
 	}
 	if storeSpecs == nil {
 		storeSpecs = func() []StoreSpec { return nil }
@@ -141,6 +202,9 @@ func NewStoreSet(
 		externalLabelStores:  map[string]int{},
 		stores:               make(map[string]*storeRef),
 		storeStatuses:        make(map[string]*StoreStatus),
+		droppedDuplicates:    map[string]string{},
+		seriesRateLimit:      seriesRateLimit,
+		seriesThrottledCalls: seriesThrottledCalls,
 	}
 
 	storeNodeCollector := &storeSetNodeCollector{externalLabelOccurrences: ss.externalLabelOccurrences}
@@ -151,6 +215,15 @@ func NewStoreSet(
 	return ss
 }
 
+// SetSeriesRateLimit limits Series calls to every single store to the given rate per second, with bursts of up to
+// the given number of calls. Calls over the limit wait for their turn until their context is done. It protects stores
+// backed by object storage from query storms. It applies to stores added after the call, so it is meant to be called
+// before the first Update. Zero rate means no limit.
+func (s *StoreSet) SetSeriesRateLimit(rate float64, burst int) {
+	s.seriesRate, s.seriesBurst = rate, burst
+	s.seriesRateLimit.Set(rate)
+}
+
 type storeRef struct {
 	storepb.StoreClient
 
@@ -163,16 +236,33 @@ type storeRef struct {
 	minTime int64
 	maxTime int64
 
+	// Estimated number of series reported by the store at the last refresh, or zero if unknown.
+	seriesEstimate int64
+	// Whether the store omits data of chunks listed as known by Series requests, reported at the last refresh.
+	supportsKnownChunks bool
+	// Type reported by the store when connected.
+	storeType storepb.StoreType
+
+	// Optional callback observing errors of calls to the store.
+	onErr func(error)
+
+	// Optional rate limit of Series calls and callback observing calls that waited for it.
+	limiter    *tokenBucket
+	onThrottle func()
+
 	logger log.Logger
 }
 
-func (s *storeRef) Update(labels []storepb.Label, minTime int64, maxTime int64) {
-	s.mtx.RLock()
-	defer s.mtx.RUnlock()
+func (s *storeRef) Update(info *storepb.InfoResponse) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.seriesEstimate = info.SeriesEstimate
+	s.supportsKnownChunks = info.SupportsKnownChunks
 
-	s.labels = labels
-	s.minTime = minTime
-	s.maxTime = maxTime
+	s.labels = info.Labels
+	s.minTime = info.MinTime
+	s.maxTime = info.MaxTime
 }
 
 func (s *storeRef) Labels() []storepb.Label {
@@ -188,11 +278,87 @@ func (s *storeRef) TimeRange() (int64, int64) {
 	return s.minTime, s.maxTime
 }
 
+func (s *storeRef) Addr() string {
+	return s.addr
+}
+
+func (s *storeRef) SeriesEstimate() int64 {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.seriesEstimate
+}
+
+func (s *storeRef) SupportsKnownChunks() bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.supportsKnownChunks
+}
+
 func (s *storeRef) String() string {
 	mint, maxt := s.TimeRange()
 	return fmt.Sprintf("Addr: %s Labels: %v Mint: %d Maxt: %d", s.addr, s.Labels(), mint, maxt)
 }
 
+// Series starts Series request against the store, waiting for the rate limit of the store if any. Errors of the
+// request, including those of the stream, are observed.
+func (s *storeRef) Series(ctx context.Context, r *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	if s.limiter != nil {
+		throttled, err := s.limiter.wait(ctx)
+		if throttled && s.onThrottle != nil {
+			s.onThrottle()
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "wait for store rate limit")
+		}
+	}
+	cl, err := s.StoreClient.Series(ctx, r, opts...)
+	if err != nil {
+		s.observeErr(ctx, err)
+		return nil, err
+	}
+	return &observedSeriesClient{Store_SeriesClient: cl, observe: func(err error) { s.observeErr(ctx, err) }}, nil
+}
+
+// LabelNames returns label names of the store. Errors are observed.
+func (s *storeRef) LabelNames(ctx context.Context, r *storepb.LabelNamesRequest, opts ...grpc.CallOption) (*storepb.LabelNamesResponse, error) {
+	resp, err := s.StoreClient.LabelNames(ctx, r, opts...)
+	s.observeErr(ctx, err)
+	return resp, err
+}
+
+// LabelValues returns label values of the store. Errors are observed.
+func (s *storeRef) LabelValues(ctx context.Context, r *storepb.LabelValuesRequest, opts ...grpc.CallOption) (*storepb.LabelValuesResponse, error) {
+	resp, err := s.StoreClient.LabelValues(ctx, r, opts...)
+	s.observeErr(ctx, err)
+	return resp, err
+}
+
+// observeErr passes the error of a call to the store made with the given context to the callback. Calls ended by
+// the querier through the context, e.g. when another store failed the query or the query timed out, are not errors
+// of the store. Canceled or DeadlineExceeded errors sent by the store are.
+func (s *storeRef) observeErr(ctx context.Context, err error) {
+	if err == nil || s.onErr == nil || ctx.Err() != nil {
+		return
+	}
+	s.onErr(err)
+}
+
+// observedSeriesClient observes errors of a Series stream other than its end.
+type observedSeriesClient struct {
+	storepb.Store_SeriesClient
+	observe func(error)
+}
+
+func (c *observedSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	resp, err := c.Store_SeriesClient.Recv()
+	if err != nil && err != io.EOF {
+		c.observe(err)
+	}
+	return resp, err
+}
+
 func (s *storeRef) close() {
 	runutil.CloseWithLogOnErr(s.logger, s.cc, fmt.Sprintf("store %v connection close", s.addr))
 }
@@ -200,7 +366,20 @@ func (s *storeRef) close() {
 // Update updates the store set. It fetches current list of store specs from function and updates the fresh metadata
 // from all stores.
 func (s *StoreSet) Update(ctx context.Context) {
-	healthyStores := s.getHealthyStores(ctx)
+	s.UpdateStores(ctx, s.storeSpecs())
+}
+
+// UpdateStores updates the store set to the given store specs, e.g. when service discovery pushes changes of the
+// stores. New stores are dialed, stores missing from the specs are closed and fresh metadata is fetched from the rest.
+// It is meant for store sets created without store specs function, as each Update replaces stores with the ones
+// returned by it. It must not be called concurrently with Update.
+func (s *StoreSet) UpdateStores(ctx context.Context, specs []StoreSpec) {
+	discovered := make(map[string]struct{}, len(specs))
+	for _, spec := range specs {
+		discovered[spec.Addr()] = struct{}{}
+	}
+	healthyStores := s.getHealthyStores(ctx, specs, discovered)
+	duplicates := s.duplicateStores(healthyStores)
 
 	// Record the number of occurrences of external label combinations for current store slice.
 	externalLabelStores := map[string]int{}
@@ -211,6 +390,14 @@ func (s *StoreSet) Update(ctx context.Context) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
+	// Close duplicates of other healthy stores, both newly discovered and already used ones.
+	for addr, dup := range duplicates {
+		dup.store.close()
+		delete(s.stores, addr)
+		s.updateStoreStatus(dup.store, errors.New(duplicateStoreMessage))
+		level.Warn(s.logger).Log("msg", duplicateStoreMessage, "address", addr, "kept", dup.kept)
+	}
+
 	// Close stores that where not healthy this time (are not in healthy stores map).
 	for addr, store := range s.stores {
 		if _, ok := healthyStores[addr]; ok {
@@ -246,11 +433,68 @@ func (s *StoreSet) Update(ctx context.Context) {
 		s.updateStoreStatus(store, nil)
 		level.Info(s.logger).Log("msg", "adding new store to query storeset", "address", addr)
 	}
+
+	// Remember dropped duplicates, so they are not dialed just to be dropped again, and forget the ones whose kept store
+	// is gone, so they are dialed again.
+	for addr, dup := range duplicates {
+		s.droppedDuplicates[addr] = dup.kept
+	}
+	for addr, kept := range s.droppedDuplicates {
+		_, ok := s.stores[kept]
+		_, discovered := discovered[addr]
+		if !ok || !discovered {
+			delete(s.droppedDuplicates, addr)
+		}
+	}
 	s.externalLabelStores = externalLabelStores
 	s.storeNodeConnections.Set(float64(len(s.stores)))
 }
 
-func (s *StoreSet) getHealthyStores(ctx context.Context) map[string]*storeRef {
+type duplicateStore struct {
+	store *storeRef
+	// kept is the address of the store with the same identity that stays in the set.
+	kept string
+}
+
+// duplicateStores removes stores that have the same identity as another healthy store from the given map and returns
+// them by address. Identity of a store is its external labels and type, so a store discovered under multiple addresses
+// (e.g. by DNS name and by IP) is queried once and its data is not counted twice. Out of the duplicates, the store
+// already in the set is kept, otherwise the one with the lowest address.
+// Stores without external labels (store gateways and rulers) have no identity and are never duplicates.
+func (s *StoreSet) duplicateStores(healthyStores map[string]*storeRef) map[string]duplicateStore {
+	identities := map[string][]string{}
+	for addr, st := range healthyStores {
+		if len(st.Labels()) == 0 {
+			continue
+		}
+		id := fmt.Sprintf("%s/%s", externalLabelsFromStore(st), st.storeType)
+		identities[id] = append(identities[id], addr)
+	}
+
+	duplicates := map[string]duplicateStore{}
+	for _, addrs := range identities {
+		if len(addrs) < 2 {
+			continue
+		}
+		sort.Slice(addrs, func(i, j int) bool {
+			_, iok := s.stores[addrs[i]]
+			_, jok := s.stores[addrs[j]]
+			if iok != jok {
+				return iok
+			}
+			return addrs[i] < addrs[j]
+		})
+		for _, addr := range addrs[1:] {
+			duplicates[addr] = duplicateStore{store: healthyStores[addr], kept: addrs[0]}
+			delete(healthyStores, addr)
+		}
+	}
+	return duplicates
+}
+
+// getHealthyStores returns stores of the given specs that responded to the info call, dialing new ones. Dropped
+// duplicates of stores in the set are skipped if the kept store is still among the discovered addresses.
+func (s *StoreSet) getHealthyStores(ctx context.Context, specs []StoreSpec, discovered map[string]struct{}) map[string]*storeRef {
 	var (
 		unique = make(map[string]struct{})
 
@@ -260,13 +504,21 @@ func (s *StoreSet) getHealthyStores(ctx context.Context) map[string]*storeRef {
 	)
 
 	// Gather healthy stores map concurrently. Build new store if does not exist already.
-	for _, storeSpec := range s.storeSpecs() {
+	for _, storeSpec := range specs {
 		if _, ok := unique[storeSpec.Addr()]; ok {
 			level.Warn(s.logger).Log("msg", "duplicated address in gossip or static store nodes", "address", storeSpec.Addr())
 			continue
 		}
 		unique[storeSpec.Addr()] = struct{}{}
 
+		if kept, ok := s.droppedDuplicates[storeSpec.Addr()]; ok {
+			_, inSet := s.stores[kept]
+			_, keptDiscovered := discovered[kept]
+			if inSet && keptDiscovered {
+				continue
+			}
+		}
+
 		wg.Add(1)
 		go func(spec StoreSpec) {
 			defer wg.Done()
@@ -279,14 +531,15 @@ func (s *StoreSet) getHealthyStores(ctx context.Context) map[string]*storeRef {
 			store, ok := s.stores[addr]
 			if ok {
 				// Check existing store. Is it healthy? What are current metadata?
-				labels, minTime, maxTime, err := spec.Metadata(ctx, store.StoreClient)
+				info, err := spec.Metadata(ctx, store.StoreClient)
 				if err != nil {
 					// Peer unhealthy. Do not include in healthy stores.
 					s.updateStoreStatus(store, err)
+					s.recordCallError(store, err)
 					level.Warn(s.logger).Log("msg", "update of store node failed", "err", err, "address", addr)
 					return
 				}
-				store.Update(labels, minTime, maxTime)
+				store.Update(info)
 			} else {
 				// New store or was unhealthy and was removed in the past - create new one.
 				conn, err := grpc.DialContext(ctx, addr, s.dialOpts...)
@@ -296,16 +549,23 @@ func (s *StoreSet) getHealthyStores(ctx context.Context) map[string]*storeRef {
 					return
 				}
 				store = &storeRef{StoreClient: storepb.NewStoreClient(conn), cc: conn, addr: addr, logger: s.logger}
+				store.onErr = func(err error) { s.recordCallError(store, err) }
+				if s.seriesRate > 0 {
+					store.limiter = newTokenBucket(s.seriesRate, s.seriesBurst)
+					store.onThrottle = s.seriesThrottledCalls.Inc
+				}
 
 				// Initial info call for all types of stores (gossip + static) to check gRPC StoreAPI.
-				resp, err := store.StoreClient.Info(ctx, &storepb.InfoRequest{}, grpc.FailFast(false))
+				resp, err := storeInfo(ctx, store.StoreClient)
 				if err != nil {
 					store.close()
 					s.updateStoreStatus(store, err)
+					s.recordCallError(store, err)
 					level.Warn(s.logger).Log("msg", "update of store node failed", "err", errors.Wrap(err, "initial store client info fetch"), "address", addr)
 					return
 				}
-				store.Update(resp.Labels, resp.MinTime, resp.MaxTime)
+				store.Update(resp)
+				store.storeType = resp.StoreType
 			}
 
 			mtx.Lock()
@@ -322,7 +582,7 @@ func (s *StoreSet) getHealthyStores(ctx context.Context) map[string]*storeRef {
 
 func externalLabelsFromStore(store *storeRef) string {
 	tsdbLabels := labels.Labels{}
-	for _, l := range store.labels {
+	for _, l := range store.Labels() {
 		tsdbLabels = append(tsdbLabels, labels.Label{
 			Name:  l.Name,
 			Value: l.Value,
@@ -338,14 +598,40 @@ func (s *StoreSet) updateStoreStatus(store *storeRef, err error) {
 	defer s.storesStatusesMtx.Unlock()
 
 	now := time.Now()
-	s.storeStatuses[store.addr] = &StoreStatus{
+	mint, maxt := store.TimeRange()
+	st := &StoreStatus{
 		Name:      store.addr,
-		Labels:    store.labels,
+		Labels:    store.Labels(),
 		LastError: err,
 		LastCheck: now,
-		MinTime:   store.minTime,
-		MaxTime:   store.maxTime,
+		MinTime:   mint,
+		MaxTime:   maxt,
+		StoreType: store.storeType,
+	}
+	if err == nil {
+		st.LastRefresh = now
+	}
+	if prev, ok := s.storeStatuses[store.addr]; ok {
+		st.LastCallError, st.LastCallErrorTime = prev.LastCallError, prev.LastCallErrorTime
+		if err != nil {
+			st.LastRefresh = prev.LastRefresh
+		}
+	}
+	s.storeStatuses[store.addr] = st
+}
+
+// recordCallError records error of a call to the store as its last call error.
+func (s *StoreSet) recordCallError(store *storeRef, err error) {
+	s.storesStatusesMtx.Lock()
+	defer s.storesStatusesMtx.Unlock()
+
+	st, ok := s.storeStatuses[store.addr]
+	if !ok {
+		mint, maxt := store.TimeRange()
+		st = &StoreStatus{Name: store.addr, Labels: store.Labels(), MinTime: mint, MaxTime: maxt, StoreType: store.storeType}
+		s.storeStatuses[store.addr] = st
 	}
+	st.LastCallError, st.LastCallErrorTime = err, time.Now()
 }
 
 func (s *StoreSet) GetStoreStatus() []StoreStatus {
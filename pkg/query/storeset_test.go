@@ -4,16 +4,26 @@ import (
 	"context"
 	"math"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"sort"
 
 	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/stats"
 	"google.golang.org/grpc/status"
 )
 
@@ -46,6 +56,15 @@ func (s *testStore) LabelValues(ctx context.Context, r *storepb.LabelValuesReque
 	return nil, status.Error(codes.Unimplemented, "not implemented")
 }
 
+// infoUnimplementedStore does not implement Info, like stores of older versions.
+type infoUnimplementedStore struct {
+	testStore
+}
+
+func (s *infoUnimplementedStore) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "not implemented")
+}
+
 type testStores struct {
 	srvs map[string]*grpc.Server
 }
@@ -175,6 +194,243 @@ func TestStoreSet_AllAvailable_ThenDown(t *testing.T) {
 	testutil.Equals(t, addr, store.labels[0].Value)
 }
 
+func TestStoreSet_UpdateStores(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	st, err := newTestStores(2)
+	testutil.Ok(t, err)
+	defer st.Close()
+	addrs := st.StoreAddresses()
+
+	storeSet := NewStoreSet(nil, nil, nil, testGRPCOpts)
+	storeSet.gRPCInfoCallTimeout = 2 * time.Second
+	defer storeSet.Close()
+
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) {
+		return storeSet.Get(), nil
+	}, nil, store.StoreLimit{})
+
+	// Test stores fail all LabelValues requests, so each contacted store reports a warning with its address.
+	contacted := func() (res []string) {
+		resp, err := proxy.LabelValues(context.Background(), &storepb.LabelValuesRequest{Label: "a"})
+		testutil.Ok(t, err)
+		for _, addr := range addrs {
+			for _, w := range resp.Warnings {
+				if strings.Contains(w, addr) {
+					res = append(res, addr)
+				}
+			}
+		}
+		return res
+	}
+
+	storeSet.UpdateStores(context.Background(), specsFromAddrFunc(addrs[:1])())
+	testutil.Equals(t, 1, len(storeSet.Get()))
+	testutil.Equals(t, addrs[:1], contacted())
+
+	// Added store is dialed.
+	storeSet.UpdateStores(context.Background(), specsFromAddrFunc(addrs)())
+	testutil.Equals(t, 2, len(storeSet.Get()))
+	testutil.Equals(t, addrs, contacted())
+
+	// Removed store is closed.
+	removed := storeSet.stores[addrs[0]]
+	storeSet.UpdateStores(context.Background(), specsFromAddrFunc(addrs[1:])())
+	testutil.Equals(t, 1, len(storeSet.Get()))
+	testutil.Equals(t, addrs[1:], contacted())
+	testutil.Equals(t, connectivity.Shutdown, removed.cc.GetState())
+}
+
+// TestStoreSet_UpdateStores_Resolved mimics how the querier feeds stores discovered through DNS (e.g. dnssrv+ addresses
+// resolved by dns.Provider) into the store set: on each round resolved addresses are pushed through UpdateStores.
+func TestStoreSet_UpdateStores_Resolved(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	st, err := newTestStores(3)
+	testutil.Ok(t, err)
+	defer st.Close()
+	addrs := st.StoreAddresses()
+	sort.Strings(addrs)
+
+	storeSet := NewStoreSet(nil, nil, nil, testGRPCOpts)
+	storeSet.gRPCInfoCallTimeout = 2 * time.Second
+	defer storeSet.Close()
+
+	// Resolver returns a different set of records on every round, like a SRV record changing between lookups.
+	rounds := [][]string{
+		{addrs[0]},
+		{addrs[0], addrs[1], addrs[2]},
+		{addrs[2], addrs[1]},
+		{},
+		{addrs[1]},
+	}
+	current := func() (res []string) {
+		for _, s := range storeSet.Get() {
+			res = append(res, s.Addr())
+		}
+		sort.Strings(res)
+		return res
+	}
+
+	var prev map[string]*storeRef
+	for round, resolved := range rounds {
+		storeSet.UpdateStores(context.Background(), specsFromAddrFunc(resolved)())
+
+		exp := append([]string(nil), resolved...)
+		sort.Strings(exp)
+		testutil.Equals(t, len(exp), len(storeSet.Get()))
+		if len(exp) > 0 {
+			testutil.Equals(t, exp, current())
+		}
+
+		// Stores that are no longer resolved are closed, the ones still resolved are kept.
+		for addr, ref := range prev {
+			if kept, ok := storeSet.stores[addr]; ok {
+				testutil.Assert(t, kept == ref, "round %d: store %s was redialed", round, addr)
+				continue
+			}
+			testutil.Equals(t, connectivity.Shutdown, ref.cc.GetState())
+		}
+		prev = map[string]*storeRef{}
+		for addr, ref := range storeSet.stores {
+			prev[addr] = ref
+		}
+	}
+}
+
+func TestStoreSet_InfoUnimplemented(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, &infoUnimplementedStore{})
+	go func() { _ = srv.Serve(listener) }()
+	defer srv.Stop()
+	addr := listener.Addr().String()
+
+	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc([]string{addr}), testGRPCOpts)
+	storeSet.gRPCInfoCallTimeout = 2 * time.Second
+	defer storeSet.Close()
+
+	// Store is kept as matching everything, also when its metadata is refreshed.
+	for i := 0; i < 2; i++ {
+		storeSet.Update(context.Background())
+		testutil.Equals(t, 1, len(storeSet.Get()))
+		mint, maxt := storeSet.Get()[0].TimeRange()
+		testutil.Equals(t, int64(math.MinInt64), mint)
+		testutil.Equals(t, int64(math.MaxInt64), maxt)
+		testutil.Equals(t, 0, len(storeSet.Get()[0].Labels()))
+	}
+
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) {
+		return storeSet.Get(), nil
+	}, nil, store.StoreLimit{})
+
+	// Test stores fail Series and LabelValues requests, so the contacted store reports a warning.
+	s := &seriesServer{ctx: context.Background(), partialResponse: true}
+	testutil.Ok(t, proxy.Series(&storepb.SeriesRequest{
+		MinTime:  1000,
+		MaxTime:  2000,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "region", Value: "eu"}},
+	}, s))
+	testutil.Equals(t, 1, len(s.warnings))
+	testutil.Assert(t, strings.Contains(s.warnings[0], "receive series"), "expected store to be contacted, got warning %q", s.warnings[0])
+
+	resp, err := proxy.LabelValues(context.Background(), &storepb.LabelValuesRequest{Label: "a"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(resp.Warnings))
+	testutil.Assert(t, strings.Contains(resp.Warnings[0], addr), "expected store to be contacted, got warning %q", resp.Warnings[0])
+}
+
+// estimateStore reports the given series estimate in Info.
+type estimateStore struct {
+	testStore
+
+	estimate int64
+}
+
+func (s *estimateStore) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	return &storepb.InfoResponse{SeriesEstimate: atomic.LoadInt64(&s.estimate)}, nil
+}
+
+func TestStoreSet_Update_SeriesEstimate(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+	srv := grpc.NewServer()
+	st := &estimateStore{estimate: 100}
+	storepb.RegisterStoreServer(srv, st)
+	go func() { _ = srv.Serve(listener) }()
+	defer srv.Stop()
+
+	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc([]string{listener.Addr().String()}), testGRPCOpts)
+	storeSet.gRPCInfoCallTimeout = 2 * time.Second
+	defer storeSet.Close()
+
+	storeSet.Update(context.Background())
+	testutil.Equals(t, 1, len(storeSet.Get()))
+	testutil.Equals(t, int64(100), storeSet.Get()[0].SeriesEstimate())
+
+	// Estimate is refreshed together with the rest of the metadata.
+	atomic.StoreInt64(&st.estimate, 200)
+	storeSet.Update(context.Background())
+	testutil.Equals(t, int64(200), storeSet.Get()[0].SeriesEstimate())
+}
+
+func TestStoreSet_LastCallError(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	st, err := newTestStores(1)
+	testutil.Ok(t, err)
+	defer st.Close()
+
+	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc(st.StoreAddresses()), testGRPCOpts)
+	storeSet.gRPCInfoCallTimeout = 2 * time.Second
+	defer storeSet.Close()
+
+	storeSet.Update(context.Background())
+	testutil.Equals(t, 1, len(storeSet.Get()))
+	testutil.Ok(t, storeSet.GetStoreStatus()[0].LastCallError)
+
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) {
+		return storeSet.Get(), nil
+	}, nil, store.StoreLimit{})
+
+	// Test stores fail Series and LabelValues requests, which is recorded while the store stays healthy.
+	var lastErrTime time.Time
+	for _, call := range []func() error{
+		func() error {
+			_, err := proxy.LabelValues(context.Background(), &storepb.LabelValuesRequest{Label: "a"})
+			return err
+		},
+		func() error {
+			return proxy.Series(&storepb.SeriesRequest{MinTime: 0, MaxTime: 100}, &seriesServer{ctx: context.Background(), partialResponse: true})
+		},
+	} {
+		testutil.Ok(t, call())
+
+		ss := storeSet.GetStoreStatus()[0]
+		testutil.Ok(t, ss.LastError)
+		testutil.NotOk(t, ss.LastCallError)
+		testutil.Equals(t, codes.Unimplemented, status.Code(ss.LastCallError))
+		testutil.Assert(t, ss.LastCallErrorTime.After(lastErrTime), "expected time of the last call error to advance")
+		lastErrTime = ss.LastCallErrorTime
+	}
+
+	// Calls ended by the querier, like those past the query deadline, are not errors of the store.
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	_, err = proxy.LabelValues(ctx, &storepb.LabelValuesRequest{Label: "a"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, lastErrTime, storeSet.GetStoreStatus()[0].LastCallErrorTime)
+
+	// Last call error is kept over health checks.
+	storeSet.Update(context.Background())
+	testutil.NotOk(t, storeSet.GetStoreStatus()[0].LastCallError)
+}
+
 func TestStoreSet_StaticStores_OneAvailable(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
@@ -269,26 +525,405 @@ func TestStoreSet_AllAvailable_BlockExtLsetDuplicates(t *testing.T) {
 	storeSet.Update(context.Background())
 	storeSet.Update(context.Background())
 
-	testutil.Assert(t, len(storeSet.stores) == 5-2, "all services should respond just fine, but we expect duplicates being blocked.")
+	testutil.Assert(t, len(storeSet.stores) == 5-1, "all services should respond just fine, but we expect duplicates being merged.")
 
 	// Sort result to be able to compare.
-
 	var existingStoreLabels [][]storepb.Label
 	for _, store := range storeSet.stores {
 		existingStoreLabels = append(existingStoreLabels, store.Labels())
 	}
 	sort.Slice(existingStoreLabels, func(i, j int) bool {
-		return len(existingStoreLabels[i]) > len(existingStoreLabels[j])
+		if len(existingStoreLabels[i]) != len(existingStoreLabels[j]) {
+			return len(existingStoreLabels[i]) > len(existingStoreLabels[j])
+		}
+		return len(existingStoreLabels[i]) > 0 && existingStoreLabels[i][0].Value < existingStoreLabels[j][0].Value
 	})
 
-	var i int
-	for _, lset := range existingStoreLabels {
-		testutil.Equals(t, storeExtLabels[i], lset)
+	// Store 1 and 2 have the same identity, so only one of them should be kept.
+	testutil.Equals(t, [][]storepb.Label{storeExtLabels[0], storeExtLabels[1], nil, nil}, existingStoreLabels)
+}
+
+// seriesTestStore serves the given series responses and counts Series calls.
+type seriesTestStore struct {
+	testStore
+
+	resps []*storepb.SeriesResponse
+	calls int64
+}
+
+func (s *seriesTestStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	atomic.AddInt64(&s.calls, 1)
+	for _, resp := range s.resps {
+		if err := srv.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestStoreSet_MergeDuplicateStores(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// The same store discovered under two addresses, e.g. by DNS name and by IP.
+	st := &seriesTestStore{
+		testStore: testStore{info: storepb.InfoResponse{
+			Labels:    []storepb.Label{{Name: "ext", Value: "1"}},
+			StoreType: storepb.StoreType_SIDECAR,
+		}},
+		resps: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}, {2, 2}}),
+		},
+	}
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, st)
+	defer srv.Stop()
+
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		testutil.Ok(t, err)
+		go func() { _ = srv.Serve(listener) }()
+		addrs = append(addrs, listener.Addr().String())
+	}
+	sort.Strings(addrs)
+
+	var dials int64
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			atomic.AddInt64(&dials, 1)
+			return net.DialTimeout("tcp", addr, timeout)
+		}),
+	}, testGRPCOpts...)
+
+	discovered := addrs
+	storeSet := NewStoreSet(nil, nil, func() []StoreSpec { return specsFromAddrFunc(discovered)() }, dialOpts)
+	storeSet.gRPCInfoCallTimeout = 2 * time.Second
+	defer storeSet.Close()
+
+	storeSet.Update(context.Background())
+	storeSet.Update(context.Background())
+	testutil.Equals(t, 1, len(storeSet.Get()))
+	_, ok := storeSet.stores[addrs[0]]
+	testutil.Assert(t, ok, "store with the lowest address should be kept")
+	// Dropped duplicate is not dialed again while the kept store is in the set.
+	testutil.Equals(t, int64(2), atomic.LoadInt64(&dials))
+
+	for _, status := range storeSet.GetStoreStatus() {
+		if status.Name != addrs[1] {
+			continue
+		}
+		testutil.NotOk(t, status.LastError)
+		testutil.Equals(t, duplicateStoreMessage, status.LastError.Error())
+	}
+
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) {
+		return storeSet.Get(), nil
+	}, nil, store.StoreLimit{})
+	q, err := NewQueryableCreator(nil, proxy, "", QuerierOpts{})(false, 0, true, nil).Querier(context.Background(), 0, 100)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	var series int
+	for res.Next() {
+		series++
+		testutil.Equals(t, labels.FromStrings("a", "a"), res.At().Labels())
+		testutil.Equals(t, []sample{{1, 1}, {2, 2}}, expandSeries(t, res.At().Iterator()))
+	}
+	testutil.Ok(t, res.Err())
+	testutil.Equals(t, 1, series)
+	testutil.Equals(t, int64(1), atomic.LoadInt64(&st.calls))
+
+	// Once the kept store goes away, the duplicate is dialed again and takes its place.
+	discovered = addrs[1:]
+	storeSet.Update(context.Background())
+	testutil.Equals(t, 1, len(storeSet.Get()))
+	_, ok = storeSet.stores[addrs[1]]
+	testutil.Assert(t, ok, "duplicate should replace the removed store")
+	testutil.Equals(t, int64(3), atomic.LoadInt64(&dials))
+}
+
+func TestQueryable_ReusesStoreConnections(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	st, err := newTestStores(2)
+	testutil.Ok(t, err)
+	defer st.Close()
+
+	var dials int64
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			atomic.AddInt64(&dials, 1)
+			return net.DialTimeout("tcp", addr, timeout)
+		}),
+	}, testGRPCOpts...)
+
+	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc(st.StoreAddresses()), dialOpts)
+	storeSet.gRPCInfoCallTimeout = 2 * time.Second
+	defer storeSet.Close()
+
+	storeSet.Update(context.Background())
+	testutil.Equals(t, 2, len(storeSet.Get()))
+	testutil.Equals(t, int64(2), atomic.LoadInt64(&dials))
+
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) {
+		return storeSet.Get(), nil
+	}, nil, store.StoreLimit{})
+	queryable := NewQueryableCreator(nil, proxy, "", QuerierOpts{})(false, 0, true, nil)
+
+	for i := 0; i < 50; i++ {
+		q, err := queryable.Querier(context.Background(), 0, 100)
+		testutil.Ok(t, err)
+
+		res, _, err := q.Select(&storage.SelectParams{})
+		testutil.Ok(t, err)
+		for res.Next() {
+		}
+		testutil.Ok(t, res.Err())
+
+		// Closing one querier must not affect connections used by the next queries.
+		testutil.Ok(t, q.Close())
+
+		if i%10 == 0 {
+			storeSet.Update(context.Background())
+		}
+	}
+	testutil.Equals(t, 2, len(storeSet.Get()))
+	testutil.Equals(t, int64(2), atomic.LoadInt64(&dials))
+}
+
+// TestStoreRef_Update_ConcurrentReads runs store metadata refreshes concurrently with readers, like queries running
+// during storeSet.Update. Run with -race to catch unsynchronized access.
+func TestStoreRef_Update_ConcurrentReads(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	infos := []*storepb.InfoResponse{
+		{Labels: []storepb.Label{{Name: "a", Value: "1"}}, MinTime: 0, MaxTime: 100, SeriesEstimate: 10},
+		{Labels: []storepb.Label{{Name: "a", Value: "2"}}, MinTime: 200, MaxTime: 300, SeriesEstimate: 20, SupportsKnownChunks: true},
+	}
+	ref := &storeRef{addr: "store"}
+	ref.Update(infos[0])
+
+	var (
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			ref.Update(infos[i%2])
+		}
+		close(done)
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// Time range is updated at once with the rest of the metadata, so it is never mixed up.
+				mint, maxt := ref.TimeRange()
+				if (mint != infos[0].MinTime || maxt != infos[0].MaxTime) && (mint != infos[1].MinTime || maxt != infos[1].MaxTime) {
+					t.Errorf("unexpected time range %d-%d", mint, maxt)
+					return
+				}
+				if len(ref.Labels()) != 1 {
+					t.Errorf("unexpected labels %v", ref.Labels())
+					return
+				}
+				_ = ref.SeriesEstimate()
+				_ = ref.SupportsKnownChunks()
+				_ = externalLabelsFromStore(ref)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestStoreInterceptors_ObserveStoreCalls(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	st, err := newTestStores(2)
+	testutil.Ok(t, err)
+	defer st.Close()
 
-		i++
-		if i == 1 {
-			// Store 1 and 2 should be blocked, so fast forward.
-			i += 2
+	var (
+		mtx   sync.Mutex
+		calls []string
+	)
+	record := func(name string) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		calls = append(calls, name)
+	}
+	unary := func(name string) grpc.UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			record(name + " " + method)
+			return invoker(ctx, method, req, reply, cc, opts...)
 		}
 	}
+	stream := func(name string) grpc.StreamClientInterceptor {
+		return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			record(name + " " + method)
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+	}
+	dialOpts := append(StoreInterceptors{
+		Unary:  []grpc.UnaryClientInterceptor{unary("first"), unary("second")},
+		Stream: []grpc.StreamClientInterceptor{stream("first"), stream("second")},
+	}.DialOptions(), testGRPCOpts...)
+
+	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc(st.StoreAddresses()[:1]), dialOpts)
+	storeSet.gRPCInfoCallTimeout = 2 * time.Second
+	defer storeSet.Close()
+
+	storeSet.Update(context.Background())
+	testutil.Equals(t, 1, len(storeSet.Get()))
+
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) {
+		return storeSet.Get(), nil
+	}, nil, store.StoreLimit{})
+	q, err := NewQueryableCreator(nil, proxy, "", QuerierOpts{})(false, 0, true, nil).Querier(context.Background(), 0, 100)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	// Test store does not implement Series, so error is just reported as warning.
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	for res.Next() {
+	}
+	testutil.Ok(t, res.Err())
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	testutil.Equals(t, []string{
+		"first /thanos.Store/Info",
+		"second /thanos.Store/Info",
+		"first /thanos.Store/Series",
+		"second /thanos.Store/Series",
+	}, calls)
+}
+
+// grpcStoreClient is store.Client of a store connected through gRPC connection.
+type grpcStoreClient struct {
+	storepb.StoreClient
+	addr string
+}
+
+func (c grpcStoreClient) Labels() []storepb.Label             { return nil }
+func (c grpcStoreClient) TimeRange() (mint int64, maxt int64) { return math.MinInt64, math.MaxInt64 }
+func (c grpcStoreClient) String() string                      { return c.addr }
+func (c grpcStoreClient) Addr() string                        { return c.addr }
+func (c grpcStoreClient) SeriesEstimate() int64               { return 0 }
+func (c grpcStoreClient) SupportsKnownChunks() bool           { return false }
+
+func TestQuerier_Stats_Transfer(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", strings.Repeat("a", 1000)), []sample{{1, 1}, {2, 1}, {3, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", strings.Repeat("b", 1000)), []sample{{1, 1}, {2, 1}, {3, 1}}),
+	}}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, proxy)
+	go func() { _ = srv.Serve(listener) }()
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), append(NewStoreTransferStats().DialOptions(), testGRPCOpts...)...)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, conn.Close()) }()
+
+	cl := grpcStoreClient{StoreClient: storepb.NewStoreClient(conn), addr: listener.Addr().String()}
+	p := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) {
+		return []store.Client{cl}, nil
+	}, nil, store.StoreLimit{})
+
+	q := newQuerier(context.Background(), nil, 1, 10, "", p, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	res, _, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	n := 0
+	for res.Next() {
+		n++
+	}
+	testutil.Ok(t, res.Err())
+	testutil.Equals(t, 2, n)
+
+	tr := q.Stats().Transfer
+	testutil.Assert(t, tr.Bytes > 2000, "expected uncompressed bytes to include label values, got %d", tr.Bytes)
+
+	// Other queriers using the same connection are not affected.
+	q2 := newQuerier(context.Background(), nil, 1, 10, "", p, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q2.Close()) }()
+	testutil.Equals(t, TransferStats{}, q2.Stats().Transfer)
+}
+
+func TestStoreTransferStats_HandleRPC(t *testing.T) {
+	s := NewStoreTransferStats()
+	tr := &transferStats{}
+	ctx := contextWithTransferStats(context.Background(), tr)
+
+	s.HandleRPC(ctx, &stats.InPayload{Client: true, Length: 100, WireLength: 40})
+	s.HandleRPC(ctx, &stats.InPayload{Client: true, Length: 50, WireLength: 30})
+	// Payloads sent by the querier and received by servers are not accounted.
+	s.HandleRPC(ctx, &stats.OutPayload{Client: true, Length: 1000, WireLength: 1000})
+	s.HandleRPC(ctx, &stats.InPayload{Client: false, Length: 1000, WireLength: 1000})
+	// Neither are payloads of requests made without a querier.
+	s.HandleRPC(context.Background(), &stats.InPayload{Client: true, Length: 1000, WireLength: 1000})
+
+	testutil.Equals(t, TransferStats{WireBytes: 70, Bytes: 150}, tr.get())
+}
+
+func TestStoreSet_SeriesRateLimit(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	st, err := newTestStores(2)
+	testutil.Ok(t, err)
+	defer st.Close()
+
+	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc(st.StoreAddresses()), testGRPCOpts)
+	storeSet.gRPCInfoCallTimeout = 2 * time.Second
+	storeSet.SetSeriesRateLimit(10, 1)
+	defer storeSet.Close()
+
+	storeSet.Update(context.Background())
+	stores := storeSet.Get()
+	testutil.Equals(t, 2, len(stores))
+	testutil.Equals(t, 10.0, promtestutil.ToFloat64(storeSet.seriesRateLimit))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Calls to the first store are paced to one per 100ms after the burst.
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := stores[0].Series(ctx, &storepb.SeriesRequest{})
+		testutil.Ok(t, err)
+	}
+	testutil.Assert(t, time.Since(start) >= 150*time.Millisecond, "expected calls to be paced, took %v", time.Since(start))
+
+	// The other store has its own limit, so it is not affected.
+	start = time.Now()
+	_, err = stores[1].Series(ctx, &storepb.SeriesRequest{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, time.Since(start) < 50*time.Millisecond, "expected call not to be throttled, took %v", time.Since(start))
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(storeSet.seriesThrottledCalls))
+
+	// Waiting calls give up when their context is done.
+	waitCtx, waitCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer waitCancel()
+	_, err = stores[0].Series(waitCtx, &storepb.SeriesRequest{})
+	testutil.NotOk(t, err)
+	testutil.Equals(t, context.DeadlineExceeded, errors.Cause(err))
 }
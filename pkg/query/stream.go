@@ -0,0 +1,447 @@
+package query
+
+import (
+	"context"
+	"sort"
+
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/tracing"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// SelectStream selects series like Select, but calls f for every merged and deduplicated series as soon as it is
+// complete instead of holding all of them, so consumers like exports can write each series out right away. Series are
+// passed to f while stores are still sending the following ones, and stores are not read while f runs, so a slow
+// consumer slows down stores instead of piling up data. The iterator is valid only during the call. An error returned
+// by f stops the select and is returned as it is.
+//
+// Without deduplication only a single series is held at a time. Deduplicated series are held until the stream passes
+// all series with the same labels sorted before the replica label, as only those may be ordered between replicas of a
+// series. If the replica label is matched ignoring case, renamed labels break that order and all series are held
+// until the end. Store health series, downsampled gap filling, shadow deduplication, grouping, series order
+// and skipping deduplication by matchers are not supported.
+func (q *querier) SelectStream(params *storage.SelectParams, f func(labels.Labels, storage.SeriesIterator) error, ms ...*labels.Matcher) error {
+	if q.rangeErr != nil {
+		return q.rangeErr
+	}
+	if err := q.checkQueryRange(); err != nil {
+		return err
+	}
+	if err := q.checkRequiredLabels(ms); err != nil {
+		return err
+	}
+
+	q.reportRangeWarning()
+	if q.expired {
+		return nil
+	}
+
+	span, ctx := tracing.StartSpan(q.ctx, "querier_select_stream")
+	defer span.Finish()
+
+	// Stores must stop sending as soon as the select fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sms, err := translateMatchers(simplifyMatchers(ms)...)
+	if err != nil {
+		return errors.Wrap(err, "convert matchers")
+	}
+
+	queryAggrs, resAggr := aggrsFromFunc(params.Func)
+
+	srv := &streamSeriesServer{
+		seriesServer: seriesServer{ctx: ctx, partialResponse: q.partialResponse, duplicateLabels: q.duplicateLabels},
+		q:            q,
+		params:       params,
+		f:            f,
+		aggr:         resAggr,
+		smoothing:    q.dedupSmoothing,
+	}
+	if q.stepDownsampling {
+		srv.buckets = newStepBuckets(params, resAggr, q.step)
+	}
+	if resAggr == resAggrCounter {
+		srv.smoothing = 0
+	}
+
+	if err := q.proxy.Series(q.seriesRequest(params, sms, queryAggrs), srv); err != nil {
+		if srv.err != nil {
+			return srv.err
+		}
+		return errors.Wrap(err, "proxy Series()")
+	}
+	q.queriedBlocks.add(srv.queriedBlocks)
+	if err := q.addChunkBytes(srv.chunkBytes); err != nil {
+		srv.warnings = append(srv.warnings, err.Error())
+	}
+
+	if err := srv.flush(true); err != nil {
+		return err
+	}
+	if err := srv.process(srv.pending); err != nil {
+		return err
+	}
+	srv.warnings = append(srv.warnings, q.flaggedWarnings(&srv.seriesServer)...)
+
+	for _, w := range srv.warnings {
+		q.warningReporter(errors.New(w))
+	}
+	return nil
+}
+
+// StreamRaw calls f for every merged series selected by the given matchers within the given time range, limited to the
+// time range of the querier, with raw chunks exactly as received from stores. Chunks are never decoded, so tools
+// processing chunks directly, like re-compaction, pay no decoding cost. Series are streamed like by SelectStream and
+// the series passed to f is valid only during the call. An error returned by f stops the stream and is returned as
+// it is.
+//
+// With deduplication, replicas of a series are deduplicated by chunk time ranges only: a single series without the
+// replica label is passed to f, holding chunks of the replica sorted first by the replica label value, with gaps filled
+// by chunks of other replicas not overlapping them, as chunks of replicas can't be merged without decoding them.
+func (q *querier) StreamRaw(ms []*labels.Matcher, mint, maxt int64, f func(storepb.Series) error) error {
+	if q.rangeErr != nil {
+		return q.rangeErr
+	}
+	if err := q.checkQueryRange(); err != nil {
+		return err
+	}
+	if err := q.checkRequiredLabels(ms); err != nil {
+		return err
+	}
+	if mint < q.mint {
+		mint = q.mint
+	}
+	if maxt > q.maxt {
+		maxt = q.maxt
+	}
+	if maxt < mint {
+		return nil
+	}
+
+	q.reportRangeWarning()
+	if q.expired {
+		return nil
+	}
+
+	span, ctx := tracing.StartSpan(q.ctx, "querier_stream_raw")
+	defer span.Finish()
+
+	// Stores must stop sending as soon as the stream fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sms, err := translateMatchers(simplifyMatchers(ms)...)
+	if err != nil {
+		return errors.Wrap(err, "convert matchers")
+	}
+
+	params := &storage.SelectParams{Start: mint, End: maxt}
+	srv := &streamSeriesServer{
+		seriesServer: seriesServer{ctx: ctx, partialResponse: q.partialResponse, duplicateLabels: q.duplicateLabels},
+		q:            q,
+		params:       params,
+		raw:          f,
+	}
+
+	req := q.seriesRequest(params, sms, []storepb.Aggr{storepb.Aggr_RAW})
+	req.MinTime, req.MaxTime = mint, maxt
+	// Chunks are passed to the callback as received, so they must not be compressed.
+	req.AcceptCompressedChunks = false
+	if err := q.proxy.Series(req, srv); err != nil {
+		if srv.err != nil {
+			return srv.err
+		}
+		return errors.Wrap(err, "proxy Series()")
+	}
+	q.queriedBlocks.add(srv.queriedBlocks)
+	if err := q.addChunkBytes(srv.chunkBytes); err != nil {
+		srv.warnings = append(srv.warnings, err.Error())
+	}
+
+	if err := srv.flush(true); err != nil {
+		return err
+	}
+	if err := srv.process(srv.pending); err != nil {
+		return err
+	}
+	srv.warnings = append(srv.warnings, q.flaggedWarnings(&srv.seriesServer)...)
+
+	for _, w := range srv.warnings {
+		q.warningReporter(errors.New(w))
+	}
+	return nil
+}
+
+// StreamUnmerged calls f for every series selected by the given matchers within the given time range, limited to the
+// time range of the querier, as received from each store, with raw chunks. Series are not merged across stores nor
+// deduplicated, so the querier never holds series of multiple stores at once and consumers merging series on their
+// own, like streaming aggregation pipelines, don't pay for merging twice. The store passed to f is the address of the
+// store the series was received from. It requires the querier to fetch series from a store.ProxyStore in-process.
+//
+// Series are passed store after store. Within a store, they are passed in the order the store sent them, which is
+// sorted by labels for stores following the StoreAPI, each with all chunks the store sent for its labels. The same
+// labels may be passed for multiple stores, see store.ContextWithUnmergedSeries for details. The series passed to f
+// is valid only during the call. An error returned by f stops the stream and is returned as it is.
+func (q *querier) StreamUnmerged(ms []*labels.Matcher, mint, maxt int64, f func(store string, s storepb.Series) error) error {
+	if q.rangeErr != nil {
+		return q.rangeErr
+	}
+	if err := q.checkQueryRange(); err != nil {
+		return err
+	}
+	if err := q.checkRequiredLabels(ms); err != nil {
+		return err
+	}
+	if mint < q.mint {
+		mint = q.mint
+	}
+	if maxt > q.maxt {
+		maxt = q.maxt
+	}
+	if maxt < mint {
+		return nil
+	}
+
+	q.reportRangeWarning()
+	if q.expired {
+		return nil
+	}
+
+	span, ctx := tracing.StartSpan(q.ctx, "querier_stream_unmerged")
+	defer span.Finish()
+
+	// Stores must stop sending as soon as the stream fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sms, err := translateMatchers(simplifyMatchers(ms)...)
+	if err != nil {
+		return errors.Wrap(err, "convert matchers")
+	}
+
+	var (
+		chunkBytes int64
+		ferr       error
+	)
+	pass := func(st string, s storepb.Series) error {
+		for _, c := range s.Chunks {
+			chunkBytes += int64(c.Size())
+		}
+		if err := f(st, s); err != nil {
+			ferr = err
+			return err
+		}
+		return nil
+	}
+	srv := &seriesServer{ctx: store.ContextWithUnmergedSeries(ctx, pass), partialResponse: q.partialResponse}
+
+	params := &storage.SelectParams{Start: mint, End: maxt}
+	req := q.seriesRequest(params, sms, []storepb.Aggr{storepb.Aggr_RAW})
+	req.MinTime, req.MaxTime = mint, maxt
+	// Chunks are passed to the callback as received, so they must not be compressed.
+	req.AcceptCompressedChunks = false
+	if err := q.proxy.Series(req, srv); err != nil {
+		if ferr != nil {
+			return ferr
+		}
+		return errors.Wrap(err, "proxy Series()")
+	}
+	q.queriedBlocks.add(srv.queriedBlocks)
+	if err := q.addChunkBytes(chunkBytes); err != nil {
+		srv.warnings = append(srv.warnings, err.Error())
+	}
+
+	for _, w := range srv.warnings {
+		q.warningReporter(errors.New(w))
+	}
+	return nil
+}
+
+// streamSeriesServer passes complete series received from the proxy to the callback of SelectStream or StreamRaw from
+// Send, so the proxy waits for the callback before sending more.
+type streamSeriesServer struct {
+	seriesServer
+
+	q      *querier
+	params *storage.SelectParams
+	f      func(labels.Labels, storage.SeriesIterator) error
+	// Callback of StreamRaw, used instead of f if set.
+	raw       func(storepb.Series) error
+	aggr      resAggr
+	buckets   stepBuckets
+	smoothing float64
+
+	// Complete series waiting for more replicas to be deduplicated with.
+	pending []storepb.Series
+	// Error of processing series, returned by SelectStream or StreamRaw instead of the proxy error wrapping it.
+	err error
+}
+
+func (s *streamSeriesServer) Send(r *storepb.SeriesResponse) error {
+	if err := s.seriesServer.Send(r); err != nil {
+		return err
+	}
+	if err := s.flush(false); err != nil {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+// flush processes complete received series. Stores send chunks of a series in consecutive responses, so all received
+// series but the last one are complete, unless the stream is done.
+func (s *streamSeriesServer) flush(done bool) error {
+	n := len(s.seriesSet)
+	if !done {
+		n--
+	}
+	if n <= 0 {
+		return nil
+	}
+	var (
+		last []storepb.Series
+		next []storepb.Label
+	)
+	if n < len(s.seriesSet) {
+		last = append(last, s.seriesSet[n])
+		next = last[0].Labels
+	}
+	s.seriesSet = s.seriesSet[:n]
+	if s.q.maxSeriesChunks > 0 {
+		s.limitChunks(s.q.maxSeriesChunks, s.q.excessChunks)
+	}
+	err := s.add(s.seriesSet, next)
+	s.seriesSet = append(s.seriesSet[:0], last...)
+	return err
+}
+
+// add processes the given complete series, holding deduplicated ones until all their replicas are received. Labels
+// of the series received after them tell which replicas may still follow, nil if the stream is done.
+func (s *streamSeriesServer) add(series []storepb.Series, next []storepb.Label) error {
+	if !s.q.isDedupEnabled() {
+		return s.process(series)
+	}
+	s.pending = append(s.pending, series...)
+	if s.q.replicaIgnoreCase || next == nil {
+		return nil
+	}
+	var ready, pending []storepb.Series
+	for _, p := range s.pending {
+		if awaitsReplicas(p.Labels, next, s.q.replicaLabel) {
+			pending = append(pending, p)
+			continue
+		}
+		ready = append(ready, p)
+	}
+	s.pending = pending
+	return s.process(ready)
+}
+
+// awaitsReplicas returns true if replicas of the deduplicated series of p may follow the series with labels next in
+// the stream ordered by labels. Replicas share labels sorted before the replica label and series ordered between
+// them start with those labels too.
+func awaitsReplicas(p, next []storepb.Label, replicaLabel string) bool {
+	for i, l := range p {
+		if l.Name >= replicaLabel {
+			return true
+		}
+		if i >= len(next) || next[i].Name != l.Name || next[i].Value != l.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// process deduplicates the given series, if enabled, and passes them to the callback.
+func (s *streamSeriesServer) process(series []storepb.Series) error {
+	if s.q.maxStaleness > 0 {
+		// All replicas of the series are received.
+		series = s.q.limitStaleness(series, &s.staleSeries)
+	}
+	if len(series) == 0 {
+		return nil
+	}
+	if s.raw != nil {
+		return s.processRaw(series)
+	}
+	var set storage.SeriesSet
+	if s.q.isDedupEnabled() {
+		if s.q.replicaIgnoreCase {
+			normalizeReplicaLabelName(series, s.q.replicaLabel)
+		}
+		sortDedupLabels(series, s.q.replicaLabel, storepb.CompareLabels)
+		set = newDedupSeriesSet(s.q.promSeriesSet(s.params, series, s.aggr, s.buckets), s.q.replicaLabel, s.q.dedupStrategy, s.smoothing, s.q.lookbackDelta, s.q.stats, s.q.replicaPriority)
+	} else {
+		set = s.q.promSeriesSet(s.params, series, s.aggr, s.buckets)
+	}
+	if len(s.q.relabelConfigs) > 0 {
+		set = newRelabeledSeriesSet(set, s.q.relabelConfigs)
+	}
+	for set.Next() {
+		series := set.At()
+		if err := s.f(series.Labels(), series.Iterator()); err != nil {
+			return err
+		}
+	}
+	return set.Err()
+}
+
+// processRaw deduplicates the given series by labels, if enabled, and passes them to the raw callback.
+func (s *streamSeriesServer) processRaw(series []storepb.Series) error {
+	if !s.q.isDedupEnabled() {
+		for _, series := range series {
+			if err := s.raw(series); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if s.q.replicaIgnoreCase {
+		normalizeReplicaLabelName(series, s.q.replicaLabel)
+	}
+	sortDedupLabels(series, s.q.replicaLabel, storepb.CompareLabels)
+
+	var cur *storepb.Series
+	pass := func() error {
+		if cur == nil {
+			return nil
+		}
+		sort.Slice(cur.Chunks, func(i, j int) bool { return cur.Chunks[i].MinTime < cur.Chunks[j].MinTime })
+		return s.raw(*cur)
+	}
+	for _, series := range series {
+		// The replica label is sorted last.
+		lset := series.Labels
+		if n := len(lset); n > 0 && lset[n-1].Name == s.q.replicaLabel {
+			lset = lset[:n-1]
+		}
+		if cur != nil && storepb.CompareLabels(cur.Labels, lset) == 0 {
+			cur.Chunks = fillChunkGaps(cur.Chunks, series.Chunks)
+			continue
+		}
+		if err := pass(); err != nil {
+			return err
+		}
+		cur = &storepb.Series{Labels: lset, Chunks: append([]storepb.AggrChunk(nil), series.Chunks...)}
+	}
+	return pass()
+}
+
+// fillChunkGaps returns the chunks with chunks of another replica added where they don't overlap any of them, so gaps
+// in data of a replica are filled without decoding chunks.
+func fillChunkGaps(chks, other []storepb.AggrChunk) []storepb.AggrChunk {
+other:
+	for _, o := range other {
+		for _, c := range chks {
+			if o.MinTime <= c.MaxTime && o.MaxTime >= c.MinTime {
+				continue other
+			}
+		}
+		chks = append(chks, o)
+	}
+	return chks
+}
@@ -0,0 +1,239 @@
+package query
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// blockingStoreServer sends the given responses, but waits for unblock before sending the ones from the given index.
+type blockingStoreServer struct {
+	storeServer
+
+	blockAt int
+	unblock chan struct{}
+}
+
+func (s *blockingStoreServer) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	for i, resp := range s.resps {
+		if i == s.blockAt {
+			select {
+			case <-s.unblock:
+			case <-srv.Context().Done():
+				return srv.Context().Err()
+			}
+		}
+		if err := srv.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestQuerier_SelectStream(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Responses are ordered by labels as the proxy sends them, so replicas of series {a="1", z="1"} are not
+	// consecutive. Series {a="2"} is split into two responses.
+	resps := []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "A"), []sample{{1, 1}, {2, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "A", "z", "1"), []sample{{1, 3}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "B"), []sample{{1, 1}, {2, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "B", "z", "1"), []sample{{1, 3}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "A"), []sample{{1, 4}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "A"), []sample{{2, 5}}),
+	}
+
+	for _, tcase := range []struct {
+		dedup bool
+		exp   []labels.Labels
+	}{
+		{
+			dedup: true,
+			exp: []labels.Labels{
+				labels.FromStrings("a", "1"),
+				labels.FromStrings("a", "1", "z", "1"),
+				labels.FromStrings("a", "2"),
+			},
+		},
+		{
+			dedup: false,
+			exp: []labels.Labels{
+				labels.FromStrings("a", "1", "replica", "A"),
+				labels.FromStrings("a", "1", "replica", "A", "z", "1"),
+				labels.FromStrings("a", "1", "replica", "B"),
+				labels.FromStrings("a", "1", "replica", "B", "z", "1"),
+				labels.FromStrings("a", "2", "replica", "A"),
+			},
+		},
+	} {
+		// The store sends the rest of the last series only after the first one was passed to the callback, so the select
+		// deadlocks if it holds all series until the store is done.
+		proxy := &blockingStoreServer{storeServer: storeServer{resps: resps}, blockAt: 5, unblock: make(chan struct{})}
+
+		q := newQuerier(context.Background(), nil, 1, math.MaxInt64, "replica", proxy, tcase.dedup, 0, true, nil, QuerierOpts{})
+
+		var (
+			got     []labels.Labels
+			samples []sample
+		)
+		testutil.Ok(t, q.SelectStream(&storage.SelectParams{}, func(lset labels.Labels, it storage.SeriesIterator) error {
+			if len(got) == 0 {
+				close(proxy.unblock)
+			}
+			got = append(got, lset)
+			samples = expandSeries(t, it)
+			return nil
+		}))
+		testutil.Equals(t, tcase.exp, got)
+		// The split series is passed as a single one.
+		testutil.Equals(t, []sample{{1, 4}, {2, 5}}, samples)
+		testutil.Ok(t, q.Close())
+	}
+}
+
+func TestQuerier_SelectStream_CallbackError(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	proxy := &storeServer{resps: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{1, 2}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "3"), []sample{{1, 3}}),
+	}}
+	q := newQuerier(context.Background(), nil, 1, math.MaxInt64, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	errStop := errors.New("stop")
+	calls := 0
+	err := q.SelectStream(&storage.SelectParams{}, func(labels.Labels, storage.SeriesIterator) error {
+		calls++
+		return errStop
+	})
+	testutil.Equals(t, errStop, err)
+	testutil.Equals(t, 1, calls)
+}
+
+func TestQuerier_StreamRaw(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	resps := []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "A"), []sample{{1, 1}, {2, 2}}, []sample{{3, 3}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "B"), []sample{{1, 1}, {2, 2}, {3, 3}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "B"), []sample{{1, 4}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "B"), []sample{{2, 5}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "3", "replica", "A"), []sample{{1, 1}, {2, 2}}, []sample{{8, 8}, {9, 9}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "3", "replica", "B"), []sample{{1, 1}, {3, 3}}, []sample{{5, 5}, {6, 6}}, []sample{{8, 8}}),
+	}
+	// The querier reorders labels of received series in place, so keep copies of the sent series to compare with.
+	var exp []storepb.Series
+	for _, r := range resps {
+		b, err := r.Marshal()
+		testutil.Ok(t, err)
+		var c storepb.SeriesResponse
+		testutil.Ok(t, c.Unmarshal(b))
+		exp = append(exp, *c.GetSeries())
+	}
+
+	for _, tcase := range []struct {
+		dedup bool
+		exp   []storepb.Series
+	}{
+		{
+			dedup: true,
+			exp: []storepb.Series{
+				// Chunks of the first replica are kept as they are.
+				{Labels: []storepb.Label{{Name: "a", Value: "1"}}, Chunks: exp[0].Chunks},
+				{Labels: []storepb.Label{{Name: "a", Value: "2"}}, Chunks: append(exp[2].Chunks, exp[3].Chunks...)},
+				// Gap of the first replica is filled by the chunk of the other replica not overlapping its chunks.
+				{Labels: []storepb.Label{{Name: "a", Value: "3"}}, Chunks: []storepb.AggrChunk{exp[4].Chunks[0], exp[5].Chunks[1], exp[4].Chunks[1]}},
+			},
+		},
+		{
+			dedup: false,
+			exp: []storepb.Series{
+				exp[0],
+				exp[1],
+				{Labels: exp[2].Labels, Chunks: append(exp[2].Chunks, exp[3].Chunks...)},
+				exp[4],
+				exp[5],
+			},
+		},
+	} {
+		proxy := &storeServer{resps: resps}
+		q := newQuerier(context.Background(), nil, 1, 100, "replica", proxy, tcase.dedup, 0, true, nil, QuerierOpts{})
+
+		var got []storepb.Series
+		testutil.Ok(t, q.StreamRaw(nil, 0, 10, func(s storepb.Series) error {
+			got = append(got, s)
+			return nil
+		}))
+		testutil.Equals(t, tcase.exp, got)
+		// Range is limited to the querier one and only raw chunks are requested.
+		testutil.Equals(t, int64(1), proxy.lastReq.MinTime)
+		testutil.Equals(t, int64(10), proxy.lastReq.MaxTime)
+		testutil.Equals(t, []storepb.Aggr{storepb.Aggr_RAW}, proxy.lastReq.Aggregates)
+		testutil.Ok(t, q.Close())
+	}
+}
+
+func TestQuerier_StreamUnmerged(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	store1 := []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}),
+		// Store splits the series into consecutive responses.
+		storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{2, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{1, 2}}),
+	}
+	store2 := []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{3, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "3"), []sample{{1, 3}}),
+	}
+	clients := []store.Client{
+		store.NewLocalClient(&rangeStoreServer{storeServer: storeServer{resps: store1}, mint: math.MinInt64, maxt: math.MaxInt64}, "store-1"),
+		store.NewLocalClient(&rangeStoreServer{storeServer: storeServer{resps: store2}, mint: math.MinInt64, maxt: math.MaxInt64}, "store-2"),
+	}
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) { return clients, nil }, nil, store.StoreLimit{})
+
+	q := newQuerier(context.Background(), nil, 1, 10, "", proxy, false, 0, true, nil, QuerierOpts{})
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	type group struct {
+		store  string
+		labels []storepb.Label
+		chunks []storepb.AggrChunk
+	}
+	var got []group
+	testutil.Ok(t, q.StreamUnmerged(nil, 0, 10, func(st string, s storepb.Series) error {
+		got = append(got, group{store: st, labels: s.Labels, chunks: s.Chunks})
+		return nil
+	}))
+
+	// Series are passed store after store and the same series of both stores is not merged. Each series holds all
+	// chunks its store sent for its labels.
+	exp := []group{
+		{store: "store-1", labels: store1[0].GetSeries().Labels, chunks: append(store1[0].GetSeries().Chunks, store1[1].GetSeries().Chunks...)},
+		{store: "store-1", labels: store1[2].GetSeries().Labels, chunks: store1[2].GetSeries().Chunks},
+		{store: "store-2", labels: store2[0].GetSeries().Labels, chunks: store2[0].GetSeries().Chunks},
+		{store: "store-2", labels: store2[1].GetSeries().Labels, chunks: store2[1].GetSeries().Chunks},
+	}
+	testutil.Equals(t, exp, got)
+
+	// Error of the callback stops the stream and is returned as it is.
+	errStop := errors.New("stop")
+	calls := 0
+	testutil.Equals(t, errStop, q.StreamUnmerged(nil, 0, 10, func(string, storepb.Series) error {
+		calls++
+		return errStop
+	}))
+	testutil.Equals(t, 1, calls)
+}
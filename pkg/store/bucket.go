@@ -418,14 +418,56 @@ func (s *BucketStore) TimeRange() (mint, maxt int64) {
 	return mint, maxt
 }
 
+// SeriesEstimate returns estimated number of series in the store. Blocks mostly hold the same series over time, so
+// it is the number of series of the largest block.
+func (s *BucketStore) SeriesEstimate() int64 {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	var n uint64
+	for _, b := range s.blocks {
+		if b.meta.Stats.NumSeries > n {
+			n = b.meta.Stats.NumSeries
+		}
+	}
+	return int64(n)
+}
+
 // Info implements the storepb.StoreServer interface.
-func (s *BucketStore) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+func (s *BucketStore) Info(_ context.Context, r *storepb.InfoRequest) (*storepb.InfoResponse, error) {
 	mint, maxt := s.TimeRange()
 	// Store nodes hold global data and thus have no labels.
-	return &storepb.InfoResponse{
-		MinTime: mint,
-		MaxTime: maxt,
-	}, nil
+	res := &storepb.InfoResponse{
+		MinTime:        mint,
+		MaxTime:        maxt,
+		SeriesEstimate: s.SeriesEstimate(),
+		StoreType:      storepb.StoreType_STORE,
+		// Chunks listed as known by Series requests are sent without data.
+		SupportsKnownChunks: true,
+	}
+	if r.ReportStats {
+		res.Stats = s.stats()
+	}
+	return res, nil
+}
+
+// bytesPerSampleEstimate is the estimated size of a sample in chunks. Prometheus chunks take 1-2 bytes per sample on
+// average, the upper bound is used as the estimate is meant for capacity planning.
+const bytesPerSampleEstimate = 2
+
+// stats returns totals of data in all loaded blocks. Size of the data is estimated from the number of samples.
+func (s *BucketStore) stats() *storepb.StoreStats {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	res := &storepb.StoreStats{NumBlocks: int64(len(s.blocks))}
+	for _, b := range s.blocks {
+		res.NumSeries += int64(b.meta.Stats.NumSeries)
+		res.NumChunks += int64(b.meta.Stats.NumChunks)
+		res.NumSamples += int64(b.meta.Stats.NumSamples)
+	}
+	res.NumBytes = res.NumSamples * bytesPerSampleEstimate
+	return res
 }
 
 type seriesEntry struct {
@@ -471,6 +513,7 @@ func (s *BucketStore) blockSeries(
 	chunkr *bucketChunkReader,
 	matchers []labels.Matcher,
 	req *storepb.SeriesRequest,
+	known map[knownChunk]struct{},
 ) (storepb.SeriesSet, *queryStats, error) {
 	ps, err := indexr.ExpandedPostings(matchers)
 	if err != nil {
@@ -524,6 +567,14 @@ func (s *BucketStore) blockSeries(
 		sort.Slice(s.lset, func(i, j int) bool {
 			return s.lset[i].Name < s.lset[j].Name
 		})
+		// Skip series of other shards before any of their chunks is loaded.
+		if !req.Shard.Matches(s.lset) {
+			continue
+		}
+		var ref uint64
+		if len(known) > 0 {
+			ref = storepb.LabelsHash(s.lset)
+		}
 
 		for _, meta := range chks {
 			if meta.MaxTime < req.MinTime {
@@ -533,6 +584,16 @@ func (s *BucketStore) blockSeries(
 				break
 			}
 
+			// Chunks the client holds are sent without data and never loaded.
+			if _, ok := known[knownChunk{ref: ref, timeRange: timeRange{mint: meta.MinTime, maxt: meta.MaxTime}}]; ok {
+				s.chks = append(s.chks, storepb.AggrChunk{
+					MinTime: meta.MinTime,
+					MaxTime: meta.MaxTime,
+				})
+				s.refs = append(s.refs, knownChunkRef)
+				continue
+			}
+
 			if err := chunkr.addPreload(meta.Ref); err != nil {
 				return nil, nil, errors.Wrap(err, "add chunk preload")
 			}
@@ -555,6 +616,9 @@ func (s *BucketStore) blockSeries(
 	// Transform all chunks into the response format.
 	for _, s := range res {
 		for i, ref := range s.refs {
+			if ref == knownChunkRef {
+				continue
+			}
 			chk, err := chunkr.Chunk(ref)
 			if err != nil {
 				return nil, nil, errors.Wrap(err, "get chunk")
@@ -568,6 +632,10 @@ func (s *BucketStore) blockSeries(
 	return newBucketSeriesSet(res), indexr.stats.merge(chunkr.stats), nil
 }
 
+// knownChunkRef marks chunks sent without data in place of their ref. Chunk segment files start with a header, so no
+// chunk has ref zero.
+const knownChunkRef = 0
+
 func populateChunk(out *storepb.AggrChunk, in chunkenc.Chunk, aggrs []storepb.Aggr) error {
 	if in.Encoding() == chunkenc.EncXOR {
 		out.Raw = &storepb.Chunk{Type: storepb.Chunk_XOR, Data: in.Bytes()}
@@ -661,10 +729,12 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
 	var (
-		stats = &queryStats{}
-		g     run.Group
-		res   []storepb.SeriesSet
-		mtx   sync.Mutex
+		stats   = &queryStats{}
+		g       run.Group
+		res     []storepb.SeriesSet
+		mtx     sync.Mutex
+		queried []string
+		known   = knownChunks(req)
 	)
 	s.mtx.RLock()
 
@@ -673,7 +743,7 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 		if !ok {
 			continue
 		}
-		blocks := bs.getFor(req.MinTime, req.MaxTime, req.MaxResolutionWindow)
+		blocks := bs.getFor(req.MinTime, req.MaxTime, resolutionFor(req))
 
 		if s.debugLogging {
 			debugFoundBlockSetOverview(s.logger, req.MinTime, req.MaxTime, bs.labels, blocks)
@@ -681,10 +751,17 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 
 		for _, b := range blocks {
 			stats.blocksQueried++
+			queried = append(queried, b.meta.ULID.String())
 
 			b := b
 			ctx, cancel := context.WithCancel(srv.Context())
 
+			// Only chunks of raw blocks are sent as they are, so downsampled blocks send all chunks.
+			blockKnown := known
+			if b.meta.Thanos.Downsample.Resolution > 0 {
+				blockKnown = nil
+			}
+
 			// We must keep the readers open until all their data has been sent.
 			indexr := b.indexReader(ctx)
 			chunkr := b.chunkReader(ctx)
@@ -701,6 +778,7 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 					chunkr,
 					blockMatchers,
 					req,
+					blockKnown,
 				)
 				if err != nil {
 					return errors.Wrapf(err, "fetch series for block %s", b.meta.ULID)
@@ -767,6 +845,12 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 		s.metrics.seriesMergeDuration.Observe(stats.mergeDuration.Seconds())
 	}
 
+	if req.ReportQueriedBlocks && len(queried) > 0 {
+		if err := srv.Send(storepb.NewQueriedBlocksSeriesResponse(queried)); err != nil {
+			return status.Error(codes.Unknown, errors.Wrap(err, "send queried blocks response").Error())
+		}
+	}
+
 	s.metrics.seriesDataTouched.WithLabelValues("postings").Observe(float64(stats.postingsTouched))
 	s.metrics.seriesDataFetched.WithLabelValues("postings").Observe(float64(stats.postingsFetched))
 	s.metrics.seriesDataSizeTouched.WithLabelValues("postings").Observe(float64(stats.postingsTouchedSizeSum))
@@ -830,9 +914,9 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 	if err := g.Wait(); err != nil {
 		return nil, status.Error(codes.Aborted, err.Error())
 	}
-	return &storepb.LabelValuesResponse{
-		Values: strutil.MergeSlices(sets...),
-	}, nil
+	resp := &storepb.LabelValuesResponse{}
+	resp.Values, resp.Truncated = limitLabelValues(strutil.MergeSlices(sets...), req.Limit)
+	return resp, nil
 }
 
 // bucketBlockSet holds all blocks of an equal label set. It internally splits
@@ -901,6 +985,21 @@ func int64index(s []int64, x int64) int {
 	return -1
 }
 
+// resolutionFor returns the resolution of blocks queried for the request, honoring its chunk encoding preference.
+// Aggregates are preferred by querying the lowest downsampled resolution, which still falls back to raw blocks for
+// data not downsampled yet, e.g. recent data.
+func resolutionFor(req *storepb.SeriesRequest) int64 {
+	switch req.ChunkEncoding {
+	case storepb.SeriesRequest_RAW:
+		return downsample.ResLevel0
+	case storepb.SeriesRequest_AGGREGATED:
+		if req.MaxResolutionWindow < downsample.ResLevel1 {
+			return downsample.ResLevel1
+		}
+	}
+	return req.MaxResolutionWindow
+}
+
 // getFor returns a time-ordered list of blocks that cover date between mint and maxt.
 // Blocks with the lowest resolution possible but not lower than the given resolution are returned.
 func (s *bucketBlockSet) getFor(mint, maxt, minResolution int64) (bs []*bucketBlock) {
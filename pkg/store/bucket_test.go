@@ -7,6 +7,7 @@ import (
 	"github.com/fortytw2/leaktest"
 	"github.com/improbable-eng/thanos/pkg/block/metadata"
 	"github.com/improbable-eng/thanos/pkg/compact/downsample"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/testutil"
 	"github.com/oklog/ulid"
 	"github.com/prometheus/tsdb/labels"
@@ -141,6 +142,22 @@ func TestBucketBlockSet_remove(t *testing.T) {
 	testutil.Equals(t, input[2].id, res[1].meta.ULID)
 }
 
+func TestResolutionFor(t *testing.T) {
+	for i, c := range []struct {
+		encoding  storepb.SeriesRequest_ChunkEncoding
+		maxWindow int64
+		exp       int64
+	}{
+		{encoding: storepb.SeriesRequest_ANY, maxWindow: downsample.ResLevel1, exp: downsample.ResLevel1},
+		{encoding: storepb.SeriesRequest_RAW, maxWindow: downsample.ResLevel2, exp: downsample.ResLevel0},
+		{encoding: storepb.SeriesRequest_AGGREGATED, maxWindow: downsample.ResLevel0, exp: downsample.ResLevel1},
+		{encoding: storepb.SeriesRequest_AGGREGATED, maxWindow: downsample.ResLevel2, exp: downsample.ResLevel2},
+	} {
+		t.Logf("case %d", i)
+		testutil.Equals(t, c.exp, resolutionFor(&storepb.SeriesRequest{MaxResolutionWindow: c.maxWindow, ChunkEncoding: c.encoding}))
+	}
+}
+
 func TestBucketBlockSet_labelMatchers(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+)
+
+type storeChunkBytesLimitKey struct{}
+
+// ContextWithStoreChunkBytesLimit returns a new context.Context that makes ProxyStore stop receiving series from a
+// store once chunks received from it for a Series request made with it exceed limit bytes, across all shards of the
+// request to the store. It protects the querier from a single pathological store dominating bandwidth. If partial
+// response is enabled, series received from the store before are kept and a warning is returned. Otherwise the
+// request fails. Zero disables the limit.
+func ContextWithStoreChunkBytesLimit(ctx context.Context, limit int64) context.Context {
+	return context.WithValue(ctx, storeChunkBytesLimitKey{}, limit)
+}
+
+func storeChunkBytesLimitFromContext(ctx context.Context) int64 {
+	v, _ := ctx.Value(storeChunkBytesLimitKey{}).(int64)
+	return v
+}
+
+// chunkBytesBudget counts bytes of chunks received from a single store. It is safe to use concurrently.
+type chunkBytesBudget struct {
+	store string
+	limit int64
+	used  int64
+}
+
+// add records n more bytes received, returning an error if the budget is exceeded.
+func (b *chunkBytesBudget) add(n int64) error {
+	if atomic.AddInt64(&b.used, n) > b.limit {
+		return chunkBytesLimitErr{store: b.store, limit: b.limit}
+	}
+	return nil
+}
+
+// chunkBytesLimitErr is returned when a store exceeds its chunk bytes budget. Retrying the request would exceed it
+// again, so it is never retried.
+type chunkBytesLimitErr struct {
+	store string
+	limit int64
+}
+
+func (e chunkBytesLimitErr) Error() string {
+	return fmt.Sprintf("store %s sent more than %d bytes of chunks, the limit per store", e.store, e.limit)
+}
+
+// chunkBytesLimitSeriesClient fails receiving once chunks received exceed its budget.
+type chunkBytesLimitSeriesClient struct {
+	storepb.Store_SeriesClient
+	budget *chunkBytesBudget
+}
+
+func (c *chunkBytesLimitSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	r, err := c.Store_SeriesClient.Recv()
+	if err != nil {
+		return r, err
+	}
+	if s := r.GetSeries(); s != nil {
+		var n int
+		for _, chk := range s.Chunks {
+			n += chk.Size()
+		}
+		if err := c.budget.add(int64(n)); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,462 @@
+package store
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/simplelru"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/tsdb/labels"
+)
+
+// maxKnownChunks caps the number of cached chunks listed in a single Series request, so requests stay small.
+const maxKnownChunks = 10000
+
+// knownChunk identifies a raw chunk listed as known by a Series request.
+type knownChunk struct {
+	ref uint64
+	timeRange
+}
+
+// knownChunks returns chunks listed as known by the request, or nil if there are none.
+func knownChunks(req *storepb.SeriesRequest) map[knownChunk]struct{} {
+	if len(req.KnownChunks) == 0 {
+		return nil
+	}
+	res := make(map[knownChunk]struct{}, len(req.KnownChunks))
+	for _, c := range req.KnownChunks {
+		res[knownChunk{ref: c.SeriesRef, timeRange: timeRange{mint: c.MinTime, maxt: c.MaxTime}}] = struct{}{}
+	}
+	return res
+}
+
+// chunkCacheKey identifies a cached chunk within chunks of a store.
+type chunkCacheKey struct {
+	ref uint64
+	timeRange
+}
+
+type cachedChunk struct {
+	data  []byte
+	added time.Time
+}
+
+// pinnedChunk is data of a cached chunk listed as known by a request, with labels of its series. Refs are hashes of
+// labels, so labels of series the store omitted the chunk of are compared to them before the data is used.
+type pinnedChunk struct {
+	lset []storepb.Label
+	data []byte
+}
+
+// cachedSeries holds labels of a series with cached chunks and time ranges of the chunks, so chunks of series
+// matching a request can be found without the store. Chunks of other series with the same ref, i.e. colliding hash
+// of labels, are not cached.
+type cachedSeries struct {
+	lset   []storepb.Label
+	name   string
+	chunks map[timeRange]struct{}
+}
+
+// storeChunkCache holds cached chunks of a single store, so requests to different stores don't contend on a lock.
+type storeChunkCache struct {
+	mtx    sync.Mutex
+	lru    *lru.LRU
+	series map[uint64]*cachedSeries
+	// Series by metric name, so requests selecting a metric by name don't match all series of the store.
+	byName map[string]map[uint64]*cachedSeries
+}
+
+// ChunkCache holds raw chunks fetched from stores by ProxyStore Series requests made with a context holding it, see
+// ContextWithChunkCache. Cached chunks of series matching a request are listed in it as known chunks, so stores
+// supporting them send only their time ranges and the data is taken from the cache. Only chunks ending before the
+// min age are cached, as more recent ones may still be appended to. Chunks are evicted after the TTL or when the
+// cache grows over its max size, least recently used chunks of the store adding chunks first.
+type ChunkCache struct {
+	// Accessed atomically, as chunks of each store are locked separately.
+	curSize int64
+	maxSize int64
+
+	mtx    sync.Mutex
+	stores map[string]*storeChunkCache
+
+	ttl    time.Duration
+	minAge time.Duration
+	now    func() time.Time
+
+	requests    prometheus.Counter
+	hits        prometheus.Counter
+	added       prometheus.Counter
+	evicted     prometheus.Counter
+	current     prometheus.Gauge
+	currentSize prometheus.Gauge
+}
+
+// NewChunkCache returns an empty ChunkCache holding up to maxBytes of chunk data, with metrics registered in the given
+// registerer.
+func NewChunkCache(reg prometheus.Registerer, maxBytes uint64, ttl, minAge time.Duration) (*ChunkCache, error) {
+	if maxBytes > math.MaxInt64 {
+		maxBytes = math.MaxInt64
+	}
+	c := &ChunkCache{
+		stores:  map[string]*storeChunkCache{},
+		maxSize: int64(maxBytes),
+		ttl:     ttl,
+		minAge:  minAge,
+		now:     time.Now,
+	}
+	c.requests = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_query_chunk_cache_requests_total",
+		Help: "Total number of raw chunks received from stores or served from the chunk cache.",
+	})
+	c.hits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_query_chunk_cache_hits_total",
+		Help: "Total number of raw chunks served from the chunk cache.",
+	})
+	c.added = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_query_chunk_cache_items_added_total",
+		Help: "Total number of chunks that were added to the chunk cache.",
+	})
+	c.evicted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_query_chunk_cache_items_evicted_total",
+		Help: "Total number of chunks that were evicted from the chunk cache.",
+	})
+	c.current = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_query_chunk_cache_items",
+		Help: "Current number of chunks in the chunk cache.",
+	})
+	c.currentSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_query_chunk_cache_items_size_bytes",
+		Help: "Current byte size of chunks in the chunk cache.",
+	})
+
+	if reg != nil {
+		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "thanos_query_chunk_cache_max_size_bytes",
+			Help: "Maximum number of bytes to be held in the chunk cache.",
+		}, func() float64 {
+			return float64(maxBytes)
+		}))
+		reg.MustRegister(c.requests, c.hits, c.added, c.evicted, c.current, c.currentSize)
+	}
+	return c, nil
+}
+
+// storeCache returns cached chunks of the store. If there are none, a new empty storeChunkCache is returned if create
+// is true, nil otherwise.
+func (c *ChunkCache) storeCache(store string, create bool) (*storeChunkCache, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if sc, ok := c.stores[store]; ok || !create {
+		return sc, nil
+	}
+	sc := &storeChunkCache{
+		series: map[uint64]*cachedSeries{},
+		byName: map[string]map[uint64]*cachedSeries{},
+	}
+	// Initialize LRU cache with a high size limit since we will manage evictions ourselves
+	// based on stored size.
+	onEvict := func(key, val interface{}) {
+		k := key.(chunkCacheKey)
+		v := val.(cachedChunk)
+
+		c.evicted.Inc()
+		c.current.Dec()
+		c.currentSize.Sub(float64(len(v.data)))
+		atomic.AddInt64(&c.curSize, -int64(len(v.data)))
+
+		s := sc.series[k.ref]
+		delete(s.chunks, k.timeRange)
+		if len(s.chunks) == 0 {
+			delete(sc.series, k.ref)
+			delete(sc.byName[s.name], k.ref)
+			if len(sc.byName[s.name]) == 0 {
+				delete(sc.byName, s.name)
+			}
+		}
+	}
+	l, err := lru.NewLRU(1e12, onEvict)
+	if err != nil {
+		return nil, err
+	}
+	sc.lru = l
+	c.stores[store] = sc
+	return sc, nil
+}
+
+type chunkCacheKeyCtx struct{}
+
+// ContextWithChunkCache returns a new context.Context that makes ProxyStore Series requests made with it reuse chunks
+// held by the given cache and add chunks they fetch to it.
+func ContextWithChunkCache(ctx context.Context, c *ChunkCache) context.Context {
+	return context.WithValue(ctx, chunkCacheKeyCtx{}, c)
+}
+
+func chunkCacheFromContext(ctx context.Context) *ChunkCache {
+	c, _ := ctx.Value(chunkCacheKeyCtx{}).(*ChunkCache)
+	return c
+}
+
+// known returns the request to the store with cached chunks of series it selects listed as known chunks, and data of
+// those chunks. Data is returned with the request, so chunks evicted before the store responds are still served.
+// It must be called only for stores supporting known chunks.
+func (c *ChunkCache) known(store string, r *storepb.SeriesRequest) (*storepb.SeriesRequest, map[chunkCacheKey]pinnedChunk) {
+	if r.SkipChunks {
+		return r, nil
+	}
+	sc, _ := c.storeCache(store, false)
+	if sc == nil {
+		return r, nil
+	}
+	matchers, err := translateMatchers(r.Matchers)
+	if err != nil {
+		return r, nil
+	}
+
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	var (
+		known  []storepb.KnownChunk
+		pinned = map[chunkCacheKey]pinnedChunk{}
+		now    = c.now()
+		series = sc.series
+	)
+	if name, ok := metricNameMatcher(r.Matchers); ok {
+		series = sc.byName[name]
+	}
+series:
+	for ref, s := range series {
+		if !r.Shard.MatchesHash(ref) || !matchesLabels(matchers, s.lset) {
+			continue
+		}
+		for tr := range s.chunks {
+			if tr.maxt < r.MinTime || tr.mint > r.MaxTime {
+				continue
+			}
+			k := chunkCacheKey{ref: ref, timeRange: tr}
+			v, ok := sc.lru.Get(k)
+			if !ok {
+				continue
+			}
+			if e := v.(cachedChunk); now.Sub(e.added) <= c.ttl {
+				if len(known) == maxKnownChunks {
+					break series
+				}
+				known = append(known, storepb.KnownChunk{SeriesRef: ref, MinTime: tr.mint, MaxTime: tr.maxt})
+				pinned[k] = pinnedChunk{lset: s.lset, data: e.data}
+				continue
+			}
+			sc.lru.Remove(k)
+		}
+	}
+	if len(known) == 0 {
+		return r, nil
+	}
+
+	kr := *r
+	kr.KnownChunks = known
+	return &kr, pinned
+}
+
+// observe fills data of chunks of the series that the store omitted as known and adds other raw chunks old enough
+// to the cache. It returns an error if the store omitted data of a chunk that was not listed as known for the series,
+// including chunks known for another series with the same ref, as the chunk can't be served then.
+func (c *ChunkCache) observe(store string, s *storepb.Series, pinned map[chunkCacheKey]pinnedChunk) error {
+	var (
+		ref  = storepb.LabelsHash(s.Labels)
+		maxt = timestamp.FromTime(c.now().Add(-c.minAge))
+		add  []*storepb.AggrChunk
+	)
+	for i := range s.Chunks {
+		chk := &s.Chunks[i]
+
+		if isEmptyChunk(chk) {
+			p, ok := pinned[chunkCacheKey{ref: ref, timeRange: timeRange{mint: chk.MinTime, maxt: chk.MaxTime}}]
+			if !ok || storepb.CompareLabels(p.lset, s.Labels) != 0 {
+				return errors.Errorf("store %s omitted data of chunk %d-%d of series %s that is not known",
+					store, chk.MinTime, chk.MaxTime, storepb.LabelsToString(s.Labels))
+			}
+			chk.Raw = &storepb.Chunk{Type: storepb.Chunk_XOR, Data: p.data}
+			c.requests.Inc()
+			c.hits.Inc()
+			continue
+		}
+		if !isRawChunk(chk) {
+			continue
+		}
+		c.requests.Inc()
+		// Compression is not cached, so compressed chunks are not either.
+		if chk.MaxTime < maxt && chk.Raw.Type == storepb.Chunk_XOR && chk.Raw.Compression == storepb.Chunk_NONE {
+			add = append(add, chk)
+		}
+	}
+	if len(add) == 0 {
+		return nil
+	}
+	sc, err := c.storeCache(store, true)
+	if err != nil {
+		return errors.Wrap(err, "create chunk cache of store")
+	}
+	c.add(sc, ref, s.Labels, add)
+	return nil
+}
+
+// add adds chunks of the series to cached chunks of the store, evicting chunks to make room for them first.
+func (c *ChunkCache) add(sc *storeChunkCache, ref uint64, lset []storepb.Label, chks []*storepb.AggrChunk) {
+	sc.mtx.Lock()
+	if s, ok := sc.series[ref]; ok && storepb.CompareLabels(s.lset, lset) != 0 {
+		// Hash of labels collides with another cached series, whose chunks would be served for this one.
+		sc.mtx.Unlock()
+		return
+	}
+	var size int64
+	for _, chk := range chks {
+		if _, ok := sc.lru.Peek(chunkCacheKey{ref: ref, timeRange: timeRange{mint: chk.MinTime, maxt: chk.MaxTime}}); !ok {
+			size += int64(len(chk.Raw.Data))
+		}
+	}
+	if size == 0 || size > c.maxSize {
+		sc.mtx.Unlock()
+		return
+	}
+	fits := c.makeRoom(sc, size)
+	sc.mtx.Unlock()
+
+	if !fits {
+		// Chunks of the store alone don't make room. Other stores are locked one at a time, so stores never wait
+		// for each other while holding a lock.
+		c.mtx.Lock()
+		others := make([]*storeChunkCache, 0, len(c.stores))
+		for _, o := range c.stores {
+			if o != sc {
+				others = append(others, o)
+			}
+		}
+		c.mtx.Unlock()
+
+		for _, o := range others {
+			o.mtx.Lock()
+			fits = c.makeRoom(o, size)
+			o.mtx.Unlock()
+			if fits {
+				break
+			}
+		}
+	}
+
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	if s, ok := sc.series[ref]; ok && storepb.CompareLabels(s.lset, lset) != 0 {
+		// Colliding series was added meanwhile.
+		return
+	}
+	now := c.now()
+	for _, chk := range chks {
+		k := chunkCacheKey{ref: ref, timeRange: timeRange{mint: chk.MinTime, maxt: chk.MaxTime}}
+		if _, ok := sc.lru.Peek(k); ok {
+			continue
+		}
+
+		s, ok := sc.series[ref]
+		if !ok {
+			s = &cachedSeries{lset: append([]storepb.Label(nil), lset...), chunks: map[timeRange]struct{}{}}
+			for _, l := range lset {
+				if l.Name == metricNameLabel {
+					s.name = l.Value
+					break
+				}
+			}
+			sc.series[ref] = s
+			if sc.byName[s.name] == nil {
+				sc.byName[s.name] = map[uint64]*cachedSeries{}
+			}
+			sc.byName[s.name][ref] = s
+		}
+		s.chunks[k.timeRange] = struct{}{}
+
+		size := int64(len(chk.Raw.Data))
+		sc.lru.Add(k, cachedChunk{data: chk.Raw.Data, added: now})
+		c.added.Inc()
+		c.current.Inc()
+		c.currentSize.Add(float64(size))
+		atomic.AddInt64(&c.curSize, size)
+	}
+}
+
+// makeRoom evicts least recently used chunks of the store until the given size fits into the cache. It returns false
+// if the store ran out of chunks first. The store must be locked. Concurrent adds to other stores may briefly grow
+// the cache over its max size, until they make room themselves.
+func (c *ChunkCache) makeRoom(sc *storeChunkCache, size int64) bool {
+	for atomic.LoadInt64(&c.curSize)+size > c.maxSize {
+		if _, _, ok := sc.lru.RemoveOldest(); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// chunkCacheSeriesClient fills chunks omitted by the store from data of known chunks and caches chunks it receives.
+type chunkCacheSeriesClient struct {
+	storepb.Store_SeriesClient
+	cache  *ChunkCache
+	store  string
+	pinned map[chunkCacheKey]pinnedChunk
+}
+
+func (c *chunkCacheSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	r, err := c.Store_SeriesClient.Recv()
+	if err != nil {
+		return r, err
+	}
+	if s := r.GetSeries(); s != nil {
+		if err := c.cache.observe(c.store, s, c.pinned); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func isEmptyChunk(c *storepb.AggrChunk) bool {
+	return c.Raw == nil && c.Count == nil && c.Sum == nil && c.Min == nil && c.Max == nil && c.Counter == nil
+}
+
+func isRawChunk(c *storepb.AggrChunk) bool {
+	return c.Raw != nil && c.Count == nil && c.Sum == nil && c.Min == nil && c.Max == nil && c.Counter == nil
+}
+
+// metricNameLabel is the name of the label holding the metric name.
+const metricNameLabel = "__name__"
+
+// metricNameMatcher returns the metric name if the matchers select a single metric by name.
+func metricNameMatcher(ms []storepb.LabelMatcher) (string, bool) {
+	for _, m := range ms {
+		if m.Name == metricNameLabel && m.Type == storepb.LabelMatcher_EQ {
+			return m.Value, true
+		}
+	}
+	return "", false
+}
+
+func matchesLabels(ms []labels.Matcher, lset []storepb.Label) bool {
+	for _, m := range ms {
+		v := ""
+		for _, l := range lset {
+			if l.Name == m.Name() {
+				v = l.Value
+				break
+			}
+		}
+		if !m.Matches(v) {
+			return false
+		}
+	}
+	return true
+}
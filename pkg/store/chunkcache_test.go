@@ -0,0 +1,260 @@
+package store
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+)
+
+// knownChunksStoreServer sends chunks listed as known by the request without data.
+type knownChunksStoreServer struct {
+	// This field just exist to pseudo-implement the unused methods of the interface.
+	storepb.StoreServer
+
+	series []storepb.Series
+	// If set, the store does not advertise support of known chunks.
+	unsupported bool
+	// If set, data of all chunks is omitted, whether listed as known or not.
+	omitAll bool
+
+	mtx     sync.Mutex
+	lastReq *storepb.SeriesRequest
+	omitted int
+}
+
+func (s *knownChunksStoreServer) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	return &storepb.InfoResponse{MinTime: math.MinInt64, MaxTime: math.MaxInt64, SupportsKnownChunks: !s.unsupported}, nil
+}
+
+func (s *knownChunksStoreServer) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	known := knownChunks(r)
+
+	s.mtx.Lock()
+	s.lastReq = r
+	s.mtx.Unlock()
+
+	for _, series := range s.series {
+		res := storepb.Series{Labels: series.Labels}
+		for _, c := range series.Chunks {
+			if _, ok := known[knownChunk{ref: storepb.LabelsHash(series.Labels), timeRange: timeRange{mint: c.MinTime, maxt: c.MaxTime}}]; ok || s.omitAll {
+				c = storepb.AggrChunk{MinTime: c.MinTime, MaxTime: c.MaxTime}
+				s.mtx.Lock()
+				s.omitted++
+				s.mtx.Unlock()
+			}
+			res.Chunks = append(res.Chunks, c)
+		}
+		if err := srv.Send(storepb.NewSeriesResponse(&res)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestProxyStore_Series_ChunkCache(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	now := time.Unix(100000, 0)
+	recent := timestamp.FromTime(now.Add(-time.Minute))
+
+	old := storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1000, 1}, {2000, 2}}).GetSeries()
+	mutable := storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{recent, 3}, {recent + 1000, 4}}).GetSeries()
+	other := storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{1000, 5}}).GetSeries()
+	series := []storepb.Series{
+		{Labels: old.Labels, Chunks: append(old.Chunks, mutable.Chunks...)},
+		*other,
+	}
+
+	srv := &knownChunksStoreServer{series: series}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return []Client{NewLocalClient(srv, "store")}, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	cache, err := NewChunkCache(nil, 1e6, time.Minute, time.Hour)
+	testutil.Ok(t, err)
+	cache.now = func() time.Time { return now }
+	ctx := ContextWithChunkCache(context.Background(), cache)
+
+	req := func(value string) *storepb.SeriesRequest {
+		return &storepb.SeriesRequest{
+			MinTime:  0,
+			MaxTime:  math.MaxInt64,
+			Matchers: []storepb.LabelMatcher{{Name: "a", Value: value, Type: storepb.LabelMatcher_RE}},
+		}
+	}
+
+	s := newStoreSeriesServer(ctx)
+	testutil.Ok(t, q.Series(req("1|2"), s))
+	testutil.Equals(t, 0, len(srv.lastReq.KnownChunks))
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(cache.hits))
+	// The recent chunk may still be appended to, so only old chunks are cached.
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(cache.current))
+
+	// The repeated query is served from the cache.
+	s = newStoreSeriesServer(ctx)
+	testutil.Ok(t, q.Series(req("1|2"), s))
+	testutil.Equals(t, 2, len(srv.lastReq.KnownChunks))
+	testutil.Equals(t, 2, srv.omitted)
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(cache.hits))
+	testutil.Equals(t, 6.0, promtestutil.ToFloat64(cache.requests))
+	seriesEqual(t, []rawSeries{
+		{lset: []storepb.Label{{Name: "a", Value: "1"}}, samples: []sample{{1000, 1}, {2000, 2}, {recent, 3}, {recent + 1000, 4}}},
+		{lset: []storepb.Label{{Name: "a", Value: "2"}}, samples: []sample{{1000, 5}}},
+	}, s.SeriesSet)
+
+	// Only chunks of series matching the request are listed.
+	s = newStoreSeriesServer(ctx)
+	testutil.Ok(t, q.Series(req("2"), s))
+	testutil.Equals(t, []storepb.KnownChunk{{SeriesRef: storepb.LabelsHash(other.Labels), MinTime: 1000, MaxTime: 1000}}, srv.lastReq.KnownChunks)
+
+	// Chunks are fetched again after the TTL.
+	now = now.Add(2 * time.Minute)
+	s = newStoreSeriesServer(ctx)
+	testutil.Ok(t, q.Series(req("1|2"), s))
+	testutil.Equals(t, 0, len(srv.lastReq.KnownChunks))
+	testutil.Equals(t, 2, len(s.SeriesSet))
+}
+
+func TestProxyStore_Series_ChunkCache_Unsupported(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	series := storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1000, 1}, {2000, 2}}).GetSeries()
+	srv := &knownChunksStoreServer{series: []storepb.Series{*series}, unsupported: true}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return []Client{NewLocalClient(srv, "store")}, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	cache, err := NewChunkCache(nil, 1e6, time.Minute, time.Hour)
+	testutil.Ok(t, err)
+	ctx := ContextWithChunkCache(context.Background(), cache)
+	req := &storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  math.MaxInt64,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "1", Type: storepb.LabelMatcher_EQ}},
+	}
+
+	// Chunks are cached, but never listed to the store not supporting known chunks.
+	for i := 0; i < 2; i++ {
+		s := newStoreSeriesServer(ctx)
+		testutil.Ok(t, q.Series(req, s))
+		testutil.Equals(t, 0, len(srv.lastReq.KnownChunks))
+		testutil.Equals(t, 1, len(s.SeriesSet))
+	}
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(cache.current))
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(cache.hits))
+}
+
+func TestProxyStore_Series_ChunkCache_MissingChunk(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	series := storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1000, 1}, {2000, 2}}).GetSeries()
+	srv := &knownChunksStoreServer{series: []storepb.Series{*series}, omitAll: true}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return []Client{NewLocalClient(srv, "store")}, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	cache, err := NewChunkCache(nil, 1e6, time.Minute, time.Hour)
+	testutil.Ok(t, err)
+	ctx := ContextWithChunkCache(context.Background(), cache)
+
+	// Chunk omitted by the store is not in the cache, so it can't be served as an empty chunk.
+	s := newStoreSeriesServer(ctx)
+	testutil.NotOk(t, q.Series(&storepb.SeriesRequest{
+		MinTime:                 0,
+		MaxTime:                 math.MaxInt64,
+		Matchers:                []storepb.LabelMatcher{{Name: "a", Value: "1", Type: storepb.LabelMatcher_EQ}},
+		PartialResponseDisabled: true,
+	}, s))
+	testutil.Equals(t, 0, len(s.SeriesSet))
+}
+
+func TestChunkCache_Evict(t *testing.T) {
+	cache, err := NewChunkCache(nil, 10, time.Minute, 0)
+	testutil.Ok(t, err)
+	cache.now = func() time.Time { return time.Unix(100000, 0) }
+
+	chunk := func(mint int64) *storepb.AggrChunk {
+		return &storepb.AggrChunk{MinTime: mint, MaxTime: mint, Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: make([]byte, 4)}}
+	}
+	observe := func(store string, lset []storepb.Label, chks ...*storepb.AggrChunk) {
+		s := &storepb.Series{Labels: lset}
+		for _, c := range chks {
+			s.Chunks = append(s.Chunks, *c)
+		}
+		testutil.Ok(t, cache.observe(store, s, nil))
+	}
+	req := &storepb.SeriesRequest{MinTime: 0, MaxTime: math.MaxInt64}
+	knownOf := func(store string) int {
+		r, _ := cache.known(store, req)
+		return len(r.KnownChunks)
+	}
+
+	observe("a", []storepb.Label{{Name: "__name__", Value: "up"}}, chunk(1), chunk(2))
+	testutil.Equals(t, 2, knownOf("a"))
+
+	// Store evicts its own chunks first.
+	observe("a", []storepb.Label{{Name: "__name__", Value: "down"}}, chunk(3))
+	testutil.Equals(t, 2, knownOf("a"))
+	testutil.Equals(t, 8.0, promtestutil.ToFloat64(cache.currentSize))
+
+	// Chunks of other stores are evicted when chunks of the store alone don't fit.
+	observe("b", []storepb.Label{{Name: "__name__", Value: "up"}}, chunk(1), chunk(2))
+	testutil.Equals(t, 0, knownOf("a"))
+	testutil.Equals(t, 2, knownOf("b"))
+	testutil.Equals(t, 8.0, promtestutil.ToFloat64(cache.currentSize))
+
+	// Series selected by metric name are looked up by name.
+	r, _ := cache.known("b", &storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  math.MaxInt64,
+		Matchers: []storepb.LabelMatcher{{Name: "__name__", Value: "down", Type: storepb.LabelMatcher_EQ}},
+	})
+	testutil.Equals(t, 0, len(r.KnownChunks))
+}
+
+func TestChunkCache_HashCollision(t *testing.T) {
+	cache, err := NewChunkCache(nil, 1e6, time.Minute, 0)
+	testutil.Ok(t, err)
+	cache.now = func() time.Time { return time.Unix(100000, 0) }
+
+	a := []storepb.Label{{Name: "a", Value: "1"}}
+	b := []storepb.Label{{Name: "a", Value: "2"}}
+	chunk := storepb.AggrChunk{MinTime: 1, MaxTime: 2, Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: []byte{1, 2, 3}}}
+	testutil.Ok(t, cache.observe("store", &storepb.Series{Labels: a, Chunks: []storepb.AggrChunk{chunk}}, nil))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(cache.current))
+
+	// Chunks of a series colliding with a cached one are not cached under its ref.
+	sc, err := cache.storeCache("store", false)
+	testutil.Ok(t, err)
+	cache.add(sc, storepb.LabelsHash(a), b, []*storepb.AggrChunk{{MinTime: 3, MaxTime: 4, Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: []byte{4}}}})
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(cache.current))
+
+	// Chunk known for a colliding series is a miss, so data of another series is never filled in.
+	pinned := map[chunkCacheKey]pinnedChunk{
+		{ref: storepb.LabelsHash(b), timeRange: timeRange{mint: 1, maxt: 2}}: {lset: a, data: chunk.Raw.Data},
+	}
+	testutil.NotOk(t, cache.observe("store", &storepb.Series{Labels: b, Chunks: []storepb.AggrChunk{{MinTime: 1, MaxTime: 2}}}, pinned))
+
+	// Chunk known for the series itself is filled in.
+	pinned = map[chunkCacheKey]pinnedChunk{
+		{ref: storepb.LabelsHash(a), timeRange: timeRange{mint: 1, maxt: 2}}: {lset: a, data: chunk.Raw.Data},
+	}
+	s := &storepb.Series{Labels: a, Chunks: []storepb.AggrChunk{{MinTime: 1, MaxTime: 2}}}
+	testutil.Ok(t, cache.observe("store", s, pinned))
+	testutil.Equals(t, chunk.Raw.Data, s.Chunks[0].Raw.Data)
+}
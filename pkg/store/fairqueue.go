@@ -0,0 +1,196 @@
+package store
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type tenantKey struct{}
+
+// ContextWithTenant returns a new context.Context identifying the tenant of ProxyStore requests made with it. Calls
+// to a store over StoreLimit.MaxConcurrency are served fairly across tenants. Requests without a tenant in their
+// context are identified by the tenant matched by them, if the proxy has a tenant label, and share a single queue
+// otherwise.
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+func tenantFromContext(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(tenantKey{}).(string)
+	return v, ok
+}
+
+// fairQueue limits concurrent calls to a single store. Calls over the limit wait in a queue of their tenant and
+// tenants are served round robin, so a tenant with many waiting calls does not starve others.
+type fairQueue struct {
+	mtx      sync.Mutex
+	limit    int
+	inflight int
+	waiting  map[string][]chan struct{}
+	// Tenants with waiting calls, in order they are served.
+	tenants []string
+}
+
+// acquire takes a slot of the store, waiting for it until the context is done.
+func (q *fairQueue) acquire(ctx context.Context, tenant string) error {
+	q.mtx.Lock()
+	if q.inflight < q.limit && len(q.tenants) == 0 {
+		q.inflight++
+		q.mtx.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	if len(q.waiting[tenant]) == 0 {
+		q.tenants = append(q.tenants, tenant)
+	}
+	q.waiting[tenant] = append(q.waiting[tenant], ch)
+	q.mtx.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+
+	q.mtx.Lock()
+	removed := q.remove(tenant, ch)
+	q.mtx.Unlock()
+	if !removed {
+		// Slot was handed over meanwhile, pass it on.
+		q.release()
+	}
+	return ctx.Err()
+}
+
+// release frees a slot of the store, handing it over to the next waiting call, if any.
+func (q *fairQueue) release() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if len(q.tenants) == 0 {
+		q.inflight--
+		return
+	}
+	tenant := q.tenants[0]
+	q.tenants = q.tenants[1:]
+	ws := q.waiting[tenant]
+	if len(ws) > 1 {
+		q.waiting[tenant] = ws[1:]
+		q.tenants = append(q.tenants, tenant)
+	} else {
+		delete(q.waiting, tenant)
+	}
+	close(ws[0])
+}
+
+func (q *fairQueue) remove(tenant string, ch chan struct{}) bool {
+	ws := q.waiting[tenant]
+	for i, w := range ws {
+		if w != ch {
+			continue
+		}
+		if len(ws) > 1 {
+			q.waiting[tenant] = append(ws[:i:i], ws[i+1:]...)
+			return true
+		}
+		delete(q.waiting, tenant)
+		for j, t := range q.tenants {
+			if t == tenant {
+				q.tenants = append(q.tenants[:j:j], q.tenants[j+1:]...)
+				break
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// maxTenantLabels is the maximum number of tenants tracked by their own series of the inflight gauge. Tenants seen
+// after that are tracked together as otherTenants, so the gauge stays bounded no matter how tenants are identified.
+const (
+	maxTenantLabels = 100
+	otherTenants    = "other"
+)
+
+// fairQueues holds fair queues of stores, by store address.
+type fairQueues struct {
+	limit    int
+	inflight *prometheus.GaugeVec
+
+	mtx     sync.Mutex
+	queues  map[string]*fairQueue
+	tenants map[string]struct{}
+}
+
+func newFairQueues(limit int, inflight *prometheus.GaugeVec) *fairQueues {
+	return &fairQueues{limit: limit, inflight: inflight, queues: map[string]*fairQueue{}, tenants: map[string]struct{}{}}
+}
+
+func (qs *fairQueues) queue(addr string) *fairQueue {
+	qs.mtx.Lock()
+	defer qs.mtx.Unlock()
+
+	q, ok := qs.queues[addr]
+	if !ok {
+		q = &fairQueue{limit: qs.limit, waiting: map[string][]chan struct{}{}}
+		qs.queues[addr] = q
+	}
+	return q
+}
+
+// tenantLabel returns value of the tenant label of the inflight gauge for the tenant.
+func (qs *fairQueues) tenantLabel(tenant string) string {
+	qs.mtx.Lock()
+	defer qs.mtx.Unlock()
+
+	if _, ok := qs.tenants[tenant]; ok {
+		return tenant
+	}
+	if len(qs.tenants) >= maxTenantLabels {
+		return otherTenants
+	}
+	qs.tenants[tenant] = struct{}{}
+	return tenant
+}
+
+// slot returns a slot of the store for a single stream of the tenant, not taken yet. If no limit is set, it returns
+// nil, which is never waited for.
+func (qs *fairQueues) slot(addr string, tenant string) *storeSlot {
+	if qs.limit <= 0 {
+		return nil
+	}
+	return &storeSlot{queue: qs.queue(addr), tenant: tenant, inflight: qs.inflight.WithLabelValues(qs.tenantLabel(tenant))}
+}
+
+// storeSlot is a slot of a store used by a single stream. It is not safe for concurrent use.
+type storeSlot struct {
+	queue    *fairQueue
+	tenant   string
+	inflight prometheus.Gauge
+	taken    bool
+}
+
+// take takes the slot, waiting for it until the context is done. It does nothing if the slot is already taken.
+func (s *storeSlot) take(ctx context.Context) error {
+	if s == nil || s.taken {
+		return nil
+	}
+	if err := s.queue.acquire(ctx, s.tenant); err != nil {
+		return err
+	}
+	s.taken = true
+	s.inflight.Inc()
+	return nil
+}
+
+// free frees the slot, if taken.
+func (s *storeSlot) free() {
+	if s == nil || !s.taken {
+		return
+	}
+	s.queue.release()
+	s.taken = false
+	s.inflight.Dec()
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"google.golang.org/grpc"
+)
+
+// gatedStoreAPI records tenants of Series calls and holds each call until it is let through the gate.
+type gatedStoreAPI struct {
+	mockedStoreAPI
+
+	gate chan struct{}
+
+	mtx     sync.Mutex
+	tenants []string
+}
+
+func (s *gatedStoreAPI) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	tenant, _ := tenantFromContext(ctx)
+	s.mtx.Lock()
+	s.tenants = append(s.tenants, tenant)
+	s.mtx.Unlock()
+
+	select {
+	case <-s.gate:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return s.mockedStoreAPI.Series(ctx, req, opts...)
+}
+
+func (s *gatedStoreAPI) calls() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]string(nil), s.tenants...)
+}
+
+func TestProxyStore_Series_TenantFairness(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	api := &gatedStoreAPI{
+		mockedStoreAPI: mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}),
+		}},
+		gate: make(chan struct{}),
+	}
+	cls := []Client{&testClient{StoreClient: api, minTime: 1, maxTime: 300, addr: "store"}}
+
+	q := NewProxyStore(nil, prometheus.NewRegistry(),
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{MaxConcurrency: 1},
+	)
+	queue := q.queues.queue("store")
+	waiting := func(tenant string) int {
+		queue.mtx.Lock()
+		defer queue.mtx.Unlock()
+		return len(queue.waiting[tenant])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	eventually := func(cond func() bool) {
+		testutil.Ok(t, runutil.Retry(time.Millisecond, ctx.Done(), func() error {
+			if !cond() {
+				return errors.New("condition not met")
+			}
+			return nil
+		}))
+	}
+
+	var wg sync.WaitGroup
+	series := func(tenant string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := newStoreSeriesServer(ContextWithTenant(context.Background(), tenant))
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:  1,
+				MaxTime:  300,
+				Matchers: []storepb.LabelMatcher{{Name: "a", Value: "1", Type: storepb.LabelMatcher_EQ}},
+			}, s))
+			testutil.Equals(t, 1, len(s.SeriesSet))
+		}()
+	}
+
+	// Heavy tenant takes the store and queues two more calls before the light tenant calls it.
+	series("heavy")
+	eventually(func() bool { return len(api.calls()) == 1 })
+	series("heavy")
+	series("heavy")
+	eventually(func() bool { return waiting("heavy") == 2 })
+	series("light")
+	eventually(func() bool { return waiting("light") == 1 })
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(q.metrics.tenantInflight.WithLabelValues("heavy")))
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(q.metrics.tenantInflight.WithLabelValues("light")))
+
+	for i := 0; i < 4; i++ {
+		api.gate <- struct{}{}
+	}
+	wg.Wait()
+
+	// Light tenant is served right after the next call of the heavy one, not after all of them.
+	testutil.Equals(t, []string{"heavy", "heavy", "light", "heavy"}, api.calls())
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(q.metrics.tenantInflight.WithLabelValues("heavy")))
+}
+
+func TestFairQueue_AcquireCanceled(t *testing.T) {
+	q := &fairQueue{limit: 1, waiting: map[string][]chan struct{}{}}
+	testutil.Ok(t, q.acquire(context.Background(), "a"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	testutil.Equals(t, context.Canceled, q.acquire(ctx, "b"))
+
+	// Canceled call gave up its place, so the slot is free once released.
+	q.release()
+	testutil.Ok(t, q.acquire(context.Background(), "c"))
+	testutil.Equals(t, 1, q.inflight)
+	testutil.Equals(t, 0, len(q.tenants))
+}
+
+func TestProxyStore_Series_BusyStoreDoesNotHoldUpOthers(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	newAPI := func(lset labels.Labels) *gatedStoreAPI {
+		return &gatedStoreAPI{
+			mockedStoreAPI: mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, lset, []sample{{1, 1}}),
+			}},
+			gate: make(chan struct{}),
+		}
+	}
+	busy, free := newAPI(labels.FromStrings("a", "1")), newAPI(labels.FromStrings("a", "2"))
+	cls := []Client{
+		&testClient{StoreClient: busy, minTime: 1, maxTime: 300, addr: "busy"},
+		&testClient{StoreClient: free, minTime: 1, maxTime: 300, addr: "free"},
+	}
+	q := NewProxyStore(nil, prometheus.NewRegistry(),
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{MaxConcurrency: 1},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Another request holds the only slot of the busy store.
+	busyQueue := q.queues.queue("busy")
+	testutil.Ok(t, busyQueue.acquire(ctx, "other"))
+
+	s := newStoreSeriesServer(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- q.Series(&storepb.SeriesRequest{
+			MinTime:  1,
+			MaxTime:  300,
+			Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+		}, s)
+	}()
+
+	// Free store is called while the busy one is still waited for.
+	testutil.Ok(t, runutil.Retry(time.Millisecond, ctx.Done(), func() error {
+		if len(free.calls()) != 1 {
+			return errors.New("free store not called")
+		}
+		return nil
+	}))
+	testutil.Equals(t, 0, len(busy.calls()))
+	free.gate <- struct{}{}
+
+	busyQueue.release()
+	busy.gate <- struct{}{}
+	testutil.Ok(t, <-errCh)
+	testutil.Equals(t, 2, len(s.SeriesSet))
+}
+
+func TestFairQueues_TenantLabelBounded(t *testing.T) {
+	qs := newFairQueues(1, prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "inflight"}, []string{"tenant"}))
+	for i := 0; i < maxTenantLabels; i++ {
+		testutil.Equals(t, fmt.Sprintf("tenant-%d", i), qs.tenantLabel(fmt.Sprintf("tenant-%d", i)))
+	}
+	testutil.Equals(t, otherTenants, qs.tenantLabel("new"))
+	// Tenants seen before keep their own label.
+	testutil.Equals(t, "tenant-0", qs.tenantLabel("tenant-0"))
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+// LocalClient is a Client of a store running in the same process, e.g TSDBStore of a local TSDB. It calls the store
+// directly instead of through gRPC, so it allows to embed stores into the querier or to test against real stores.
+type LocalClient struct {
+	srv  storepb.StoreServer
+	name string
+
+	// Info of the store cached for requests within the refresh interval.
+	infoMtx sync.Mutex
+	info    *storepb.InfoResponse
+	infoAt  time.Time
+}
+
+// localInfoRefreshInterval is how long the series estimate and capabilities of a local store are cached, as they are
+// asked for by every Series request and estimating series may not be cheap for the store.
+const localInfoRefreshInterval = time.Minute
+
+// NewLocalClient returns LocalClient of the given store. Name identifies the store in place of an address.
+func NewLocalClient(srv storepb.StoreServer, name string) *LocalClient {
+	return &LocalClient{srv: srv, name: name}
+}
+
+// Info returns store information of the local store.
+func (c *LocalClient) Info(ctx context.Context, r *storepb.InfoRequest, _ ...grpc.CallOption) (*storepb.InfoResponse, error) {
+	return c.srv.Info(ctx, r)
+}
+
+// Series starts Series request against the local store. Like with gRPC, the request runs until the whole response
+// is received or the context is canceled.
+func (c *LocalClient) Series(ctx context.Context, r *storepb.SeriesRequest, _ ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	cl := &localSeriesClient{
+		ctx:    ctx,
+		cancel: cancel,
+		respCh: make(chan *storepb.SeriesResponse),
+	}
+	go func() {
+		defer close(cl.respCh)
+		cl.err = c.srv.Series(r, &localSeriesServer{ctx: ctx, respCh: cl.respCh})
+	}()
+	return cl, nil
+}
+
+// LabelNames returns all known label names of the local store.
+func (c *LocalClient) LabelNames(ctx context.Context, r *storepb.LabelNamesRequest, _ ...grpc.CallOption) (*storepb.LabelNamesResponse, error) {
+	return c.srv.LabelNames(ctx, r)
+}
+
+// LabelValues returns all known label values for a given label name of the local store.
+func (c *LocalClient) LabelValues(ctx context.Context, r *storepb.LabelValuesRequest, _ ...grpc.CallOption) (*storepb.LabelValuesResponse, error) {
+	return c.srv.LabelValues(ctx, r)
+}
+
+// Labels returns external labels of the local store.
+func (c *LocalClient) Labels() []storepb.Label {
+	info, err := c.srv.Info(context.Background(), &storepb.InfoRequest{})
+	if err != nil {
+		return nil
+	}
+	return info.Labels
+}
+
+// TimeRange returns time range of data in the local store. If it is not known, the store is assumed to have all data.
+func (c *LocalClient) TimeRange() (mint int64, maxt int64) {
+	info, err := c.srv.Info(context.Background(), &storepb.InfoRequest{})
+	if err != nil {
+		return math.MinInt64, math.MaxInt64
+	}
+	return info.MinTime, info.MaxTime
+}
+
+// SeriesEstimate returns estimated number of series in the local store, or zero if unknown.
+func (c *LocalClient) SeriesEstimate() int64 {
+	return c.cachedInfo().SeriesEstimate
+}
+
+// SupportsKnownChunks returns true if the local store omits data of chunks listed as known by Series requests.
+func (c *LocalClient) SupportsKnownChunks() bool {
+	return c.cachedInfo().SupportsKnownChunks
+}
+
+// cachedInfo returns info of the local store refreshed at most once per localInfoRefreshInterval. If Info fails,
+// empty info is returned until the next refresh.
+func (c *LocalClient) cachedInfo() *storepb.InfoResponse {
+	c.infoMtx.Lock()
+	defer c.infoMtx.Unlock()
+
+	if c.info != nil && time.Since(c.infoAt) < localInfoRefreshInterval {
+		return c.info
+	}
+	c.info, c.infoAt = &storepb.InfoResponse{}, time.Now()
+	if info, err := c.srv.Info(context.Background(), &storepb.InfoRequest{}); err == nil {
+		c.info = info
+	}
+	return c.info
+}
+
+func (c *LocalClient) String() string { return c.name }
+
+// Addr returns name of the local store.
+func (c *LocalClient) Addr() string { return c.name }
+
+// localSeriesServer passes series sent by the local store to localSeriesClient.
+type localSeriesServer struct {
+	// This field just exist to pseudo-implement the unused methods of the interface.
+	storepb.Store_SeriesServer
+
+	ctx    context.Context
+	respCh chan<- *storepb.SeriesResponse
+}
+
+func (s *localSeriesServer) Send(r *storepb.SeriesResponse) error {
+	// Stores may reuse the response once sent, like gRPC allows them to. Copy it through the wire format.
+	b, err := r.Marshal()
+	if err != nil {
+		return errors.Wrap(err, "marshal series response")
+	}
+	resp := &storepb.SeriesResponse{}
+	if err := resp.Unmarshal(b); err != nil {
+		return errors.Wrap(err, "unmarshal series response")
+	}
+
+	select {
+	case s.respCh <- resp:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *localSeriesServer) Context() context.Context {
+	return s.ctx
+}
+
+// localSeriesClient receives series of the local store.
+type localSeriesClient struct {
+	// This field just exist to pseudo-implement the unused methods of the interface.
+	storepb.Store_SeriesClient
+
+	ctx    context.Context
+	cancel func()
+	respCh chan *storepb.SeriesResponse
+	// Set before respCh is closed.
+	err error
+}
+
+func (c *localSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	resp, ok := <-c.respCh
+	if ok {
+		return resp, nil
+	}
+	c.cancel()
+	if c.err != nil {
+		return nil, c.err
+	}
+	return nil, io.EOF
+}
+
+func (c *localSeriesClient) Context() context.Context {
+	return c.ctx
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// reusingStoreServer reuses the series response for every series it sends, like TSDBStore does.
+type reusingStoreServer struct {
+	// This field just exist to pseudo-implement the unused methods of the interface.
+	storepb.StoreServer
+
+	info   storepb.InfoResponse
+	series []storepb.Series
+	err    error
+}
+
+func (s *reusingStoreServer) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	return &s.info, nil
+}
+
+func (s *reusingStoreServer) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	var resp storepb.Series
+	for _, series := range s.series {
+		resp.Labels = append(resp.Labels[:0], series.Labels...)
+		resp.Chunks = append(resp.Chunks[:0], series.Chunks...)
+		if err := srv.Send(storepb.NewSeriesResponse(&resp)); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+func TestLocalClient_Series(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	srv := &reusingStoreServer{
+		info: storepb.InfoResponse{MinTime: 1, MaxTime: 300, Labels: []storepb.Label{{Name: "ext", Value: "1"}}},
+		series: []storepb.Series{
+			*storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}, {2, 2}}).GetSeries(),
+			*storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{3, 3}}).GetSeries(),
+		},
+	}
+	cl := NewLocalClient(srv, "local")
+	testutil.Equals(t, []storepb.Label{{Name: "ext", Value: "1"}}, cl.Labels())
+	mint, maxt := cl.TimeRange()
+	testutil.Equals(t, int64(1), mint)
+	testutil.Equals(t, int64(300), maxt)
+
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return []Client{cl}, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "ext", Value: "1"}},
+	}, s))
+	testutil.Equals(t, 0, len(s.Warnings))
+	testutil.Equals(t, srv.series, s.SeriesSet)
+
+	// Error of the store is returned by the stream.
+	srv.err = errors.New("store failure")
+	s = newStoreSeriesServer(context.Background())
+	err := q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300, PartialResponseDisabled: true}, s)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, "store failure", errors.Cause(err).Error())
+}
+
+// countingInfoStoreServer counts Info calls.
+type countingInfoStoreServer struct {
+	storepb.StoreServer
+
+	calls int
+}
+
+func (s *countingInfoStoreServer) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	s.calls++
+	return &storepb.InfoResponse{SeriesEstimate: 100}, nil
+}
+
+func TestLocalClient_SeriesEstimate(t *testing.T) {
+	srv := &countingInfoStoreServer{}
+	c := NewLocalClient(srv, "local")
+
+	// Estimate is asked for by every Series request, so it is cached instead of calling Info every time.
+	for i := 0; i < 3; i++ {
+		testutil.Equals(t, int64(100), c.SeriesEstimate())
+	}
+	testutil.Equals(t, 1, srv.calls)
+
+	c.infoAt = time.Now().Add(-localInfoRefreshInterval)
+	testutil.Equals(t, int64(100), c.SeriesEstimate())
+	testutil.Equals(t, 2, srv.calls)
+}
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"regexp"
+
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/pkg/errors"
 	"github.com/prometheus/tsdb/labels"
@@ -23,6 +25,9 @@ func translateMatcher(m storepb.LabelMatcher) (labels.Matcher, error) {
 			return nil, err
 		}
 		return labels.Not(m), nil
+
+	case storepb.LabelMatcher_EQ_CI:
+		return labels.NewRegexpMatcher(m.Name, "^"+caseInsensitiveRegexp(m.Value)+"$")
 	}
 	return nil, errors.Errorf("unknown label matcher type %d", m.Type)
 }
@@ -37,3 +42,28 @@ func translateMatchers(ms []storepb.LabelMatcher) (res []labels.Matcher, err err
 	}
 	return res, nil
 }
+
+// caseInsensitiveRegexp returns regular expression matching the given value ignoring case.
+func caseInsensitiveRegexp(v string) string {
+	return "(?i:" + regexp.QuoteMeta(v) + ")"
+}
+
+// forwardedMatchers replaces Thanos extension matchers with equivalent standard ones, so they are understood
+// by all stores.
+func forwardedMatchers(ms []storepb.LabelMatcher) []storepb.LabelMatcher {
+	var res []storepb.LabelMatcher
+	for i, m := range ms {
+		if m.Type != storepb.LabelMatcher_EQ_CI {
+			continue
+		}
+		if res == nil {
+			// Don't modify matchers of the request.
+			res = append([]storepb.LabelMatcher(nil), ms...)
+		}
+		res[i] = storepb.LabelMatcher{Type: storepb.LabelMatcher_RE, Name: m.Name, Value: caseInsensitiveRegexp(m.Value)}
+	}
+	if res == nil {
+		return ms
+	}
+	return res
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// storeOverLimit is the skip reason of stores not queried as the request matched more stores than StoreLimit.Max.
+const storeOverLimit = "over store limit"
+
+// StoreOutcome describes what happened to a store in Series requests of ProxyStore.
+type StoreOutcome struct {
+	// SkipReason tells why the store was not contacted, e.g. "out of time range". It is empty if the store was
+	// contacted.
+	SkipReason string
+	// Failure is the error of the store, if it failed. With partial response enabled it was returned as a warning.
+	Failure string
+	// Series is the number of series received from the store.
+	Series int
+}
+
+func (o StoreOutcome) String() string {
+	switch {
+	case o.SkipReason != "":
+		return "skipped, " + o.SkipReason
+	case o.Failure != "":
+		return "failed, " + o.Failure
+	case o.Series == 0:
+		return "contacted, no series"
+	}
+	return fmt.Sprintf("contacted, %d series", o.Series)
+}
+
+// StoreOutcomes records outcome of each store for Series requests of ProxyStore made with a context holding it, see
+// ContextWithStoreOutcomes. It explains results, e.g. whether an empty result comes from stores holding no matching
+// series, skipped or failed ones. It is safe to use concurrently.
+type StoreOutcomes struct {
+	mtx      sync.Mutex
+	outcomes map[string]StoreOutcome
+	// Addresses of stores that sent downsampled chunks.
+	downsampled map[string]struct{}
+}
+
+// NewStoreOutcomes returns empty StoreOutcomes.
+func NewStoreOutcomes() *StoreOutcomes {
+	return &StoreOutcomes{outcomes: map[string]StoreOutcome{}, downsampled: map[string]struct{}{}}
+}
+
+type storeOutcomesKey struct{}
+
+// ContextWithStoreOutcomes returns a new context.Context that makes ProxyStore record outcome of each store in the
+// given StoreOutcomes.
+func ContextWithStoreOutcomes(ctx context.Context, o *StoreOutcomes) context.Context {
+	return context.WithValue(ctx, storeOutcomesKey{}, o)
+}
+
+func storeOutcomesFromContext(ctx context.Context) *StoreOutcomes {
+	o, _ := ctx.Value(storeOutcomesKey{}).(*StoreOutcomes)
+	return o
+}
+
+// add records outcome of the store. If the store is recorded multiple times, e.g. for multiple requests of a query or
+// for multiple shards, received series are summed, the first failure is kept and the store is skipped only if it was
+// never contacted.
+func (o *StoreOutcomes) add(store string, so StoreOutcome) {
+	if o == nil {
+		return
+	}
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	prev, ok := o.outcomes[store]
+	if !ok {
+		o.outcomes[store] = so
+		return
+	}
+	if prev.SkipReason != "" && so.SkipReason == "" {
+		prev.SkipReason = ""
+	}
+	if prev.Failure == "" {
+		prev.Failure = so.Failure
+	}
+	prev.Series += so.Series
+	o.outcomes[store] = prev
+}
+
+// addDownsampled records that the store with the given address sent downsampled chunks.
+func (o *StoreOutcomes) addDownsampled(addr string) {
+	if o == nil {
+		return
+	}
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	o.downsampled[addr] = struct{}{}
+}
+
+// DownsampledStores returns sorted addresses of stores that sent downsampled chunks. Raw data filling gaps of
+// downsampled series is held by the same stores.
+func (o *StoreOutcomes) DownsampledStores() []string {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	addrs := make([]string, 0, len(o.downsampled))
+	for addr := range o.downsampled {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// Merge records all outcomes of the other StoreOutcomes, like if they were recorded in this one.
+func (o *StoreOutcomes) Merge(other *StoreOutcomes) {
+	for store, so := range other.Outcomes() {
+		o.add(store, so)
+	}
+	for _, addr := range other.DownsampledStores() {
+		o.addDownsampled(addr)
+	}
+}
+
+// Outcomes returns recorded outcomes by store names.
+func (o *StoreOutcomes) Outcomes() map[string]StoreOutcome {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	res := make(map[string]StoreOutcome, len(o.outcomes))
+	for store, so := range o.outcomes {
+		res[store] = so
+	}
+	return res
+}
+
+// String returns recorded outcomes sorted by store names.
+func (o *StoreOutcomes) String() string {
+	return o.Summary(0)
+}
+
+// Summary returns recorded outcomes of up to max stores sorted by their names, followed by the number of stores left
+// out, e.g. for warnings explaining an empty result. Zero max lists all stores.
+func (o *StoreOutcomes) Summary(max int) string {
+	outcomes := o.Outcomes()
+	stores := make([]string, 0, len(outcomes))
+	for store := range outcomes {
+		stores = append(stores, store)
+	}
+	sort.Strings(stores)
+
+	var more int
+	if max > 0 && len(stores) > max {
+		stores, more = stores[:max], len(stores)-max
+	}
+	msgs := make([]string, 0, len(stores)+1)
+	for _, store := range stores {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", store, outcomes[store]))
+	}
+	if more > 0 {
+		msgs = append(msgs, fmt.Sprintf("%d more stores", more))
+	}
+	return strings.Join(msgs, "; ")
+}
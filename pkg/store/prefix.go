@@ -0,0 +1,73 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+)
+
+// ServedPrefixLabel is the external label of stores declaring which metric name prefixes they serve, as a comma
+// separated list, e.g. __served_prefix__="http_,grpc_". ProxyStore skips such stores for requests with an equality
+// matcher for a metric name without any of the prefixes. The label is removed from series received from the stores.
+const ServedPrefixLabel = "__served_prefix__"
+
+// metricMatcher returns the metric name selected by an equality matcher, if there is one.
+func metricMatcher(matchers []storepb.LabelMatcher) (string, bool) {
+	for _, m := range matchers {
+		if m.Name == "__name__" && m.Type == storepb.LabelMatcher_EQ && m.Value != "" {
+			return m.Value, true
+		}
+	}
+	return "", false
+}
+
+// storeServesMetric returns false if the store declares metric name prefixes it serves and the given metric name has
+// none of them.
+func storeServesMetric(s Client, metric string) bool {
+	for _, l := range s.Labels() {
+		if l.Name != ServedPrefixLabel {
+			continue
+		}
+		for _, p := range strings.Split(l.Value, ",") {
+			if strings.HasPrefix(metric, strings.TrimSpace(p)) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// storeDeclaresPrefixes returns true if the store has the served prefix external label.
+func storeDeclaresPrefixes(s Client) bool {
+	for _, l := range s.Labels() {
+		if l.Name == ServedPrefixLabel {
+			return true
+		}
+	}
+	return false
+}
+
+// servedPrefixSeriesClient removes the served prefix label from received series. The label is an external label, so
+// it has the same value for all series of the store and removing it keeps their order, unless series differ in labels
+// sorted before it, e.g. with upper case names. Such streams are sorted by the querier, like ones of misbehaving
+// stores.
+type servedPrefixSeriesClient struct {
+	storepb.Store_SeriesClient
+}
+
+func (c *servedPrefixSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	r, err := c.Store_SeriesClient.Recv()
+	if err != nil {
+		return r, err
+	}
+	if s := r.GetSeries(); s != nil {
+		for i, l := range s.Labels {
+			if l.Name == ServedPrefixLabel {
+				s.Labels = append(s.Labels[:i:i], s.Labels[i+1:]...)
+				break
+			}
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"sync"
+)
+
+// progressSeriesInterval is the number of merged series after which progress of a Series request is sent.
+const progressSeriesInterval = 1000
+
+// SeriesProgress is progress of a Series request of ProxyStore.
+type SeriesProgress struct {
+	// Stores is the number of stores queried for the request.
+	Stores int
+	// StoresDone is the number of queried stores that are done sending series, successfully or not.
+	StoresDone int
+	// SeriesMerged is the number of series merged from all stores so far.
+	SeriesMerged int
+	// Done is true for the last progress of the request, sent once it is complete.
+	Done bool
+}
+
+type seriesProgressKey struct{}
+
+// ContextWithSeriesProgress returns a new context.Context that makes ProxyStore send progress of Series requests made
+// with it to the given channel, e.g. for progress bars of long queries. Selects of queriers created with it pass it
+// to their requests. Progress is sent whenever a store is done and every 1000 merged series, followed by progress
+// with Done set once the request is complete. Values only grow within a request. Sending never blocks the request,
+// progress is dropped if the channel is not ready, so it should be buffered and drained concurrently. The channel is
+// never closed, as a single query may make multiple requests.
+func ContextWithSeriesProgress(ctx context.Context, ch chan<- SeriesProgress) context.Context {
+	return context.WithValue(ctx, seriesProgressKey{}, ch)
+}
+
+func seriesProgressFromContext(ctx context.Context) chan<- SeriesProgress {
+	ch, _ := ctx.Value(seriesProgressKey{}).(chan<- SeriesProgress)
+	return ch
+}
+
+// progressTracker tracks progress of a Series request. Stores are identified by their index in the request. It is
+// safe to use concurrently. All methods of nil tracker do nothing.
+type progressTracker struct {
+	ch chan<- SeriesProgress
+
+	mtx    sync.Mutex
+	cur    SeriesProgress
+	stores map[int]*storeProgress
+}
+
+type storeProgress struct {
+	streams int
+	opened  bool
+	done    bool
+}
+
+func newProgressTracker(ch chan<- SeriesProgress, stores int) *progressTracker {
+	if ch == nil {
+		return nil
+	}
+	return &progressTracker{ch: ch, cur: SeriesProgress{Stores: stores}, stores: map[int]*storeProgress{}}
+}
+
+func (t *progressTracker) store(i int) *storeProgress {
+	sp, ok := t.stores[i]
+	if !ok {
+		sp = &storeProgress{}
+		t.stores[i] = sp
+	}
+	return sp
+}
+
+// streamStarted records a stream of the store started.
+func (t *progressTracker) streamStarted(i int) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.store(i).streams++
+}
+
+// storeOpened records all streams of the store were started, or failed to start.
+func (t *progressTracker) storeOpened(i int) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.store(i).opened = true
+	t.checkStoreDone(i)
+}
+
+// streamDone records a stream of the store is done.
+func (t *progressTracker) streamDone(i int) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.store(i).streams--
+	t.checkStoreDone(i)
+}
+
+func (t *progressTracker) checkStoreDone(i int) {
+	sp := t.store(i)
+	if sp.done || !sp.opened || sp.streams > 0 {
+		return
+	}
+	sp.done = true
+	t.cur.StoresDone++
+	t.send()
+}
+
+// seriesMerged records a series merged from all stores.
+func (t *progressTracker) seriesMerged() {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.cur.SeriesMerged++
+	if t.cur.SeriesMerged%progressSeriesInterval == 0 {
+		t.send()
+	}
+}
+
+// done records the request is complete. Stores that were never contacted, as the request failed before, are
+// counted as done.
+func (t *progressTracker) done() {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.cur.StoresDone = t.cur.Stores
+	t.cur.Done = true
+	t.send()
+}
+
+func (t *progressTracker) send() {
+	select {
+	case t.ch <- t.cur:
+	default:
+	}
+}
@@ -74,9 +74,10 @@ func (p *PrometheusStore) Info(ctx context.Context, r *storepb.InfoRequest) (*st
 	mint, maxt := p.timestamps()
 
 	res := &storepb.InfoResponse{
-		MinTime: mint,
-		MaxTime: maxt,
-		Labels:  make([]storepb.Label, 0, len(lset)),
+		MinTime:   mint,
+		MaxTime:   maxt,
+		Labels:    make([]storepb.Label, 0, len(lset)),
+		StoreType: storepb.StoreType_SIDECAR,
 	}
 	for _, l := range lset {
 		res.Labels = append(res.Labels, storepb.Label{
@@ -126,6 +127,8 @@ func (p *PrometheusStore) Series(r *storepb.SeriesRequest, s storepb.Store_Serie
 			pm.Type = prompb.LabelMatcher_RE
 		case storepb.LabelMatcher_NRE:
 			pm.Type = prompb.LabelMatcher_NRE
+		case storepb.LabelMatcher_EQ_CI:
+			pm.Type, pm.Value = prompb.LabelMatcher_RE, caseInsensitiveRegexp(m.Value)
 		default:
 			return errors.New("unrecognized matcher type")
 		}
@@ -371,5 +374,7 @@ func (p *PrometheusStore) LabelValues(ctx context.Context, r *storepb.LabelValue
 	}
 	sort.Strings(m.Data)
 
-	return &storepb.LabelValuesResponse{Values: m.Data}, nil
+	res := &storepb.LabelValuesResponse{}
+	res.Values, res.Truncated = limitLabelValues(m.Data, r.Limit)
+	return res, nil
 }
@@ -273,6 +273,7 @@ func TestPrometheusStore_Info(t *testing.T) {
 	testutil.Equals(t, []storepb.Label{{Name: "region", Value: "eu-west"}}, resp.Labels)
 	testutil.Equals(t, int64(123), resp.MinTime)
 	testutil.Equals(t, int64(456), resp.MaxTime)
+	testutil.Equals(t, storepb.StoreType_SIDECAR, resp.StoreType)
 }
 
 // Regression test for https://github.com/improbable-eng/thanos/issues/396.
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"math"
+	"sort"
 	"strings"
 	"sync"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/strutil"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/tsdb/labels"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
@@ -32,6 +34,187 @@ type Client interface {
 	TimeRange() (mint int64, maxt int64)
 
 	String() string
+
+	// Addr returns address of the store. It is used as store identity.
+	Addr() string
+
+	// SeriesEstimate returns estimated number of series in the store, or zero if unknown.
+	SeriesEstimate() int64
+
+	// SupportsKnownChunks returns true if the store omits data of chunks listed as known by Series requests.
+	SupportsKnownChunks() bool
+}
+
+// StoreLimit caps the number of stores contacted by a single Series request after stores not matching the request
+// are filtered out, and the number of Series requests contacting a single store at once. It protects against fanout
+// explosion when matchers match everything and against queries of a single tenant overloading stores.
+type StoreLimit struct {
+	// Max is the maximum number of stores contacted. Zero means no limit.
+	Max int
+	// Truncate controls what happens when more stores match. By default the request fails. If Truncate is true,
+	// only Max stores holding the most data within the requested time range are contacted and a warning is returned.
+	Truncate bool
+	// MaxConcurrency is the maximum number of Series streams receiving from a single store concurrently. Streams over
+	// it wait for the store in a queue of their tenant, see ContextWithTenant, and tenants are served round robin, so
+	// a tenant sending many heavy queries does not starve others. Each store of a request is waited for on its own,
+	// so busy stores do not hold up calls to others. Zero means no limit.
+	MaxConcurrency int
+}
+
+type storeAddrsKey struct{}
+
+// ContextWithStoreAddrs returns a new context.Context that restricts ProxyStore Series, LabelNames and LabelValues
+// requests made with it to stores with the given addresses. Those stores are contacted even if their external labels or time range do not match the
+// request, so a single misbehaving store can be isolated when debugging.
+func ContextWithStoreAddrs(ctx context.Context, addrs ...string) context.Context {
+	allowed := make(map[string]struct{}, len(addrs))
+	for _, a := range addrs {
+		allowed[a] = struct{}{}
+	}
+	return context.WithValue(ctx, storeAddrsKey{}, allowed)
+}
+
+func storeAddrsFromContext(ctx context.Context) (map[string]struct{}, bool) {
+	allowed, ok := ctx.Value(storeAddrsKey{}).(map[string]struct{})
+	return allowed, ok
+}
+
+type labelValuesTimeRangeKey struct{}
+
+type timeRange struct{ mint, maxt int64 }
+
+// ContextWithLabelValuesTimeRange returns a new context.Context that makes LabelValues requests of ProxyStore made with
+// it skip stores with time range not overlapping the given one. Stores covering only part of it are asked, so values
+// are the union of stores holding complementary time ranges, like sidecars with recent data and store gateways with
+// historical data. Asked stores return values of all their data, as StoreAPI does not restrict them by time.
+func ContextWithLabelValuesTimeRange(ctx context.Context, mint, maxt int64) context.Context {
+	return context.WithValue(ctx, labelValuesTimeRangeKey{}, timeRange{mint: mint, maxt: maxt})
+}
+
+func labelValuesTimeRangeFromContext(ctx context.Context) (timeRange, bool) {
+	r, ok := ctx.Value(labelValuesTimeRangeKey{}).(timeRange)
+	return r, ok
+}
+
+type labelValuesSlotsKey struct{}
+
+// ContextWithLabelValuesSlots returns a new context.Context that makes LabelValues requests of ProxyStore made with it
+// call each store only within a free slot of the given channel. Requests sharing it, e.g. for multiple labels fetched
+// at once, contact stores at most as many times concurrently as its capacity.
+func ContextWithLabelValuesSlots(ctx context.Context, slots chan struct{}) context.Context {
+	return context.WithValue(ctx, labelValuesSlotsKey{}, slots)
+}
+
+func labelValuesSlotsFromContext(ctx context.Context) chan struct{} {
+	s, _ := ctx.Value(labelValuesSlotsKey{}).(chan struct{})
+	return s
+}
+
+type seriesBatchSizeKey struct{}
+
+// maxSeriesShards caps the number of shards a Series request to a single store is split into.
+const maxSeriesShards = 64
+
+// ContextWithSeriesBatchSize returns a new context.Context that makes ProxyStore split Series requests made with it
+// into shards of about the given number of series for stores estimating to hold more series. Shards are requested as
+// separate streams and merged, so memory held by each stream on both sides is bounded. Zero disables splitting.
+func ContextWithSeriesBatchSize(ctx context.Context, size int64) context.Context {
+	return context.WithValue(ctx, seriesBatchSizeKey{}, size)
+}
+
+func seriesBatchSizeFromContext(ctx context.Context) int64 {
+	v, _ := ctx.Value(seriesBatchSizeKey{}).(int64)
+	return v
+}
+
+type allStoresFailedErrorKey struct{}
+
+// ContextWithAllStoresFailedError returns a new context.Context that makes Series requests of ProxyStore made with it
+// fail if all stores queried for the request failed, even if partial response is enabled. Otherwise such requests
+// succeed with no series and a warning for each failed store. Requests to stores that all succeeded without any
+// series are successful either way.
+func ContextWithAllStoresFailedError(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allStoresFailedErrorKey{}, true)
+}
+
+func allStoresFailedErrorFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(allStoresFailedErrorKey{}).(bool)
+	return v
+}
+
+// seriesRequestShards returns requests the given Series request to the store is split into. Only stores estimating
+// to hold more series than the batch size are split, as they support shards.
+func seriesRequestShards(st Client, r *storepb.SeriesRequest, batchSize int64) []*storepb.SeriesRequest {
+	if batchSize <= 0 || r.Shard != nil {
+		return []*storepb.SeriesRequest{r}
+	}
+	estimate := st.SeriesEstimate()
+	if estimate <= batchSize {
+		return []*storepb.SeriesRequest{r}
+	}
+	n := (estimate + batchSize - 1) / batchSize
+	if n > maxSeriesShards {
+		n = maxSeriesShards
+	}
+	shards := storepb.SeriesShards(int(n))
+	res := make([]*storepb.SeriesRequest, 0, len(shards))
+	for i := range shards {
+		sr := *r
+		sr.Shard = &shards[i]
+		res = append(res, &sr)
+	}
+	return res
+}
+
+type storeHealthKey struct{}
+
+// StoreHealth records whether stores responded successfully to Series requests of ProxyStore made with a context
+// holding it, see ContextWithStoreHealth. Stores skipped for the request are recorded as not responding. If a store
+// is recorded multiple times, e.g. for multiple requests of a query, it is up only if it responded to all of them.
+type StoreHealth struct {
+	mtx sync.Mutex
+	up  map[string]bool
+}
+
+// NewStoreHealth returns empty StoreHealth.
+func NewStoreHealth() *StoreHealth {
+	return &StoreHealth{up: map[string]bool{}}
+}
+
+// ContextWithStoreHealth returns a new context.Context that makes ProxyStore record outcome of each store in the
+// given StoreHealth.
+func ContextWithStoreHealth(ctx context.Context, h *StoreHealth) context.Context {
+	return context.WithValue(ctx, storeHealthKey{}, h)
+}
+
+func storeHealthFromContext(ctx context.Context) *StoreHealth {
+	h, _ := ctx.Value(storeHealthKey{}).(*StoreHealth)
+	return h
+}
+
+func (h *StoreHealth) set(store string, up bool) {
+	if h == nil {
+		return
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if prev, ok := h.up[store]; ok && !prev {
+		return
+	}
+	h.up[store] = up
+}
+
+// Stores returns recorded stores identified by their addresses with true for those that responded successfully.
+func (h *StoreHealth) Stores() map[string]bool {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	res := make(map[string]bool, len(h.up))
+	for s, up := range h.up {
+		res[s] = up
+	}
+	return res
 }
 
 // ProxyStore implements the store API that proxies request to all given underlying stores.
@@ -39,14 +222,60 @@ type ProxyStore struct {
 	logger         log.Logger
 	stores         func(context.Context) ([]Client, error)
 	selectorLabels labels.Labels
+	storeLimit     StoreLimit
+	tenantLabel    string
+	metrics        *proxyStoreMetrics
+	queues         *fairQueues
+}
+
+type proxyStoreMetrics struct {
+	outOfOrderStreams    *prometheus.CounterVec
+	tenantInflight       *prometheus.GaugeVec
+	retryBudgetExhausted prometheus.Counter
+}
+
+func newProxyStoreMetrics(reg prometheus.Registerer) *proxyStoreMetrics {
+	m := &proxyStoreMetrics{
+		outOfOrderStreams: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_proxy_store_out_of_order_series_streams_total",
+			Help: "Number of Series streams of the store that sent series not ordered by labels, which are sorted by the querier.",
+		}, []string{"store"}),
+		tenantInflight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_proxy_store_tenant_inflight_series_requests",
+			Help: "Number of Series streams of the tenant concurrently receiving from stores, if concurrency per store is limited. Tenants seen after the first 100 are counted as other.",
+		}, []string{"tenant"}),
+		retryBudgetExhausted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_proxy_store_retry_budget_exhausted_total",
+			Help: "Number of failed store calls not retried because the retry budget of their query was exhausted.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(m.outOfOrderStreams, m.tenantInflight, m.retryBudgetExhausted)
+	}
+	return m
+}
+
+// ProxyStoreOption configures optional behavior of ProxyStore.
+type ProxyStoreOption func(*ProxyStore)
+
+// WithTenantLabel makes requests with an equality matcher for the given label be sent only to stores with external
+// label of the matched tenant. Stores without the label are then filtered out too.
+func WithTenantLabel(label string) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.tenantLabel = label
+	}
 }
 
 // NewProxyStore returns a new ProxyStore that uses the given clients that implements storeAPI to fan-in all series to the client.
 // Note that there is no deduplication support. Deduplication should be done on the highest level (just before PromQL)
+// Metrics are registered with reg, if given.
 func NewProxyStore(
 	logger log.Logger,
+	reg prometheus.Registerer,
 	stores func(context.Context) ([]Client, error),
 	selectorLabels labels.Labels,
+	storeLimit StoreLimit,
+	opts ...ProxyStoreOption,
 ) *ProxyStore {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -55,16 +284,23 @@ func NewProxyStore(
 		logger:         logger,
 		stores:         stores,
 		selectorLabels: selectorLabels,
+		storeLimit:     storeLimit,
+		metrics:        newProxyStoreMetrics(reg),
+	}
+	for _, o := range opts {
+		o(s)
 	}
+	s.queues = newFairQueues(storeLimit.MaxConcurrency, s.metrics.tenantInflight)
 	return s
 }
 
 // Info returns store information about the external labels this store have.
 func (s *ProxyStore) Info(ctx context.Context, r *storepb.InfoRequest) (*storepb.InfoResponse, error) {
 	res := &storepb.InfoResponse{
-		MinTime: 0,
-		MaxTime: math.MaxInt64,
-		Labels:  make([]storepb.Label, 0, len(s.selectorLabels)),
+		MinTime:   0,
+		MaxTime:   math.MaxInt64,
+		Labels:    make([]storepb.Label, 0, len(s.selectorLabels)),
+		StoreType: storepb.StoreType_QUERY,
 	}
 	for _, l := range s.selectorLabels {
 		res.Labels = append(res.Labels, storepb.Label{
@@ -112,6 +348,28 @@ func (s *ProxyStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesSe
 		return status.Errorf(codes.Unknown, err.Error())
 	}
 
+	// We might be able to skip stores if their meta information indicates
+	// they cannot have series matching our query.
+	matched, skipped := s.newStoreSelector(srv.Context(), r.MinTime, r.MaxTime, newMatchers).selectStores(stores)
+	health := storeHealthFromContext(srv.Context())
+	outcomes := storeOutcomesFromContext(srv.Context())
+	storeDebugMsgs := make([]string, 0, len(stores))
+	for _, d := range skipped {
+		storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("store %s filtered out, %s", d.store, d.reason))
+		health.set(d.store.Addr(), false)
+		outcomes.add(d.store.String(), StoreOutcome{SkipReason: string(d.reason)})
+	}
+	if len(matched) == 0 {
+		// Nothing to fan out to, e.g. external label matchers exclude all stores, so return empty result right away.
+		// It indicates that configured StoreAPIs are not the ones end user expects.
+		err := errors.New("No store matched for this query")
+		level.Warn(s.logger).Log("err", err, "stores", strings.Join(storeDebugMsgs, ";"))
+		if err := srv.Send(storepb.NewWarnSeriesResponse(err)); err != nil {
+			return status.Error(codes.Unknown, errors.Wrap(err, "send series response").Error())
+		}
+		return nil
+	}
+
 	var (
 		g, gctx = errgroup.WithContext(srv.Context())
 
@@ -121,71 +379,230 @@ func (s *ProxyStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesSe
 	)
 
 	g.Go(func() error {
+		// Streams are canceled as soon as one of them fails the request, so other stores are not waited for.
+		streamCtx, cancelStreams := context.WithCancel(gctx)
+		defer cancelStreams()
+
 		var (
-			seriesSet      []storepb.SeriesSet
-			storeDebugMsgs []string
-			r              = &storepb.SeriesRequest{
+			seriesSet []storepb.SeriesSet
+			streams   []*streamSeriesSet
+			r         = &storepb.SeriesRequest{
 				MinTime:                 r.MinTime,
 				MaxTime:                 r.MaxTime,
-				Matchers:                newMatchers,
+				Matchers:                forwardedMatchers(newMatchers),
 				Aggregates:              r.Aggregates,
 				MaxResolutionWindow:     r.MaxResolutionWindow,
 				PartialResponseDisabled: r.PartialResponseDisabled,
+				SkipChunks:              r.SkipChunks,
+				Hints:                   r.Hints,
+				ReportQueriedBlocks:     r.ReportQueriedBlocks,
+				Shard:                   r.Shard,
+				ChunkEncoding:           r.ChunkEncoding,
+				AcceptCompressedChunks:  r.AcceptCompressedChunks,
 			}
 			wg = &sync.WaitGroup{}
+			// Failures of stores that failed to open any of their streams, by store.
+			openFailures = map[string]error{}
 		)
 
+		var progress *progressTracker
 		defer func() {
 			wg.Wait()
+			progress.done()
+			for name, err := range openFailures {
+				outcomes.add(name, StoreOutcome{Failure: err.Error()})
+			}
+			for _, st := range streams {
+				health.set(st.addr, st.up)
+				outcomes.add(st.name, st.outcome())
+				if st.downsampled {
+					outcomes.addDownsampled(st.addr)
+				}
+				if st.outOfOrder {
+					level.Debug(s.logger).Log("msg", "store sent series out of order", "store", st.name)
+					s.metrics.outOfOrderStreams.WithLabelValues(st.addr).Inc()
+				}
+			}
 			closeFn()
 		}()
 
-		for _, st := range stores {
-			// We might be able to skip the store if its meta information indicates
-			// it cannot have series matching our query.
-			// NOTE: all matchers are validated in labelsMatches method so we explicitly ignore error.
-			if ok, _ := storeMatches(st, r.MinTime, r.MaxTime, r.Matchers...); !ok {
-				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("store %s filtered out", st))
-				continue
+		if max := s.storeLimit.Max; max > 0 && len(matched) > max {
+			if !s.storeLimit.Truncate {
+				return status.Errorf(codes.InvalidArgument, "query matches %d stores, more than the limit of %d", len(matched), max)
+			}
+			respSender.send(storepb.NewWarnSeriesResponse(errors.Errorf("query matches %d stores, only %d of them with the most data in the requested time range were queried", len(matched), max)))
+			matched = storesWithMostData(matched, r.MinTime, r.MaxTime)
+			for _, st := range matched[max:] {
+				health.set(st.Addr(), false)
+				outcomes.add(st.String(), StoreOutcome{SkipReason: storeOverLimit})
 			}
-			storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("store %s queried", st))
+			matched = matched[:max]
+		}
 
-			sc, err := st.Series(gctx, r)
-			if err != nil {
-				storeID := fmt.Sprintf("%v", storepb.LabelsToString(st.Labels()))
-				if storeID == "" {
-					storeID = "Store Gateway"
+		progress = newProgressTracker(seriesProgressFromContext(srv.Context()), len(matched))
+		tenant, ok := tenantFromContext(srv.Context())
+		if !ok {
+			tenant, _ = tenantMatcher(s.tenantLabel, newMatchers)
+		}
+
+		batchSize := seriesBatchSizeFromContext(srv.Context())
+		cache := chunkCacheFromContext(srv.Context())
+		bytesLimit := storeChunkBytesLimitFromContext(srv.Context())
+		retryBudget := retryBudgetFromContext(srv.Context())
+	open:
+		for i, st := range matched {
+			reqs := seriesRequestShards(st, r, batchSize)
+			var budget *chunkBytesBudget
+			if bytesLimit > 0 {
+				// Shards of the store share its budget.
+				budget = &chunkBytesBudget{store: st.String(), limit: bytesLimit}
+			}
+			if len(reqs) > 1 {
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("store %s queried in %d shards", st, len(reqs)))
+			} else {
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("store %s queried", st))
+			}
+
+			for _, r := range reqs {
+				var pinned map[chunkCacheKey]pinnedChunk
+				// Other stores send all chunks, so listing cached ones would only make requests bigger.
+				if cache != nil && st.SupportsKnownChunks() {
+					r, pinned = cache.known(st.Addr(), r)
 				}
-				err = errors.Wrapf(err, "fetch series for %s %s", storeID, st)
-				if r.PartialResponseDisabled {
-					level.Error(s.logger).Log("err", err, "msg", "partial response disabled; aborting request")
-					return err
+				// Nothing was consumed from a stream failing on its first receive, so the request can be safely sent again.
+				st, r := st, r
+				prefixes := storeDeclaresPrefixes(st)
+				wrap := func(sc storepb.Store_SeriesClient) storepb.Store_SeriesClient {
+					if prefixes {
+						sc = &servedPrefixSeriesClient{Store_SeriesClient: sc}
+					}
+					// Budget counts only chunks sent by the store, not the ones filled in from the cache.
+					if budget != nil {
+						sc = &chunkBytesLimitSeriesClient{Store_SeriesClient: sc, budget: budget}
+					}
+					if cache != nil {
+						sc = &chunkCacheSeriesClient{Store_SeriesClient: sc, cache: cache, store: st.Addr(), pinned: pinned}
+					}
+					return sc
+				}
+				openStream := func() (storepb.Store_SeriesClient, error) {
+					sc, err := st.Series(streamCtx, r)
+					if err != nil {
+						return nil, err
+					}
+					return wrap(sc), nil
+				}
+				retry := func() (storepb.Store_SeriesClient, error) {
+					if !retryBudget.take() {
+						s.metrics.retryBudgetExhausted.Inc()
+						level.Debug(s.logger).Log("msg", "retry budget exhausted, not retrying failed store", "store", st)
+						return nil, nil
+					}
+					return openStream()
 				}
-				respSender.send(storepb.NewWarnSeriesResponse(err))
-				continue
-			}
 
-			// Schedule streamSeriesSet that translates gRPC streamed response into seriesSet (if series) or respCh if warnings.
-			seriesSet = append(seriesSet, startStreamSeriesSet(gctx, wg, sc, respSender, st.String(), !r.PartialResponseDisabled))
+				// With concurrency of the store limited, the stream is opened once it takes a slot of the store, so
+				// stores with free slots are contacted right away, no matter how long others are busy.
+				slot := s.queues.slot(st.Addr(), tenant)
+				start := openStream
+				if slot == nil {
+					sc, err := openStream()
+					if canceledByCaller(streamCtx, err) {
+						// Stream that is already open failed the request or the request is past its deadline. Its error
+						// is returned once open streams are merged, not the cancellation of this one.
+						break open
+					}
+					if err != nil {
+						storeID := fmt.Sprintf("%v", storepb.LabelsToString(st.Labels()))
+						if storeID == "" {
+							storeID = "Store Gateway"
+						}
+						health.set(st.Addr(), false)
+						err = errors.Wrapf(explainStoreErr(err), "fetch series for %s %s", storeID, st)
+						if r.PartialResponseDisabled {
+							level.Error(s.logger).Log("err", err, "msg", "partial response disabled; aborting request")
+							return err
+						}
+						openFailures[st.String()] = err
+						respSender.send(storepb.NewWarnSeriesResponse(err))
+						// Other shards of the store would fail the same way.
+						break
+					}
+					start = func() (storepb.Store_SeriesClient, error) { return sc, nil }
+				}
+
+				// Schedule streamSeriesSet that translates gRPC streamed response into seriesSet (if series) or respCh if warnings
+				// or queried blocks. Shards of a store hold disjoint series, so they are merged like streams of different stores.
+				i := i
+				progress.streamStarted(i)
+				stream := startStreamSeriesSet(streamCtx, cancelStreams, wg, func() { progress.streamDone(i) }, slot, start, retry, respSender, st.String(), !r.PartialResponseDisabled)
+				stream.addr = st.Addr()
+				stream.shard = r.Shard
+				streams = append(streams, stream)
+				seriesSet = append(seriesSet, stream)
+			}
+			progress.storeOpened(i)
 		}
 
 		level.Debug(s.logger).Log("msg", strings.Join(storeDebugMsgs, ";"))
 
+		failOnAll := !r.PartialResponseDisabled && allStoresFailedErrorFromContext(srv.Context())
 		if len(seriesSet) == 0 {
-			// This is indicates that configured StoreAPIs are not the ones end user expects
-			err := errors.New("No store matched for this query")
-			level.Warn(s.logger).Log("err", err, "stores", strings.Join(storeDebugMsgs, ";"))
-			respSender.send(storepb.NewWarnSeriesResponse(err))
+			if err := gctx.Err(); err != nil {
+				return errors.Wrap(err, "open series streams")
+			}
+			// All queried stores failed and their failures were already sent as warnings.
+			if failOnAll {
+				return allStoresFailed(len(matched), openFailures, nil)
+			}
+			return nil
+		}
+
+		if f := unmergedSeriesFromContext(srv.Context()); f != nil {
+			passed, err := passUnmerged(gctx, streams, r.Shard, f, progress)
+			if err != nil {
+				return err
+			}
+			if err := gctx.Err(); err != nil {
+				return errors.Wrapf(err, "pass unmerged series, %d series passed", passed)
+			}
+			if failOnAll {
+				wg.Wait()
+				return allStoresFailed(len(matched), openFailures, streams)
+			}
 			return nil
 		}
 
 		mergedSet := storepb.MergeSeriesSets(seriesSet...)
+		merged := 0
 		for mergedSet.Next() {
+			// Check the context between series, so series merged before the deadline are kept. The set is still
+			// drained, so the stream goroutines are not blocked.
+			if gctx.Err() != nil {
+				continue
+			}
 			var series storepb.Series
 			series.Labels, series.Chunks = mergedSet.At()
+			// Stores not supporting shards return all matching series.
+			if !r.Shard.Matches(series.Labels) {
+				continue
+			}
 			respSender.send(storepb.NewSeriesResponse(&series))
+			progress.seriesMerged()
+			merged++
+		}
+		if err := gctx.Err(); err != nil {
+			return errors.Wrapf(err, "merge series, %d series merged", merged)
 		}
-		return mergedSet.Err()
+		if err := mergedSet.Err(); err != nil {
+			return err
+		}
+		if failOnAll {
+			// Outcome of streams is known only once they are done.
+			wg.Wait()
+			return allStoresFailed(len(matched), openFailures, streams)
+		}
+		return nil
 	})
 
 	for resp := range respRecv {
@@ -195,13 +612,215 @@ func (s *ProxyStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesSe
 	}
 
 	if err := g.Wait(); err != nil {
+		if !r.PartialResponseDisabled && errors.Cause(err) == context.DeadlineExceeded {
+			// Series merged before the deadline were already sent, so return them as partial response.
+			warn := storepb.NewWarnSeriesResponse(errors.Wrap(err, "query timed out, returning partial result"))
+			if err := srv.Send(warn); err != nil {
+				return status.Error(codes.Unknown, errors.Wrap(err, "send series response").Error())
+			}
+			return nil
+		}
 		level.Error(s.logger).Log("err", err)
+		// Keep status code of the failed store, so clients can tell e.g. invalid requests from internal errors.
+		if st, ok := status.FromError(errors.Cause(err)); ok {
+			return status.Error(st.Code(), err.Error())
+		}
 		return err
 	}
 	return nil
 
 }
 
+// allStoresFailed returns an error if each of the queried stores failed to open or receive all of its streams. Stores
+// split into shards succeeded if any of their shards was received, so failures of streams are told apart by shard.
+func allStoresFailed(queried int, openFailures map[string]error, streams []*streamSeriesSet) error {
+	failures := make(map[string]error, queried)
+	for name, err := range openFailures {
+		failures[name] = err
+	}
+	for _, st := range streams {
+		if st.up {
+			return nil
+		}
+		key := st.name
+		if st.shard != nil {
+			key = fmt.Sprintf("%s shard %s", st.name, st.shard)
+		}
+		failures[key] = st.failure
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(failures))
+	for key := range failures {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// Report the first failure, so the error is the same for the same failures.
+	err := failures[keys[0]]
+	if err == nil {
+		err = errors.Errorf("receive series from %s", keys[0])
+	}
+	return status.Error(codes.Unavailable, errors.Wrapf(err, "all %d queried stores failed", queried).Error())
+}
+
+// storesWithMostData sorts stores by the length of overlap of their time range with the given one, descending.
+// Stores with equal overlap keep their order.
+func storesWithMostData(stores []Client, mint, maxt int64) []Client {
+	overlap := func(st Client) int64 {
+		smint, smaxt := st.TimeRange()
+		if smint < mint {
+			smint = mint
+		}
+		if smaxt > maxt {
+			smaxt = maxt
+		}
+		return smaxt - smint
+	}
+	sort.SliceStable(stores, func(i, j int) bool {
+		return overlap(stores[i]) > overlap(stores[j])
+	})
+	return stores
+}
+
+// SeriesStores returns addresses of stores that have at least one series for the requested time range and label
+// matchers. Stores are asked to skip chunks and each stream is closed after the first series, so it is much cheaper
+// than Series. Failures of stores are returned as warnings unless partial response is disabled.
+func (s *ProxyStore) SeriesStores(ctx context.Context, r *storepb.SeriesRequest) (addrs []string, warnings []string, err error) {
+	match, newMatchers, err := labelsMatches(s.selectorLabels, r.Matchers)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !match {
+		return nil, nil, nil
+	}
+
+	stores, err := s.stores(ctx)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Unknown, errors.Wrap(err, "failed to get store APIs").Error())
+	}
+
+	var (
+		mtx     sync.Mutex
+		g, gctx = errgroup.WithContext(ctx)
+		req     = &storepb.SeriesRequest{
+			MinTime:                 r.MinTime,
+			MaxTime:                 r.MaxTime,
+			Matchers:                forwardedMatchers(newMatchers),
+			MaxResolutionWindow:     r.MaxResolutionWindow,
+			Aggregates:              r.Aggregates,
+			PartialResponseDisabled: r.PartialResponseDisabled,
+			SkipChunks:              true,
+		}
+	)
+	matched, _ := s.newStoreSelector(ctx, req.MinTime, req.MaxTime, req.Matchers).selectStores(stores)
+	for _, st := range matched {
+		store := st
+		g.Go(func() error {
+			ok, warns, err := hasSeries(gctx, store, req)
+			if err != nil {
+				err = errors.Wrapf(err, "probe series of store %s", store)
+				if req.PartialResponseDisabled {
+					return err
+				}
+				warns = append(warns, err.Error())
+			}
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			warnings = append(warnings, warns...)
+			if ok {
+				addrs = append(addrs, store.Addr())
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(addrs)
+	return addrs, warnings, nil
+}
+
+// hasSeries returns true if the store streams at least one series for the given request.
+func hasSeries(ctx context.Context, st Client, r *storepb.SeriesRequest) (bool, []string, error) {
+	// Cancel the stream once we know the answer.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sc, err := st.Series(ctx, r)
+	if err != nil {
+		return false, nil, err
+	}
+
+	var warnings []string
+	for {
+		resp, err := sc.Recv()
+		if err == io.EOF {
+			return false, warnings, nil
+		}
+		if err != nil {
+			return false, warnings, err
+		}
+		if w := resp.GetWarning(); w != "" {
+			warnings = append(warnings, w)
+			continue
+		}
+		return true, warnings, nil
+	}
+}
+
+// StoreStats are totals of data held by a single store.
+type StoreStats struct {
+	// Addr is the address of the store.
+	Addr  string             `json:"addr"`
+	Stats storepb.StoreStats `json:"stats"`
+}
+
+// StoreStats asks all stores for totals of data they hold, without fetching any data. Stores not reporting them are
+// skipped. Failures of stores are returned as warnings unless partial response is disabled. Stats are sorted by
+// address of the store.
+func (s *ProxyStore) StoreStats(ctx context.Context, partialResponseDisabled bool) (stats []StoreStats, warnings []string, err error) {
+	stores, err := s.stores(ctx)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Unknown, errors.Wrap(err, "failed to get store APIs").Error())
+	}
+
+	var (
+		mtx     sync.Mutex
+		g, gctx = errgroup.WithContext(ctx)
+	)
+	for _, st := range stores {
+		store := st
+		g.Go(func() error {
+			info, err := store.Info(gctx, &storepb.InfoRequest{ReportStats: true})
+			if err != nil {
+				err = errors.Wrapf(err, "fetch stats of store %s", store)
+				if partialResponseDisabled {
+					return err
+				}
+				mtx.Lock()
+				warnings = append(warnings, err.Error())
+				mtx.Unlock()
+				return nil
+			}
+			if info.Stats == nil {
+				return nil
+			}
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			stats = append(stats, StoreStats{Addr: store.Addr(), Stats: *info.Stats})
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats, warnings, nil
+}
+
 type warnSender interface {
 	send(*storepb.SeriesResponse)
 }
@@ -219,18 +838,60 @@ type streamSeriesSet struct {
 	err    error
 
 	name string
+	addr string
+	// Shard of the store requested by the stream, nil if the store was not split into shards.
+	shard *storepb.SeriesShard
+	// True if the store sent series not ordered by labels. Merge of such stream yields series out of order too, which
+	// is left to be fixed by the querier. Set before the receiving goroutine is done.
+	outOfOrder bool
+	// True if the whole stream was received. Set before the receiving goroutine is done.
+	up bool
+	// Failure of the stream reported as warning with partial response enabled. Set before the receiving goroutine is
+	// done.
+	failure error
+	// Number of series received, counting consecutive responses of the same series once. Set before the receiving
+	// goroutine is done.
+	series int
+	// True if the store sent any downsampled chunk. Set before the receiving goroutine is done.
+	downsampled bool
+}
+
+// outcome returns outcome of the stream. It must be called once the receiving goroutine is done.
+func (s *streamSeriesSet) outcome() StoreOutcome {
+	o := StoreOutcome{Series: s.series}
+	if s.up {
+		return o
+	}
+	if s.failure != nil {
+		o.Failure = s.failure.Error()
+	} else if s.err != nil {
+		o.Failure = s.err.Error()
+	}
+	return o
 }
 
+// startStreamSeriesSet starts receiving the stream returned by open. If the stream fails before any response was
+// received, retry is called once to open a new one in its place. If it returns no stream and no error, e.g. as the
+// retry budget is exhausted, the failure is not retried. Failures after that are never retried, as series already
+// received would be duplicated. Unless partial response is enabled, a failure calls cancel, which is expected to
+// cancel ctx of all streams of the request. Once receiving is over, done is called, if not nil.
+//
+// If slot is not nil, the stream is opened and received only while the slot is taken. The slot is freed while
+// received series wait for the consumer, so streams never hold slots waiting for other streams, which may wait for
+// slots themselves.
 func startStreamSeriesSet(
 	ctx context.Context,
+	cancel func(),
 	wg *sync.WaitGroup,
-	stream storepb.Store_SeriesClient,
+	done func(),
+	slot *storeSlot,
+	open func() (storepb.Store_SeriesClient, error),
+	retry func() (storepb.Store_SeriesClient, error),
 	warnCh warnSender,
 	name string,
 	partialResponse bool,
 ) *streamSeriesSet {
 	s := &streamSeriesSet{
-		stream: stream,
 		warnCh: warnCh,
 		recvCh: make(chan *storepb.Series, 10),
 		name:   name,
@@ -239,39 +900,133 @@ func startStreamSeriesSet(
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		if done != nil {
+			defer done()
+		}
 		defer close(s.recvCh)
+		defer slot.free()
+
+		fail := func(err error, msg string) {
+			err = explainStoreErr(err)
+			if partialResponse {
+				s.failure = errors.Wrapf(err, "%s from %s", msg, s.name)
+				s.warnCh.send(storepb.NewWarnSeriesResponse(errors.Wrap(err, msg)))
+				return
+			}
+
+			s.errMtx.Lock()
+			s.err = err
+			s.errMtx.Unlock()
+			cancel()
+		}
+
+		if err := slot.take(ctx); err != nil {
+			// Request is done before the store had a free slot.
+			return
+		}
+		stream, err := open()
+		if err != nil {
+			if ctx.Err() == nil {
+				fail(err, "open series stream")
+			}
+			return
+		}
+		s.stream = stream
+
+		var (
+			received = false
+			// Copy of labels of the last received series. Receivers of series may modify their labels, e.g. to intern
+			// them, while the next series is received.
+			lastLabels []storepb.Label
+			haveLast   bool
+		)
 		for {
+			if err := slot.take(ctx); err != nil {
+				return
+			}
 			r, err := s.stream.Recv()
 			if err == io.EOF {
+				s.up = true
 				return
 			}
 
 			if ctx.Err() != nil {
+				// Receiving was stopped by the proxy, either after another stream failed the request or past its
+				// deadline. Any error of the stream is caused by that, so it is not a failure of the store.
 				return
 			}
 
-			if err != nil {
-				if partialResponse {
-					s.warnCh.send(storepb.NewWarnSeriesResponse(errors.Wrap(err, "receive series")))
-					return
+			// Otherwise errors come from the store, including Canceled or DeadlineExceeded sent by it.
+			if err != nil && !received && retry != nil && retriableStoreErr(err) {
+				stream, rerr := retry()
+				retry = nil
+				if rerr == nil && stream != nil {
+					s.stream = stream
+					continue
+				}
+				if rerr != nil {
+					err = rerr
 				}
+			}
 
-				s.errMtx.Lock()
-				defer s.errMtx.Unlock()
-				s.err = err
+			if err != nil {
+				fail(err, "receive series")
 				return
 			}
 
+			received = true
+
 			if w := r.GetWarning(); w != "" {
 				s.warnCh.send(storepb.NewWarnSeriesResponse(errors.New(w)))
 				continue
 			}
-			s.recvCh <- r.GetSeries()
+			if qb := r.GetQueriedBlocks(); qb != nil {
+				// Blocks reported through another proxy are already attributed to their store.
+				if qb.Store == "" {
+					qb.Store = s.name
+				}
+				s.warnCh.send(r)
+				continue
+			}
+			if series := r.GetSeries(); series != nil {
+				if !haveLast || storepb.CompareLabels(series.Labels, lastLabels) != 0 {
+					s.series++
+				}
+				if haveLast && storepb.CompareLabels(series.Labels, lastLabels) < 0 {
+					s.outOfOrder = true
+				}
+				lastLabels, haveLast = append(lastLabels[:0], series.Labels...), true
+				if !s.downsampled && hasAggrChunks(series.Chunks) {
+					s.downsampled = true
+				}
+			}
+			select {
+			case s.recvCh <- r.GetSeries():
+				continue
+			default:
+			}
+			// Consumer waits for other streams of the request.
+			slot.free()
+			select {
+			case s.recvCh <- r.GetSeries():
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 	return s
 }
 
+// hasAggrChunks returns true if any of the chunks is downsampled, i.e. holds aggregates instead of raw samples.
+func hasAggrChunks(chks []storepb.AggrChunk) bool {
+	for _, c := range chks {
+		if c.Raw == nil {
+			return true
+		}
+	}
+	return false
+}
+
 // Next blocks until new message is received or stream is closed.
 func (s *streamSeriesSet) Next() (ok bool) {
 	s.currSeries, ok = <-s.recvCh
@@ -290,11 +1045,140 @@ func (s *streamSeriesSet) Err() error {
 	return errors.Wrap(s.err, s.name)
 }
 
-// matchStore returns true if the given store may hold data for the given label matchers.
-func storeMatches(s Client, mint, maxt int64, matchers ...storepb.LabelMatcher) (bool, error) {
+// canceledByCaller returns true if the call to a store failed because its context is done, i.e. the proxy canceled
+// it after another store failed the request or the request is past its deadline. Such errors are not failures of the
+// store, unlike Canceled or DeadlineExceeded errors sent by the store itself.
+func canceledByCaller(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() != nil
+}
+
+// explainStoreErr adds actionable explanation to errors of stores that are not self-explanatory.
+// RESOURCE_EXHAUSTED is returned by gRPC when a message exceeds the size limits of the store or the querier.
+// StoreAPI has no way to ask a store for smaller messages, so such request cannot be retried.
+func explainStoreErr(err error) error {
+	if status.Code(errors.Cause(err)) != codes.ResourceExhausted {
+		return err
+	}
+	return errors.Wrap(err, "store response exceeds gRPC message size limit; query shorter time range or fewer series, or increase the message size limits of the store and the querier")
+}
+
+// retriableStoreErr returns true if the request failing with the given store error may succeed when sent again.
+// Requests canceled by the querier, too big for gRPC message size limits or exceeding the chunk bytes limit of the store
+// fail again the same way.
+func retriableStoreErr(err error) bool {
+	if _, ok := errors.Cause(err).(chunkBytesLimitErr); ok {
+		return false
+	}
+	switch status.Code(errors.Cause(err)) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted, codes.InvalidArgument, codes.Unimplemented:
+		return false
+	}
+	return true
+}
+
+// tenantMatcher returns the tenant selected by an equality matcher for the given tenant label, if there is one.
+func tenantMatcher(tenantLabel string, matchers []storepb.LabelMatcher) (string, bool) {
+	if tenantLabel == "" {
+		return "", false
+	}
+	for _, m := range matchers {
+		if m.Name == tenantLabel && m.Type == storepb.LabelMatcher_EQ && m.Value != "" {
+			return m.Value, true
+		}
+	}
+	return "", false
+}
+
+// storeHasLabel returns true if the store has external label with the given name and value.
+func storeHasLabel(s Client, name, value string) bool {
+	for _, l := range s.Labels() {
+		if l.Name == name {
+			return l.Value == value
+		}
+	}
+	return false
+}
+
+// storeSkipReason tells why a store was skipped for a request. Empty reason means the store was selected.
+type storeSkipReason string
+
+const (
+	storeSelected      storeSkipReason = ""
+	storeOutOfRange    storeSkipReason = "out of time range"
+	storeLabelMismatch storeSkipReason = "external labels not matching"
+	storeOtherTenant   storeSkipReason = "not serving tenant"
+	storeOtherMetric   storeSkipReason = "not serving metric"
+	storeNotRequested  storeSkipReason = "not requested"
+)
+
+// storeDecision is decision of storeSelector about a single store.
+type storeDecision struct {
+	store  Client
+	reason storeSkipReason
+}
+
+// storeSelector decides in a single pass over stores which of them may hold data for a request, based on their time
+// range and external labels, the tenant and metric selected by the request and store addresses requested explicitly.
+type storeSelector struct {
+	mint, maxt  int64
+	matchers    []storepb.LabelMatcher
+	tenantLabel string
+	tenant      string
+	scoped      bool
+	metric      string
+	allowed     map[string]struct{}
+	only        bool
+}
+
+// newStoreSelector returns storeSelector for request with the given time range and matchers. Matchers must be
+// already validated.
+func (s *ProxyStore) newStoreSelector(ctx context.Context, mint, maxt int64, matchers []storepb.LabelMatcher) storeSelector {
+	sel := storeSelector{mint: mint, maxt: maxt, matchers: matchers, tenantLabel: s.tenantLabel}
+	sel.tenant, sel.scoped = tenantMatcher(s.tenantLabel, matchers)
+	sel.metric, _ = metricMatcher(matchers)
+	sel.allowed, sel.only = storeAddrsFromContext(ctx)
+	return sel
+}
+
+// selectStores returns stores selected for the request and decisions with reasons for all skipped ones.
+func (sel storeSelector) selectStores(stores []Client) (matched []Client, skipped []storeDecision) {
+	for _, st := range stores {
+		if reason := sel.decide(st); reason != storeSelected {
+			skipped = append(skipped, storeDecision{store: st, reason: reason})
+			continue
+		}
+		matched = append(matched, st)
+	}
+	return matched, skipped
+}
+
+func (sel storeSelector) decide(st Client) storeSkipReason {
+	// Explicitly requested stores are queried regardless of their meta information.
+	if sel.only {
+		if _, ok := sel.allowed[st.Addr()]; !ok {
+			return storeNotRequested
+		}
+		return storeSelected
+	}
+	// NOTE: all matchers are validated by the caller so we explicitly ignore error.
+	if reason, _ := matchStore(st, sel.mint, sel.maxt, sel.matchers...); reason != storeSelected {
+		return reason
+	}
+	if sel.scoped && !storeHasLabel(st, sel.tenantLabel, sel.tenant) {
+		return storeOtherTenant
+	}
+	if sel.metric != "" && !storeServesMetric(st, sel.metric) {
+		return storeOtherMetric
+	}
+	return storeSelected
+}
+
+// matchStore returns storeSelected if the given store may hold data for the given time range and label matchers,
+// otherwise the reason why it cannot.
+func matchStore(s Client, mint, maxt int64, matchers ...storepb.LabelMatcher) (storeSkipReason, error) {
 	storeMinTime, storeMaxTime := s.TimeRange()
 	if mint > storeMaxTime || maxt < storeMinTime {
-		return false, nil
+		return storeOutOfRange, nil
 	}
 	for _, m := range matchers {
 		for _, l := range s.Labels() {
@@ -304,22 +1188,76 @@ func storeMatches(s Client, mint, maxt int64, matchers ...storepb.LabelMatcher)
 
 			m, err := translateMatcher(m)
 			if err != nil {
-				return false, err
+				return storeLabelMismatch, err
 			}
 
 			if !m.Matches(l.Value) {
-				return false, nil
+				return storeLabelMismatch, nil
 			}
 		}
 	}
-	return true, nil
+	return storeSelected, nil
 }
 
 // LabelNames returns all known label names.
 func (s *ProxyStore) LabelNames(ctx context.Context, r *storepb.LabelNamesRequest) (
 	*storepb.LabelNamesResponse, error,
 ) {
-	return nil, status.Error(codes.Unimplemented, "not implemented")
+	var (
+		warnings  []string
+		all       [][]string
+		truncated bool
+		mtx       sync.Mutex
+		g, gctx   = errgroup.WithContext(ctx)
+	)
+
+	stores, err := s.stores(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unknown, err.Error())
+	}
+	allowed, only := storeAddrsFromContext(ctx)
+	for _, st := range stores {
+		if _, ok := allowed[st.Addr()]; only && !ok {
+			continue
+		}
+		store := st
+		g.Go(func() error {
+			resp, err := store.LabelNames(gctx, &storepb.LabelNamesRequest{
+				PartialResponseDisabled: r.PartialResponseDisabled,
+				Limit:                   r.Limit,
+			})
+			if err != nil {
+				err = errors.Wrapf(err, "fetch label names from store %s", store)
+				if r.PartialResponseDisabled {
+					return err
+				}
+
+				mtx.Lock()
+				warnings = append(warnings, errors.Wrap(err, "fetch label names").Error())
+				mtx.Unlock()
+				return nil
+			}
+
+			mtx.Lock()
+			warnings = append(warnings, resp.Warnings...)
+			truncated = truncated || resp.Truncated
+			all = append(all, resp.Names)
+			mtx.Unlock()
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	names, dropped := mergeLimited(all, r.Limit)
+	return &storepb.LabelNamesResponse{
+		Names:     names,
+		Warnings:  warnings,
+		Truncated: dropped || truncated,
+	}, nil
 }
 
 // LabelValues returns all known label values for a given label name.
@@ -327,22 +1265,43 @@ func (s *ProxyStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequ
 	*storepb.LabelValuesResponse, error,
 ) {
 	var (
-		warnings []string
-		all      [][]string
-		mtx      sync.Mutex
-		g, gctx  = errgroup.WithContext(ctx)
+		warnings  []string
+		all       [][]string
+		truncated bool
+		mtx       sync.Mutex
+		g, gctx   = errgroup.WithContext(ctx)
 	)
 
 	stores, err := s.stores(ctx)
 	if err != nil {
 		return nil, status.Errorf(codes.Unknown, err.Error())
 	}
+	allowed, only := storeAddrsFromContext(ctx)
+	tr, pruneByTime := labelValuesTimeRangeFromContext(ctx)
+	slots := labelValuesSlotsFromContext(ctx)
 	for _, st := range stores {
+		if _, ok := allowed[st.Addr()]; only && !ok {
+			continue
+		}
+		if pruneByTime {
+			if reason, _ := matchStore(st, tr.mint, tr.maxt); reason != storeSelected {
+				continue
+			}
+		}
 		store := st
 		g.Go(func() error {
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+				case <-gctx.Done():
+					return errors.Wrapf(gctx.Err(), "wait for label values slot of store %s", store)
+				}
+				defer func() { <-slots }()
+			}
 			resp, err := store.LabelValues(gctx, &storepb.LabelValuesRequest{
 				Label: r.Label,
 				PartialResponseDisabled: r.PartialResponseDisabled,
+				Limit:                   r.Limit,
 			})
 			if err != nil {
 				err = errors.Wrapf(err, "fetch label values from store %s", store)
@@ -358,6 +1317,7 @@ func (s *ProxyStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequ
 
 			mtx.Lock()
 			warnings = append(warnings, resp.Warnings...)
+			truncated = truncated || resp.Truncated
 			all = append(all, resp.Values)
 			mtx.Unlock()
 
@@ -369,8 +1329,31 @@ func (s *ProxyStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequ
 		return nil, err
 	}
 
+	values, dropped := mergeLimited(all, r.Limit)
 	return &storepb.LabelValuesResponse{
-		Values:   strutil.MergeUnsortedSlices(all...),
-		Warnings: warnings,
+		Values:    values,
+		Warnings:  warnings,
+		Truncated: dropped || truncated,
 	}, nil
 }
+
+// mergeLimited merges label names or values of stores into a single sorted set and stops once it has limit strings.
+// Each store returns its first strings up to the limit, so the merged prefix of that length is globally correct.
+// It returns true if any strings were dropped.
+func mergeLimited(sets [][]string, limit int64) ([]string, bool) {
+	for _, s := range sets {
+		if !sort.StringsAreSorted(s) {
+			sort.Strings(s)
+		}
+	}
+	return strutil.MergeSlicesLimit(int(limit), sets...)
+}
+
+// limitLabelValues returns up to limit first of the given sorted values and whether any values were dropped.
+// Zero limit keeps all values.
+func limitLabelValues(values []string, limit int64) ([]string, bool) {
+	if limit <= 0 || int64(len(values)) <= limit {
+		return values, false
+	}
+	return values[:limit], true
+}
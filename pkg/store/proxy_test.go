@@ -2,7 +2,12 @@ package store
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,6 +16,8 @@ import (
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/testutil"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/tsdb/chunkenc"
 	tlabels "github.com/prometheus/tsdb/labels"
@@ -26,6 +33,9 @@ type testClient struct {
 	labels  []storepb.Label
 	minTime int64
 	maxTime int64
+	addr    string
+
+	supportsKnownChunks bool
 }
 
 func (c *testClient) Labels() []storepb.Label {
@@ -40,12 +50,25 @@ func (c *testClient) String() string {
 	return "test"
 }
 
+func (c *testClient) Addr() string {
+	return c.addr
+}
+
+func (c *testClient) SeriesEstimate() int64 {
+	return 0
+}
+
+func (c *testClient) SupportsKnownChunks() bool {
+	return c.supportsKnownChunks
+}
+
 func TestProxyStore_Series_StoresFetchFail(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
-	q := NewProxyStore(nil,
+	q := NewProxyStore(nil, nil,
 		func(_ context.Context) ([]Client, error) { return nil, errors.New("Fail") },
 		nil,
+		StoreLimit{},
 	)
 
 	s := newStoreSeriesServer(context.Background())
@@ -146,6 +169,46 @@ func TestProxyStore_Series(t *testing.T) {
 				},
 			},
 		},
+		{
+			title: "metric name regexp matcher is not an external label, so all stores are queried",
+			storeAPIs: []Client{
+				&testClient{
+					StoreClient: &mockedStoreAPI{
+						RespSeries: []*storepb.SeriesResponse{
+							storeSeriesResponse(t, labels.FromStrings("__name__", "http_requests_total", "ext", "1"), []sample{{0, 0}, {2, 1}}),
+						},
+					},
+					minTime: 1,
+					maxTime: 300,
+					labels:  []storepb.Label{{Name: "ext", Value: "1"}},
+				},
+				&testClient{
+					StoreClient: &mockedStoreAPI{
+						RespSeries: []*storepb.SeriesResponse{
+							storeSeriesResponse(t, labels.FromStrings("__name__", "http_errors_total", "ext", "2"), []sample{{0, 0}, {2, 1}}),
+						},
+					},
+					minTime: 1,
+					maxTime: 300,
+					labels:  []storepb.Label{{Name: "ext", Value: "2"}},
+				},
+			},
+			req: &storepb.SeriesRequest{
+				MinTime:  1,
+				MaxTime:  300,
+				Matchers: []storepb.LabelMatcher{{Name: "__name__", Value: "http_.*", Type: storepb.LabelMatcher_RE}},
+			},
+			expectedSeries: []rawSeries{
+				{
+					lset:    []storepb.Label{{Name: "__name__", Value: "http_errors_total"}, {Name: "ext", Value: "2"}},
+					samples: []sample{{0, 0}, {2, 1}},
+				},
+				{
+					lset:    []storepb.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "ext", Value: "1"}},
+					samples: []sample{{0, 0}, {2, 1}},
+				},
+			},
+		},
 		{
 			title: "storeAPI available for time range; available series for any external label matcher",
 			storeAPIs: []Client{
@@ -290,6 +353,40 @@ func TestProxyStore_Series(t *testing.T) {
 			},
 			expectedWarningsLen: 2,
 		},
+		{
+			title: "series split across stores by time range",
+			storeAPIs: []Client{
+				&testClient{
+					StoreClient: &mockedStoreAPI{
+						RespSeries: []*storepb.SeriesResponse{
+							storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{150, 4}, {200, 5}, {250, 6}}),
+						},
+					},
+					minTime: 150,
+					maxTime: 300,
+				},
+				&testClient{
+					StoreClient: &mockedStoreAPI{
+						RespSeries: []*storepb.SeriesResponse{
+							storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}, {50, 2}, {100, 3}, {150, 4}}),
+						},
+					},
+					minTime: 1,
+					maxTime: 150,
+				},
+			},
+			req: &storepb.SeriesRequest{
+				MinTime:  1,
+				MaxTime:  300,
+				Matchers: []storepb.LabelMatcher{{Name: "a", Value: "a", Type: storepb.LabelMatcher_EQ}},
+			},
+			expectedSeries: []rawSeries{
+				{
+					lset:    []storepb.Label{{Name: "a", Value: "a"}},
+					samples: []sample{{150, 4}, {200, 5}, {250, 6}, {1, 1}, {50, 2}, {100, 3}, {150, 4}}, // No sort merge.
+				},
+			},
+		},
 		{
 			title: "same external labels are validated during upload and on querier storeset, proxy does not care",
 			storeAPIs: []Client{
@@ -395,9 +492,10 @@ func TestProxyStore_Series(t *testing.T) {
 		},
 	} {
 		if ok := t.Run(tc.title, func(t *testing.T) {
-			q := NewProxyStore(nil,
+			q := NewProxyStore(nil, nil,
 				func(_ context.Context) ([]Client, error) { return tc.storeAPIs, nil }, // what if err?
 				tc.selectorLabels,
+				StoreLimit{},
 			)
 
 			s := newStoreSeriesServer(context.Background())
@@ -435,9 +533,10 @@ func TestProxyStore_Series_RequestParamsProxied(t *testing.T) {
 			maxTime:     300,
 		},
 	}
-	q := NewProxyStore(nil,
+	q := NewProxyStore(nil, nil,
 		func(context.Context) ([]Client, error) { return cls, nil },
 		nil,
+		StoreLimit{},
 	)
 
 	ctx := context.Background()
@@ -453,166 +552,1540 @@ func TestProxyStore_Series_RequestParamsProxied(t *testing.T) {
 			storepb.Aggr_COUNT,
 		},
 		MaxResolutionWindow: 1234,
+		SkipChunks:          true,
+		Hints:               &storepb.SeriesHints{StartTime: 1, EndTime: 300, Step: 15, Func: "rate", Grouping: []string{"ext"}, By: true},
 	}
 	testutil.Ok(t, q.Series(req, s))
 
 	testutil.Assert(t, proto.Equal(req, m.LastSeriesReq), "request was not proxied properly to underlying storeAPI: %s vs %s", req, m.LastSeriesReq)
 }
 
-func TestProxyStore_Series_RegressionFillResponseChannel(t *testing.T) {
+func TestProxyStore_Series_MetricNameRegexpForwarded(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
-	var cls []Client
-	for i := 0; i < 10; i++ {
-		cls = append(cls, &testClient{
-			StoreClient: &mockedStoreAPI{
-				RespError: errors.New("test error"),
-			},
-			minTime: 1,
-			maxTime: 300,
-		})
-		cls = append(cls, &testClient{
-			StoreClient: &mockedStoreAPI{
-				RespSeries: []*storepb.SeriesResponse{
-					storepb.NewWarnSeriesResponse(errors.New("warning")),
-					storepb.NewWarnSeriesResponse(errors.New("warning")),
-					storepb.NewWarnSeriesResponse(errors.New("warning")),
-					storepb.NewWarnSeriesResponse(errors.New("warning")),
-					storepb.NewWarnSeriesResponse(errors.New("warning")),
-					storepb.NewWarnSeriesResponse(errors.New("warning")),
-					storepb.NewWarnSeriesResponse(errors.New("warning")),
-					storepb.NewWarnSeriesResponse(errors.New("warning")),
-					storepb.NewWarnSeriesResponse(errors.New("warning")),
-					storepb.NewWarnSeriesResponse(errors.New("warning")),
-				},
-			},
-			minTime: 1,
-			maxTime: 300,
-		})
+	m := &mockedStoreAPI{}
+	cls := []Client{
+		&testClient{StoreClient: m, labels: []storepb.Label{{Name: "ext", Value: "1"}}, minTime: 1, maxTime: 300},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
 
+	ms := []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_RE, Name: "__name__", Value: "http_.*"},
+		{Type: storepb.LabelMatcher_EQ, Name: "job", Value: "api"},
 	}
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300, Matchers: ms}, newStoreSeriesServer(context.Background())))
+
+	// Matcher is forwarded as is, so stores can resolve it from their label values index.
+	testutil.Equals(t, ms, m.LastSeriesReq.Matchers)
+}
+
+func TestProxyStore_Series_NoStoreMatched(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
-	q := NewProxyStore(nil,
+	m1, m2 := &mockedStoreAPI{}, &mockedStoreAPI{}
+	cls := []Client{
+		&testClient{StoreClient: m1, labels: []storepb.Label{{Name: "ext", Value: "1"}}, minTime: 1, maxTime: 300},
+		&testClient{StoreClient: m2, labels: []storepb.Label{{Name: "ext", Value: "2"}}, minTime: 1, maxTime: 300},
+	}
+	q := NewProxyStore(nil, nil,
 		func(context.Context) ([]Client, error) { return cls, nil },
-		tlabels.FromStrings("fed", "a"),
+		nil,
+		StoreLimit{},
 	)
 
-	ctx := context.Background()
-	s := newStoreSeriesServer(ctx)
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "ext", Value: "3"}},
+	}, s))
 
-	testutil.Ok(t, q.Series(
-		&storepb.SeriesRequest{
-			MinTime:  1,
-			MaxTime:  300,
-			Matchers: []storepb.LabelMatcher{{Name: "fed", Value: "a", Type: storepb.LabelMatcher_EQ}},
-		}, s,
-	))
+	testutil.Assert(t, m1.LastSeriesReq == nil, "store with not matching external labels was queried")
+	testutil.Assert(t, m2.LastSeriesReq == nil, "store with not matching external labels was queried")
 	testutil.Equals(t, 0, len(s.SeriesSet))
-	testutil.Equals(t, 110, len(s.Warnings))
+	testutil.Equals(t, []string{"No store matched for this query"}, s.Warnings)
 }
 
-func TestProxyStore_LabelValues(t *testing.T) {
+func TestProxyStore_Series_TenantScoped(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
-	m1 := &mockedStoreAPI{
-		RespLabelValues: &storepb.LabelValuesResponse{
-			Values:   []string{"1", "2"},
-			Warnings: []string{"warning"},
-		},
+	resp := []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}})}
+	t1, t2, shared := &mockedStoreAPI{RespSeries: resp}, &mockedStoreAPI{RespSeries: resp}, &mockedStoreAPI{RespSeries: resp}
+	cls := []Client{
+		&testClient{StoreClient: t1, labels: []storepb.Label{{Name: "tenant", Value: "t1"}}, minTime: 1, maxTime: 300},
+		&testClient{StoreClient: t2, labels: []storepb.Label{{Name: "tenant", Value: "t2"}}, minTime: 1, maxTime: 300},
+		&testClient{StoreClient: shared, labels: []storepb.Label{{Name: "region", Value: "eu"}}, minTime: 1, maxTime: 300},
 	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+		WithTenantLabel("tenant"),
+	)
+
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "tenant", Value: "t1"}},
+	}, s))
+	testutil.Assert(t, t1.LastSeriesReq != nil, "store of the tenant was not queried")
+	testutil.Assert(t, t2.LastSeriesReq == nil, "store of other tenant was queried")
+	testutil.Assert(t, shared.LastSeriesReq == nil, "store without tenant label was queried")
+	testutil.Equals(t, 1, len(s.SeriesSet))
+	testutil.Equals(t, 0, len(s.Warnings))
+
+	// Queries not selecting a single tenant are not scoped.
+	t1.LastSeriesReq = nil
+	s = newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_RE, Name: "tenant", Value: "t.*"}},
+	}, s))
+	testutil.Assert(t, t1.LastSeriesReq != nil, "store of the tenant was not queried")
+	testutil.Assert(t, t2.LastSeriesReq != nil, "store of the tenant was not queried")
+	testutil.Assert(t, shared.LastSeriesReq != nil, "store without tenant label was not queried")
+}
+
+func TestProxyStore_Series_StoreAddrs(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	resp := []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}})}
+	m1, m2, m3 := &mockedStoreAPI{RespSeries: resp}, &mockedStoreAPI{RespSeries: resp}, &mockedStoreAPI{RespSeries: resp}
 	cls := []Client{
-		&testClient{StoreClient: m1},
-		&testClient{StoreClient: &mockedStoreAPI{
-			RespLabelValues: &storepb.LabelValuesResponse{
-				Values: []string{"3", "4"},
-			},
-		}},
+		&testClient{StoreClient: m1, labels: []storepb.Label{{Name: "ext", Value: "1"}}, minTime: 1, maxTime: 300, addr: "store-1"},
+		&testClient{StoreClient: m2, labels: []storepb.Label{{Name: "ext", Value: "1"}}, minTime: 1, maxTime: 300, addr: "store-2"},
+		// Does not match the request by external labels nor by time range.
+		&testClient{StoreClient: m3, labels: []storepb.Label{{Name: "ext", Value: "2"}}, minTime: 400, maxTime: 500, addr: "store-3"},
 	}
-	q := NewProxyStore(nil,
+	q := NewProxyStore(nil, nil,
 		func(context.Context) ([]Client, error) { return cls, nil },
 		nil,
+		StoreLimit{},
 	)
 
-	ctx := context.Background()
-	req := &storepb.LabelValuesRequest{
-		Label: "a",
-		PartialResponseDisabled: true,
+	s := newStoreSeriesServer(ContextWithStoreAddrs(context.Background(), "store-1", "store-3"))
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "ext", Value: "1"}},
+	}, s))
+	testutil.Assert(t, m1.LastSeriesReq != nil, "allowed store was not queried")
+	testutil.Assert(t, m2.LastSeriesReq == nil, "not allowed store was queried")
+	testutil.Assert(t, m3.LastSeriesReq != nil, "allowed store was not queried")
+	testutil.Equals(t, 0, len(s.Warnings))
+
+	addrs, warnings, err := q.SeriesStores(ContextWithStoreAddrs(context.Background(), "store-2"), &storepb.SeriesRequest{MinTime: 1, MaxTime: 300})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(warnings))
+	testutil.Equals(t, []string{"store-2"}, addrs)
+}
+
+func TestProxyStore_Labels_StoreAddrs(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	m1 := &mockedStoreAPI{
+		RespLabelNames:  &storepb.LabelNamesResponse{Names: []string{"a"}},
+		RespLabelValues: &storepb.LabelValuesResponse{Values: []string{"1"}},
 	}
-	resp, err := q.LabelValues(ctx, req)
+	m2 := &mockedStoreAPI{
+		RespLabelNames:  &storepb.LabelNamesResponse{Names: []string{"b"}},
+		RespLabelValues: &storepb.LabelValuesResponse{Values: []string{"2"}},
+	}
+	cls := []Client{
+		&testClient{StoreClient: m1, minTime: 1, maxTime: 300, addr: "store-1"},
+		&testClient{StoreClient: m2, minTime: 1, maxTime: 300, addr: "store-2"},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
+	ctx := ContextWithStoreAddrs(context.Background(), "store-2")
+
+	names, err := q.LabelNames(ctx, &storepb.LabelNamesRequest{})
 	testutil.Ok(t, err)
-	testutil.Assert(t, proto.Equal(req, m1.LastLabelValuesReq), "request was not proxied properly to underlying storeAPI: %s vs %s", req, m1.LastLabelValuesReq)
+	testutil.Equals(t, []string{"b"}, names.Names)
+	testutil.Assert(t, m1.LastLabelNamesReq == nil, "not allowed store was asked for label names")
 
-	testutil.Equals(t, []string{"1", "2", "3", "4"}, resp.Values)
-	testutil.Equals(t, 1, len(resp.Warnings))
+	values, err := q.LabelValues(ctx, &storepb.LabelValuesRequest{Label: "a"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"2"}, values.Values)
+	testutil.Assert(t, m1.LastLabelValuesReq == nil, "not allowed store was asked for label values")
 }
 
-type rawSeries struct {
-	lset    []storepb.Label
-	samples []sample
+func TestProxyStore_Series_CaseInsensitiveMatcherForwardedAsRegexp(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	matching := &mockedStoreAPI{}
+	other := &mockedStoreAPI{}
+	cls := []Client{
+		&testClient{StoreClient: matching, labels: []storepb.Label{{Name: "region", Value: "us"}}, minTime: 1, maxTime: 300},
+		&testClient{StoreClient: other, labels: []storepb.Label{{Name: "region", Value: "eu"}}, minTime: 1, maxTime: 300},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	req := &storepb.SeriesRequest{
+		MinTime: 1,
+		MaxTime: 300,
+		Matchers: []storepb.LabelMatcher{
+			{Type: storepb.LabelMatcher_EQ_CI, Name: "region", Value: "US"},
+			{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "b"},
+		},
+	}
+	testutil.Ok(t, q.Series(req, newStoreSeriesServer(context.Background())))
+
+	testutil.Assert(t, other.LastSeriesReq == nil, "store with not matching labels was queried")
+	testutil.Equals(t, []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_RE, Name: "region", Value: "(?i:US)"},
+		{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "b"},
+	}, matching.LastSeriesReq.Matchers)
+	// Request of the caller is not modified.
+	testutil.Equals(t, storepb.LabelMatcher_EQ_CI, req.Matchers[0].Type)
+
+	m, err := translateMatcher(matching.LastSeriesReq.Matchers[0])
+	testutil.Ok(t, err)
+	testutil.Assert(t, m.Matches("us") && m.Matches("Us"), "expected forwarded matcher to match ignoring case")
+	testutil.Assert(t, !m.Matches("us-east"), "expected forwarded matcher to be anchored")
 }
 
-func seriesEqual(t *testing.T, expected []rawSeries, got []storepb.Series) {
-	testutil.Equals(t, len(expected), len(got), "got: %v", got)
+func TestProxyStore_Series_ResourceExhausted(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
-	for i, series := range got {
-		testutil.Equals(t, expected[i].lset, series.Labels)
+	cls := []Client{
+		&testClient{
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}}),
+				},
+				RespRecvError: status.Error(codes.ResourceExhausted, "grpc: received message larger than max (5000 vs. 4096)"),
+			},
+			minTime: 1,
+			maxTime: 300,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
+	const hint = "store response exceeds gRPC message size limit"
 
-		k := 0
-		for _, chk := range series.Chunks {
-			c, err := chunkenc.FromData(chunkenc.EncXOR, chk.Raw.Data)
-			testutil.Ok(t, err)
+	// Partial response disabled.
+	s := newStoreSeriesServer(context.Background())
+	err := q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300, PartialResponseDisabled: true}, s)
+	testutil.NotOk(t, err)
+	testutil.Assert(t, strings.Contains(err.Error(), hint), "unexpected error: %s", err)
+	testutil.Equals(t, codes.ResourceExhausted, status.Code(errors.Cause(err)))
+
+	// Partial response enabled.
+	s = newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300}, s))
+	testutil.Equals(t, 1, len(s.SeriesSet))
+	testutil.Equals(t, 1, len(s.Warnings))
+	testutil.Assert(t, strings.Contains(s.Warnings[0], hint), "unexpected warning: %s", s.Warnings[0])
+}
 
-			iter := c.Iterator()
-			for iter.Next() {
-				testutil.Assert(t, k < len(expected[i].samples), "more samples than expected")
+// flakyStoreAPI is test gRPC store API client whose first series stream sends firstResps and fails with firstErr.
+type flakyStoreAPI struct {
+	mockedStoreAPI
 
-				tv, v := iter.At()
-				testutil.Equals(t, expected[i].samples[k], sample{tv, v})
-				k++
-			}
-			testutil.Ok(t, iter.Err())
-		}
-		testutil.Equals(t, len(expected[i].samples), k)
+	firstResps []*storepb.SeriesResponse
+	firstErr   error
+	calls      int
+}
+
+func (s *flakyStoreAPI) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	s.calls++
+	if s.calls == 1 {
+		return &StoreSeriesClient{ctx: ctx, respSet: s.firstResps, err: s.firstErr}, nil
 	}
+	return s.mockedStoreAPI.Series(ctx, req, opts...)
 }
 
-func TestStoreMatches(t *testing.T) {
+func TestProxyStore_Series_RetryBeforeData(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
-	cases := []struct {
-		s          Client
-		mint, maxt int64
-		ms         []storepb.LabelMatcher
-		ok         bool
+	series := []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{2, 2}}),
+	}
+	for _, tcase := range []struct {
+		name       string
+		firstResps []*storepb.SeriesResponse
+		firstErr   error
+
+		expectedCalls    int
+		expectedSeries   int
+		expectedWarnings int
 	}{
 		{
-			s: &testClient{labels: []storepb.Label{{Name: "a", Value: "b"}}},
-			ms: []storepb.LabelMatcher{
-				{Type: storepb.LabelMatcher_EQ, Name: "b", Value: "1"},
-			},
-			ok: true,
-		},
-		{
-			s:    &testClient{minTime: 100, maxTime: 200},
-			mint: 201,
-			maxt: 300,
-			ok:   false,
+			name:           "failure on first receive is retried",
+			firstErr:       status.Error(codes.Unavailable, "connection reset"),
+			expectedCalls:  2,
+			expectedSeries: 2,
 		},
 		{
-			s:    &testClient{minTime: 100, maxTime: 200},
-			mint: 200,
-			maxt: 300,
-			ok:   true,
+			name:             "failure after series is not retried",
+			firstResps:       series[:1],
+			firstErr:         status.Error(codes.Unavailable, "connection reset"),
+			expectedCalls:    1,
+			expectedSeries:   1,
+			expectedWarnings: 1,
 		},
 		{
-			s:    &testClient{minTime: 100, maxTime: 200},
-			mint: 50,
-			maxt: 99,
-			ok:   false,
+			name:             "failure fatal for retry is not retried",
+			firstErr:         status.Error(codes.ResourceExhausted, "grpc: received message larger than max (5000 vs. 4096)"),
+			expectedCalls:    1,
+			expectedWarnings: 1,
 		},
-		{
-			s:    &testClient{minTime: 100, maxTime: 200},
-			mint: 50,
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			st := &flakyStoreAPI{
+				mockedStoreAPI: mockedStoreAPI{RespSeries: series},
+				firstResps:     tcase.firstResps,
+				firstErr:       tcase.firstErr,
+			}
+			q := NewProxyStore(nil, nil,
+				func(context.Context) ([]Client, error) {
+					return []Client{&testClient{StoreClient: st, minTime: 1, maxTime: 300}}, nil
+				},
+				nil,
+				StoreLimit{},
+			)
+
+			s := newStoreSeriesServer(context.Background())
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300}, s))
+			testutil.Equals(t, tcase.expectedCalls, st.calls)
+			testutil.Equals(t, tcase.expectedSeries, len(s.SeriesSet))
+			testutil.Equals(t, tcase.expectedWarnings, len(s.Warnings))
+		})
+	}
+
+	// Stream failing again after the retry fails the request if partial response is disabled.
+	st := &flakyStoreAPI{
+		mockedStoreAPI: mockedStoreAPI{RespRecvError: status.Error(codes.Unavailable, "connection reset")},
+		firstErr:       status.Error(codes.Unavailable, "connection reset"),
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) {
+			return []Client{&testClient{StoreClient: st, minTime: 1, maxTime: 300}}, nil
+		},
+		nil,
+		StoreLimit{},
+	)
+	err := q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300, PartialResponseDisabled: true}, newStoreSeriesServer(context.Background()))
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 2, st.calls)
+}
+
+func TestProxyStore_Series_RetryBudget(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var (
+		sts []*flakyStoreAPI
+		cls []Client
+	)
+	for i := 0; i < 5; i++ {
+		st := &flakyStoreAPI{
+			mockedStoreAPI: mockedStoreAPI{RespRecvError: status.Error(codes.Unavailable, "connection reset")},
+			firstErr:       status.Error(codes.Unavailable, "connection reset"),
+		}
+		sts = append(sts, st)
+		cls = append(cls, &testClient{StoreClient: st, minTime: 1, maxTime: 300, addr: fmt.Sprintf("store-%d", i)})
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
+	calls := func() (n int) {
+		for _, st := range sts {
+			n += st.calls
+		}
+		return n
+	}
+
+	// Requests of a query share its budget.
+	ctx := ContextWithRetryBudget(context.Background(), NewRetryBudget(2))
+	s := newStoreSeriesServer(ctx)
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300}, s))
+	testutil.Equals(t, 5, len(s.Warnings))
+	testutil.Equals(t, 5+2, calls())
+	testutil.Equals(t, 3.0, promtestutil.ToFloat64(q.metrics.retryBudgetExhausted))
+
+	s = newStoreSeriesServer(ctx)
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300}, s))
+	testutil.Equals(t, 5, len(s.Warnings))
+	testutil.Equals(t, 2*5+2, calls())
+	testutil.Equals(t, 8.0, promtestutil.ToFloat64(q.metrics.retryBudgetExhausted))
+}
+
+func TestProxyStore_Series_StoreFailureFailsFast(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	cls := []Client{
+		&testClient{
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}}),
+				},
+				RespRecvError: status.Error(codes.Internal, "corrupted block"),
+			},
+			minTime: 1,
+			maxTime: 300,
+		},
+		&testClient{
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}}),
+				},
+				// Store hangs until its stream is canceled.
+				RespBlock: true,
+			},
+			minTime: 1,
+			maxTime: 300,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	// Without a deadline, the request returns only if the failure cancels the hanging store.
+	err := q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300, PartialResponseDisabled: true}, newStoreSeriesServer(context.Background()))
+	testutil.NotOk(t, err)
+	testutil.Equals(t, codes.Internal, status.Code(err))
+	testutil.Assert(t, strings.Contains(err.Error(), "corrupted block"), "unexpected error: %s", err)
+}
+
+// openBlockingStoreAPI is test gRPC store API client whose Series call blocks until its context is done, like a call
+// waiting for a connection or a rate limit.
+type openBlockingStoreAPI struct {
+	mockedStoreAPI
+}
+
+func (s *openBlockingStoreAPI) Series(ctx context.Context, _ *storepb.SeriesRequest, _ ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	<-ctx.Done()
+	return nil, status.Error(codes.Canceled, ctx.Err().Error())
+}
+
+func TestProxyStore_Series_StoreFailureCancelsOpening(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	cls := []Client{
+		&testClient{
+			StoreClient: &mockedStoreAPI{RespRecvError: status.Error(codes.Internal, "corrupted block")},
+			minTime:     1,
+			maxTime:     300,
+		},
+		&testClient{StoreClient: &openBlockingStoreAPI{}, minTime: 1, maxTime: 300},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	// Cancellation of the store still being opened is not reported in place of the failure that caused it.
+	err := q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300, PartialResponseDisabled: true}, newStoreSeriesServer(context.Background()))
+	testutil.NotOk(t, err)
+	testutil.Equals(t, codes.Internal, status.Code(err))
+	testutil.Assert(t, strings.Contains(err.Error(), "corrupted block"), "unexpected error: %s", err)
+
+	// Request past its deadline fails with it, as no stream was opened.
+	q = NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls[1:], nil },
+		nil,
+		StoreLimit{},
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300, PartialResponseDisabled: true}, newStoreSeriesServer(ctx))
+	testutil.NotOk(t, err)
+	testutil.Equals(t, context.DeadlineExceeded, errors.Cause(err))
+}
+
+// warnRecorder records responses sent by streams.
+type warnRecorder struct {
+	mtx   sync.Mutex
+	resps []*storepb.SeriesResponse
+}
+
+func (r *warnRecorder) send(resp *storepb.SeriesResponse) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.resps = append(r.resps, resp)
+}
+
+func TestStreamSeriesSet_TerminationCauses(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	series := []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}})}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	pastDeadline, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	for _, tcase := range []struct {
+		name            string
+		ctx             context.Context
+		recvErr         error
+		block           bool
+		partialResponse bool
+
+		expectedUp       bool
+		expectedErr      bool
+		expectedFailure  bool
+		expectedWarnings int
+		// Whether the stream canceled other streams of the request.
+		expectedCancel bool
+	}{
+		{
+			name:       "end of stream",
+			ctx:        context.Background(),
+			expectedUp: true,
+		},
+		{
+			name:           "store error",
+			ctx:            context.Background(),
+			recvErr:        status.Error(codes.Internal, "corrupted block"),
+			expectedErr:    true,
+			expectedCancel: true,
+		},
+		{
+			name:             "store error with partial response",
+			ctx:              context.Background(),
+			recvErr:          status.Error(codes.Internal, "corrupted block"),
+			partialResponse:  true,
+			expectedFailure:  true,
+			expectedWarnings: 1,
+		},
+		{
+			name:           "canceled by store",
+			ctx:            context.Background(),
+			recvErr:        status.Error(codes.Canceled, "store shutting down"),
+			expectedErr:    true,
+			expectedCancel: true,
+		},
+		{
+			name:           "deadline of store",
+			ctx:            context.Background(),
+			recvErr:        status.Error(codes.DeadlineExceeded, "store query timed out"),
+			expectedErr:    true,
+			expectedCancel: true,
+		},
+		{
+			name:  "canceled by proxy",
+			ctx:   canceled,
+			block: true,
+		},
+		{
+			name:            "canceled by proxy with partial response",
+			ctx:             canceled,
+			block:           true,
+			partialResponse: true,
+		},
+		{
+			name:  "deadline of request",
+			ctx:   pastDeadline,
+			block: true,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			var (
+				wg       sync.WaitGroup
+				warns    = &warnRecorder{}
+				canceled bool
+				retries  int
+			)
+			stream := &StoreSeriesClient{ctx: tcase.ctx, respSet: series, err: tcase.recvErr, block: tcase.block}
+			s := startStreamSeriesSet(tcase.ctx, func() { canceled = true }, &wg, nil, nil, func() (storepb.Store_SeriesClient, error) {
+				return stream, nil
+			}, func() (storepb.Store_SeriesClient, error) {
+				retries++
+				return nil, errors.New("not expected")
+			}, warns, "store", tcase.partialResponse)
+			for s.Next() {
+			}
+			wg.Wait()
+
+			testutil.Equals(t, tcase.expectedUp, s.up)
+			testutil.Equals(t, tcase.expectedErr, s.Err() != nil)
+			testutil.Equals(t, tcase.expectedFailure, s.failure != nil)
+			testutil.Equals(t, tcase.expectedWarnings, len(warns.resps))
+			testutil.Equals(t, tcase.expectedCancel, canceled)
+			// Failures after the first response are never retried.
+			testutil.Equals(t, 0, retries)
+		})
+	}
+}
+
+func TestProxyStore_Series_DeadlineDuringMerge(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	cls := []Client{
+		&testClient{
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}}),
+					storeSeriesResponse(t, labels.FromStrings("a", "c"), []sample{{1, 1}}),
+				},
+				// Store hangs until the query deadline.
+				RespBlock: true,
+			},
+			minTime: 1,
+			maxTime: 300,
+		},
+		&testClient{
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}}),
+				},
+			},
+			minTime: 1,
+			maxTime: 300,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	t.Run("partial response enabled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		s := newStoreSeriesServer(ctx)
+		testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300}, s))
+
+		var got [][]storepb.Label
+		for _, series := range s.SeriesSet {
+			got = append(got, series.Labels)
+		}
+		// Merge advances the set of the returned series right away, so series "c" is returned only once the hanging
+		// store sends the next one.
+		testutil.Equals(t, [][]storepb.Label{{{Name: "a", Value: "a"}}, {{Name: "a", Value: "b"}}}, got)
+		testutil.Equals(t, 1, len(s.Warnings))
+		testutil.Assert(t, strings.Contains(s.Warnings[0], "query timed out"), "unexpected warning: %s", s.Warnings[0])
+	})
+	t.Run("partial response disabled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		err := q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300, PartialResponseDisabled: true}, newStoreSeriesServer(ctx))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, context.DeadlineExceeded, errors.Cause(err))
+	})
+}
+
+func TestProxyStore_Series_StoreLimit(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	storeWithSeries := func(name string, mint, maxt int64) Client {
+		return &testClient{
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", name), []sample{{mint, 1}}),
+				},
+			},
+			minTime: mint,
+			maxTime: maxt,
+		}
+	}
+	cls := []Client{
+		storeWithSeries("1", 1, 100),
+		storeWithSeries("2", 1, 300),
+		storeWithSeries("3", 200, 300),
+		// Outside of the requested time range, so it does not count to the limit.
+		storeWithSeries("4", 400, 500),
+		storeWithSeries("5", 1, 250),
+	}
+	req := &storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+	}
+
+	for _, tcase := range []struct {
+		limit StoreLimit
+
+		expectedErr      bool
+		expectedSeries   []string
+		expectedWarnings int
+	}{
+		{limit: StoreLimit{Max: 0}, expectedSeries: []string{"1", "2", "3", "5"}},
+		{limit: StoreLimit{Max: 4}, expectedSeries: []string{"1", "2", "3", "5"}},
+		{limit: StoreLimit{Max: 2}, expectedErr: true},
+		// Stores with the largest overlap with the requested time range are queried.
+		{limit: StoreLimit{Max: 2, Truncate: true}, expectedSeries: []string{"2", "5"}, expectedWarnings: 1},
+	} {
+		t.Run(fmt.Sprintf("%+v", tcase.limit), func(t *testing.T) {
+			q := NewProxyStore(nil, nil,
+				func(context.Context) ([]Client, error) { return append([]Client{}, cls...), nil },
+				nil,
+				tcase.limit,
+			)
+
+			s := newStoreSeriesServer(context.Background())
+			err := q.Series(req, s)
+			if tcase.expectedErr {
+				testutil.NotOk(t, err)
+				testutil.Equals(t, codes.InvalidArgument, status.Code(err))
+				return
+			}
+			testutil.Ok(t, err)
+
+			var got []string
+			for _, series := range s.SeriesSet {
+				got = append(got, series.Labels[0].Value)
+			}
+			testutil.Equals(t, tcase.expectedSeries, got)
+			testutil.Equals(t, tcase.expectedWarnings, len(s.Warnings), "got %v", s.Warnings)
+		})
+	}
+}
+
+func TestProxyStore_Series_OutOfOrderStore(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	cls := []Client{
+		&testClient{
+			StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{1, 1}}),
+				storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}),
+			}},
+			minTime: 1,
+			maxTime: 300,
+			addr:    "misbehaving:10901",
+		},
+		&testClient{
+			StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}),
+				// Series split into multiple responses is not out of order.
+				storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{2, 2}}),
+				storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{1, 1}}),
+			}},
+			minTime: 1,
+			maxTime: 300,
+			addr:    "ok:10901",
+		},
+	}
+
+	reg := prometheus.NewRegistry()
+	q := NewProxyStore(nil, reg,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+	}, s))
+
+	// Stores are told apart by address, not by their description.
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(q.metrics.outOfOrderStreams.WithLabelValues("misbehaving:10901")))
+	testutil.Equals(t, 0.0, promtestutil.ToFloat64(q.metrics.outOfOrderStreams.WithLabelValues("ok:10901")))
+}
+
+func TestProxyStore_Series_Progress(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var many, few []*storepb.SeriesResponse
+	for i := 0; i < 2500; i++ {
+		resp := storeSeriesResponse(t, labels.FromStrings("a", fmt.Sprintf("%04d", i)), []sample{{1, 1}})
+		many = append(many, resp)
+		if i < 10 {
+			few = append(few, resp)
+		}
+	}
+	cls := []Client{
+		&testClient{StoreClient: &mockedStoreAPI{RespSeries: many}, minTime: 1, maxTime: 300},
+		&testClient{StoreClient: &mockedStoreAPI{RespSeries: few}, minTime: 1, maxTime: 300},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	ch := make(chan SeriesProgress, 100)
+	s := newStoreSeriesServer(ContextWithSeriesProgress(context.Background(), ch))
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+	}, s))
+	testutil.Equals(t, 2500, len(s.SeriesSet))
+
+	var updates []SeriesProgress
+	for len(ch) > 0 {
+		updates = append(updates, <-ch)
+	}
+	// Updates for each store done and every 1000 merged series, followed by the final one.
+	testutil.Equals(t, 5, len(updates))
+	for i := 1; i < len(updates); i++ {
+		prev, cur := updates[i-1], updates[i]
+		testutil.Assert(t, cur.StoresDone >= prev.StoresDone && cur.SeriesMerged >= prev.SeriesMerged, "expected monotonic progress, got %v after %v", cur, prev)
+		testutil.Assert(t, !prev.Done, "expected done to be the last progress")
+	}
+	testutil.Equals(t, SeriesProgress{Stores: 2, StoresDone: 2, SeriesMerged: 2500, Done: true}, updates[len(updates)-1])
+}
+
+func TestProxyStore_Series_ServedPrefix(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	node := &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("__name__", "node_cpu", ServedPrefixLabel, "node_"), []sample{{1, 1}}),
+	}}
+	http := &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("__name__", "http_requests_total", ServedPrefixLabel, "grpc_, http_", "zone", "a"), []sample{{1, 1}}),
+	}}
+	other := &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("__name__", "http_requests_total", "zone", "b"), []sample{{1, 2}}),
+	}}
+	cls := []Client{
+		&testClient{StoreClient: node, labels: []storepb.Label{{Name: ServedPrefixLabel, Value: "node_"}}, minTime: 1, maxTime: 300},
+		&testClient{StoreClient: http, labels: []storepb.Label{{Name: ServedPrefixLabel, Value: "grpc_, http_"}}, minTime: 1, maxTime: 300},
+		&testClient{StoreClient: other, minTime: 1, maxTime: 300},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "__name__", Value: "http_requests_total", Type: storepb.LabelMatcher_EQ}},
+	}, s))
+
+	// Store declaring only other prefix is not contacted.
+	testutil.Assert(t, node.LastSeriesReq == nil, "expected store serving other metrics to be skipped")
+	testutil.Assert(t, http.LastSeriesReq != nil && other.LastSeriesReq != nil, "expected stores serving the metric to be queried")
+	// Served prefix label is removed from series.
+	testutil.Equals(t, 2, len(s.SeriesSet))
+	testutil.Equals(t, []storepb.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "zone", Value: "a"}}, s.SeriesSet[0].Labels)
+	testutil.Equals(t, []storepb.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "zone", Value: "b"}}, s.SeriesSet[1].Labels)
+}
+
+func TestProxyStore_Series_StoreChunkBytesLimit(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var big []*storepb.SeriesResponse
+	for _, v := range []string{"1", "2", "3"} {
+		big = append(big, storeSeriesResponse(t, labels.FromStrings("a", v), []sample{{1, 1}, {2, 2}}))
+	}
+	chunkBytes := int64(big[0].GetSeries().Chunks[0].Size())
+
+	cls := []Client{
+		&testClient{StoreClient: &mockedStoreAPI{RespSeries: big}, minTime: 1, maxTime: 300},
+		&testClient{
+			StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "4"), []sample{{1, 1}, {2, 2}}),
+			}},
+			minTime: 1,
+			maxTime: 300,
+		},
+	}
+
+	for _, tcase := range []struct {
+		limit                   int64
+		partialResponseDisabled bool
+
+		expectedErr      bool
+		expectedSeries   []string
+		expectedWarnings int
+	}{
+		{limit: 0, expectedSeries: []string{"1", "2", "3", "4"}},
+		{limit: 3 * chunkBytes, expectedSeries: []string{"1", "2", "3", "4"}},
+		// Series received from the store before it exceeded the limit are kept.
+		{limit: chunkBytes + chunkBytes/2, expectedSeries: []string{"1", "4"}, expectedWarnings: 1},
+		{limit: chunkBytes + chunkBytes/2, partialResponseDisabled: true, expectedErr: true},
+	} {
+		t.Run(fmt.Sprintf("limit=%d,partialResponseDisabled=%v", tcase.limit, tcase.partialResponseDisabled), func(t *testing.T) {
+			q := NewProxyStore(nil, nil,
+				func(context.Context) ([]Client, error) { return append([]Client{}, cls...), nil },
+				nil,
+				StoreLimit{},
+			)
+
+			s := newStoreSeriesServer(ContextWithStoreChunkBytesLimit(context.Background(), tcase.limit))
+			err := q.Series(&storepb.SeriesRequest{
+				MinTime:                 1,
+				MaxTime:                 300,
+				Matchers:                []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+				PartialResponseDisabled: tcase.partialResponseDisabled,
+			}, s)
+			if tcase.expectedErr {
+				testutil.NotOk(t, err)
+				testutil.Assert(t, strings.Contains(err.Error(), "bytes of chunks, the limit per store"), "unexpected error %v", err)
+				return
+			}
+			testutil.Ok(t, err)
+
+			var got []string
+			for _, series := range s.SeriesSet {
+				got = append(got, series.Labels[0].Value)
+			}
+			testutil.Equals(t, tcase.expectedSeries, got)
+			testutil.Equals(t, tcase.expectedWarnings, len(s.Warnings), "got %v", s.Warnings)
+		})
+	}
+}
+
+// shardingStoreServer serves the given series, honoring shard of Series requests.
+type shardingStoreServer struct {
+	// This field just exist to pseudo-implement the unused methods of the interface.
+	storepb.StoreServer
+
+	series   []storepb.Series
+	estimate int64
+	// If true, requests of the first shard fail.
+	failFirstShard bool
+
+	mtx    sync.Mutex
+	shards []*storepb.SeriesShard
+}
+
+func (s *shardingStoreServer) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	return &storepb.InfoResponse{MinTime: math.MinInt64, MaxTime: math.MaxInt64, SeriesEstimate: s.estimate}, nil
+}
+
+func (s *shardingStoreServer) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	s.mtx.Lock()
+	s.shards = append(s.shards, r.Shard)
+	s.mtx.Unlock()
+
+	if s.failFirstShard && r.Shard != nil && r.Shard.MinHash == 0 {
+		return errors.New("shard failure")
+	}
+	for i := range s.series {
+		if !r.Shard.Matches(s.series[i].Labels) {
+			continue
+		}
+		if err := srv.Send(storepb.NewSeriesResponse(&s.series[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestProxyStore_Series_SeriesBatching(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var series []storepb.Series
+	for i := 0; i < 100; i++ {
+		series = append(series, *storeSeriesResponse(t, labels.FromStrings("a", fmt.Sprintf("%03d", i)), []sample{{int64(i), 1}}).GetSeries())
+	}
+	req := &storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+	}
+
+	for _, tcase := range []struct {
+		name      string
+		estimate  int64
+		batchSize int64
+
+		expectedShards int
+	}{
+		{name: "disabled", estimate: 100, expectedShards: 1},
+		{name: "unknown estimate", estimate: 0, batchSize: 30, expectedShards: 1},
+		{name: "estimate below batch size", estimate: 100, batchSize: 100, expectedShards: 1},
+		{name: "split", estimate: 100, batchSize: 30, expectedShards: 4},
+		{name: "split capped", estimate: 100000, batchSize: 1, expectedShards: maxSeriesShards},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			srv := &shardingStoreServer{series: series, estimate: tcase.estimate}
+			q := NewProxyStore(nil, nil,
+				func(context.Context) ([]Client, error) { return []Client{NewLocalClient(srv, "sharded")}, nil },
+				nil,
+				StoreLimit{},
+			)
+
+			s := newStoreSeriesServer(ContextWithSeriesBatchSize(context.Background(), tcase.batchSize))
+			testutil.Ok(t, q.Series(req, s))
+			testutil.Equals(t, 0, len(s.Warnings))
+
+			// Merged shards yield the same series as a single request.
+			testutil.Equals(t, series, s.SeriesSet)
+			testutil.Equals(t, tcase.expectedShards, len(srv.shards))
+			if tcase.expectedShards == 1 {
+				testutil.Assert(t, srv.shards[0] == nil, "expected request without shard")
+			}
+		})
+	}
+}
+
+func TestProxyStore_Series_AllStoresFailed_Shards(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var series []storepb.Series
+	for i := 0; i < 100; i++ {
+		series = append(series, *storeSeriesResponse(t, labels.FromStrings("a", fmt.Sprintf("%03d", i)), []sample{{int64(i), 1}}).GetSeries())
+	}
+	srv := &shardingStoreServer{series: series, estimate: 100, failFirstShard: true}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return []Client{NewLocalClient(srv, "sharded")}, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	// Store that failed only one of its shards did not fail, so series of its other shard are returned.
+	ctx := ContextWithAllStoresFailedError(ContextWithSeriesBatchSize(context.Background(), 50))
+	s := newStoreSeriesServer(ctx)
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+	}, s))
+	testutil.Equals(t, 1, len(s.Warnings))
+	testutil.Assert(t, len(s.SeriesSet) > 0 && len(s.SeriesSet) < len(series), "expected series of one shard, got %d", len(s.SeriesSet))
+}
+
+func TestSeriesShards(t *testing.T) {
+	for _, n := range []int{1, 3, 4, 64} {
+		shards := storepb.SeriesShards(n)
+		testutil.Equals(t, n, len(shards))
+		testutil.Equals(t, uint64(0), shards[0].MinHash)
+		testutil.Equals(t, uint64(math.MaxUint64), shards[n-1].MaxHash)
+		for i := 1; i < n; i++ {
+			testutil.Equals(t, shards[i-1].MaxHash+1, shards[i].MinHash)
+		}
+	}
+}
+
+func TestProxyStore_SeriesStores(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	upSeries := func() []*storepb.SeriesResponse {
+		return []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("__name__", "up", "a", "1"), []sample{{1, 1}}),
+			storeSeriesResponse(t, labels.FromStrings("__name__", "up", "a", "2"), []sample{{1, 1}}),
+		}
+	}
+	var (
+		withSeries1 = &mockedStoreAPI{RespSeries: upSeries()}
+		withSeries2 = &mockedStoreAPI{RespSeries: append([]*storepb.SeriesResponse{storepb.NewWarnSeriesResponse(errors.New("warning"))}, upSeries()...)}
+		empty       = &mockedStoreAPI{}
+		outOfRange  = &mockedStoreAPI{RespSeries: upSeries()}
+		failing     = &mockedStoreAPI{RespError: errors.New("error")}
+	)
+	cls := []Client{
+		&testClient{StoreClient: withSeries1, minTime: 1, maxTime: 300, addr: "store-1"},
+		&testClient{StoreClient: empty, minTime: 1, maxTime: 300, addr: "store-2"},
+		&testClient{StoreClient: withSeries2, minTime: 1, maxTime: 300, addr: "store-3"},
+		&testClient{StoreClient: outOfRange, minTime: 400, maxTime: 500, addr: "store-4"},
+		&testClient{StoreClient: failing, minTime: 1, maxTime: 300, addr: "store-5"},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	req := &storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "__name__", Value: "up", Type: storepb.LabelMatcher_EQ}},
+	}
+	addrs, warnings, err := q.SeriesStores(context.Background(), req)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"store-1", "store-3"}, addrs)
+	testutil.Equals(t, 2, len(warnings))
+
+	testutil.Assert(t, withSeries1.LastSeriesReq.SkipChunks, "expected chunks to be skipped")
+	testutil.Assert(t, outOfRange.LastSeriesReq == nil, "expected store out of time range to be not queried")
+
+	req.PartialResponseDisabled = true
+	_, _, err = q.SeriesStores(context.Background(), req)
+	testutil.NotOk(t, err)
+}
+
+func TestProxyStore_Series_RegressionFillResponseChannel(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var cls []Client
+	for i := 0; i < 10; i++ {
+		cls = append(cls, &testClient{
+			StoreClient: &mockedStoreAPI{
+				RespError: errors.New("test error"),
+			},
+			minTime: 1,
+			maxTime: 300,
+		})
+		cls = append(cls, &testClient{
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{
+					storepb.NewWarnSeriesResponse(errors.New("warning")),
+					storepb.NewWarnSeriesResponse(errors.New("warning")),
+					storepb.NewWarnSeriesResponse(errors.New("warning")),
+					storepb.NewWarnSeriesResponse(errors.New("warning")),
+					storepb.NewWarnSeriesResponse(errors.New("warning")),
+					storepb.NewWarnSeriesResponse(errors.New("warning")),
+					storepb.NewWarnSeriesResponse(errors.New("warning")),
+					storepb.NewWarnSeriesResponse(errors.New("warning")),
+					storepb.NewWarnSeriesResponse(errors.New("warning")),
+					storepb.NewWarnSeriesResponse(errors.New("warning")),
+				},
+			},
+			minTime: 1,
+			maxTime: 300,
+		})
+
+	}
+
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		tlabels.FromStrings("fed", "a"),
+		StoreLimit{},
+	)
+
+	ctx := context.Background()
+	s := newStoreSeriesServer(ctx)
+
+	testutil.Ok(t, q.Series(
+		&storepb.SeriesRequest{
+			MinTime:  1,
+			MaxTime:  300,
+			Matchers: []storepb.LabelMatcher{{Name: "fed", Value: "a", Type: storepb.LabelMatcher_EQ}},
+		}, s,
+	))
+	testutil.Equals(t, 0, len(s.SeriesSet))
+	testutil.Equals(t, 110, len(s.Warnings))
+}
+
+func TestProxyStore_LabelValues(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	m1 := &mockedStoreAPI{
+		RespLabelValues: &storepb.LabelValuesResponse{
+			Values:   []string{"1", "2"},
+			Warnings: []string{"warning"},
+		},
+	}
+	cls := []Client{
+		&testClient{StoreClient: m1},
+		&testClient{StoreClient: &mockedStoreAPI{
+			RespLabelValues: &storepb.LabelValuesResponse{
+				Values: []string{"3", "4"},
+			},
+		}},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	ctx := context.Background()
+	req := &storepb.LabelValuesRequest{
+		Label: "a",
+		PartialResponseDisabled: true,
+	}
+	resp, err := q.LabelValues(ctx, req)
+	testutil.Ok(t, err)
+	testutil.Assert(t, proto.Equal(req, m1.LastLabelValuesReq), "request was not proxied properly to underlying storeAPI: %s vs %s", req, m1.LastLabelValuesReq)
+
+	testutil.Equals(t, []string{"1", "2", "3", "4"}, resp.Values)
+	testutil.Equals(t, 1, len(resp.Warnings))
+}
+
+func TestProxyStore_LabelValues_Limit(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	for _, tcase := range []struct {
+		name     string
+		resps    []*storepb.LabelValuesResponse
+		limit    int64
+		expected  []string
+		truncated bool
+	}{
+		{
+			name: "no limit",
+			resps: []*storepb.LabelValuesResponse{
+				{Values: []string{"a", "c", "e"}},
+				{Values: []string{"b", "d"}},
+			},
+			expected: []string{"a", "b", "c", "d", "e"},
+		},
+		{
+			name: "merged values over limit",
+			resps: []*storepb.LabelValuesResponse{
+				{Values: []string{"a", "c", "e"}},
+				{Values: []string{"b", "d"}},
+			},
+			limit:    3,
+			expected:  []string{"a", "b", "c"},
+			truncated: true,
+		},
+		{
+			name: "merged values within limit",
+			resps: []*storepb.LabelValuesResponse{
+				{Values: []string{"a", "c"}},
+				{Values: []string{"b"}},
+			},
+			limit:    3,
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name: "values truncated by stores",
+			resps: []*storepb.LabelValuesResponse{
+				{Values: []string{"a", "b"}, Truncated: true},
+				{Values: []string{"a", "b"}, Warnings: []string{"store warning"}},
+			},
+			limit:     2,
+			expected:  []string{"a", "b"},
+			truncated: true,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			var (
+				cls []Client
+				ms  []*mockedStoreAPI
+			)
+			for _, resp := range tcase.resps {
+				m := &mockedStoreAPI{RespLabelValues: resp}
+				ms = append(ms, m)
+				cls = append(cls, &testClient{StoreClient: m})
+			}
+			q := NewProxyStore(nil, nil,
+				func(context.Context) ([]Client, error) { return cls, nil },
+				nil,
+				StoreLimit{},
+			)
+
+			resp, err := q.LabelValues(context.Background(), &storepb.LabelValuesRequest{Label: "a", Limit: tcase.limit})
+			testutil.Ok(t, err)
+			for _, m := range ms {
+				testutil.Equals(t, tcase.limit, m.LastLabelValuesReq.Limit)
+			}
+			testutil.Equals(t, tcase.expected, resp.Values)
+			testutil.Equals(t, tcase.truncated, resp.Truncated)
+			// Warnings of stores are passed through.
+			var warnings []string
+			for _, r := range tcase.resps {
+				warnings = append(warnings, r.Warnings...)
+			}
+			testutil.Equals(t, warnings, resp.Warnings)
+		})
+	}
+}
+
+func TestProxyStore_LabelValues_TimeSplit(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Store gateway with historical data and sidecar with recent data, covering adjacent time ranges.
+	cls := []Client{
+		&testClient{
+			StoreClient: &mockedStoreAPI{RespLabelValues: &storepb.LabelValuesResponse{Values: []string{"old", "shared"}}},
+			minTime:     0,
+			maxTime:     99,
+		},
+		&testClient{
+			StoreClient: &mockedStoreAPI{RespLabelValues: &storepb.LabelValuesResponse{Values: []string{"new", "shared"}}},
+			minTime:     100,
+			maxTime:     199,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		StoreLimit{},
+	)
+
+	for _, tcase := range []struct {
+		name     string
+		ctx      context.Context
+		expected []string
+	}{
+		{name: "no time range", ctx: context.Background(), expected: []string{"new", "old", "shared"}},
+		{name: "spanning both stores", ctx: ContextWithLabelValuesTimeRange(context.Background(), 50, 150), expected: []string{"new", "old", "shared"}},
+		{name: "at the split", ctx: ContextWithLabelValuesTimeRange(context.Background(), 99, 100), expected: []string{"new", "old", "shared"}},
+		{name: "historical only", ctx: ContextWithLabelValuesTimeRange(context.Background(), 10, 99), expected: []string{"old", "shared"}},
+		{name: "recent only", ctx: ContextWithLabelValuesTimeRange(context.Background(), 100, 1000), expected: []string{"new", "shared"}},
+		{name: "no store", ctx: ContextWithLabelValuesTimeRange(context.Background(), 200, 300)},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			resp, err := q.LabelValues(tcase.ctx, &storepb.LabelValuesRequest{Label: "a", PartialResponseDisabled: true})
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, resp.Values)
+			testutil.Equals(t, 0, len(resp.Warnings))
+		})
+	}
+}
+
+type rawSeries struct {
+	lset    []storepb.Label
+	samples []sample
+}
+
+func seriesEqual(t *testing.T, expected []rawSeries, got []storepb.Series) {
+	testutil.Equals(t, len(expected), len(got), "got: %v", got)
+
+	for i, series := range got {
+		testutil.Equals(t, expected[i].lset, series.Labels)
+
+		k := 0
+		for _, chk := range series.Chunks {
+			c, err := chunkenc.FromData(chunkenc.EncXOR, chk.Raw.Data)
+			testutil.Ok(t, err)
+
+			iter := c.Iterator()
+			for iter.Next() {
+				testutil.Assert(t, k < len(expected[i].samples), "more samples than expected")
+
+				tv, v := iter.At()
+				testutil.Equals(t, expected[i].samples[k], sample{tv, v})
+				k++
+			}
+			testutil.Ok(t, iter.Err())
+		}
+		testutil.Equals(t, len(expected[i].samples), k)
+	}
+}
+
+func TestProxyStore_LabelNames_Limit(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	for _, tcase := range []struct {
+		name      string
+		resps     []*storepb.LabelNamesResponse
+		limit     int64
+		expected  []string
+		truncated bool
+	}{
+		{
+			name: "no limit",
+			resps: []*storepb.LabelNamesResponse{
+				{Names: []string{"__name__", "instance", "job"}},
+				{Names: []string{"instance", "job", "zone"}},
+				{Names: []string{"cluster"}},
+			},
+			expected: []string{"__name__", "cluster", "instance", "job", "zone"},
+		},
+		{
+			name: "merged names over limit",
+			resps: []*storepb.LabelNamesResponse{
+				{Names: []string{"__name__", "instance", "job"}},
+				{Names: []string{"instance", "job", "zone"}},
+				{Names: []string{"cluster"}},
+			},
+			limit:     3,
+			expected:  []string{"__name__", "cluster", "instance"},
+			truncated: true,
+		},
+		{
+			name: "merged names within limit",
+			resps: []*storepb.LabelNamesResponse{
+				{Names: []string{"instance", "job"}},
+				{Names: []string{"job"}},
+			},
+			limit:    2,
+			expected: []string{"instance", "job"},
+		},
+		{
+			name: "names truncated by a store",
+			resps: []*storepb.LabelNamesResponse{
+				{Names: []string{"a", "b"}, Truncated: true},
+				{Names: []string{"a"}, Warnings: []string{"store warning"}},
+			},
+			limit:     2,
+			expected:  []string{"a", "b"},
+			truncated: true,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			var (
+				cls []Client
+				ms  []*mockedStoreAPI
+			)
+			for _, resp := range tcase.resps {
+				m := &mockedStoreAPI{RespLabelNames: resp}
+				ms = append(ms, m)
+				cls = append(cls, &testClient{StoreClient: m})
+			}
+			q := NewProxyStore(nil, nil,
+				func(context.Context) ([]Client, error) { return cls, nil },
+				nil,
+				StoreLimit{},
+			)
+
+			resp, err := q.LabelNames(context.Background(), &storepb.LabelNamesRequest{Limit: tcase.limit})
+			testutil.Ok(t, err)
+			for _, m := range ms {
+				testutil.Equals(t, tcase.limit, m.LastLabelNamesReq.Limit)
+			}
+			testutil.Equals(t, tcase.expected, resp.Names)
+			testutil.Equals(t, tcase.truncated, resp.Truncated)
+			// Warnings of stores are passed through.
+			var warnings []string
+			for _, r := range tcase.resps {
+				warnings = append(warnings, r.Warnings...)
+			}
+			testutil.Equals(t, warnings, resp.Warnings)
+		})
+	}
+}
+
+func TestProxyStore_Series_ForwardsRequest(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	api := &mockedStoreAPI{}
+	cls := []Client{&testClient{StoreClient: api, minTime: 1, maxTime: 300, addr: "store"}}
+	q := NewProxyStore(nil, nil, func(context.Context) ([]Client, error) { return cls, nil }, nil, StoreLimit{})
+
+	req := &storepb.SeriesRequest{
+		MinTime:                 1,
+		MaxTime:                 300,
+		Matchers:                []storepb.LabelMatcher{{Name: "a", Value: "1", Type: storepb.LabelMatcher_EQ}},
+		MaxResolutionWindow:     300000,
+		Aggregates:              []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM},
+		PartialResponseDisabled: true,
+		SkipChunks:              true,
+		Hints:                   &storepb.SeriesHints{StartTime: 1, EndTime: 300, Step: 15, Func: "rate"},
+		ReportQueriedBlocks:     true,
+		Shard:                   &storepb.SeriesShard{MinHash: 0, MaxHash: math.MaxUint64},
+		KnownChunks:             []storepb.KnownChunk{{SeriesRef: 1, MinTime: 1, MaxTime: 2}},
+		ChunkEncoding:           storepb.SeriesRequest_RAW,
+		AcceptCompressedChunks:  true,
+	}
+	testutil.Ok(t, q.Series(req, newStoreSeriesServer(context.Background())))
+
+	// Every field is set, so fields added later are noticed here if the proxy does not forward them. Known chunks are
+	// not forwarded, as chunks omitted by stores are filled in only from the chunk cache of the proxy.
+	v := reflect.ValueOf(*req)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		if strings.HasPrefix(name, "XXX_") {
+			continue
+		}
+		testutil.Assert(t, !reflect.DeepEqual(v.Field(i).Interface(), reflect.Zero(v.Field(i).Type()).Interface()), "field %s not set by the test", name)
+	}
+	exp := *req
+	exp.KnownChunks = nil
+	testutil.Equals(t, &exp, api.LastSeriesReq)
+}
+
+func TestProxyStore_Series_UnmergedStoreAddr(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	cls := []Client{
+		&testClient{
+			StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}),
+			}},
+			minTime: 1,
+			maxTime: 300,
+			addr:    "store-1:10901",
+		},
+	}
+	q := NewProxyStore(nil, nil, func(context.Context) ([]Client, error) { return cls, nil }, nil, StoreLimit{})
+
+	var stores []string
+	ctx := ContextWithUnmergedSeries(context.Background(), func(store string, _ storepb.Series) error {
+		stores = append(stores, store)
+		return nil
+	})
+	s := newStoreSeriesServer(ctx)
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "1", Type: storepb.LabelMatcher_EQ}},
+	}, s))
+
+	// Series are passed with the address of their store, not its description.
+	testutil.Equals(t, []string{"store-1:10901"}, stores)
+	testutil.Equals(t, 0, len(s.SeriesSet))
+}
+
+func TestStoreSelector_SkipReasons(t *testing.T) {
+	var (
+		selected    = &testClient{addr: "selected", labels: []storepb.Label{{Name: "tenant", Value: "t1"}, {Name: "region", Value: "eu"}}, minTime: 100, maxTime: 200}
+		onLabels    = &testClient{addr: "labels", labels: []storepb.Label{{Name: "tenant", Value: "t1"}, {Name: "region", Value: "us"}}, minTime: 100, maxTime: 200}
+		onTime      = &testClient{addr: "time", labels: []storepb.Label{{Name: "tenant", Value: "t1"}, {Name: "region", Value: "eu"}}, minTime: 300, maxTime: 400}
+		otherTenant = &testClient{addr: "tenant", labels: []storepb.Label{{Name: "region", Value: "eu"}}, minTime: 100, maxTime: 200}
+		stores      = []Client{selected, onLabels, onTime, otherTenant}
+		matchers    = []storepb.LabelMatcher{
+			{Type: storepb.LabelMatcher_EQ, Name: "tenant", Value: "t1"},
+			{Type: storepb.LabelMatcher_EQ, Name: "region", Value: "eu"},
+		}
+		q = NewProxyStore(nil, nil, nil, nil, StoreLimit{}, WithTenantLabel("tenant"))
+	)
+
+	matched, skipped := q.newStoreSelector(context.Background(), 150, 250, matchers).selectStores(stores)
+	testutil.Equals(t, []Client{selected}, matched)
+	testutil.Equals(t, []storeDecision{
+		{store: onLabels, reason: storeLabelMismatch},
+		{store: onTime, reason: storeOutOfRange},
+		{store: otherTenant, reason: storeOtherTenant},
+	}, skipped)
+
+	// Explicitly requested stores are selected regardless of their meta information.
+	ctx := ContextWithStoreAddrs(context.Background(), "labels", "time")
+	matched, skipped = q.newStoreSelector(ctx, 150, 250, matchers).selectStores(stores)
+	testutil.Equals(t, []Client{onLabels, onTime}, matched)
+	testutil.Equals(t, []storeDecision{
+		{store: selected, reason: storeNotRequested},
+		{store: otherTenant, reason: storeNotRequested},
+	}, skipped)
+}
+
+func TestMatchStore(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	cases := []struct {
+		s          Client
+		mint, maxt int64
+		ms         []storepb.LabelMatcher
+		ok         bool
+	}{
+		{
+			s: &testClient{labels: []storepb.Label{{Name: "a", Value: "b"}}},
+			ms: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ, Name: "b", Value: "1"},
+			},
+			ok: true,
+		},
+		{
+			s:    &testClient{minTime: 100, maxTime: 200},
+			mint: 201,
+			maxt: 300,
+			ok:   false,
+		},
+		{
+			s:    &testClient{minTime: 100, maxTime: 200},
+			mint: 200,
+			maxt: 300,
+			ok:   true,
+		},
+		{
+			s:    &testClient{minTime: 100, maxTime: 200},
+			mint: 50,
+			maxt: 99,
+			ok:   false,
+		},
+		{
+			s:    &testClient{minTime: 100, maxTime: 200},
+			mint: 50,
 			maxt: 100,
 			ok:   true,
 		},
@@ -644,12 +2117,49 @@ func TestStoreMatches(t *testing.T) {
 			},
 			ok: true,
 		},
+		{
+			s: &testClient{labels: []storepb.Label{{Name: "a", Value: "b"}}},
+			ms: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_RE, Name: "__name__", Value: "http_.*"},
+			},
+			ok: true,
+		},
+		{
+			s: &testClient{labels: []storepb.Label{{Name: "a", Value: "b"}}},
+			ms: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_RE, Name: "__name__", Value: "http_.*"},
+				{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "c"},
+			},
+			ok: false,
+		},
+		{
+			s: &testClient{labels: []storepb.Label{{Name: "region", Value: "us"}}},
+			ms: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ_CI, Name: "region", Value: "US"},
+			},
+			ok: true,
+		},
+		{
+			s: &testClient{labels: []storepb.Label{{Name: "region", Value: "us-east"}}},
+			ms: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ_CI, Name: "region", Value: "US"},
+			},
+			ok: false,
+		},
+		{
+			// Value is matched literally, not as a regular expression.
+			s: &testClient{labels: []storepb.Label{{Name: "region", Value: "us"}}},
+			ms: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ_CI, Name: "region", Value: "U."},
+			},
+			ok: false,
+		},
 	}
 
 	for i, c := range cases {
-		ok, err := storeMatches(c.s, c.mint, c.maxt, c.ms...)
+		reason, err := matchStore(c.s, c.mint, c.maxt, c.ms...)
 		testutil.Ok(t, err)
-		testutil.Assert(t, c.ok == ok, "test case %d failed", i)
+		testutil.Assert(t, c.ok == (reason == storeSelected), "test case %d failed", i)
 	}
 }
 
@@ -688,10 +2198,16 @@ func (s *storeSeriesServer) Context() context.Context {
 // mockedStoreAPI is test gRPC store API client.
 type mockedStoreAPI struct {
 	RespSeries      []*storepb.SeriesResponse
+	RespLabelNames  *storepb.LabelNamesResponse
 	RespLabelValues *storepb.LabelValuesResponse
 	RespError       error
+	// RespRecvError is returned by the series stream after all RespSeries were received.
+	RespRecvError error
+	// RespBlock makes the series stream block after all RespSeries were received until its context is done.
+	RespBlock bool
 
 	LastSeriesReq      *storepb.SeriesRequest
+	LastLabelNamesReq  *storepb.LabelNamesRequest
 	LastLabelValuesReq *storepb.LabelValuesRequest
 }
 
@@ -702,11 +2218,13 @@ func (s *mockedStoreAPI) Info(ctx context.Context, req *storepb.InfoRequest, _ .
 func (s *mockedStoreAPI) Series(ctx context.Context, req *storepb.SeriesRequest, _ ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
 	s.LastSeriesReq = req
 
-	return &StoreSeriesClient{ctx: ctx, respSet: s.RespSeries}, s.RespError
+	return &StoreSeriesClient{ctx: ctx, respSet: s.RespSeries, err: s.RespRecvError, block: s.RespBlock}, s.RespError
 }
 
 func (s *mockedStoreAPI) LabelNames(ctx context.Context, req *storepb.LabelNamesRequest, _ ...grpc.CallOption) (*storepb.LabelNamesResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "not implemented")
+	s.LastLabelNamesReq = req
+
+	return s.RespLabelNames, s.RespError
 }
 
 func (s *mockedStoreAPI) LabelValues(ctx context.Context, req *storepb.LabelValuesRequest, _ ...grpc.CallOption) (*storepb.LabelValuesResponse, error) {
@@ -722,10 +2240,19 @@ type StoreSeriesClient struct {
 	ctx     context.Context
 	i       int
 	respSet []*storepb.SeriesResponse
+	err     error
+	block   bool
 }
 
 func (c *StoreSeriesClient) Recv() (*storepb.SeriesResponse, error) {
 	if c.i >= len(c.respSet) {
+		if c.block {
+			<-c.ctx.Done()
+			return nil, c.ctx.Err()
+		}
+		if c.err != nil {
+			return nil, c.err
+		}
 		return nil, io.EOF
 	}
 	s := c.respSet[c.i]
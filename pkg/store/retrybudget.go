@@ -0,0 +1,40 @@
+package store
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// RetryBudget bounds the total number of retries of failed store calls across all stores and Series requests of
+// ProxyStore made with a context holding it, see ContextWithRetryBudget. Retries of each store are not coordinated,
+// so during an incident they amplify load of already struggling stores. Sharing a budget by all requests of a query
+// caps it. It is safe to use concurrently.
+type RetryBudget struct {
+	left int64
+}
+
+// NewRetryBudget returns RetryBudget allowing the given number of retries.
+func NewRetryBudget(retries int) *RetryBudget {
+	return &RetryBudget{left: int64(retries)}
+}
+
+// take takes a retry from the budget, returning false if it is exhausted. Nil budget is unlimited.
+func (b *RetryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	return atomic.AddInt64(&b.left, -1) >= 0
+}
+
+type retryBudgetKey struct{}
+
+// ContextWithRetryBudget returns a new context.Context that makes ProxyStore take retries of failed store calls of
+// Series requests made with it from the given budget. Once the budget is exhausted, failures are not retried.
+func ContextWithRetryBudget(ctx context.Context, b *RetryBudget) context.Context {
+	return context.WithValue(ctx, retryBudgetKey{}, b)
+}
+
+func retryBudgetFromContext(ctx context.Context) *RetryBudget {
+	b, _ := ctx.Value(retryBudgetKey{}).(*RetryBudget)
+	return b
+}
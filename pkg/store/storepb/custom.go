@@ -1,6 +1,8 @@
 package storepb
 
 import (
+	"hash/fnv"
+	"math"
 	"strings"
 
 	"github.com/prometheus/prometheus/pkg/labels"
@@ -22,6 +24,14 @@ func NewSeriesResponse(series *Series) *SeriesResponse {
 	}
 }
 
+func NewQueriedBlocksSeriesResponse(ids []string) *SeriesResponse {
+	return &SeriesResponse{
+		Result: &SeriesResponse_QueriedBlocks{
+			QueriedBlocks: &QueriedBlocks{Ids: ids},
+		},
+	}
+}
+
 // CompareLabels compares two sets of labels.
 func CompareLabels(a, b []Label) int {
 	l := len(a)
@@ -40,6 +50,33 @@ func CompareLabels(a, b []Label) int {
 	return len(a) - len(b)
 }
 
+// LabelsComparator compares two sets of labels, returning a negative number if a is ordered before b, a positive one
+// if it is ordered after b and zero if they are equal. Only equal label sets may compare as equal, as merges join
+// chunks of such series.
+type LabelsComparator func(a, b []Label) int
+
+// CompareLabelsBy returns LabelsComparator ordering label sets by values of the given labels first, in the given
+// order, and then by CompareLabels. Missing labels have empty value.
+func CompareLabelsBy(names ...string) LabelsComparator {
+	return func(a, b []Label) int {
+		for _, n := range names {
+			if d := strings.Compare(labelValue(a, n), labelValue(b, n)); d != 0 {
+				return d
+			}
+		}
+		return CompareLabels(a, b)
+	}
+}
+
+func labelValue(lset []Label, name string) string {
+	for _, l := range lset {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
+
 type emptySeriesSet struct{}
 
 func (emptySeriesSet) Next() bool                 { return false }
@@ -162,6 +199,15 @@ func LabelsToPromLabels(lset []Label) labels.Labels {
 	return ret
 }
 
+// PromLabelsToLabels converts Prometheus labels to labels of StoreAPI.
+func PromLabelsToLabels(lset labels.Labels) []Label {
+	ret := make([]Label, len(lset))
+	for i, l := range lset {
+		ret[i] = Label{Name: l.Name, Value: l.Value}
+	}
+	return ret
+}
+
 func LabelsToString(lset []Label) string {
 	var s []string
 	for _, l := range lset {
@@ -169,3 +215,54 @@ func LabelsToString(lset []Label) string {
 	}
 	return "[" + strings.Join(s, ",") + "]"
 }
+
+// LabelsHash returns FNV-1a hash of the given labels, used to assign series to shards.
+func LabelsHash(lset []Label) uint64 {
+	h := fnv.New64a()
+	for _, l := range lset {
+		_, _ = h.Write([]byte(l.Name))
+		_, _ = h.Write(hashSep)
+		_, _ = h.Write([]byte(l.Value))
+		_, _ = h.Write(hashSep)
+	}
+	return h.Sum64()
+}
+
+var hashSep = []byte{0xff}
+
+// Matches returns true if hash of the given labels falls into the shard. Nil shard matches all labels.
+func (m *SeriesShard) Matches(lset []Label) bool {
+	if m == nil {
+		return true
+	}
+	return m.MatchesHash(LabelsHash(lset))
+}
+
+// MatchesHash returns true if the given hash of labels falls into the shard. Nil shard matches all hashes.
+func (m *SeriesShard) MatchesHash(h uint64) bool {
+	if m == nil {
+		return true
+	}
+	return h >= m.MinHash && h <= m.MaxHash
+}
+
+// SeriesRefShard returns shard selecting only series with the given hash of labels, see LabelsHash.
+func SeriesRefShard(ref uint64) *SeriesShard {
+	return &SeriesShard{MinHash: ref, MaxHash: ref}
+}
+
+// SeriesShards returns n shards of equal hash ranges covering all series.
+func SeriesShards(n int) []SeriesShard {
+	if n < 1 {
+		n = 1
+	}
+	var (
+		res  = make([]SeriesShard, 0, n)
+		span = math.MaxUint64 / uint64(n)
+	)
+	for i := uint64(0); i < uint64(n); i++ {
+		res = append(res, SeriesShard{MinHash: i * span, MaxHash: (i+1)*span - 1})
+	}
+	res[n-1].MaxHash = math.MaxUint64
+	return res
+}
@@ -59,7 +59,74 @@ func (Aggr) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor_rpc_6ccafde20b200300, []int{0}
 }
 
+// / StoreType is the kind of component serving StoreAPI. Stores of older versions report UNKNOWN.
+type StoreType int32
+
+const (
+	StoreType_UNKNOWN StoreType = 0
+	StoreType_QUERY   StoreType = 1
+	StoreType_RULE    StoreType = 2
+	StoreType_SIDECAR StoreType = 3
+	StoreType_STORE   StoreType = 4
+)
+
+var StoreType_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "QUERY",
+	2: "RULE",
+	3: "SIDECAR",
+	4: "STORE",
+}
+var StoreType_value = map[string]int32{
+	"UNKNOWN": 0,
+	"QUERY":   1,
+	"RULE":    2,
+	"SIDECAR": 3,
+	"STORE":   4,
+}
+
+func (x StoreType) String() string {
+	return proto.EnumName(StoreType_name, int32(x))
+}
+func (StoreType) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_rpc_6ccafde20b200300, []int{1}
+}
+
+// / ChunkEncoding is the encoding of chunks preferred by the client.
+type SeriesRequest_ChunkEncoding int32
+
+const (
+	// / ANY leaves the encoding to the store, picking the resolution by max_resolution_window.
+	SeriesRequest_ANY SeriesRequest_ChunkEncoding = 0
+	// / RAW asks for raw chunks, neither downsampled nor compressed, even if max_resolution_window allows aggregates.
+	SeriesRequest_RAW SeriesRequest_ChunkEncoding = 1
+	// / AGGREGATED asks for downsampled chunks of the requested aggregates, even where max_resolution_window selects raw
+	// / data, e.g. for recent data, to reduce the size of responses.
+	SeriesRequest_AGGREGATED SeriesRequest_ChunkEncoding = 2
+)
+
+var SeriesRequest_ChunkEncoding_name = map[int32]string{
+	0: "ANY",
+	1: "RAW",
+	2: "AGGREGATED",
+}
+var SeriesRequest_ChunkEncoding_value = map[string]int32{
+	"ANY":        0,
+	"RAW":        1,
+	"AGGREGATED": 2,
+}
+
+func (x SeriesRequest_ChunkEncoding) String() string {
+	return proto.EnumName(SeriesRequest_ChunkEncoding_name, int32(x))
+}
+func (SeriesRequest_ChunkEncoding) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_rpc_6ccafde20b200300, []int{3, 0}
+}
+
 type InfoRequest struct {
+	// / report_stats requests totals of data held by the store in stats of the response. They may be expensive to compute,
+	// / so they are reported only on request.
+	ReportStats          bool     `protobuf:"varint,1,opt,name=report_stats,json=reportStats,proto3" json:"report_stats,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -99,9 +166,18 @@ func (m *InfoRequest) XXX_DiscardUnknown() {
 var xxx_messageInfo_InfoRequest proto.InternalMessageInfo
 
 type InfoResponse struct {
-	Labels               []Label  `protobuf:"bytes,1,rep,name=labels" json:"labels"`
-	MinTime              int64    `protobuf:"varint,2,opt,name=min_time,json=minTime,proto3" json:"min_time,omitempty"`
-	MaxTime              int64    `protobuf:"varint,3,opt,name=max_time,json=maxTime,proto3" json:"max_time,omitempty"`
+	Labels  []Label `protobuf:"bytes,1,rep,name=labels" json:"labels"`
+	MinTime int64   `protobuf:"varint,2,opt,name=min_time,json=minTime,proto3" json:"min_time,omitempty"`
+	MaxTime int64   `protobuf:"varint,3,opt,name=max_time,json=maxTime,proto3" json:"max_time,omitempty"`
+	// / series_estimate is the estimated number of series in the store, or zero if unknown. Stores reporting it must
+	// / support shard of Series request, as clients may split requests of stores with many series into shards.
+	SeriesEstimate int64 `protobuf:"varint,4,opt,name=series_estimate,json=seriesEstimate,proto3" json:"series_estimate,omitempty"`
+	// / stats are totals of data held by the store, set only if requested and supported by the store.
+	Stats *StoreStats `protobuf:"bytes,5,opt,name=stats" json:"stats,omitempty"`
+	// / store_type is the kind of component serving the StoreAPI.
+	StoreType StoreType `protobuf:"varint,6,opt,name=store_type,json=storeType,proto3,enum=thanos.StoreType" json:"store_type,omitempty"`
+	// / supports_known_chunks is true if the store omits data of chunks listed in known_chunks of Series requests.
+	SupportsKnownChunks  bool     `protobuf:"varint,7,opt,name=supports_known_chunks,json=supportsKnownChunks,proto3" json:"supports_known_chunks,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -140,6 +216,53 @@ func (m *InfoResponse) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_InfoResponse proto.InternalMessageInfo
 
+// / StoreStats are totals of data held by a store, e.g. for capacity planning. Series and chunks present in multiple
+// / blocks are counted for each of them.
+type StoreStats struct {
+	NumBlocks  int64 `protobuf:"varint,1,opt,name=num_blocks,json=numBlocks,proto3" json:"num_blocks,omitempty"`
+	NumSeries  int64 `protobuf:"varint,2,opt,name=num_series,json=numSeries,proto3" json:"num_series,omitempty"`
+	NumChunks  int64 `protobuf:"varint,3,opt,name=num_chunks,json=numChunks,proto3" json:"num_chunks,omitempty"`
+	NumSamples int64 `protobuf:"varint,4,opt,name=num_samples,json=numSamples,proto3" json:"num_samples,omitempty"`
+	// / num_bytes is the approximate size of the data in bytes, or zero if unknown.
+	NumBytes             int64    `protobuf:"varint,5,opt,name=num_bytes,json=numBytes,proto3" json:"num_bytes,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StoreStats) Reset()         { *m = StoreStats{} }
+func (m *StoreStats) String() string { return proto.CompactTextString(m) }
+func (*StoreStats) ProtoMessage()    {}
+func (*StoreStats) Descriptor() ([]byte, []int) {
+	return fileDescriptor_rpc_6ccafde20b200300, []int{2}
+}
+func (m *StoreStats) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *StoreStats) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_StoreStats.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (dst *StoreStats) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StoreStats.Merge(dst, src)
+}
+func (m *StoreStats) XXX_Size() int {
+	return m.Size()
+}
+func (m *StoreStats) XXX_DiscardUnknown() {
+	xxx_messageInfo_StoreStats.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StoreStats proto.InternalMessageInfo
+
 type SeriesRequest struct {
 	MinTime                 int64          `protobuf:"varint,1,opt,name=min_time,json=minTime,proto3" json:"min_time,omitempty"`
 	MaxTime                 int64          `protobuf:"varint,2,opt,name=max_time,json=maxTime,proto3" json:"max_time,omitempty"`
@@ -147,16 +270,36 @@ type SeriesRequest struct {
 	MaxResolutionWindow     int64          `protobuf:"varint,4,opt,name=max_resolution_window,json=maxResolutionWindow,proto3" json:"max_resolution_window,omitempty"`
 	Aggregates              []Aggr         `protobuf:"varint,5,rep,packed,name=aggregates,enum=thanos.Aggr" json:"aggregates,omitempty"`
 	PartialResponseDisabled bool           `protobuf:"varint,6,opt,name=partial_response_disabled,json=partialResponseDisabled,proto3" json:"partial_response_disabled,omitempty"`
-	XXX_NoUnkeyedLiteral    struct{}       `json:"-"`
-	XXX_unrecognized        []byte         `json:"-"`
-	XXX_sizecache           int32          `json:"-"`
+	// / skip_chunks controls whether chunks should be omitted from the response. Stores that support it send
+	// / only series labels, which is much cheaper. Others may still send chunks.
+	SkipChunks bool `protobuf:"varint,7,opt,name=skip_chunks,json=skipChunks,proto3" json:"skip_chunks,omitempty"`
+	// / hints are optional hints about the PromQL query the series are selected for, like the ones Prometheus remote read
+	// / sends. They are advisory only: stores may use them to plan the work, e.g. to prefetch data or to cut chunks at step
+	// / boundaries, but must return the same series and samples as without them, so stores are free to ignore them.
+	Hints *SeriesHints `protobuf:"bytes,8,opt,name=hints" json:"hints,omitempty"`
+	// / report_queried_blocks asks stores to report blocks they queried with a queried_blocks response. Stores that don't
+	// / query blocks ignore it.
+	ReportQueriedBlocks bool `protobuf:"varint,9,opt,name=report_queried_blocks,json=reportQueriedBlocks,proto3" json:"report_queried_blocks,omitempty"`
+	// / shard restricts the request to series with hash of labels within the shard. All series are requested if not set.
+	Shard *SeriesShard `protobuf:"bytes,10,opt,name=shard" json:"shard,omitempty"`
+	// / known_chunks lists raw chunks the client already holds, e.g. in a cache. Stores may omit data of listed chunks,
+	// / sending them as chunks with only min_time and max_time set. Others send all chunks.
+	KnownChunks []KnownChunk `protobuf:"bytes,11,rep,name=known_chunks,json=knownChunks" json:"known_chunks"`
+	// / chunk_encoding is the encoding of chunks preferred by the client. Stores honor it when they hold data in the
+	// / preferred encoding and send chunks in another encoding otherwise, so clients must decode any of them.
+	ChunkEncoding SeriesRequest_ChunkEncoding `protobuf:"varint,12,opt,name=chunk_encoding,json=chunkEncoding,proto3,enum=thanos.SeriesRequest_ChunkEncoding" json:"chunk_encoding,omitempty"`
+	// / accept_compressed_chunks tells that the client decompresses chunks with compression set. Stores may compress chunks only if it is set.
+	AcceptCompressedChunks bool     `protobuf:"varint,13,opt,name=accept_compressed_chunks,json=acceptCompressedChunks,proto3" json:"accept_compressed_chunks,omitempty"`
+	XXX_NoUnkeyedLiteral   struct{} `json:"-"`
+	XXX_unrecognized       []byte   `json:"-"`
+	XXX_sizecache          int32    `json:"-"`
 }
 
 func (m *SeriesRequest) Reset()         { *m = SeriesRequest{} }
 func (m *SeriesRequest) String() string { return proto.CompactTextString(m) }
 func (*SeriesRequest) ProtoMessage()    {}
 func (*SeriesRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_rpc_6ccafde20b200300, []int{2}
+	return fileDescriptor_rpc_6ccafde20b200300, []int{3}
 }
 func (m *SeriesRequest) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -185,10 +328,150 @@ func (m *SeriesRequest) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_SeriesRequest proto.InternalMessageInfo
 
+// / SeriesHints describe the PromQL query selecting the series.
+type SeriesHints struct {
+	// / start_time and end_time of the query evaluation including the lookback, in milliseconds.
+	StartTime int64 `protobuf:"varint,1,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	EndTime   int64 `protobuf:"varint,2,opt,name=end_time,json=endTime,proto3" json:"end_time,omitempty"`
+	// / step of the query evaluation in milliseconds. Zero for instant queries.
+	Step int64 `protobuf:"varint,3,opt,name=step,proto3" json:"step,omitempty"`
+	// / func is the name of the function wrapping the selector, if any.
+	Func string `protobuf:"bytes,4,opt,name=func,proto3" json:"func,omitempty"`
+	// / grouping holds labels of the aggregation wrapping the selector, if any.
+	Grouping []string `protobuf:"bytes,5,rep,name=grouping" json:"grouping,omitempty"`
+	// / by is true if the aggregation preserves the grouping labels and false if it removes them (without).
+	By                   bool     `protobuf:"varint,6,opt,name=by,proto3" json:"by,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SeriesHints) Reset()         { *m = SeriesHints{} }
+func (m *SeriesHints) String() string { return proto.CompactTextString(m) }
+func (*SeriesHints) ProtoMessage()    {}
+func (*SeriesHints) Descriptor() ([]byte, []int) {
+	return fileDescriptor_rpc_6ccafde20b200300, []int{4}
+}
+func (m *SeriesHints) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *SeriesHints) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_SeriesHints.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (dst *SeriesHints) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SeriesHints.Merge(dst, src)
+}
+func (m *SeriesHints) XXX_Size() int {
+	return m.Size()
+}
+func (m *SeriesHints) XXX_DiscardUnknown() {
+	xxx_messageInfo_SeriesHints.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SeriesHints proto.InternalMessageInfo
+
+// / SeriesShard selects series with hash of labels within [min_hash, max_hash], so a request can be split into shards
+// / covering all series without overlaps. Labels are hashed by FNV-1a of their names and values, each followed by 0xff.
+type SeriesShard struct {
+	MinHash              uint64   `protobuf:"varint,1,opt,name=min_hash,json=minHash,proto3" json:"min_hash,omitempty"`
+	MaxHash              uint64   `protobuf:"varint,2,opt,name=max_hash,json=maxHash,proto3" json:"max_hash,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SeriesShard) Reset()         { *m = SeriesShard{} }
+func (m *SeriesShard) String() string { return proto.CompactTextString(m) }
+func (*SeriesShard) ProtoMessage()    {}
+func (*SeriesShard) Descriptor() ([]byte, []int) {
+	return fileDescriptor_rpc_6ccafde20b200300, []int{5}
+}
+func (m *SeriesShard) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *SeriesShard) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_SeriesShard.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (dst *SeriesShard) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SeriesShard.Merge(dst, src)
+}
+func (m *SeriesShard) XXX_Size() int {
+	return m.Size()
+}
+func (m *SeriesShard) XXX_DiscardUnknown() {
+	xxx_messageInfo_SeriesShard.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SeriesShard proto.InternalMessageInfo
+
+// / KnownChunk identifies a raw chunk by its time range and series_ref, the storepb.LabelsHash of labels of its series,
+// / which both the client and the store compute. Refs of different series may collide, so clients compare labels of
+// / series before filling in data of omitted chunks.
+type KnownChunk struct {
+	SeriesRef            uint64   `protobuf:"varint,1,opt,name=series_ref,json=seriesRef,proto3" json:"series_ref,omitempty"`
+	MinTime              int64    `protobuf:"varint,2,opt,name=min_time,json=minTime,proto3" json:"min_time,omitempty"`
+	MaxTime              int64    `protobuf:"varint,3,opt,name=max_time,json=maxTime,proto3" json:"max_time,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *KnownChunk) Reset()         { *m = KnownChunk{} }
+func (m *KnownChunk) String() string { return proto.CompactTextString(m) }
+func (*KnownChunk) ProtoMessage()    {}
+func (*KnownChunk) Descriptor() ([]byte, []int) {
+	return fileDescriptor_rpc_6ccafde20b200300, []int{6}
+}
+func (m *KnownChunk) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *KnownChunk) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_KnownChunk.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (dst *KnownChunk) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_KnownChunk.Merge(dst, src)
+}
+func (m *KnownChunk) XXX_Size() int {
+	return m.Size()
+}
+func (m *KnownChunk) XXX_DiscardUnknown() {
+	xxx_messageInfo_KnownChunk.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_KnownChunk proto.InternalMessageInfo
+
 type SeriesResponse struct {
 	// Types that are valid to be assigned to Result:
 	//	*SeriesResponse_Series
 	//	*SeriesResponse_Warning
+	//	*SeriesResponse_QueriedBlocks
 	Result               isSeriesResponse_Result `protobuf_oneof:"result"`
 	XXX_NoUnkeyedLiteral struct{}                `json:"-"`
 	XXX_unrecognized     []byte                  `json:"-"`
@@ -199,7 +482,7 @@ func (m *SeriesResponse) Reset()         { *m = SeriesResponse{} }
 func (m *SeriesResponse) String() string { return proto.CompactTextString(m) }
 func (*SeriesResponse) ProtoMessage()    {}
 func (*SeriesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_rpc_6ccafde20b200300, []int{3}
+	return fileDescriptor_rpc_6ccafde20b200300, []int{7}
 }
 func (m *SeriesResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -240,9 +523,13 @@ type SeriesResponse_Series struct {
 type SeriesResponse_Warning struct {
 	Warning string `protobuf:"bytes,2,opt,name=warning,proto3,oneof"`
 }
+type SeriesResponse_QueriedBlocks struct {
+	QueriedBlocks *QueriedBlocks `protobuf:"bytes,3,opt,name=queried_blocks,json=queriedBlocks,oneof"`
+}
 
-func (*SeriesResponse_Series) isSeriesResponse_Result()  {}
-func (*SeriesResponse_Warning) isSeriesResponse_Result() {}
+func (*SeriesResponse_Series) isSeriesResponse_Result()        {}
+func (*SeriesResponse_Warning) isSeriesResponse_Result()       {}
+func (*SeriesResponse_QueriedBlocks) isSeriesResponse_Result() {}
 
 func (m *SeriesResponse) GetResult() isSeriesResponse_Result {
 	if m != nil {
@@ -265,11 +552,19 @@ func (m *SeriesResponse) GetWarning() string {
 	return ""
 }
 
+func (m *SeriesResponse) GetQueriedBlocks() *QueriedBlocks {
+	if x, ok := m.GetResult().(*SeriesResponse_QueriedBlocks); ok {
+		return x.QueriedBlocks
+	}
+	return nil
+}
+
 // XXX_OneofFuncs is for the internal use of the proto package.
 func (*SeriesResponse) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
 	return _SeriesResponse_OneofMarshaler, _SeriesResponse_OneofUnmarshaler, _SeriesResponse_OneofSizer, []interface{}{
 		(*SeriesResponse_Series)(nil),
 		(*SeriesResponse_Warning)(nil),
+		(*SeriesResponse_QueriedBlocks)(nil),
 	}
 }
 
@@ -285,6 +580,11 @@ func _SeriesResponse_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
 	case *SeriesResponse_Warning:
 		_ = b.EncodeVarint(2<<3 | proto.WireBytes)
 		_ = b.EncodeStringBytes(x.Warning)
+	case *SeriesResponse_QueriedBlocks:
+		_ = b.EncodeVarint(3<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.QueriedBlocks); err != nil {
+			return err
+		}
 	case nil:
 	default:
 		return fmt.Errorf("SeriesResponse.Result has unexpected type %T", x)
@@ -310,6 +610,14 @@ func _SeriesResponse_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto
 		x, err := b.DecodeStringBytes()
 		m.Result = &SeriesResponse_Warning{x}
 		return true, err
+	case 3: // result.queried_blocks
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(QueriedBlocks)
+		err := b.DecodeMessage(msg)
+		m.Result = &SeriesResponse_QueriedBlocks{msg}
+		return true, err
 	default:
 		return false, nil
 	}
@@ -328,6 +636,11 @@ func _SeriesResponse_OneofSizer(msg proto.Message) (n int) {
 		n += 1 // tag and wire
 		n += proto.SizeVarint(uint64(len(x.Warning)))
 		n += len(x.Warning)
+	case *SeriesResponse_QueriedBlocks:
+		s := proto.Size(x.QueriedBlocks)
+		n += 1 // tag and wire
+		n += proto.SizeVarint(uint64(s))
+		n += s
 	case nil:
 	default:
 		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
@@ -335,18 +648,65 @@ func _SeriesResponse_OneofSizer(msg proto.Message) (n int) {
 	return n
 }
 
+// / QueriedBlocks identifies blocks queried by a store.
+type QueriedBlocks struct {
+	// / store identifies the store that queried the blocks. It is set by the proxy forwarding the response.
+	Store string `protobuf:"bytes,1,opt,name=store,proto3" json:"store,omitempty"`
+	// / ids are ULIDs of the blocks.
+	Ids                  []string `protobuf:"bytes,2,rep,name=ids" json:"ids,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *QueriedBlocks) Reset()         { *m = QueriedBlocks{} }
+func (m *QueriedBlocks) String() string { return proto.CompactTextString(m) }
+func (*QueriedBlocks) ProtoMessage()    {}
+func (*QueriedBlocks) Descriptor() ([]byte, []int) {
+	return fileDescriptor_rpc_6ccafde20b200300, []int{8}
+}
+func (m *QueriedBlocks) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *QueriedBlocks) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_QueriedBlocks.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (dst *QueriedBlocks) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_QueriedBlocks.Merge(dst, src)
+}
+func (m *QueriedBlocks) XXX_Size() int {
+	return m.Size()
+}
+func (m *QueriedBlocks) XXX_DiscardUnknown() {
+	xxx_messageInfo_QueriedBlocks.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_QueriedBlocks proto.InternalMessageInfo
+
 type LabelNamesRequest struct {
-	PartialResponseDisabled bool     `protobuf:"varint,1,opt,name=partial_response_disabled,json=partialResponseDisabled,proto3" json:"partial_response_disabled,omitempty"`
-	XXX_NoUnkeyedLiteral    struct{} `json:"-"`
-	XXX_unrecognized        []byte   `json:"-"`
-	XXX_sizecache           int32    `json:"-"`
+	PartialResponseDisabled bool `protobuf:"varint,1,opt,name=partial_response_disabled,json=partialResponseDisabled,proto3" json:"partial_response_disabled,omitempty"`
+	// / limit is the maximum number of names returned. Only the first names in sorted order are kept and truncated is
+	// / set in the response if more names exist. Zero means no limit.
+	Limit                int64    `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *LabelNamesRequest) Reset()         { *m = LabelNamesRequest{} }
 func (m *LabelNamesRequest) String() string { return proto.CompactTextString(m) }
 func (*LabelNamesRequest) ProtoMessage()    {}
 func (*LabelNamesRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_rpc_6ccafde20b200300, []int{4}
+	return fileDescriptor_rpc_6ccafde20b200300, []int{9}
 }
 func (m *LabelNamesRequest) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -376,8 +736,10 @@ func (m *LabelNamesRequest) XXX_DiscardUnknown() {
 var xxx_messageInfo_LabelNamesRequest proto.InternalMessageInfo
 
 type LabelNamesResponse struct {
-	Names                []string `protobuf:"bytes,1,rep,name=names" json:"names,omitempty"`
-	Warnings             []string `protobuf:"bytes,2,rep,name=warnings" json:"warnings,omitempty"`
+	Names    []string `protobuf:"bytes,1,rep,name=names" json:"names,omitempty"`
+	Warnings []string `protobuf:"bytes,2,rep,name=warnings" json:"warnings,omitempty"`
+	// / truncated is true if names were dropped because of the limit of the request.
+	Truncated            bool     `protobuf:"varint,3,opt,name=truncated,proto3" json:"truncated,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -387,7 +749,7 @@ func (m *LabelNamesResponse) Reset()         { *m = LabelNamesResponse{} }
 func (m *LabelNamesResponse) String() string { return proto.CompactTextString(m) }
 func (*LabelNamesResponse) ProtoMessage()    {}
 func (*LabelNamesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_rpc_6ccafde20b200300, []int{5}
+	return fileDescriptor_rpc_6ccafde20b200300, []int{10}
 }
 func (m *LabelNamesResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -417,18 +779,21 @@ func (m *LabelNamesResponse) XXX_DiscardUnknown() {
 var xxx_messageInfo_LabelNamesResponse proto.InternalMessageInfo
 
 type LabelValuesRequest struct {
-	Label                   string   `protobuf:"bytes,1,opt,name=label,proto3" json:"label,omitempty"`
-	PartialResponseDisabled bool     `protobuf:"varint,2,opt,name=partial_response_disabled,json=partialResponseDisabled,proto3" json:"partial_response_disabled,omitempty"`
-	XXX_NoUnkeyedLiteral    struct{} `json:"-"`
-	XXX_unrecognized        []byte   `json:"-"`
-	XXX_sizecache           int32    `json:"-"`
+	Label                   string `protobuf:"bytes,1,opt,name=label,proto3" json:"label,omitempty"`
+	PartialResponseDisabled bool   `protobuf:"varint,2,opt,name=partial_response_disabled,json=partialResponseDisabled,proto3" json:"partial_response_disabled,omitempty"`
+	// / limit is the maximum number of values returned. Only the first values in sorted order are kept and truncated is
+	// / set in the response if more values exist. Zero means no limit.
+	Limit                int64    `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *LabelValuesRequest) Reset()         { *m = LabelValuesRequest{} }
 func (m *LabelValuesRequest) String() string { return proto.CompactTextString(m) }
 func (*LabelValuesRequest) ProtoMessage()    {}
 func (*LabelValuesRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_rpc_6ccafde20b200300, []int{6}
+	return fileDescriptor_rpc_6ccafde20b200300, []int{11}
 }
 func (m *LabelValuesRequest) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -458,8 +823,10 @@ func (m *LabelValuesRequest) XXX_DiscardUnknown() {
 var xxx_messageInfo_LabelValuesRequest proto.InternalMessageInfo
 
 type LabelValuesResponse struct {
-	Values               []string `protobuf:"bytes,1,rep,name=values" json:"values,omitempty"`
-	Warnings             []string `protobuf:"bytes,2,rep,name=warnings" json:"warnings,omitempty"`
+	Values   []string `protobuf:"bytes,1,rep,name=values" json:"values,omitempty"`
+	Warnings []string `protobuf:"bytes,2,rep,name=warnings" json:"warnings,omitempty"`
+	// / truncated is true if values were dropped because of the limit of the request.
+	Truncated            bool     `protobuf:"varint,3,opt,name=truncated,proto3" json:"truncated,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -469,7 +836,7 @@ func (m *LabelValuesResponse) Reset()         { *m = LabelValuesResponse{} }
 func (m *LabelValuesResponse) String() string { return proto.CompactTextString(m) }
 func (*LabelValuesResponse) ProtoMessage()    {}
 func (*LabelValuesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_rpc_6ccafde20b200300, []int{7}
+	return fileDescriptor_rpc_6ccafde20b200300, []int{12}
 }
 func (m *LabelValuesResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -501,13 +868,20 @@ var xxx_messageInfo_LabelValuesResponse proto.InternalMessageInfo
 func init() {
 	proto.RegisterType((*InfoRequest)(nil), "thanos.InfoRequest")
 	proto.RegisterType((*InfoResponse)(nil), "thanos.InfoResponse")
+	proto.RegisterType((*StoreStats)(nil), "thanos.StoreStats")
 	proto.RegisterType((*SeriesRequest)(nil), "thanos.SeriesRequest")
+	proto.RegisterType((*SeriesHints)(nil), "thanos.SeriesHints")
+	proto.RegisterType((*SeriesShard)(nil), "thanos.SeriesShard")
+	proto.RegisterType((*KnownChunk)(nil), "thanos.KnownChunk")
 	proto.RegisterType((*SeriesResponse)(nil), "thanos.SeriesResponse")
+	proto.RegisterType((*QueriedBlocks)(nil), "thanos.QueriedBlocks")
 	proto.RegisterType((*LabelNamesRequest)(nil), "thanos.LabelNamesRequest")
 	proto.RegisterType((*LabelNamesResponse)(nil), "thanos.LabelNamesResponse")
 	proto.RegisterType((*LabelValuesRequest)(nil), "thanos.LabelValuesRequest")
 	proto.RegisterType((*LabelValuesResponse)(nil), "thanos.LabelValuesResponse")
 	proto.RegisterEnum("thanos.Aggr", Aggr_name, Aggr_value)
+	proto.RegisterEnum("thanos.StoreType", StoreType_name, StoreType_value)
+	proto.RegisterEnum("thanos.SeriesRequest_ChunkEncoding", SeriesRequest_ChunkEncoding_name, SeriesRequest_ChunkEncoding_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -736,6 +1110,16 @@ func (m *InfoRequest) MarshalTo(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if m.ReportStats {
+		dAtA[i] = 0x8
+		i++
+		if m.ReportStats {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
@@ -779,6 +1163,82 @@ func (m *InfoResponse) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintRpc(dAtA, i, uint64(m.MaxTime))
 	}
+	if m.SeriesEstimate != 0 {
+		dAtA[i] = 0x20
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.SeriesEstimate))
+	}
+	if m.Stats != nil {
+		dAtA[i] = 0x2a
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Stats.Size()))
+		n3, err := m.Stats.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n3
+	}
+	if m.StoreType != 0 {
+		dAtA[i] = 0x30
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.StoreType))
+	}
+	if m.SupportsKnownChunks {
+		dAtA[i] = 0x38
+		i++
+		if m.SupportsKnownChunks {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *StoreStats) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *StoreStats) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.NumBlocks != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.NumBlocks))
+	}
+	if m.NumSeries != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.NumSeries))
+	}
+	if m.NumChunks != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.NumChunks))
+	}
+	if m.NumSamples != 0 {
+		dAtA[i] = 0x20
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.NumSamples))
+	}
+	if m.NumBytes != 0 {
+		dAtA[i] = 0x28
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.NumBytes))
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
@@ -854,20 +1314,221 @@ func (m *SeriesRequest) MarshalTo(dAtA []byte) (int, error) {
 		}
 		i++
 	}
-	if m.XXX_unrecognized != nil {
-		i += copy(dAtA[i:], m.XXX_unrecognized)
-	}
-	return i, nil
-}
-
-func (m *SeriesResponse) Marshal() (dAtA []byte, err error) {
-	size := m.Size()
-	dAtA = make([]byte, size)
-	n, err := m.MarshalTo(dAtA)
-	if err != nil {
-		return nil, err
+	if m.SkipChunks {
+		dAtA[i] = 0x38
+		i++
+		if m.SkipChunks {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
 	}
-	return dAtA[:n], nil
+	if m.Hints != nil {
+		dAtA[i] = 0x42
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Hints.Size()))
+		n4, err := m.Hints.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n4
+	}
+	if m.ReportQueriedBlocks {
+		dAtA[i] = 0x48
+		i++
+		if m.ReportQueriedBlocks {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.Shard != nil {
+		dAtA[i] = 0x52
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Shard.Size()))
+		n5, err := m.Shard.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n5
+	}
+	if len(m.KnownChunks) > 0 {
+		for _, msg := range m.KnownChunks {
+			dAtA[i] = 0x5a
+			i++
+			i = encodeVarintRpc(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if m.ChunkEncoding != 0 {
+		dAtA[i] = 0x60
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.ChunkEncoding))
+	}
+	if m.AcceptCompressedChunks {
+		dAtA[i] = 0x68
+		i++
+		if m.AcceptCompressedChunks {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *SeriesHints) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SeriesHints) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.StartTime != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.StartTime))
+	}
+	if m.EndTime != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.EndTime))
+	}
+	if m.Step != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Step))
+	}
+	if len(m.Func) > 0 {
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Func)))
+		i += copy(dAtA[i:], m.Func)
+	}
+	if len(m.Grouping) > 0 {
+		for _, s := range m.Grouping {
+			dAtA[i] = 0x2a
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	if m.By {
+		dAtA[i] = 0x30
+		i++
+		if m.By {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *SeriesShard) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SeriesShard) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.MinHash != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.MinHash))
+	}
+	if m.MaxHash != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.MaxHash))
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *KnownChunk) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *KnownChunk) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.SeriesRef != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.SeriesRef))
+	}
+	if m.MinTime != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.MinTime))
+	}
+	if m.MaxTime != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.MaxTime))
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *SeriesResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
 }
 
 func (m *SeriesResponse) MarshalTo(dAtA []byte) (int, error) {
@@ -876,11 +1537,11 @@ func (m *SeriesResponse) MarshalTo(dAtA []byte) (int, error) {
 	var l int
 	_ = l
 	if m.Result != nil {
-		nn3, err := m.Result.MarshalTo(dAtA[i:])
+		nn6, err := m.Result.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += nn3
+		i += nn6
 	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
@@ -894,11 +1555,11 @@ func (m *SeriesResponse_Series) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0xa
 		i++
 		i = encodeVarintRpc(dAtA, i, uint64(m.Series.Size()))
-		n4, err := m.Series.MarshalTo(dAtA[i:])
+		n7, err := m.Series.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += n4
+		i += n7
 	}
 	return i, nil
 }
@@ -910,6 +1571,62 @@ func (m *SeriesResponse_Warning) MarshalTo(dAtA []byte) (int, error) {
 	i += copy(dAtA[i:], m.Warning)
 	return i, nil
 }
+func (m *SeriesResponse_QueriedBlocks) MarshalTo(dAtA []byte) (int, error) {
+	i := 0
+	if m.QueriedBlocks != nil {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.QueriedBlocks.Size()))
+		n8, err := m.QueriedBlocks.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n8
+	}
+	return i, nil
+}
+func (m *QueriedBlocks) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *QueriedBlocks) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Store) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Store)))
+		i += copy(dAtA[i:], m.Store)
+	}
+	if len(m.Ids) > 0 {
+		for _, s := range m.Ids {
+			dAtA[i] = 0x12
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
 func (m *LabelNamesRequest) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -935,6 +1652,11 @@ func (m *LabelNamesRequest) MarshalTo(dAtA []byte) (int, error) {
 		}
 		i++
 	}
+	if m.Limit != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Limit))
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
@@ -986,6 +1708,16 @@ func (m *LabelNamesResponse) MarshalTo(dAtA []byte) (int, error) {
 			i += copy(dAtA[i:], s)
 		}
 	}
+	if m.Truncated {
+		dAtA[i] = 0x18
+		i++
+		if m.Truncated {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
@@ -1023,6 +1755,11 @@ func (m *LabelValuesRequest) MarshalTo(dAtA []byte) (int, error) {
 		}
 		i++
 	}
+	if m.Limit != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Limit))
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
@@ -1074,6 +1811,16 @@ func (m *LabelValuesResponse) MarshalTo(dAtA []byte) (int, error) {
 			i += copy(dAtA[i:], s)
 		}
 	}
+	if m.Truncated {
+		dAtA[i] = 0x18
+		i++
+		if m.Truncated {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
@@ -1092,6 +1839,9 @@ func encodeVarintRpc(dAtA []byte, offset int, v uint64) int {
 func (m *InfoRequest) Size() (n int) {
 	var l int
 	_ = l
+	if m.ReportStats {
+		n += 2
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1113,6 +1863,43 @@ func (m *InfoResponse) Size() (n int) {
 	if m.MaxTime != 0 {
 		n += 1 + sovRpc(uint64(m.MaxTime))
 	}
+	if m.SeriesEstimate != 0 {
+		n += 1 + sovRpc(uint64(m.SeriesEstimate))
+	}
+	if m.Stats != nil {
+		l = m.Stats.Size()
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if m.StoreType != 0 {
+		n += 1 + sovRpc(uint64(m.StoreType))
+	}
+	if m.SupportsKnownChunks {
+		n += 2
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *StoreStats) Size() (n int) {
+	var l int
+	_ = l
+	if m.NumBlocks != 0 {
+		n += 1 + sovRpc(uint64(m.NumBlocks))
+	}
+	if m.NumSeries != 0 {
+		n += 1 + sovRpc(uint64(m.NumSeries))
+	}
+	if m.NumChunks != 0 {
+		n += 1 + sovRpc(uint64(m.NumChunks))
+	}
+	if m.NumSamples != 0 {
+		n += 1 + sovRpc(uint64(m.NumSamples))
+	}
+	if m.NumBytes != 0 {
+		n += 1 + sovRpc(uint64(m.NumBytes))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1147,17 +1934,107 @@ func (m *SeriesRequest) Size() (n int) {
 	if m.PartialResponseDisabled {
 		n += 2
 	}
-	if m.XXX_unrecognized != nil {
-		n += len(m.XXX_unrecognized)
+	if m.SkipChunks {
+		n += 2
 	}
-	return n
-}
-
-func (m *SeriesResponse) Size() (n int) {
-	var l int
-	_ = l
-	if m.Result != nil {
-		n += m.Result.Size()
+	if m.Hints != nil {
+		l = m.Hints.Size()
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if m.ReportQueriedBlocks {
+		n += 2
+	}
+	if m.Shard != nil {
+		l = m.Shard.Size()
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if len(m.KnownChunks) > 0 {
+		for _, e := range m.KnownChunks {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if m.ChunkEncoding != 0 {
+		n += 1 + sovRpc(uint64(m.ChunkEncoding))
+	}
+	if m.AcceptCompressedChunks {
+		n += 2
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *SeriesHints) Size() (n int) {
+	var l int
+	_ = l
+	if m.StartTime != 0 {
+		n += 1 + sovRpc(uint64(m.StartTime))
+	}
+	if m.EndTime != 0 {
+		n += 1 + sovRpc(uint64(m.EndTime))
+	}
+	if m.Step != 0 {
+		n += 1 + sovRpc(uint64(m.Step))
+	}
+	l = len(m.Func)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if len(m.Grouping) > 0 {
+		for _, s := range m.Grouping {
+			l = len(s)
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if m.By {
+		n += 2
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *SeriesShard) Size() (n int) {
+	var l int
+	_ = l
+	if m.MinHash != 0 {
+		n += 1 + sovRpc(uint64(m.MinHash))
+	}
+	if m.MaxHash != 0 {
+		n += 1 + sovRpc(uint64(m.MaxHash))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *KnownChunk) Size() (n int) {
+	var l int
+	_ = l
+	if m.SeriesRef != 0 {
+		n += 1 + sovRpc(uint64(m.SeriesRef))
+	}
+	if m.MinTime != 0 {
+		n += 1 + sovRpc(uint64(m.MinTime))
+	}
+	if m.MaxTime != 0 {
+		n += 1 + sovRpc(uint64(m.MaxTime))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *SeriesResponse) Size() (n int) {
+	var l int
+	_ = l
+	if m.Result != nil {
+		n += m.Result.Size()
 	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
@@ -1181,12 +2058,43 @@ func (m *SeriesResponse_Warning) Size() (n int) {
 	n += 1 + l + sovRpc(uint64(l))
 	return n
 }
+func (m *SeriesResponse_QueriedBlocks) Size() (n int) {
+	var l int
+	_ = l
+	if m.QueriedBlocks != nil {
+		l = m.QueriedBlocks.Size()
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	return n
+}
+func (m *QueriedBlocks) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Store)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if len(m.Ids) > 0 {
+		for _, s := range m.Ids {
+			l = len(s)
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
 func (m *LabelNamesRequest) Size() (n int) {
 	var l int
 	_ = l
 	if m.PartialResponseDisabled {
 		n += 2
 	}
+	if m.Limit != 0 {
+		n += 1 + sovRpc(uint64(m.Limit))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1208,6 +2116,9 @@ func (m *LabelNamesResponse) Size() (n int) {
 			n += 1 + l + sovRpc(uint64(l))
 		}
 	}
+	if m.Truncated {
+		n += 2
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1224,6 +2135,9 @@ func (m *LabelValuesRequest) Size() (n int) {
 	if m.PartialResponseDisabled {
 		n += 2
 	}
+	if m.Limit != 0 {
+		n += 1 + sovRpc(uint64(m.Limit))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1245,6 +2159,9 @@ func (m *LabelValuesResponse) Size() (n int) {
 			n += 1 + l + sovRpc(uint64(l))
 		}
 	}
+	if m.Truncated {
+		n += 2
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1293,6 +2210,26 @@ func (m *InfoRequest) Unmarshal(dAtA []byte) error {
 			return fmt.Errorf("proto: InfoRequest: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ReportStats", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.ReportStats = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -1341,14 +2278,1043 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 			return fmt.Errorf("proto: InfoResponse: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: InfoResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: InfoResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Labels = append(m.Labels, Label{})
+			if err := m.Labels[len(m.Labels)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MinTime", wireType)
+			}
+			m.MinTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MinTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxTime", wireType)
+			}
+			m.MaxTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SeriesEstimate", wireType)
+			}
+			m.SeriesEstimate = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.SeriesEstimate |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Stats", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Stats == nil {
+				m.Stats = &StoreStats{}
+			}
+			if err := m.Stats.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StoreType", wireType)
+			}
+			m.StoreType = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.StoreType |= (StoreType(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SupportsKnownChunks", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.SupportsKnownChunks = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *StoreStats) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: StoreStats: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: StoreStats: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NumBlocks", wireType)
+			}
+			m.NumBlocks = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.NumBlocks |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NumSeries", wireType)
+			}
+			m.NumSeries = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.NumSeries |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NumChunks", wireType)
+			}
+			m.NumChunks = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.NumChunks |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NumSamples", wireType)
+			}
+			m.NumSamples = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.NumSamples |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NumBytes", wireType)
+			}
+			m.NumBytes = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.NumBytes |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SeriesRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SeriesRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MinTime", wireType)
+			}
+			m.MinTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MinTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxTime", wireType)
+			}
+			m.MaxTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Matchers", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Matchers = append(m.Matchers, LabelMatcher{})
+			if err := m.Matchers[len(m.Matchers)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxResolutionWindow", wireType)
+			}
+			m.MaxResolutionWindow = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxResolutionWindow |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType == 0 {
+				var v Aggr
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowRpc
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					v |= (Aggr(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				m.Aggregates = append(m.Aggregates, v)
+			} else if wireType == 2 {
+				var packedLen int
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowRpc
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					packedLen |= (int(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				if packedLen < 0 {
+					return ErrInvalidLengthRpc
+				}
+				postIndex := iNdEx + packedLen
+				if postIndex > l {
+					return io.ErrUnexpectedEOF
+				}
+				for iNdEx < postIndex {
+					var v Aggr
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowRpc
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						v |= (Aggr(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					m.Aggregates = append(m.Aggregates, v)
+				}
+			} else {
+				return fmt.Errorf("proto: wrong wireType = %d for field Aggregates", wireType)
+			}
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PartialResponseDisabled", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.PartialResponseDisabled = bool(v != 0)
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SkipChunks", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.SkipChunks = bool(v != 0)
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Hints", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Hints == nil {
+				m.Hints = &SeriesHints{}
+			}
+			if err := m.Hints.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ReportQueriedBlocks", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.ReportQueriedBlocks = bool(v != 0)
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Shard", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Shard == nil {
+				m.Shard = &SeriesShard{}
+			}
+			if err := m.Shard.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KnownChunks", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.KnownChunks = append(m.KnownChunks, KnownChunk{})
+			if err := m.KnownChunks[len(m.KnownChunks)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ChunkEncoding", wireType)
+			}
+			m.ChunkEncoding = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ChunkEncoding |= (SeriesRequest_ChunkEncoding(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 13:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field AcceptCompressedChunks", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.AcceptCompressedChunks = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *SeriesHints) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SeriesHints: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SeriesHints: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StartTime", wireType)
+			}
+			m.StartTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.StartTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field EndTime", wireType)
+			}
+			m.EndTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.EndTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Step", wireType)
+			}
+			m.Step = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Step |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Func", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Func = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Grouping", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Grouping = append(m.Grouping, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field By", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.By = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *SeriesShard) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SeriesShard: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SeriesShard: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MinHash", wireType)
+			}
+			m.MinHash = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MinHash |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxHash", wireType)
+			}
+			m.MaxHash = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxHash |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *KnownChunk) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: KnownChunk: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: KnownChunk: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SeriesRef", wireType)
 			}
-			var msglen int
+			m.SeriesRef = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1358,23 +3324,11 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= (int(b) & 0x7F) << shift
+				m.SeriesRef |= (uint64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
-				return ErrInvalidLengthRpc
-			}
-			postIndex := iNdEx + msglen
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.Labels = append(m.Labels, Label{})
-			if err := m.Labels[len(m.Labels)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
-			iNdEx = postIndex
 		case 2:
 			if wireType != 0 {
 				return fmt.Errorf("proto: wrong wireType = %d for field MinTime", wireType)
@@ -1435,7 +3389,7 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
+func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1458,17 +3412,17 @@ func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: SeriesRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: SeriesResponse: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: SeriesRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: SeriesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field MinTime", wireType)
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Series", wireType)
 			}
-			m.MinTime = 0
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1478,16 +3432,29 @@ func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.MinTime |= (int64(b) & 0x7F) << shift
+				msglen |= (int(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			v := &Series{}
+			if err := v.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			m.Result = &SeriesResponse_Series{v}
+			iNdEx = postIndex
 		case 2:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field MaxTime", wireType)
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Warning", wireType)
 			}
-			m.MaxTime = 0
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1497,14 +3464,24 @@ func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.MaxTime |= (int64(b) & 0x7F) << shift
+				stringLen |= (uint64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Result = &SeriesResponse_Warning{string(dAtA[iNdEx:postIndex])}
+			iNdEx = postIndex
 		case 3:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Matchers", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field QueriedBlocks", wireType)
 			}
 			var msglen int
 			for shift := uint(0); ; shift += 7 {
@@ -1528,112 +3505,12 @@ func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Matchers = append(m.Matchers, LabelMatcher{})
-			if err := m.Matchers[len(m.Matchers)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+			v := &QueriedBlocks{}
+			if err := v.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
 				return err
 			}
+			m.Result = &SeriesResponse_QueriedBlocks{v}
 			iNdEx = postIndex
-		case 4:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field MaxResolutionWindow", wireType)
-			}
-			m.MaxResolutionWindow = 0
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowRpc
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				m.MaxResolutionWindow |= (int64(b) & 0x7F) << shift
-				if b < 0x80 {
-					break
-				}
-			}
-		case 5:
-			if wireType == 0 {
-				var v Aggr
-				for shift := uint(0); ; shift += 7 {
-					if shift >= 64 {
-						return ErrIntOverflowRpc
-					}
-					if iNdEx >= l {
-						return io.ErrUnexpectedEOF
-					}
-					b := dAtA[iNdEx]
-					iNdEx++
-					v |= (Aggr(b) & 0x7F) << shift
-					if b < 0x80 {
-						break
-					}
-				}
-				m.Aggregates = append(m.Aggregates, v)
-			} else if wireType == 2 {
-				var packedLen int
-				for shift := uint(0); ; shift += 7 {
-					if shift >= 64 {
-						return ErrIntOverflowRpc
-					}
-					if iNdEx >= l {
-						return io.ErrUnexpectedEOF
-					}
-					b := dAtA[iNdEx]
-					iNdEx++
-					packedLen |= (int(b) & 0x7F) << shift
-					if b < 0x80 {
-						break
-					}
-				}
-				if packedLen < 0 {
-					return ErrInvalidLengthRpc
-				}
-				postIndex := iNdEx + packedLen
-				if postIndex > l {
-					return io.ErrUnexpectedEOF
-				}
-				for iNdEx < postIndex {
-					var v Aggr
-					for shift := uint(0); ; shift += 7 {
-						if shift >= 64 {
-							return ErrIntOverflowRpc
-						}
-						if iNdEx >= l {
-							return io.ErrUnexpectedEOF
-						}
-						b := dAtA[iNdEx]
-						iNdEx++
-						v |= (Aggr(b) & 0x7F) << shift
-						if b < 0x80 {
-							break
-						}
-					}
-					m.Aggregates = append(m.Aggregates, v)
-				}
-			} else {
-				return fmt.Errorf("proto: wrong wireType = %d for field Aggregates", wireType)
-			}
-		case 6:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field PartialResponseDisabled", wireType)
-			}
-			var v int
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowRpc
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				v |= (int(b) & 0x7F) << shift
-				if b < 0x80 {
-					break
-				}
-			}
-			m.PartialResponseDisabled = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -1656,7 +3533,7 @@ func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
+func (m *QueriedBlocks) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1679,17 +3556,17 @@ func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: SeriesResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: QueriedBlocks: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: SeriesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: QueriedBlocks: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Series", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Store", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1699,27 +3576,24 @@ func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= (int(b) & 0x7F) << shift
+				stringLen |= (uint64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthRpc
 			}
-			postIndex := iNdEx + msglen
+			postIndex := iNdEx + intStringLen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			v := &Series{}
-			if err := v.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
-			m.Result = &SeriesResponse_Series{v}
+			m.Store = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
 		case 2:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Warning", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Ids", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -1744,7 +3618,7 @@ func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Result = &SeriesResponse_Warning{string(dAtA[iNdEx:postIndex])}
+			m.Ids = append(m.Ids, string(dAtA[iNdEx:postIndex]))
 			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
@@ -1817,6 +3691,25 @@ func (m *LabelNamesRequest) Unmarshal(dAtA []byte) error {
 				}
 			}
 			m.PartialResponseDisabled = bool(v != 0)
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Limit", wireType)
+			}
+			m.Limit = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Limit |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -1926,6 +3819,26 @@ func (m *LabelNamesResponse) Unmarshal(dAtA []byte) error {
 			}
 			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
 			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Truncated", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Truncated = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -2026,6 +3939,25 @@ func (m *LabelValuesRequest) Unmarshal(dAtA []byte) error {
 				}
 			}
 			m.PartialResponseDisabled = bool(v != 0)
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Limit", wireType)
+			}
+			m.Limit = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Limit |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -2135,6 +4067,26 @@ func (m *LabelValuesResponse) Unmarshal(dAtA []byte) error {
 			}
 			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
 			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Truncated", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Truncated = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -2265,43 +4217,81 @@ var (
 func init() { proto.RegisterFile("rpc.proto", fileDescriptor_rpc_6ccafde20b200300) }
 
 var fileDescriptor_rpc_6ccafde20b200300 = []byte{
-	// 602 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x54, 0xcd, 0x6e, 0xd3, 0x40,
-	0x10, 0x8e, 0xed, 0xd8, 0x49, 0xc6, 0x6d, 0x65, 0xb6, 0x69, 0x71, 0x8c, 0x14, 0x22, 0x9f, 0x22,
-	0x40, 0x05, 0x82, 0x84, 0x04, 0xb7, 0xa6, 0x50, 0xb5, 0x12, 0x6d, 0xa5, 0x6d, 0x4b, 0x11, 0x97,
-	0xb2, 0x69, 0xb7, 0xae, 0x25, 0xff, 0xd5, 0xeb, 0xd0, 0x72, 0xe5, 0x35, 0x78, 0xa1, 0x1c, 0x79,
-	0x02, 0x04, 0x79, 0x12, 0xb4, 0x3f, 0x4e, 0x62, 0x54, 0x72, 0x9b, 0xf9, 0xbe, 0xf1, 0x37, 0x9f,
-	0x66, 0x66, 0x0d, 0xad, 0x3c, 0xbb, 0xd8, 0xca, 0xf2, 0xb4, 0x48, 0x91, 0x55, 0x5c, 0x93, 0x24,
-	0x65, 0x9e, 0x5d, 0x7c, 0xcb, 0x28, 0x93, 0xa0, 0xd7, 0x0e, 0xd2, 0x20, 0x15, 0xe1, 0x73, 0x1e,
-	0x49, 0xd4, 0x5f, 0x05, 0x7b, 0x3f, 0xb9, 0x4a, 0x31, 0xbd, 0x19, 0x53, 0x56, 0xf8, 0x37, 0xb0,
-	0x22, 0x53, 0x96, 0xa5, 0x09, 0xa3, 0xe8, 0x29, 0x58, 0x11, 0x19, 0xd1, 0x88, 0xb9, 0x5a, 0xcf,
-	0xe8, 0xdb, 0x83, 0xd5, 0x2d, 0x29, 0xbd, 0xf5, 0x81, 0xa3, 0xc3, 0xfa, 0xe4, 0xd7, 0xe3, 0x1a,
-	0x56, 0x25, 0xa8, 0x03, 0xcd, 0x38, 0x4c, 0xce, 0x8b, 0x30, 0xa6, 0xae, 0xde, 0xd3, 0xfa, 0x06,
-	0x6e, 0xc4, 0x61, 0x72, 0x12, 0xc6, 0x54, 0x50, 0xe4, 0x4e, 0x52, 0x86, 0xa2, 0xc8, 0x1d, 0xa7,
-	0xfc, 0x1f, 0x3a, 0xac, 0x1e, 0xd3, 0x3c, 0xa4, 0x4c, 0x99, 0xa8, 0xe8, 0x68, 0xff, 0xd7, 0xd1,
-	0x2b, 0x3a, 0xe8, 0x35, 0xa7, 0x8a, 0x8b, 0x6b, 0x9a, 0x33, 0xd7, 0x10, 0x66, 0xdb, 0x15, 0xb3,
-	0x07, 0x92, 0x54, 0x9e, 0x67, 0xb5, 0x68, 0x00, 0x1b, 0x5c, 0x32, 0xa7, 0x2c, 0x8d, 0xc6, 0x45,
-	0x98, 0x26, 0xe7, 0xb7, 0x61, 0x72, 0x99, 0xde, 0xba, 0x75, 0xa1, 0xbf, 0x1e, 0x93, 0x3b, 0x3c,
-	0xe3, 0xce, 0x04, 0x85, 0x9e, 0x01, 0x90, 0x20, 0xc8, 0x69, 0x40, 0x0a, 0xca, 0x5c, 0xb3, 0x67,
-	0xf4, 0xd7, 0x06, 0x2b, 0x65, 0xb7, 0xed, 0x20, 0xc8, 0xf1, 0x02, 0x8f, 0xde, 0x42, 0x27, 0x23,
-	0x79, 0x11, 0x92, 0x88, 0x77, 0x11, 0x83, 0x3d, 0xbf, 0x0c, 0x19, 0x19, 0x45, 0xf4, 0xd2, 0xb5,
-	0x7a, 0x5a, 0xbf, 0x89, 0x1f, 0xaa, 0x82, 0x72, 0xf0, 0xef, 0x14, 0xed, 0x7f, 0x81, 0xb5, 0x72,
-	0x38, 0x6a, 0x25, 0x7d, 0xb0, 0x98, 0x40, 0xc4, 0x6c, 0xec, 0xc1, 0x5a, 0xd9, 0x57, 0xd6, 0xed,
-	0xd5, 0xb0, 0xe2, 0x91, 0x07, 0x8d, 0x5b, 0x92, 0x27, 0x61, 0x12, 0x88, 0x59, 0xb5, 0xf6, 0x6a,
-	0xb8, 0x04, 0x86, 0x4d, 0xb0, 0x72, 0xca, 0xc6, 0x51, 0xe1, 0x1f, 0xc1, 0x03, 0x31, 0x9f, 0x43,
-	0x12, 0xcf, 0x57, 0xb0, 0xd4, 0xb2, 0xb6, 0xdc, 0xf2, 0x2e, 0xa0, 0x45, 0x41, 0x65, 0xbb, 0x0d,
-	0x66, 0xc2, 0x01, 0x71, 0x48, 0x2d, 0x2c, 0x13, 0xe4, 0x41, 0x53, 0x39, 0x62, 0xae, 0x2e, 0x88,
-	0x59, 0xee, 0x5f, 0x29, 0x9d, 0x8f, 0x24, 0x1a, 0xcf, 0x9d, 0xb5, 0xc1, 0x14, 0xe7, 0x26, 0x5c,
-	0xb4, 0xb0, 0x4c, 0x96, 0xfb, 0xd5, 0x97, 0xfb, 0xdd, 0x87, 0xf5, 0x4a, 0x1f, 0x65, 0x78, 0x13,
-	0xac, 0xaf, 0x02, 0x51, 0x8e, 0x55, 0xb6, 0xcc, 0xf2, 0x93, 0x21, 0xd4, 0xf9, 0xf6, 0x51, 0x03,
-	0x0c, 0xbc, 0x7d, 0xe6, 0xd4, 0x50, 0x0b, 0xcc, 0x9d, 0xa3, 0xd3, 0xc3, 0x13, 0x47, 0xe3, 0xd8,
-	0xf1, 0xe9, 0x81, 0xa3, 0xf3, 0xe0, 0x60, 0xff, 0xd0, 0x31, 0x44, 0xb0, 0xfd, 0xc9, 0xa9, 0x23,
-	0x1b, 0x1a, 0xa2, 0xea, 0x3d, 0x76, 0xcc, 0xc1, 0x77, 0x1d, 0xcc, 0xe3, 0x22, 0xcd, 0x29, 0x7a,
-	0x09, 0x75, 0xfe, 0x18, 0xd1, 0x7a, 0xb9, 0xe1, 0x85, 0x97, 0xea, 0xb5, 0xab, 0xa0, 0x32, 0xfd,
-	0x06, 0x2c, 0x79, 0x06, 0x68, 0xa3, 0x7a, 0x16, 0xe5, 0x67, 0x9b, 0xff, 0xc2, 0xf2, 0xc3, 0x17,
-	0x1a, 0xda, 0x01, 0x98, 0xaf, 0x0d, 0x75, 0x2a, 0x6f, 0x67, 0xf1, 0x36, 0x3c, 0xef, 0x3e, 0x4a,
-	0xf5, 0xdf, 0x05, 0x7b, 0x61, 0x96, 0xa8, 0x5a, 0x5a, 0x59, 0xa4, 0xf7, 0xe8, 0x5e, 0x4e, 0xea,
-	0x0c, 0x3b, 0x93, 0x3f, 0xdd, 0xda, 0x64, 0xda, 0xd5, 0x7e, 0x4e, 0xbb, 0xda, 0xef, 0x69, 0x57,
-	0xfb, 0xdc, 0x60, 0x7c, 0x26, 0xd9, 0x68, 0x64, 0x89, 0x1f, 0xd7, 0xab, 0xbf, 0x01, 0x00, 0x00,
-	0xff, 0xff, 0x60, 0xf8, 0xf1, 0xea, 0xf0, 0x04, 0x00, 0x00,
+	// 1201 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x56, 0xdd, 0x6e, 0x1b, 0x45,
+	0x14, 0xf6, 0xee, 0xda, 0x8e, 0xf7, 0x6c, 0x6c, 0xb6, 0x93, 0xb4, 0x38, 0x2e, 0xa4, 0x61, 0xb9,
+	0xc0, 0x2d, 0x28, 0xb4, 0x41, 0xe2, 0x57, 0x42, 0x72, 0x52, 0xd3, 0x94, 0xb6, 0xae, 0x3a, 0x4e,
+	0x28, 0xe5, 0x66, 0x35, 0xf6, 0x4e, 0xec, 0x55, 0xbc, 0x3f, 0xdd, 0x99, 0x25, 0x89, 0xc4, 0x15,
+	0xaf, 0xc1, 0x0d, 0x77, 0x48, 0x3c, 0x03, 0x0f, 0xd0, 0x4b, 0x9e, 0x00, 0x41, 0x9f, 0x04, 0xcd,
+	0xcf, 0xda, 0xbb, 0x55, 0xa8, 0x50, 0xef, 0x66, 0xbe, 0xef, 0xcc, 0x9c, 0x39, 0xe7, 0x7c, 0xe7,
+	0xec, 0x82, 0x9d, 0xa5, 0xd3, 0xdd, 0x34, 0x4b, 0x78, 0x82, 0x9a, 0x7c, 0x4e, 0xe2, 0x84, 0xf5,
+	0x1c, 0x7e, 0x91, 0x52, 0xa6, 0xc0, 0xde, 0xe6, 0x2c, 0x99, 0x25, 0x72, 0xf9, 0xb1, 0x58, 0x29,
+	0xd4, 0xbb, 0x0d, 0xce, 0xfd, 0xf8, 0x24, 0xc1, 0xf4, 0x79, 0x4e, 0x19, 0x47, 0xef, 0xc1, 0x7a,
+	0x46, 0xd3, 0x24, 0xe3, 0x3e, 0xe3, 0x84, 0xb3, 0xae, 0xb1, 0x63, 0xf4, 0x5b, 0xd8, 0x51, 0xd8,
+	0x58, 0x40, 0xde, 0xef, 0x26, 0xac, 0xab, 0x23, 0x2c, 0x4d, 0x62, 0x46, 0xd1, 0x87, 0xd0, 0x5c,
+	0x90, 0x09, 0x5d, 0x08, 0x6b, 0xab, 0xef, 0xec, 0xb5, 0x77, 0x95, 0xfb, 0xdd, 0x87, 0x02, 0xdd,
+	0xaf, 0xbf, 0xf8, 0xeb, 0x46, 0x0d, 0x6b, 0x13, 0xb4, 0x05, 0xad, 0x28, 0x8c, 0x7d, 0x1e, 0x46,
+	0xb4, 0x6b, 0xee, 0x18, 0x7d, 0x0b, 0xaf, 0x45, 0x61, 0x7c, 0x14, 0x46, 0x54, 0x52, 0xe4, 0x5c,
+	0x51, 0x96, 0xa6, 0xc8, 0xb9, 0xa4, 0x3e, 0x80, 0xb7, 0x18, 0xcd, 0x42, 0xca, 0x7c, 0xca, 0x78,
+	0x18, 0x11, 0x4e, 0xbb, 0x75, 0x69, 0xd1, 0x51, 0xf0, 0x50, 0xa3, 0xa8, 0x0f, 0x0d, 0xf5, 0xf0,
+	0xc6, 0x8e, 0xd1, 0x77, 0xf6, 0x50, 0xf1, 0x94, 0x31, 0x4f, 0x32, 0x2a, 0xdf, 0x8f, 0x95, 0x01,
+	0xba, 0x0d, 0xc0, 0x04, 0xe8, 0x8b, 0x1c, 0x75, 0x9b, 0x3b, 0x46, 0xbf, 0xb3, 0x77, 0xa5, 0x62,
+	0x7e, 0x74, 0x91, 0x52, 0x6c, 0xb3, 0x62, 0x89, 0xf6, 0xe0, 0x2a, 0xcb, 0x53, 0x91, 0x08, 0xe6,
+	0x9f, 0xc6, 0xc9, 0x59, 0xec, 0x4f, 0xe7, 0x79, 0x7c, 0xca, 0xba, 0x6b, 0x32, 0x49, 0x1b, 0x05,
+	0xf9, 0x40, 0x70, 0x07, 0x92, 0xf2, 0x7e, 0x33, 0x00, 0x56, 0xbe, 0xd1, 0xbb, 0x00, 0x71, 0x1e,
+	0xf9, 0x93, 0x45, 0x32, 0x3d, 0x55, 0xc9, 0xb5, 0xb0, 0x1d, 0xe7, 0xd1, 0xbe, 0x04, 0x0a, 0x5a,
+	0xc5, 0xd4, 0x35, 0x97, 0xf4, 0x58, 0x02, 0x05, 0xad, 0xbd, 0x5a, 0x4b, 0x5a, 0xf9, 0x42, 0x37,
+	0xc0, 0x91, 0xa7, 0x49, 0x94, 0x2e, 0x28, 0xd3, 0x09, 0x12, 0x27, 0xc6, 0x0a, 0x41, 0xd7, 0xc1,
+	0x96, 0xde, 0x2f, 0x38, 0x55, 0x09, 0xb2, 0x70, 0x4b, 0x38, 0x17, 0x7b, 0xef, 0x8f, 0x06, 0xb4,
+	0x95, 0x9f, 0x42, 0x0b, 0xe5, 0x52, 0x19, 0xff, 0x5d, 0x2a, 0xb3, 0x5a, 0xaa, 0x4f, 0x05, 0xc5,
+	0xa7, 0x73, 0x9a, 0x89, 0x27, 0x0a, 0x3d, 0x6c, 0x56, 0xf4, 0xf0, 0x48, 0x91, 0x5a, 0x16, 0x4b,
+	0x5b, 0x91, 0x5d, 0x71, 0x65, 0x46, 0x59, 0xb2, 0xc8, 0x79, 0x98, 0xc4, 0xfe, 0x59, 0x18, 0x07,
+	0xc9, 0x99, 0x8e, 0x63, 0x23, 0x22, 0xe7, 0x78, 0xc9, 0x3d, 0x95, 0x14, 0xfa, 0x08, 0x80, 0xcc,
+	0x66, 0x19, 0x9d, 0x11, 0x15, 0x91, 0xd5, 0xef, 0xec, 0xad, 0x17, 0xde, 0x06, 0xb3, 0x59, 0x86,
+	0x4b, 0x3c, 0xfa, 0x12, 0xb6, 0x52, 0x92, 0xf1, 0x90, 0x2c, 0xfc, 0x4c, 0x6b, 0xd7, 0x0f, 0x42,
+	0x46, 0x26, 0x0b, 0x1a, 0x48, 0x01, 0xb4, 0xf0, 0xdb, 0xda, 0xa0, 0xd0, 0xf6, 0x5d, 0x4d, 0x8b,
+	0xdc, 0xb2, 0xd3, 0x30, 0xad, 0x56, 0x1c, 0x04, 0xa4, 0x93, 0x7f, 0x13, 0x1a, 0xf3, 0x30, 0xe6,
+	0xac, 0xdb, 0x92, 0xc2, 0xdb, 0x58, 0x2a, 0x49, 0xa6, 0xf4, 0x50, 0x50, 0x58, 0x59, 0x88, 0x48,
+	0x75, 0x8f, 0x3d, 0xcf, 0x05, 0x1b, 0x14, 0x7a, 0xb0, 0x95, 0x8e, 0x14, 0xf9, 0x44, 0x71, 0x5a,
+	0x19, 0x37, 0xa1, 0xc1, 0xe6, 0x24, 0x0b, 0xba, 0x70, 0xd9, 0xf5, 0x63, 0x41, 0x61, 0x65, 0x81,
+	0xbe, 0x82, 0xf5, 0x8a, 0x3a, 0x9d, 0x1d, 0xab, 0xdc, 0x09, 0x2b, 0x75, 0xea, 0x12, 0x38, 0xa7,
+	0x4b, 0x84, 0xa1, 0x6f, 0xa1, 0x23, 0x8f, 0xf9, 0x34, 0x9e, 0x26, 0x41, 0x18, 0xcf, 0xba, 0xeb,
+	0xb2, 0x33, 0xde, 0xaf, 0x3a, 0xd4, 0x12, 0xd9, 0x95, 0xa7, 0x86, 0xda, 0x14, 0xb7, 0xa7, 0xe5,
+	0x2d, 0xfa, 0x1c, 0xba, 0x64, 0x3a, 0xa5, 0x29, 0xf7, 0xa7, 0x49, 0x94, 0x66, 0x94, 0x31, 0x1a,
+	0x14, 0x8f, 0x6a, 0xcb, 0x50, 0xaf, 0x29, 0xfe, 0x60, 0x49, 0xeb, 0xae, 0xb9, 0x03, 0xed, 0xca,
+	0xcd, 0x68, 0x0d, 0xac, 0xc1, 0xe8, 0x99, 0x5b, 0x13, 0x0b, 0x3c, 0x78, 0xea, 0x1a, 0xa8, 0x03,
+	0x30, 0xb8, 0x77, 0x0f, 0x0f, 0xef, 0x0d, 0x8e, 0x86, 0x77, 0x5d, 0xd3, 0xfb, 0xc5, 0x00, 0xa7,
+	0x94, 0x6b, 0xd1, 0x2b, 0x8c, 0x93, 0x8c, 0x97, 0xe5, 0x6b, 0x4b, 0xa4, 0x10, 0x30, 0x8d, 0x83,
+	0x8a, 0x80, 0x69, 0x1c, 0x48, 0x0a, 0x41, 0x9d, 0x71, 0x9a, 0xea, 0xfe, 0x92, 0x6b, 0x81, 0x9d,
+	0xe4, 0xf1, 0x54, 0x6a, 0xd1, 0xc6, 0x72, 0x8d, 0x7a, 0xd0, 0x9a, 0x65, 0x49, 0x9e, 0x8a, 0x24,
+	0x09, 0xe9, 0xd9, 0x78, 0xb9, 0x47, 0x1d, 0x30, 0x27, 0x17, 0x5a, 0x53, 0xe6, 0xe4, 0xc2, 0x3b,
+	0x00, 0xa7, 0x54, 0xa9, 0xa2, 0xb3, 0xe6, 0x84, 0xcd, 0xe5, 0xd3, 0xea, 0xb2, 0xb3, 0x0e, 0x09,
+	0x9b, 0x17, 0x9d, 0x25, 0x29, 0x53, 0x53, 0xe4, 0x5c, 0x50, 0x1e, 0x01, 0x58, 0x15, 0x4f, 0x06,
+	0xa8, 0x46, 0x62, 0x46, 0x4f, 0xf4, 0x2d, 0x36, 0xd3, 0xd5, 0x39, 0x79, 0xb3, 0x39, 0xeb, 0xfd,
+	0x6a, 0x40, 0xa7, 0xa8, 0xb0, 0x9e, 0xee, 0x7d, 0x68, 0xea, 0x79, 0x64, 0x48, 0xe9, 0x75, 0x5e,
+	0x51, 0x76, 0x0d, 0x6b, 0x1e, 0xf5, 0x60, 0xed, 0x8c, 0x64, 0xb1, 0xc8, 0x87, 0xf0, 0x68, 0x1f,
+	0xd6, 0x70, 0x01, 0xa0, 0xaf, 0xa1, 0xf3, 0x8a, 0xd8, 0x2d, 0x79, 0xdb, 0xd5, 0xe2, 0xb6, 0x8a,
+	0xdc, 0x0f, 0x6b, 0xb8, 0xfd, 0xbc, 0x0c, 0xec, 0xb7, 0xa0, 0x99, 0x51, 0x96, 0x2f, 0xb8, 0xf7,
+	0x19, 0xb4, 0xab, 0xad, 0xb1, 0x29, 0x46, 0x7e, 0x92, 0xa9, 0x22, 0xdb, 0x58, 0x6d, 0x90, 0x0b,
+	0x56, 0x18, 0x88, 0x19, 0x2a, 0x0a, 0x23, 0x96, 0x1e, 0x85, 0x2b, 0x72, 0x00, 0x8d, 0x48, 0xb4,
+	0x9a, 0x71, 0xaf, 0x9d, 0x09, 0xc6, 0xeb, 0x67, 0xc2, 0x26, 0x34, 0x16, 0x61, 0x14, 0x72, 0x9d,
+	0x5f, 0xb5, 0xf1, 0x02, 0x40, 0x65, 0x37, 0x3a, 0x8b, 0x9b, 0xd0, 0x88, 0x05, 0x20, 0x3f, 0x91,
+	0x36, 0x56, 0x1b, 0x21, 0x21, 0x9d, 0xa0, 0xe2, 0xa5, 0xcb, 0x3d, 0x7a, 0x07, 0x6c, 0x9e, 0xe5,
+	0xf1, 0x94, 0x70, 0x1a, 0xc8, 0x64, 0xb5, 0xf0, 0x0a, 0xf0, 0x7e, 0xd2, 0x5e, 0xbe, 0x23, 0x8b,
+	0x7c, 0x15, 0x8d, 0x78, 0x91, 0x40, 0x8b, 0x54, 0xc8, 0xcd, 0xeb, 0x63, 0x34, 0xff, 0x67, 0x8c,
+	0x56, 0x39, 0xc6, 0x19, 0x6c, 0x54, 0xbc, 0xeb, 0x20, 0xaf, 0x41, 0xf3, 0x47, 0x89, 0xe8, 0x28,
+	0xf5, 0xee, 0xcd, 0xc3, 0xbc, 0xb5, 0x0f, 0x75, 0x31, 0xc6, 0x8b, 0xb6, 0xaf, 0x21, 0x1b, 0x1a,
+	0x07, 0x8f, 0x8f, 0x47, 0x47, 0xae, 0x21, 0xb0, 0xf1, 0xf1, 0x23, 0xd7, 0x14, 0x8b, 0x47, 0xf7,
+	0x47, 0xae, 0x25, 0x17, 0x83, 0xef, 0xdd, 0x3a, 0x72, 0x60, 0x4d, 0x5a, 0x0d, 0xb1, 0xdb, 0xb8,
+	0x35, 0x04, 0x7b, 0xf9, 0x39, 0x17, 0xcc, 0xf1, 0xe8, 0xc1, 0xe8, 0xf1, 0xd3, 0x91, 0xba, 0xec,
+	0xc9, 0xf1, 0x10, 0x3f, 0x73, 0x0d, 0xd4, 0x82, 0x3a, 0x3e, 0x7e, 0x38, 0x74, 0x4d, 0x61, 0x31,
+	0xbe, 0x7f, 0x77, 0x78, 0x30, 0xc0, 0xae, 0x25, 0x2c, 0xc6, 0x47, 0x8f, 0xf1, 0xd0, 0xad, 0xef,
+	0xfd, 0x6c, 0x42, 0x43, 0xde, 0x83, 0xee, 0x40, 0x5d, 0xfc, 0xff, 0xa0, 0xe5, 0x10, 0x2e, 0xfd,
+	0x40, 0xf5, 0x36, 0xab, 0xa0, 0xce, 0xcc, 0x17, 0xd0, 0xd4, 0xdf, 0xf0, 0xab, 0x97, 0x0e, 0xd2,
+	0xde, 0xb5, 0x57, 0x61, 0x75, 0xf0, 0xb6, 0x81, 0x0e, 0x00, 0x56, 0x7a, 0x42, 0x5b, 0x95, 0x6f,
+	0x69, 0x59, 0xca, 0xbd, 0xde, 0x65, 0x94, 0xf6, 0xff, 0x0d, 0x38, 0xa5, 0x82, 0xa1, 0xaa, 0x69,
+	0x45, 0x43, 0xbd, 0xeb, 0x97, 0x72, 0xea, 0x9e, 0xfd, 0xad, 0x17, 0xff, 0x6c, 0xd7, 0x5e, 0xbc,
+	0xdc, 0x36, 0xfe, 0x7c, 0xb9, 0x6d, 0xfc, 0xfd, 0x72, 0xdb, 0xf8, 0x61, 0x4d, 0xb6, 0x5b, 0x3a,
+	0x99, 0x34, 0xe5, 0xff, 0xe4, 0x27, 0xff, 0x0e, 0x00, 0x33, 0xb9, 0xa3, 0xd3, 0x87, 0x0a, 0x00,
+	0x00,
 }
@@ -24,14 +24,20 @@ const _ = proto.GoGoProtoPackageIsVersion2 // please upgrade the proto package
 type Chunk_Encoding int32
 
 const (
-	Chunk_XOR Chunk_Encoding = 0
+	Chunk_XOR          Chunk_Encoding = 0
+	Chunk_DELTA        Chunk_Encoding = 1
+	Chunk_DOUBLE_DELTA Chunk_Encoding = 2
 )
 
 var Chunk_Encoding_name = map[int32]string{
 	0: "XOR",
+	1: "DELTA",
+	2: "DOUBLE_DELTA",
 }
 var Chunk_Encoding_value = map[string]int32{
-	"XOR": 0,
+	"XOR":          0,
+	"DELTA":        1,
+	"DOUBLE_DELTA": 2,
 }
 
 func (x Chunk_Encoding) String() string {
@@ -41,13 +47,39 @@ func (Chunk_Encoding) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor_types_60e135d4a4f03620, []int{1, 0}
 }
 
+// / Compression of data, applied on top of the encoding, e.g. by stores keeping cold chunks compressed.
+// / Stores may compress chunks only for Series requests with accept_compressed_chunks set.
+type Chunk_Compression int32
+
+const (
+	Chunk_NONE Chunk_Compression = 0
+	Chunk_GZIP Chunk_Compression = 1
+)
+
+var Chunk_Compression_name = map[int32]string{
+	0: "NONE",
+	1: "GZIP",
+}
+var Chunk_Compression_value = map[string]int32{
+	"NONE": 0,
+	"GZIP": 1,
+}
+
+func (x Chunk_Compression) String() string {
+	return proto.EnumName(Chunk_Compression_name, int32(x))
+}
+func (Chunk_Compression) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_types_60e135d4a4f03620, []int{1, 1}
+}
+
 type LabelMatcher_Type int32
 
 const (
-	LabelMatcher_EQ  LabelMatcher_Type = 0
-	LabelMatcher_NEQ LabelMatcher_Type = 1
-	LabelMatcher_RE  LabelMatcher_Type = 2
-	LabelMatcher_NRE LabelMatcher_Type = 3
+	LabelMatcher_EQ    LabelMatcher_Type = 0
+	LabelMatcher_NEQ   LabelMatcher_Type = 1
+	LabelMatcher_RE    LabelMatcher_Type = 2
+	LabelMatcher_NRE   LabelMatcher_Type = 3
+	LabelMatcher_EQ_CI LabelMatcher_Type = 4
 )
 
 var LabelMatcher_Type_name = map[int32]string{
@@ -55,12 +87,14 @@ var LabelMatcher_Type_name = map[int32]string{
 	1: "NEQ",
 	2: "RE",
 	3: "NRE",
+	4: "EQ_CI",
 }
 var LabelMatcher_Type_value = map[string]int32{
-	"EQ":  0,
-	"NEQ": 1,
-	"RE":  2,
-	"NRE": 3,
+	"EQ":    0,
+	"NEQ":   1,
+	"RE":    2,
+	"NRE":   3,
+	"EQ_CI": 4,
 }
 
 func (x LabelMatcher_Type) String() string {
@@ -112,11 +146,12 @@ func (m *Label) XXX_DiscardUnknown() {
 var xxx_messageInfo_Label proto.InternalMessageInfo
 
 type Chunk struct {
-	Type                 Chunk_Encoding `protobuf:"varint,1,opt,name=type,proto3,enum=thanos.Chunk_Encoding" json:"type,omitempty"`
-	Data                 []byte         `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
-	XXX_unrecognized     []byte         `json:"-"`
-	XXX_sizecache        int32          `json:"-"`
+	Type                 Chunk_Encoding    `protobuf:"varint,1,opt,name=type,proto3,enum=thanos.Chunk_Encoding" json:"type,omitempty"`
+	Data                 []byte            `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Compression          Chunk_Compression `protobuf:"varint,3,opt,name=compression,proto3,enum=thanos.Chunk_Compression" json:"compression,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
 func (m *Chunk) Reset()         { *m = Chunk{} }
@@ -290,6 +325,7 @@ func init() {
 	proto.RegisterType((*AggrChunk)(nil), "thanos.AggrChunk")
 	proto.RegisterType((*LabelMatcher)(nil), "thanos.LabelMatcher")
 	proto.RegisterEnum("thanos.Chunk_Encoding", Chunk_Encoding_name, Chunk_Encoding_value)
+	proto.RegisterEnum("thanos.Chunk_Compression", Chunk_Compression_name, Chunk_Compression_value)
 	proto.RegisterEnum("thanos.LabelMatcher_Type", LabelMatcher_Type_name, LabelMatcher_Type_value)
 }
 func (m *Label) Marshal() (dAtA []byte, err error) {
@@ -351,6 +387,11 @@ func (m *Chunk) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintTypes(dAtA, i, uint64(len(m.Data)))
 		i += copy(dAtA[i:], m.Data)
 	}
+	if m.Compression != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintTypes(dAtA, i, uint64(m.Compression))
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
@@ -567,6 +608,9 @@ func (m *Chunk) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovTypes(uint64(l))
 	}
+	if m.Compression != 0 {
+		n += 1 + sovTypes(uint64(m.Compression))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -854,6 +898,25 @@ func (m *Chunk) Unmarshal(dAtA []byte) error {
 				m.Data = []byte{}
 			}
 			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Compression", wireType)
+			}
+			m.Compression = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTypes
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Compression |= (Chunk_Compression(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipTypes(dAtA[iNdEx:])
@@ -1512,32 +1575,37 @@ var (
 func init() { proto.RegisterFile("types.proto", fileDescriptor_types_60e135d4a4f03620) }
 
 var fileDescriptor_types_60e135d4a4f03620 = []byte{
-	// 432 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x92, 0xdd, 0x6e, 0xd3, 0x30,
-	0x14, 0xc7, 0xeb, 0x7c, 0x76, 0x67, 0x03, 0x05, 0x33, 0x21, 0x97, 0x8b, 0xae, 0x0a, 0x17, 0x54,
-	0x20, 0x32, 0x31, 0x9e, 0x80, 0xa1, 0xdc, 0xf1, 0xa1, 0x99, 0x5d, 0x20, 0x84, 0x84, 0xdc, 0xce,
-	0xa4, 0x11, 0x8d, 0x5d, 0xc5, 0x0e, 0x74, 0x8f, 0x81, 0x78, 0xa9, 0x5e, 0xf2, 0x04, 0x08, 0xfa,
-	0x24, 0xc8, 0x27, 0x0d, 0x5b, 0xa5, 0xdc, 0x1d, 0x9f, 0xff, 0xef, 0x7c, 0xc8, 0xe7, 0x0f, 0x87,
-	0xf6, 0x7a, 0x25, 0x4d, 0xb6, 0xaa, 0xb5, 0xd5, 0x34, 0xb2, 0x0b, 0xa1, 0xb4, 0x79, 0x78, 0x5c,
-	0xe8, 0x42, 0x63, 0xea, 0xd4, 0x45, 0xad, 0x9a, 0x3e, 0x87, 0xf0, 0xb5, 0x98, 0xc9, 0x25, 0xa5,
-	0x10, 0x28, 0x51, 0x49, 0x46, 0x26, 0x64, 0x7a, 0xc0, 0x31, 0xa6, 0xc7, 0x10, 0x7e, 0x13, 0xcb,
-	0x46, 0x32, 0x0f, 0x93, 0xed, 0x23, 0xfd, 0x04, 0xe1, 0xab, 0x45, 0xa3, 0xbe, 0xd2, 0x27, 0x10,
-	0xb8, 0x41, 0x58, 0x72, 0xf7, 0xec, 0x41, 0xd6, 0x0e, 0xca, 0x50, 0xcc, 0x72, 0x35, 0xd7, 0x57,
-	0xa5, 0x2a, 0x38, 0x32, 0xae, 0xfd, 0x95, 0xb0, 0x02, 0x3b, 0x1d, 0x71, 0x8c, 0xd3, 0xfb, 0x30,
-	0xec, 0x28, 0x1a, 0x83, 0xff, 0xe1, 0x1d, 0x4f, 0x06, 0xe9, 0x17, 0x88, 0xde, 0xcb, 0xba, 0x94,
-	0x86, 0x3e, 0x85, 0x68, 0xe9, 0x56, 0x33, 0x8c, 0x4c, 0xfc, 0xe9, 0xe1, 0xd9, 0x9d, 0x6e, 0x00,
-	0x2e, 0x7c, 0x1e, 0x6c, 0x7e, 0x9f, 0x0c, 0xf8, 0x0e, 0xa1, 0xa7, 0x10, 0xcd, 0xdd, 0x5c, 0xc3,
-	0x3c, 0x84, 0xef, 0x75, 0xf0, 0xcb, 0xa2, 0xa8, 0x71, 0xa3, 0xae, 0xa0, 0xc5, 0xd2, 0x9f, 0x1e,
-	0x1c, 0xfc, 0xd7, 0xe8, 0x08, 0x86, 0x55, 0xa9, 0x3e, 0xdb, 0x72, 0xf7, 0x03, 0x3e, 0x8f, 0xab,
-	0x52, 0x5d, 0x96, 0x95, 0x44, 0x49, 0xac, 0x5b, 0xc9, 0xdb, 0x49, 0x62, 0x8d, 0xd2, 0x09, 0xf8,
-	0xb5, 0xf8, 0xce, 0xfc, 0x09, 0xb9, 0xbd, 0x1e, 0x76, 0xe4, 0x4e, 0xa1, 0x8f, 0x20, 0x9c, 0xeb,
-	0x46, 0x59, 0x16, 0xf4, 0x21, 0xad, 0xe6, 0xba, 0x98, 0xa6, 0x62, 0x61, 0x6f, 0x17, 0xd3, 0x54,
-	0x0e, 0xa8, 0x4a, 0xc5, 0xa2, 0x5e, 0xa0, 0x2a, 0x15, 0x02, 0x62, 0xcd, 0xe2, 0x7e, 0x40, 0xac,
-	0xe9, 0x63, 0x88, 0x71, 0x96, 0xac, 0xd9, 0xb0, 0x0f, 0xea, 0xd4, 0xf4, 0x07, 0x81, 0x23, 0xfc,
-	0xde, 0x37, 0xc2, 0xce, 0x17, 0xb2, 0xa6, 0xcf, 0xf6, 0x6e, 0x3c, 0xda, 0x3b, 0xc1, 0x8e, 0xc9,
-	0x2e, 0xaf, 0x57, 0xf2, 0xe6, 0xcc, 0xe8, 0x22, 0xaf, 0xcf, 0x45, 0xfe, 0x6d, 0x17, 0x4d, 0x21,
-	0x70, 0x75, 0x34, 0x02, 0x2f, 0xbf, 0x48, 0x06, 0xce, 0x00, 0x6f, 0xf3, 0x8b, 0x84, 0xb8, 0x04,
-	0xcf, 0x13, 0x0f, 0x13, 0x3c, 0x4f, 0xfc, 0xf3, 0xd1, 0xe6, 0xef, 0x78, 0xb0, 0xd9, 0x8e, 0xc9,
-	0xaf, 0xed, 0x98, 0xfc, 0xd9, 0x8e, 0xc9, 0xc7, 0xd8, 0x58, 0x5d, 0xcb, 0xd5, 0x6c, 0x16, 0xa1,
-	0x89, 0x5f, 0xfc, 0x0b, 0x00, 0x00, 0xff, 0xff, 0x46, 0x5a, 0xe2, 0x68, 0xf1, 0x02, 0x00, 0x00,
+	// 508 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x93, 0xcf, 0x6f, 0x12, 0x41,
+	0x14, 0xc7, 0x99, 0xfd, 0x05, 0x7d, 0xa0, 0x59, 0x27, 0x8d, 0x59, 0x3c, 0xd0, 0xba, 0x1e, 0x6c,
+	0x34, 0x52, 0x6d, 0x8f, 0x9e, 0x0a, 0xdd, 0x98, 0x26, 0x08, 0x32, 0x62, 0x62, 0x7a, 0x21, 0x03,
+	0x1d, 0x61, 0x23, 0x3b, 0x43, 0x76, 0x16, 0xa5, 0x7f, 0x87, 0x57, 0xff, 0x20, 0x8e, 0x5e, 0xbd,
+	0x18, 0xe5, 0x2f, 0x31, 0xf3, 0x16, 0x2c, 0xc4, 0xbd, 0xbd, 0x7d, 0xdf, 0xcf, 0x7c, 0xdf, 0xec,
+	0xdb, 0xef, 0x42, 0x35, 0xbb, 0x9d, 0x0b, 0xdd, 0x9c, 0xa7, 0x2a, 0x53, 0xd4, 0xcb, 0xa6, 0x5c,
+	0x2a, 0xfd, 0xe8, 0x70, 0xa2, 0x26, 0x0a, 0x5b, 0xa7, 0xa6, 0xca, 0xd5, 0xf0, 0x15, 0xb8, 0x1d,
+	0x3e, 0x12, 0x33, 0x4a, 0xc1, 0x91, 0x3c, 0x11, 0x01, 0x39, 0x26, 0x27, 0x07, 0x0c, 0x6b, 0x7a,
+	0x08, 0xee, 0x17, 0x3e, 0x5b, 0x88, 0xc0, 0xc2, 0x66, 0xfe, 0x10, 0xfe, 0x24, 0xe0, 0xb6, 0xa7,
+	0x0b, 0xf9, 0x99, 0x3e, 0x03, 0xc7, 0x4c, 0xc2, 0x33, 0xf7, 0xcf, 0x1e, 0x36, 0xf3, 0x49, 0x4d,
+	0x14, 0x9b, 0x91, 0x1c, 0xab, 0x9b, 0x58, 0x4e, 0x18, 0x32, 0xc6, 0xff, 0x86, 0x67, 0x1c, 0xad,
+	0x6a, 0x0c, 0x6b, 0xfa, 0x1a, 0xaa, 0x63, 0x95, 0xcc, 0x53, 0xa1, 0x75, 0xac, 0x64, 0x60, 0xa3,
+	0x4d, 0x7d, 0xdf, 0xa6, 0x7d, 0x07, 0xb0, 0x5d, 0x3a, 0x7c, 0x09, 0x95, 0xed, 0x08, 0x5a, 0x06,
+	0xfb, 0x63, 0x8f, 0xf9, 0x25, 0x7a, 0x00, 0xee, 0x65, 0xd4, 0x19, 0x5c, 0xf8, 0x84, 0xfa, 0x50,
+	0xbb, 0xec, 0x7d, 0x68, 0x75, 0xa2, 0x61, 0xde, 0xb1, 0xc2, 0xc7, 0x50, 0xdd, 0x71, 0xa3, 0x15,
+	0x70, 0xba, 0xbd, 0x6e, 0xe4, 0x97, 0x4c, 0xf5, 0xe6, 0xfa, 0xea, 0x9d, 0x4f, 0xc2, 0x4f, 0xe0,
+	0xbd, 0x17, 0x69, 0x2c, 0x34, 0x7d, 0x0e, 0xde, 0xcc, 0x2c, 0x46, 0x07, 0xe4, 0xd8, 0x3e, 0xa9,
+	0x9e, 0xdd, 0xdb, 0x5e, 0x0b, 0xd7, 0xd5, 0x72, 0x56, 0xbf, 0x8e, 0x4a, 0x6c, 0x83, 0xd0, 0x53,
+	0xf0, 0xc6, 0xe6, 0xb6, 0x3a, 0xb0, 0x10, 0x7e, 0xb0, 0x85, 0x2f, 0x26, 0x93, 0x14, 0xdf, 0x63,
+	0x7b, 0x20, 0xc7, 0xc2, 0x6f, 0x16, 0x1c, 0xfc, 0xd3, 0x68, 0x1d, 0x2a, 0x49, 0x2c, 0x87, 0x59,
+	0xbc, 0xd9, 0xbf, 0xcd, 0xca, 0x49, 0x2c, 0x07, 0x71, 0x22, 0x50, 0xe2, 0xcb, 0x5c, 0xb2, 0x36,
+	0x12, 0x5f, 0xa2, 0x74, 0x04, 0x76, 0xca, 0xbf, 0xe2, 0xd6, 0x76, 0xae, 0x87, 0x8e, 0xcc, 0x28,
+	0xf4, 0x09, 0xb8, 0x63, 0xb5, 0x90, 0x59, 0xe0, 0x14, 0x21, 0xb9, 0x66, 0x5c, 0xf4, 0x22, 0x09,
+	0xdc, 0x42, 0x17, 0xbd, 0x48, 0x0c, 0x90, 0xc4, 0x32, 0xf0, 0x0a, 0x81, 0x24, 0x96, 0x08, 0xf0,
+	0x65, 0x50, 0x2e, 0x06, 0xf8, 0x92, 0x3e, 0x85, 0x32, 0xce, 0x12, 0x69, 0x50, 0x29, 0x82, 0xb6,
+	0x6a, 0xf8, 0x9d, 0x40, 0x0d, 0xd7, 0xfb, 0x96, 0x67, 0xe3, 0xa9, 0x48, 0xe9, 0x8b, 0xbd, 0x80,
+	0xd5, 0xf7, 0x3e, 0xc1, 0x86, 0x69, 0x0e, 0x6e, 0xe7, 0xe2, 0x2e, 0x63, 0x92, 0x6f, 0x16, 0xf5,
+	0x5f, 0x86, 0xed, 0xdd, 0x0c, 0x9f, 0x83, 0x63, 0xce, 0x51, 0x0f, 0xac, 0xa8, 0xef, 0x97, 0x4c,
+	0x80, 0xba, 0x51, 0xdf, 0x27, 0xa6, 0xc1, 0x22, 0xdf, 0xc2, 0x06, 0x8b, 0x7c, 0xdb, 0x24, 0x2a,
+	0xea, 0x0f, 0xdb, 0x57, 0xbe, 0xd3, 0xaa, 0xaf, 0xfe, 0x34, 0x4a, 0xab, 0x75, 0x83, 0xfc, 0x58,
+	0x37, 0xc8, 0xef, 0x75, 0x83, 0x5c, 0x97, 0x75, 0xa6, 0x52, 0x31, 0x1f, 0x8d, 0x3c, 0xfc, 0x9b,
+	0xce, 0xff, 0x0e, 0x00, 0x26, 0x23, 0x56, 0xb2, 0x7a, 0x03, 0x00, 0x00,
 }
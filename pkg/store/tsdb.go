@@ -44,6 +44,8 @@ func (s *TSDBStore) Info(ctx context.Context, r *storepb.InfoRequest) (*storepb.
 		MinTime: 0,
 		MaxTime: math.MaxInt64,
 		Labels:  make([]storepb.Label, 0, len(s.labels)),
+		// TSDBStore serves local TSDB of rulers.
+		StoreType: storepb.StoreType_RULE,
 	}
 	if blocks := s.db.Blocks(); len(blocks) > 0 {
 		res.MinTime = blocks[0].Meta().MinTime
@@ -92,13 +94,16 @@ func (s *TSDBStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesSer
 	for set.Next() {
 		series := set.At()
 
-		c, err := s.encodeChunk(series.Iterator())
-		if err != nil {
-			return status.Errorf(codes.Internal, "encode chunk: %s", err)
-		}
-
 		respSeries.Labels = s.translateAndExtendLabels(series.Labels(), s.labels)
-		respSeries.Chunks = append(respSeries.Chunks[:0], c)
+		respSeries.Chunks = respSeries.Chunks[:0]
+
+		if !r.SkipChunks {
+			c, err := s.encodeChunk(series.Iterator())
+			if err != nil {
+				return status.Errorf(codes.Internal, "encode chunk: %s", err)
+			}
+			respSeries.Chunks = append(respSeries.Chunks, c)
+		}
 
 		if err := srv.Send(storepb.NewSeriesResponse(&respSeries)); err != nil {
 			return status.Error(codes.Aborted, err.Error())
@@ -181,5 +186,7 @@ func (s *TSDBStore) LabelValues(ctx context.Context, r *storepb.LabelValuesReque
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	return &storepb.LabelValuesResponse{Values: res}, nil
+	resp := &storepb.LabelValuesResponse{}
+	resp.Values, resp.Truncated = limitLabelValues(res, r.Limit)
+	return resp, nil
 }
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+)
+
+// UnmergedSeriesFunc receives a series of a single store, identified by its address, see ContextWithUnmergedSeries.
+// The series is valid only during the call.
+type UnmergedSeriesFunc func(store string, s storepb.Series) error
+
+type unmergedSeriesKey struct{}
+
+// ContextWithUnmergedSeries returns a new context.Context that makes ProxyStore pass series of Series requests made
+// with it to f instead of merging them across stores and sending them to the server, so series of all stores are
+// never held at once. It is meant for in-process consumers merging series on their own. Warnings and queried blocks
+// are still sent to the server.
+//
+// Series are passed store after store, in order of stores of the request. Series of a store queried in multiple
+// shards are passed shard after shard. Within a store or shard, series are passed in the order the store sent them,
+// which is sorted by labels for stores following the StoreAPI, and consecutive responses of the same series are
+// passed as a single series with all their chunks. The same labels may be passed for multiple stores. f is never
+// called concurrently and an error returned by it fails the request.
+func ContextWithUnmergedSeries(ctx context.Context, f UnmergedSeriesFunc) context.Context {
+	return context.WithValue(ctx, unmergedSeriesKey{}, f)
+}
+
+func unmergedSeriesFromContext(ctx context.Context) UnmergedSeriesFunc {
+	f, _ := ctx.Value(unmergedSeriesKey{}).(UnmergedSeriesFunc)
+	return f
+}
+
+// passUnmerged passes series of the given streams to f, stream after stream, see ContextWithUnmergedSeries. All
+// streams are drained even if passing fails, so their goroutines are not blocked. It returns the number of series
+// passed.
+func passUnmerged(ctx context.Context, streams []*streamSeriesSet, shard *storepb.SeriesShard, f UnmergedSeriesFunc, progress *progressTracker) (int, error) {
+	var (
+		passed int
+		err    error
+	)
+	pass := func(st *streamSeriesSet, s *storepb.Series) {
+		if s == nil || err != nil {
+			return
+		}
+		if ferr := f(st.addr, *s); ferr != nil {
+			err = errors.Wrapf(ferr, "pass series of %s", st.name)
+			return
+		}
+		progress.seriesMerged()
+		passed++
+	}
+
+	for _, st := range streams {
+		var cur *storepb.Series
+		for st.Next() {
+			// Check the context between series, like when merging, and keep draining.
+			if err != nil || ctx.Err() != nil {
+				continue
+			}
+			lset, chks := st.At()
+			// Stores not supporting shards return all matching series.
+			if !shard.Matches(lset) {
+				continue
+			}
+			if cur != nil && storepb.CompareLabels(cur.Labels, lset) == 0 {
+				// Chunks may share backing array with the received response, so don't append in place.
+				cur.Chunks = append(cur.Chunks[:len(cur.Chunks):len(cur.Chunks)], chks...)
+				continue
+			}
+			pass(st, cur)
+			cur = &storepb.Series{Labels: lset, Chunks: chks}
+		}
+		if ctx.Err() == nil {
+			pass(st, cur)
+		}
+		if serr := st.Err(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return passed, err
+}
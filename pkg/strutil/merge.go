@@ -55,3 +55,36 @@ func mergeTwoStringSlices(a, b []string) []string {
 	res = append(res, b...)
 	return res
 }
+
+// MergeSlicesLimit merges a set of sorted string slices like MergeSlices, but stops once limit unique strings are
+// merged. It returns true if any strings were left out. Zero limit merges all strings.
+func MergeSlicesLimit(limit int, a ...[]string) ([]string, bool) {
+	if limit <= 0 {
+		return MergeSlices(a...), false
+	}
+	var (
+		res   []string
+		heads = append([][]string(nil), a...)
+	)
+	for {
+		min := -1
+		for i, s := range heads {
+			if len(s) > 0 && (min < 0 || s[0] < heads[min][0]) {
+				min = i
+			}
+		}
+		if min < 0 {
+			return res, false
+		}
+		if len(res) == limit {
+			return res, true
+		}
+		v := heads[min][0]
+		res = append(res, v)
+		for i, s := range heads {
+			if len(s) > 0 && s[0] == v {
+				heads[i] = s[1:]
+			}
+		}
+	}
+}
@@ -267,7 +267,7 @@ func pkgUiTemplatesStatusHtml() (*asset, error) {
 	return a, nil
 }
 
-var _pkgUiTemplatesStoresHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x9c\x55\x4f\x6b\x1b\x3f\x10\xbd\xfb\x53\x0c\xe2\x77\xb5\x17\x72\xf9\x41\xd9\x75\x29\x25\xd0\x43\x12\x0a\x29\xb9\x16\x59\x1a\x7b\x45\x64\x69\xd1\xcc\xa6\x36\x42\xdf\xbd\x68\xd7\x1b\xdb\xf1\xda\xb1\x7b\x11\x68\xfe\xe8\xbd\x19\x3d\x8d\x62\xd4\xb8\x34\x0e\x41\xd4\x28\xb5\x48\x69\x52\x5a\xe3\x5e\x81\xb7\x0d\x56\x82\x71\xc3\x85\x22\x12\x10\xd0\x56\x82\x78\x6b\x91\x6a\x44\x16\x50\x07\x5c\x56\x22\x46\x68\x24\xd7\x3f\x03\x2e\xcd\x06\x52\x2a\x88\x25\x1b\x95\x73\x8a\xd0\x5a\xa4\x99\x22\xfa\xfa\x56\xc5\x08\x8b\xd6\x58\xfd\x82\x81\x8c\x77\x90\x92\x98\x4f\x62\x44\xa7\x53\x9a\x4c\xf6\x24\x94\x77\x8c\x8e\x3b\x1e\xda\xbc\x81\xb2\x92\xa8\xea\xcc\xd2\x38\x0c\xd3\xa5\x6d\x8d\x16\xf3\x09\x00\x40\x59\xdf\xcd\x9f\xd9\x07\xa4\xb2\xa8\xef\x76\x36\x96\x0b\x8b\x43\x5e\xbf\xe9\xd6\xe9\xc2\x07\x8d\x01\x87\xe4\x3e\x38\x17\x7d\xb8\x0f\xfb\xcd\x2e\x60\x7e\xef\x74\xe3\x8d\xe3\xb2\xe0\xfa\xd4\xfb\xcc\x92\x5b\x1a\xf7\x7d\x73\xce\xb7\x4e\xa1\x86\x07\xb9\x40\x7b\x26\xea\xd1\x38\xf8\x65\xd6\x78\xc6\x2b\x37\x17\xbc\x0f\x92\x18\x7e\xa0\xb4\x5c\xc3\xf7\x1a\xd5\xeb\x85\xb0\x47\x24\x92\xab\x0f\x07\x95\xc5\x61\xc9\xd9\xf7\xa1\x21\x0b\xaf\xb7\xfb\x7d\x8c\x41\xba\x15\xc2\x7f\x94\xdb\x0e\x5f\x2a\x98\xa5\x74\xa9\x7d\x7a\x1e\x63\x1f\x3c\x7b\x92\x6b\x4c\xa9\x2c\x58\x9f\x04\x0d\xd7\x95\xc5\x83\xe2\xd8\xdd\xc3\x9a\x25\x38\xcf\x3b\xdc\x59\xae\xe7\x3e\x04\x1f\x0e\xc0\xdf\x8f\xa3\x46\xba\xe1\x40\x69\x31\x30\x74\xeb\x94\x5a\xa5\x90\x08\x3a\x90\xdf\xc6\x69\xa3\x24\xfb\x00\x59\xe3\xd3\xb6\x69\x30\x28\x49\x63\xe8\x6d\x73\x0a\x52\x64\x94\x31\xa2\x68\x09\x6f\x61\xa5\x73\x3f\xc3\xed\xa4\xb4\xff\xe3\x6e\xa1\xd5\x3d\xb4\xe3\xd8\x91\x8b\x38\x36\xbc\xdf\xb6\xcd\xf2\xcd\xb7\xfd\xde\xff\x2c\xe7\xcf\xca\xec\xb3\xba\x75\xda\x04\xb3\x96\x61\x2b\xb2\x1c\x3a\xcb\x4e\x0e\x79\x82\xec\x0c\x2f\xd2\xb6\x98\x92\x18\x2b\xe2\xfa\x02\x62\x5c\xfa\xb0\x96\x9c\xdf\x0c\xb1\x5c\x37\x03\xe7\x47\xe3\xb2\xed\x8c\x02\x2f\xe4\xc9\xcd\xe5\x3c\x32\x4e\xe1\xa1\x32\xbb\x87\x98\x12\xc8\x95\xbf\xa2\xc9\x30\xe8\xfb\x0a\x6d\x9f\xb4\xf8\x73\x25\x8d\x48\xa7\x47\xbc\x16\xee\x9f\x25\x75\x3c\x5a\x4e\x5e\xc6\xd8\xb0\x00\xe5\x6d\x86\xab\xc4\xff\x23\xbc\x9f\x3c\x74\x9c\x09\x02\xae\x0c\x71\x1e\xe6\xb7\xe0\x1f\xf1\x2d\x8b\x83\xd1\x56\x16\xdd\x17\x31\x9f\x94\x85\x36\x6f\xfb\x8f\xe9\x6f\x00\x00\x00\xff\xff\xdf\xa9\x37\xf4\x1d\x07\x00\x00")
+var _pkgUiTemplatesStoresHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xbd\x55\xdf\x6b\xdb\x30\x10\x7e\xcf\x5f\x71\x88\xbd\x26\x86\x3e\x8d\x11\x67\x8c\x52\xd8\x43\x5b\x06\x1d\x7d\x1d\x8a\x74\x89\x45\x15\xc9\x48\x72\x97\x20\xfc\xbf\xef\x64\xc7\x49\xbd\x38\x6e\x52\xc6\xfc\x20\x7c\xa7\x3b\x7d\xf7\xe3\xd3\x29\x46\x89\x2b\x65\x10\x58\x81\x5c\xb2\xba\x9e\xcc\xb5\x32\x2f\x10\x76\x25\xe6\x2c\xe0\x36\x64\xc2\x7b\x06\x0e\x75\xce\x7c\xd8\x69\xf4\x05\x62\x60\x50\x38\x5c\xe5\x2c\x46\x28\x79\x28\x7e\x90\xa0\xb6\x50\xd7\x99\x0f\x3c\x28\x91\x7c\x32\x57\x91\xf1\x8c\xfe\xbe\xbe\xe6\x64\xb7\xac\x94\x96\xcf\xe8\xbc\xb2\x86\x2c\xd9\x62\x12\x23\x1a\x49\x88\xf4\xd3\x05\x21\xac\x09\x68\x42\x13\x87\x54\xaf\x20\x34\xf7\x3e\x6f\xd4\x9c\x0c\xdc\x74\xa5\x2b\x25\xc9\x17\xe8\x9b\x17\x37\x8b\xa7\x60\x1d\xfa\x79\x46\xbf\xad\x2e\xf0\xa5\xc6\xce\xaf\x15\x9a\x75\xba\xb4\x4e\xa2\xc3\xce\xb9\x35\x4e\x49\xbf\x95\xdd\x51\xd8\x1b\x2c\xee\x8c\x2c\xad\x32\x61\x9e\x91\x70\xb2\xfb\x44\xf9\x56\x7e\x78\xef\x9b\x31\xb6\x32\x02\x25\xdc\xf3\x25\xea\x33\x56\x0f\xca\xc0\x4f\xb5\xc1\x33\xbb\x7c\x3b\xb2\x7b\xcf\x7d\x80\xef\xc8\x75\x28\xe0\xb6\x40\xf1\x32\x62\xf6\x80\xde\xf3\xf5\xd8\x41\xb7\x5c\x6b\xb8\x73\xce\xba\xbe\x11\x49\xae\x27\xfd\x5d\xb5\xa5\x95\xbb\xa3\x1c\xa3\xe3\x66\x8d\xf0\xc9\xa7\xde\xc0\x97\x1c\x66\xd4\xce\x91\x1a\xcb\x45\x8c\xad\xf1\xec\x91\x6f\xb0\xae\x09\x42\x9e\x18\x75\x3d\x4d\x0c\x43\xd6\xdf\x6e\x61\xd5\x0a\x8c\x0d\x7b\xdc\x59\x4a\xa9\x49\xe6\x0d\xf8\xe1\x38\x5f\x72\xd3\x1d\xc8\x35\xba\x00\xcd\x3a\xf5\x95\x10\x54\x27\x68\x40\x7e\x29\x23\x95\xe0\x74\x1a\xa4\x8b\x30\xad\xca\x12\x9d\xe0\x7e\x08\xbd\x2a\x4f\x41\xb2\x84\x32\x14\x28\x71\x01\xaf\x89\x4a\xa6\x7a\xba\xeb\x83\x92\xf6\xb7\xb9\x26\xac\xe6\x36\xf6\x6d\x07\x1a\xd1\x57\x1c\xba\xad\x13\xc7\x53\xb7\x0f\xf5\x4f\x9c\x7f\x2f\xcd\xd6\xab\x59\xa7\xa5\x53\x1b\xee\x76\x2c\xd1\xa1\xd1\xec\xe9\x90\xc6\xcc\x5e\xf1\xcc\x75\x45\x1a\x36\x94\xc4\xe5\x09\xc4\xb8\xb2\x6e\xc3\x43\xba\x58\x54\xd4\x4d\xd9\xc5\x4c\x77\x31\xe9\xce\x30\x70\xc4\x8f\x6f\xc7\xfd\xbc\xa2\x39\xf0\x96\x99\xcd\x6d\xad\x6b\xe0\x6b\x7b\x41\x91\x0f\xfc\xbe\x80\xdb\x1f\x60\xd2\x00\x75\x5a\xc4\x4b\xe1\xfe\x2d\xa5\x86\xb2\x4d\xb3\xe9\x3f\x67\xfc\x2e\xe4\x99\xac\xdb\x83\x4e\x3b\xde\x1d\xd7\x32\x25\x75\xfe\x03\x05\xeb\xcf\xe2\x93\x51\x32\x34\x5d\x41\x58\x9d\x22\xcd\xd9\xe7\x81\xb4\x1f\x2d\x34\x31\x7a\x7a\xe2\xd7\xca\x87\xf4\x44\x5e\x83\xdf\x8b\x97\x76\x8f\x6f\x01\x09\xe9\xe1\x5d\x4c\xe6\x19\x3d\xe5\xc7\xe7\xfe\x0f\xbd\xaf\x54\x35\x73\x08\x00\x00")
 
 func pkgUiTemplatesStoresHtmlBytes() ([]byte, error) {
 	return bindataRead(
@@ -282,7 +282,7 @@ func pkgUiTemplatesStoresHtml() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "pkg/ui/templates/stores.html", size: 1821, mode: os.FileMode(420), modTime: time.Unix(1545065695, 0)}
+	info := bindataFileInfo{name: "pkg/ui/templates/stores.html", size: 2163, mode: os.FileMode(420), modTime: time.Unix(1545065695, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }